@@ -0,0 +1,56 @@
+// Command migrate applies or reverts database migrations outside of server startup.
+//
+// Usage:
+//
+//	migrate up              apply all pending migrations (the server does this on start too)
+//	migrate down <version>  revert migrations until the schema is at <version> (0 reverts everything)
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/user/minicoinbase/backend/internal/config"
+	"github.com/user/minicoinbase/backend/internal/database"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		log.Fatal("usage: migrate up | migrate down <version>")
+	}
+
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
+	ctx := context.Background()
+	pool, err := pgxpool.New(ctx, cfg.DatabaseURL)
+	if err != nil {
+		log.Fatalf("Unable to connect to database: %v", err)
+	}
+	defer pool.Close()
+
+	switch os.Args[1] {
+	case "up":
+		err = database.Migrate(ctx, pool)
+	case "down":
+		if len(os.Args) != 3 {
+			log.Fatal("usage: migrate down <version>")
+		}
+		target, convErr := strconv.Atoi(os.Args[2])
+		if convErr != nil || target < 0 {
+			log.Fatalf("Invalid target version %q", os.Args[2])
+		}
+		err = database.MigrateDown(ctx, pool, target)
+	default:
+		log.Fatalf("Unknown command %q, expected up or down", os.Args[1])
+	}
+	if err != nil {
+		log.Fatalf("Migration failed: %v", err)
+	}
+	log.Println("Done.")
+}
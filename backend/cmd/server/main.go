@@ -1,70 +1,149 @@
 package main
 
 import (
+	"context"
 	"log"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/gofiber/contrib/websocket" // Keep original import name
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid" // Need this for type assertion
 
 	// Use module path + directory structure for internal packages
+	"github.com/user/minicoinbase/backend/internal/apierror"
+	"github.com/user/minicoinbase/backend/internal/archive"
+	"github.com/user/minicoinbase/backend/internal/auth"
+	"github.com/user/minicoinbase/backend/internal/config"
 	"github.com/user/minicoinbase/backend/internal/database"
-	"github.com/user/minicoinbase/backend/internal/handlers"             // Import handlers
+	"github.com/user/minicoinbase/backend/internal/fees"
+	"github.com/user/minicoinbase/backend/internal/handlers" // Import handlers
+	"github.com/user/minicoinbase/backend/internal/logging"
+	"github.com/user/minicoinbase/backend/internal/markets"
 	"github.com/user/minicoinbase/backend/internal/middleware"           // Import middleware
 	"github.com/user/minicoinbase/backend/internal/orderbook"            // Import orderbook
 	"github.com/user/minicoinbase/backend/internal/ticker"               // Import ticker
 	internalws "github.com/user/minicoinbase/backend/internal/websocket" // Alias internal websocket
 )
 
+// shutdownTimeout bounds how long in-flight requests get to finish on shutdown.
+const shutdownTimeout = 10 * time.Second
+
 func main() {
-	// Initialize Database
-	database.InitDB()
-	defer database.CloseDB() // Ensure DB connection is closed on exit
+	// Cancelled on SIGINT/SIGTERM to start a graceful shutdown
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	// Load configuration from the environment
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	logging.Init(cfg)
+	auth.Init(cfg)
+	fees.Init(cfg)
+	markets.Init(cfg)
+	archive.Init(cfg)
+	handlers.InitAuth(cfg)
+	handlers.InitOrders(cfg)
+	handlers.InitWebSocket(cfg)
+	handlers.InitMarketData(cfg)
+
+	// Initialize Database (waits for it to come up)
+	if err := database.InitDB(ctx, cfg); err != nil {
+		log.Fatalf("Database initialization failed: %v", err)
+	}
 
 	// Initialize WebSocket Hub
 	internalws.InitializeGlobalHub() // Use alias
 
 	// Initialize Price Ticker (starts broadcasting to the hub)
-	ticker.InitTicker()
+	ticker.InitTicker(cfg)
 
 	// Initialize Order Book Manager
-	orderbook.InitManager()
-
-	app := fiber.New()
+	orderbook.InitManager(cfg)
+	if cfg.OrderBookEventLog != "" {
+		if err := orderbook.GlobalOrderBookManager.OpenEventLog(cfg.OrderBookEventLog); err != nil {
+			log.Fatalf("Order book event log: %v", err)
+		}
+	}
+	// Rebuild the books from their snapshots and the open orders, then keep snapshotting
+	if err := orderbook.GlobalOrderBookManager.Recover(ctx); err != nil {
+		log.Fatalf("Order book recovery failed: %v", err)
+	}
+	orderbook.GlobalOrderBookManager.StartSnapshots(ctx)
+	orderbook.GlobalOrderBookManager.StartExpirySweeper(ctx)
+	orderbook.GlobalOrderBookManager.StartOutboxWorker(ctx)
+	orderbook.GlobalOrderBookManager.StartBookReaper(ctx)
+	archive.Start(ctx) // Moves old filled and cancelled orders out of the orders table
+
+	app := fiber.New(fiber.Config{
+		BodyLimit: cfg.BodyLimit, // Larger bodies are rejected with 413 before reaching a handler
+	})
+	app.Use(middleware.RequestID()) // Correlation ID for every request's log lines
+	app.Use(middleware.CORS(cfg))   // Before any route, so preflight requests get answered
+	// 503 for everything but the probes and admins while in maintenance mode
+	middleware.SetMaintenance(cfg.MaintenanceMode)
+	app.Use(middleware.Maintenance())
 
 	// --- WebSocket Routes ---
 	// Needs to be defined before the /api group if it shouldn't inherit middleware
 	wsGroup := app.Group("/ws")
-	wsGroup.Use("/", func(c *fiber.Ctx) error {
-		// Middleware to check for upgrade request
-		if websocket.IsWebSocketUpgrade(c) {
-			c.Locals("allowed", true)
-			return c.Next()
-		}
-		return fiber.ErrUpgradeRequired
-	})
+	wsGroup.Use("/", middleware.WebSocketUpgrade(cfg)) // Upgrade requests from allowed origins only
 	// Price feed WebSocket endpoint - Use websocket.New
 	wsGroup.Get("/prices", websocket.New(handlers.PriceWSEndpoint))
+	wsGroup.Get("/trades/:symbol", websocket.New(handlers.TradeWSEndpoint))
+	wsGroup.Get("/depth/:symbol", websocket.New(handlers.DepthWSEndpoint))
+	wsGroup.Get("/user", websocket.New(handlers.UserWSEndpoint)) // Private, authenticated by the first message
 
 	// --- API Routes ---
 	api := app.Group("/api") // Group routes under /api
 
-	// Health check (Public)
-	api.Get("/health", func(c *fiber.Ctx) error {
-		return c.SendString("Mini-Coinbase API is healthy!")
-	})
+	// Liveness and readiness probes (Public)
+	api.Get("/health", handlers.Health)
+	api.Get("/ready", handlers.Ready)
+
+	// Tradable symbols and their order limits (Public)
+	api.Get("/symbols", handlers.GetSymbols)
+	api.Get("/markets", handlers.GetMarkets) // Rules plus last price, 24h volume and halt status
 
 	// Order Book Depth (Public)
 	api.Get("/book/:symbol", handlers.GetOrderBookDepth)
+	api.Get("/book/:symbol/updates", handlers.GetDepthUpdates) // ?since_seq=, depth feed gap recovery
+	api.Get("/book/:symbol/raw", handlers.GetRawBook)          // Individual orders instead of price levels
+
+	// Candlesticks (Public)
+	api.Get("/klines/:symbol", handlers.GetKlines)
+
+	// Public trades of a symbol (?since_seq=, trade feed gap recovery)
+	api.Get("/trades/:symbol", handlers.GetSymbolTrades)
+
+	// 24 hour statistics (Public)
+	api.Get("/ticker/24hr", handlers.GetAllTickers24h)
+	api.Get("/ticker/24hr/:symbol", handlers.GetTicker24h)
+	api.Get("/ticker/book/:symbol", handlers.GetBookTicker) // Best bid/ask and spread
+
+	// Market order cost estimate (Public, ?side=&quantity=)
+	api.Get("/quote/:symbol", handlers.GetQuote)
 
 	// Auth routes (Public)
 	authGroup := api.Group("/auth")
 	authGroup.Post("/signup", handlers.Signup)
 	authGroup.Post("/login", handlers.Login)
+	authGroup.Post("/refresh", handlers.Refresh)
+	authGroup.Post("/logout", middleware.Protected(), handlers.Logout) // Needs the access token to revoke
+	authGroup.Post("/change-password", middleware.Protected(), handlers.ChangePassword)
+
+	// API key management (JWT only, a key can't be used to create more keys)
+	keysGroup := api.Group("/keys", middleware.Protected())
+	keysGroup.Post("/", handlers.CreateAPIKey)
+	keysGroup.Get("/", handlers.GetAPIKeys)
+	keysGroup.Delete("/:id", handlers.DeleteAPIKey)
 
 	// --- Protected Routes ---
-	// Apply the Protected middleware to all routes defined after this
-	api.Use(middleware.Protected())
+	// Apply the auth middleware (JWT or signed API key) to all routes defined after this
+	api.Use(middleware.Authenticated())
 
 	// Example Protected Route: Get current user info
 	api.Get("/me", func(c *fiber.Ctx) error {
@@ -73,28 +152,102 @@ func main() {
 
 		if !ok || !ok2 {
 			// This shouldn't happen if middleware ran correctly, but good practice to check
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to get user info from context"})
+			return apierror.Send(c, apierror.Internal, "Failed to get user info from context")
 		}
 
-		return c.JSON(fiber.Map{
+		resp := fiber.Map{
 			"message":  "Successfully authenticated",
 			"user_id":  userID,
 			"username": username,
-		})
+		}
+		// Lets the frontend refresh before the access token expires (API key requests have none)
+		if claims, ok := c.Locals("claims").(*auth.Claims); ok {
+			resp["expires_at"] = claims.ExpiresAt.Time
+			resp["expires_in"] = int64(claims.RemainingTTL().Seconds())
+		}
+		return c.JSON(resp)
 	})
 
 	// Order Routes (Protected)
 	ordersGroup := api.Group("/orders")
-	ordersGroup.Post("/", handlers.CreateOrder)
-	ordersGroup.Get("/", handlers.GetOrders)         // Get user's orders
-	ordersGroup.Get("/:id", handlers.GetOrderByID)   // Get specific order by ID
-	ordersGroup.Delete("/:id", handlers.CancelOrder) // Cancel specific order by ID
+	orderRateLimit := middleware.OrderRateLimit(cfg) // Shared by order submissions and modifications
+	ordersGroup.Post("/", orderRateLimit, handlers.CreateOrder)
+	ordersGroup.Post("/validate", handlers.ValidateOrder)           // Dry run: checks and a fill estimate, places nothing
+	ordersGroup.Get("/", handlers.GetOrders)                        // Get user's orders
+	ordersGroup.Get("/:id", handlers.GetOrderByID)                  // Get specific order by ID
+	ordersGroup.Get("/:id/fills", handlers.GetOrderFills)           // Executions of an order with running totals
+	ordersGroup.Delete("/", handlers.CancelAllOrders)               // Cancel all open orders (optionally ?symbol=)
+	ordersGroup.Patch("/:id", orderRateLimit, handlers.ModifyOrder) // Change price/quantity (cancel-replace)
+	ordersGroup.Delete("/:id", handlers.CancelOrder)                // Cancel specific order by ID
 
 	// Portfolio Route (Protected)
 	api.Get("/portfolio", handlers.GetPortfolio)
-
-	// TODO: Add other PROTECTED routes here (e.g., Trade History?)
-
-	log.Println("Starting server on :8080")
-	log.Fatal(app.Listen(":8080"))
+	api.Get("/balances/holds", handlers.GetBalanceHolds) // Locked funds by order; before /balances/:asset, which would match it
+	api.Get("/balances/:asset", handlers.GetBalance)     // One asset, zero if never held
+	api.Get("/positions", handlers.GetPositions)         // Average entry price, realized and unrealized P&L per market
+
+	// Trade History Route (Protected)
+	api.Get("/trades", handlers.GetTrades)
+
+	// Account statement download, JSON or CSV (Protected)
+	api.Get("/account/statement", handlers.GetStatement)
+
+	// Internal transfers to other users (Protected, not with an API key)
+	api.Post("/transfers", middleware.SessionOnly(), handlers.CreateTransfer)
+
+	// Admin Routes (Protected, admin role only, not with an API key)
+	adminGroup := api.Group("/admin", middleware.SessionOnly(), middleware.RequireRole(auth.RoleAdmin))
+	adminGroup.Get("/users", handlers.ListUsers)
+	adminGroup.Get("/reconcile", handlers.Reconcile)            // ?user_id=, ?fix=true to correct locked balances
+	adminGroup.Post("/balances/adjust", handlers.AdjustBalance) // Credit or debit available funds, recorded in the ledger
+	adminGroup.Post("/symbols/:symbol/halt", handlers.HaltSymbol)
+	adminGroup.Post("/symbols/:symbol/resume", handlers.ResumeSymbol)
+	adminGroup.Get("/book/:symbol/orders", handlers.GetBookOrders) // Live orders of the in-memory book, with their owners
+	adminGroup.Post("/orders/archive", handlers.ArchiveOrders)     // ?older_than=720h, default the configured retention
+	adminGroup.Get("/metrics", handlers.Metrics)                   // WebSocket backpressure: drops, slow disconnects
+	adminGroup.Post("/maintenance/enable", handlers.EnableMaintenance)
+	adminGroup.Post("/maintenance/disable", handlers.DisableMaintenance)
+	adminGroup.Get("/settlement/review", handlers.SettlementReview)           // Trades held after failing to settle, double-applied trades
+	adminGroup.Post("/settlement/review/:id/retry", handlers.RetrySettlement) // Hand a held trade back to the outbox worker
+
+	// TODO: Add other PROTECTED routes here
+
+	handlers.SetReady(true)
+	go func() {
+		var err error
+		if cfg.TLSCertFile != "" {
+			log.Printf("Starting server on %s (TLS)", cfg.ListenAddr)
+			err = app.ListenTLS(cfg.ListenAddr, cfg.TLSCertFile, cfg.TLSKeyFile)
+		} else {
+			log.Printf("Starting server on %s", cfg.ListenAddr)
+			err = app.Listen(cfg.ListenAddr)
+		}
+		if err != nil {
+			log.Fatalf("Server error: %v", err)
+		}
+	}()
+
+	<-ctx.Done()
+	stop() // A second signal kills the process straight away
+	log.Println("Shutting down...")
+	handlers.SetReady(false)
+
+	// Stop accepting connections and let in-flight requests (e.g., order placement) finish
+	if err := app.ShutdownWithTimeout(shutdownTimeout); err != nil {
+		log.Printf("Error shutting down server: %v", err)
+	}
+	// Send WebSocket clients a close frame
+	internalws.GlobalHub.Close()
+	ticker.Stop()
+	// Trades matched before shutdown must be settled before the pool goes away
+	orderbook.GlobalOrderBookManager.WaitForSettlement()
+	// With everything settled the books match the database, so the next start restores them exactly
+	if err := orderbook.GlobalOrderBookManager.SaveSnapshots(context.Background()); err != nil {
+		log.Printf("Error saving order book snapshots: %v", err)
+	}
+	if err := orderbook.GlobalOrderBookManager.CloseEventLog(); err != nil {
+		log.Printf("Error closing order book event log: %v", err)
+	}
+	database.CloseDB()
+	log.Println("Shutdown complete")
 }
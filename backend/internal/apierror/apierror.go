@@ -0,0 +1,140 @@
+// Package apierror renders the API's error responses. Every error response has the same envelope:
+//
+//	{"code": "INSUFFICIENT_FUNDS", "message": "Insufficient USD balance to place order", "details": {...}}
+//
+// code is a stable, machine-readable Code for clients to act on instead of matching the message;
+// message is human-readable; details, when present, carries structured context about the failure.
+// The message is repeated under "error", the key clients read it from before codes existed.
+package apierror
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// Code identifies a kind of error. Codes are part of the API: once published they don't change
+// meaning, and each always comes with the same HTTP status, see Status.
+type Code string
+
+// Generic codes, one per HTTP status, for errors without a more specific code.
+const (
+	BadRequest     Code = "BAD_REQUEST"     // The request is malformed or a parameter is invalid
+	Unauthorized   Code = "UNAUTHORIZED"    // Missing, invalid or expired credentials
+	Forbidden      Code = "FORBIDDEN"       // Authenticated, but not allowed to do this
+	NotFound       Code = "NOT_FOUND"       // The resource doesn't exist (or isn't the caller's)
+	Conflict       Code = "CONFLICT"        // The resource changed or exists already; retrying may help
+	Gone           Code = "GONE"            // The resource existed but no longer does
+	Unprocessable  Code = "UNPROCESSABLE"   // Well-formed, but can't be applied as it stands
+	RateLimited    Code = "RATE_LIMITED"    // Too many requests, see details for when to retry
+	Internal       Code = "INTERNAL"        // Something failed on the server
+	NotImplemented Code = "NOT_IMPLEMENTED" // Valid, but not supported yet
+	Unavailable    Code = "UNAVAILABLE"     // Temporarily unable to serve the request, retry later
+)
+
+// Domain codes.
+const (
+	InsufficientFunds    Code = "INSUFFICIENT_FUNDS"     // Not enough available balance for the request
+	InvalidOrder         Code = "INVALID_ORDER"          // The order fails validation (type, price, size, ...)
+	OrderNotFound        Code = "ORDER_NOT_FOUND"        // No such order of the caller's
+	OrderNotCancellable  Code = "ORDER_NOT_CANCELLABLE"  // The order is filled or cancelled already
+	OrderNotModifiable   Code = "ORDER_NOT_MODIFIABLE"   // The order can't be modified in its state or way
+	OrderWouldCross      Code = "ORDER_WOULD_CROSS"      // A post-only order would have taken liquidity
+	SymbolUnknown        Code = "SYMBOL_UNKNOWN"         // No market trades the symbol
+	SymbolHalted         Code = "SYMBOL_HALTED"          // Trading on the symbol is halted
+	OrderLimitReached    Code = "ORDER_LIMIT_REACHED"    // Too many open orders
+	IdempotencyKeyReused Code = "IDEMPOTENCY_KEY_REUSED" // The Idempotency-Key was used for a different request
+	Timeout              Code = "TIMEOUT"                // The database took too long; nothing was changed
+	Maintenance          Code = "MAINTENANCE"            // The service is under maintenance
+)
+
+// statuses are the HTTP statuses of the codes.
+var statuses = map[Code]int{
+	BadRequest:     fiber.StatusBadRequest,
+	Unauthorized:   fiber.StatusUnauthorized,
+	Forbidden:      fiber.StatusForbidden,
+	NotFound:       fiber.StatusNotFound,
+	Conflict:       fiber.StatusConflict,
+	Gone:           fiber.StatusGone,
+	Unprocessable:  fiber.StatusUnprocessableEntity,
+	RateLimited:    fiber.StatusTooManyRequests,
+	Internal:       fiber.StatusInternalServerError,
+	NotImplemented: fiber.StatusNotImplemented,
+	Unavailable:    fiber.StatusServiceUnavailable,
+
+	InsufficientFunds:    fiber.StatusBadRequest,
+	InvalidOrder:         fiber.StatusBadRequest,
+	OrderNotFound:        fiber.StatusNotFound,
+	OrderNotCancellable:  fiber.StatusBadRequest,
+	OrderNotModifiable:   fiber.StatusBadRequest,
+	OrderWouldCross:      fiber.StatusBadRequest,
+	SymbolUnknown:        fiber.StatusNotFound,
+	SymbolHalted:         fiber.StatusServiceUnavailable,
+	OrderLimitReached:    fiber.StatusBadRequest,
+	IdempotencyKeyReused: fiber.StatusUnprocessableEntity,
+	Timeout:              fiber.StatusServiceUnavailable,
+	Maintenance:          fiber.StatusServiceUnavailable,
+}
+
+// Status returns the HTTP status the code is sent with; 500 for an unknown code.
+func (code Code) Status() int {
+	if status, ok := statuses[code]; ok {
+		return status
+	}
+	return fiber.StatusInternalServerError
+}
+
+// Error is an API error: a Code, the message for the client and optional details.
+// It can be returned up to the handler as an error and sent from there with Respond.
+type Error struct {
+	Code    Code        `json:"code"`
+	Message string      `json:"message"`
+	Details interface{} `json:"details,omitempty"`
+}
+
+// MarshalJSON adds the message's legacy "error" key to the envelope.
+func (e *Error) MarshalJSON() ([]byte, error) {
+	type envelope Error
+	return json.Marshal(struct {
+		*envelope
+		Legacy string `json:"error"`
+	}{(*envelope)(e), e.Message})
+}
+
+// New returns an Error with the given code and message.
+func New(code Code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+// Error returns the message.
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// WithDetails returns a copy of the error carrying details.
+func (e *Error) WithDetails(details interface{}) *Error {
+	copied := *e
+	copied.Details = details
+	return &copied
+}
+
+// Send writes an error response with the code's status.
+func Send(c *fiber.Ctx, code Code, message string) error {
+	return c.Status(code.Status()).JSON(&Error{Code: code, Message: message})
+}
+
+// SendDetails is Send with details.
+func SendDetails(c *fiber.Ctx, code Code, message string, details interface{}) error {
+	return c.Status(code.Status()).JSON(&Error{Code: code, Message: message, Details: details})
+}
+
+// Respond writes err as the response if it is (or wraps) an *Error, and a generic internal
+// error otherwise, so nothing about an unexpected failure leaks to the client.
+func Respond(c *fiber.Ctx, err error) error {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		return c.Status(apiErr.Code.Status()).JSON(apiErr)
+	}
+	return Send(c, Internal, "Internal server error")
+}
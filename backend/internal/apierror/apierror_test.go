@@ -0,0 +1,81 @@
+package apierror
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestCodeStatus(t *testing.T) {
+	tests := []struct {
+		code Code
+		want int
+	}{
+		{BadRequest, fiber.StatusBadRequest},
+		{InsufficientFunds, fiber.StatusBadRequest},
+		{OrderNotFound, fiber.StatusNotFound},
+		{SymbolHalted, fiber.StatusServiceUnavailable},
+		{Code("NO_SUCH_CODE"), fiber.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		if got := tt.code.Status(); got != tt.want {
+			t.Errorf("%s.Status() = %d, want %d", tt.code, got, tt.want)
+		}
+	}
+}
+
+// respond runs handler in a Fiber app and returns the response's status and body.
+func respond(t *testing.T, handler fiber.Handler) (int, map[string]interface{}) {
+	t.Helper()
+	app := fiber.New()
+	app.Get("/", handler)
+	resp, err := app.Test(httptest.NewRequest("GET", "/", nil), -1)
+	if err != nil {
+		t.Fatalf("request: %v", err)
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("reading body: %v", err)
+	}
+	var body map[string]interface{}
+	if err := json.Unmarshal(raw, &body); err != nil {
+		t.Fatalf("decoding %s: %v", raw, err)
+	}
+	return resp.StatusCode, body
+}
+
+func TestSendDetailsEnvelope(t *testing.T) {
+	status, body := respond(t, func(c *fiber.Ctx) error {
+		return SendDetails(c, RateLimited, "Too many requests", fiber.Map{"retry_after": 3})
+	})
+	if status != fiber.StatusTooManyRequests {
+		t.Errorf("status = %d, want %d", status, fiber.StatusTooManyRequests)
+	}
+	if body["code"] != "RATE_LIMITED" || body["message"] != "Too many requests" || body["error"] != "Too many requests" {
+		t.Errorf("body = %v, want code RATE_LIMITED with the message under message and error", body)
+	}
+	if details, _ := body["details"].(map[string]interface{}); details["retry_after"] != float64(3) {
+		t.Errorf("details = %v, want retry_after 3", body["details"])
+	}
+}
+
+func TestRespond(t *testing.T) {
+	wrapped := fmt.Errorf("placing order: %w", New(InsufficientFunds, "Insufficient USD balance"))
+	status, body := respond(t, func(c *fiber.Ctx) error { return Respond(c, wrapped) })
+	if status != fiber.StatusBadRequest || body["code"] != "INSUFFICIENT_FUNDS" || body["message"] != "Insufficient USD balance" {
+		t.Errorf("wrapped *Error: status %d body %v, want 400 INSUFFICIENT_FUNDS", status, body)
+	}
+	if _, ok := body["details"]; ok {
+		t.Errorf("details = %v, want it omitted", body["details"])
+	}
+
+	status, body = respond(t, func(c *fiber.Ctx) error { return Respond(c, fmt.Errorf("connection refused")) })
+	if status != fiber.StatusInternalServerError || body["code"] != "INTERNAL" || body["message"] != "Internal server error" {
+		t.Errorf("plain error: status %d body %v, want 500 INTERNAL without the error's text", status, body)
+	}
+}
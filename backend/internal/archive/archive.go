@@ -0,0 +1,70 @@
+package archive
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/user/minicoinbase/backend/internal/config"
+	"github.com/user/minicoinbase/backend/internal/database"
+)
+
+// batchSize is how many orders one statement archives, keeping each transaction short.
+const batchSize = 1000
+
+// Retention is how old a filled or cancelled order must be before it is archived, set by Init.
+var Retention = 30 * 24 * time.Hour
+
+// interval is how often Start archives, set by Init. 0 disables the background job.
+var interval = time.Hour
+
+// Init configures order archival. Call once at startup.
+func Init(cfg *config.Config) {
+	Retention = cfg.OrderArchiveRetention
+	interval = cfg.OrderArchiveInterval
+}
+
+// Start archives orders older than Retention every configured interval until ctx is done.
+// It does nothing if the interval is 0.
+func Start(ctx context.Context) {
+	if interval <= 0 {
+		return
+	}
+	go loop(ctx)
+}
+
+// loop calls ArchiveOrders every interval until ctx is done.
+func loop(ctx context.Context) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if _, err := ArchiveOrders(ctx, time.Now().Add(-Retention)); err != nil {
+				slog.Error("Failed to archive orders", "err", err)
+			}
+		}
+	}
+}
+
+// ArchiveOrders moves every filled or cancelled order last updated before the given time to the
+// archive, in batches, and returns how many it moved. On error, the batches already moved stay moved.
+func ArchiveOrders(ctx context.Context, before time.Time) (int64, error) {
+	var total int64
+	for {
+		n, err := database.ArchiveOrders(ctx, before, batchSize)
+		total += n
+		if err != nil {
+			return total, err
+		}
+		if n < batchSize {
+			break
+		}
+	}
+	if total > 0 {
+		slog.Info("Archived orders", "count", total, "before", before)
+	}
+	return total, nil
+}
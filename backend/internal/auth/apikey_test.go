@@ -0,0 +1,76 @@
+package auth
+
+import (
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/user/minicoinbase/backend/internal/config"
+)
+
+func TestVerifyAPIRequest(t *testing.T) {
+	const secret = "test-api-secret"
+	body := []byte(`{"symbol":"BTC-USD","side":"buy"}`)
+	stamp := func(offset time.Duration) string {
+		return strconv.FormatInt(time.Now().Add(offset).UnixMilli(), 10)
+	}
+	now := stamp(0)
+	signature := SignAPIRequest(secret, now, "POST", "/api/orders?dry=1", body)
+
+	tests := []struct {
+		name              string
+		secret, timestamp string
+		method, path, sig string
+		body              []byte
+		wantErr           bool
+	}{
+		{"valid", secret, now, "POST", "/api/orders?dry=1", signature, body, false},
+		{"other secret", "other-secret", now, "POST", "/api/orders?dry=1", signature, body, true},
+		{"other method", secret, now, "GET", "/api/orders?dry=1", signature, body, true},
+		{"other query", secret, now, "POST", "/api/orders?dry=0", signature, body, true},
+		{"other body", secret, now, "POST", "/api/orders?dry=1", signature, []byte(`{}`), true},
+		{"malformed timestamp", secret, "yesterday", "POST", "/api/orders?dry=1", signature, body, true},
+	}
+	for _, tt := range tests {
+		err := VerifyAPIRequest(tt.secret, tt.timestamp, tt.method, tt.path, tt.body, tt.sig)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: VerifyAPIRequest error = %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+
+	// Correctly signed, but too far from the server clock either way
+	for _, offset := range []time.Duration{-APIKeyTimestampWindow - time.Second, APIKeyTimestampWindow + time.Second} {
+		timestamp := stamp(offset)
+		signature := SignAPIRequest(secret, timestamp, "GET", "/api/orders", nil)
+		if err := VerifyAPIRequest(secret, timestamp, "GET", "/api/orders", nil, signature); err == nil {
+			t.Errorf("VerifyAPIRequest accepted a timestamp %v off", offset)
+		}
+	}
+	// Within the window is fine
+	timestamp := stamp(-APIKeyTimestampWindow / 2)
+	signature = SignAPIRequest(secret, timestamp, "GET", "/api/orders", nil)
+	if err := VerifyAPIRequest(secret, timestamp, "GET", "/api/orders", nil, signature); err != nil {
+		t.Errorf("VerifyAPIRequest of a timestamp within the window: %v", err)
+	}
+}
+
+func TestAPISecretEncryption(t *testing.T) {
+	Init(&config.Config{JWTSecret: "test-secret", APIKeyEncryptionKey: "test-encryption-key"})
+	_, secret, err := GenerateAPIKey()
+	if err != nil {
+		t.Fatalf("GenerateAPIKey: %v", err)
+	}
+	encrypted, err := EncryptAPISecret(secret)
+	if err != nil {
+		t.Fatalf("EncryptAPISecret: %v", err)
+	}
+	if decrypted, err := DecryptAPISecret(encrypted); err != nil || decrypted != secret {
+		t.Errorf("DecryptAPISecret = %q, %v; want the secret back", decrypted, err)
+	}
+
+	// Under another encryption key the stored secret can't be read
+	Init(&config.Config{JWTSecret: "test-secret", APIKeyEncryptionKey: "other-encryption-key"})
+	if _, err := DecryptAPISecret(encrypted); err == nil {
+		t.Error("DecryptAPISecret succeeded under another encryption key")
+	}
+}
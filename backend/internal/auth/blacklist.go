@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Blacklist stores revoked access tokens by jti until they would have expired anyway.
+// Implementations must be safe for concurrent use; the default is in-memory,
+// a shared store (e.g., Redis) is needed once there is more than one API instance.
+type Blacklist interface {
+	// Revoke blacklists a jti until expiresAt.
+	Revoke(ctx context.Context, jti string, expiresAt time.Time) error
+	// IsRevoked reports whether a jti is blacklisted.
+	IsRevoked(ctx context.Context, jti string) (bool, error)
+}
+
+// TokenBlacklist is the blacklist consulted by middleware.Protected. Replace it at startup to change the store.
+var TokenBlacklist Blacklist = NewMemoryBlacklist()
+
+// MemoryBlacklist is an in-process Blacklist. Entries are dropped once their token has expired.
+type MemoryBlacklist struct {
+	mu      sync.Mutex
+	revoked map[string]time.Time // jti -> token expiry
+}
+
+// NewMemoryBlacklist creates an empty in-memory blacklist.
+func NewMemoryBlacklist() *MemoryBlacklist {
+	return &MemoryBlacklist{revoked: make(map[string]time.Time)}
+}
+
+// Revoke implements Blacklist.
+func (b *MemoryBlacklist) Revoke(ctx context.Context, jti string, expiresAt time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.purgeExpired()
+	b.revoked[jti] = expiresAt
+	return nil
+}
+
+// IsRevoked implements Blacklist.
+func (b *MemoryBlacklist) IsRevoked(ctx context.Context, jti string) (bool, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	expiresAt, ok := b.revoked[jti]
+	return ok && time.Now().Before(expiresAt), nil
+}
+
+// purgeExpired drops entries for tokens that have expired (they are rejected anyway).
+// Must be called with the lock held.
+func (b *MemoryBlacklist) purgeExpired() {
+	now := time.Now()
+	for jti, expiresAt := range b.revoked {
+		if !now.Before(expiresAt) {
+			delete(b.revoked, jti)
+		}
+	}
+}
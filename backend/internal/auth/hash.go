@@ -4,9 +4,12 @@ import (
 	"golang.org/x/crypto/bcrypt"
 )
 
+// Work factor of new password hashes, set by Init. Below bcrypt.MinCost bcrypt uses its default.
+var passwordCost = bcrypt.DefaultCost
+
 // HashPassword generates a bcrypt hash of the password.
 func HashPassword(password string) (string, error) {
-	hashedBytes, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	hashedBytes, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost)
 	if err != nil {
 		return "", err
 	}
@@ -18,3 +21,10 @@ func CheckPasswordHash(password, hash string) bool {
 	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password))
 	return err == nil
 }
+
+// PasswordNeedsRehash reports whether a stored hash was made at a lower cost than new hashes
+// are, so the password should be hashed again the next time it's known (on login).
+func PasswordNeedsRehash(hash string) bool {
+	cost, err := bcrypt.Cost([]byte(hash))
+	return err == nil && cost < passwordCost
+}
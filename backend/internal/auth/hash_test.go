@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"testing"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestPasswordNeedsRehash(t *testing.T) {
+	defer func(cost int) { passwordCost = cost }(passwordCost)
+	passwordCost = bcrypt.MinCost
+
+	old, err := HashPassword("Hash-Test-1")
+	if err != nil {
+		t.Fatalf("HashPassword: %v", err)
+	}
+	if PasswordNeedsRehash(old) {
+		t.Errorf("hash at the configured cost needs rehash")
+	}
+
+	passwordCost = bcrypt.MinCost + 1
+	if !PasswordNeedsRehash(old) {
+		t.Errorf("hash below the configured cost doesn't need rehash")
+	}
+	upgraded, err := HashPassword("Hash-Test-1")
+	if err != nil {
+		t.Fatalf("HashPassword: %v", err)
+	}
+	if cost, _ := bcrypt.Cost([]byte(upgraded)); cost != bcrypt.MinCost+1 {
+		t.Errorf("new hash has cost %d, want %d", cost, bcrypt.MinCost+1)
+	}
+	if !CheckPasswordHash("Hash-Test-1", old) || !CheckPasswordHash("Hash-Test-1", upgraded) {
+		t.Errorf("password doesn't match its hashes")
+	}
+
+	if PasswordNeedsRehash("not a bcrypt hash") {
+		t.Errorf("unparseable hash needs rehash")
+	}
+}
@@ -2,44 +2,67 @@ package auth
 
 import (
 	"fmt"
-	"os"
 	"time"
 
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
+	"github.com/user/minicoinbase/backend/internal/config"
 )
 
-// TODO: Move secret key to configuration/environment variable!
-var jwtSecret = []byte(getJwtSecret())
+// Signing key for JWTs, set by Init.
+var jwtSecret []byte
+
+// AccessTokenTTL is the lifetime of an access token. Clients renew it with a refresh token.
+var AccessTokenTTL = 15 * time.Minute
+
+// Issuer and audience of access tokens, set by Init. ValidateJWT only accepts tokens with
+// both, so a token minted for another service sharing the secret can't be replayed here.
+var (
+	jwtIssuer   = "minicoinbase"
+	jwtAudience = "minicoinbase-api"
+)
+
+// Init configures token signing, token lifetimes, password hashing and API secret encryption.
+// Call once at startup.
+func Init(cfg *config.Config) {
+	jwtSecret = []byte(cfg.JWTSecret)
+	AccessTokenTTL = cfg.AccessTokenTTL
+	jwtIssuer = cfg.JWTIssuer
+	jwtAudience = cfg.JWTAudience
+	RefreshTokenTTL = cfg.RefreshTokenTTL
+	apiKeyCipher = newAPIKeyCipher(cfg.APIKeyEncryptionKey)
+	passwordCost = cfg.BcryptCost
+}
+
+// User roles carried in Claims.
+const (
+	RoleUser  = "user"
+	RoleAdmin = "admin"
+)
 
 // Claims defines the structure of the JWT payload
 type Claims struct {
 	UserID   uuid.UUID `json:"user_id"`
 	Username string    `json:"username"`
+	Role     string    `json:"role"` // RoleUser or RoleAdmin
 	jwt.RegisteredClaims
 }
 
-func getJwtSecret() string {
-	secret := os.Getenv("JWT_SECRET")
-	if secret == "" {
-		fmt.Println("WARNING: JWT_SECRET environment variable not set. Using default insecure secret.")
-		return "!!REPLACE_THIS_WITH_A_STRONG_SECRET_KEY!!"
-	}
-	return secret
-}
-
-// GenerateJWT creates a new JWT for a given user ID and username.
-func GenerateJWT(userID uuid.UUID, username string) (string, error) {
-	// Token expires in 24 hours
-	expirationTime := time.Now().Add(24 * time.Hour)
+// GenerateJWT creates a new JWT for a given user ID, username and role.
+func GenerateJWT(userID uuid.UUID, username string, role string) (string, error) {
+	// Short-lived, renewed via the refresh endpoint
+	expirationTime := time.Now().Add(AccessTokenTTL)
 
 	claims := &Claims{
 		UserID:   userID,
 		Username: username,
+		Role:     role,
 		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        uuid.NewString(), // jti, used to revoke this token on logout
 			ExpiresAt: jwt.NewNumericDate(expirationTime),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
-			Issuer:    "minicoinbase", // Optional: identifies the issuer
+			Issuer:    jwtIssuer,
+			Audience:  jwt.ClaimStrings{jwtAudience},
 		},
 	}
 
@@ -59,7 +82,7 @@ func ValidateJWT(tokenString string) (*Claims, error) {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
 		return jwtSecret, nil
-	})
+	}, jwt.WithIssuer(jwtIssuer), jwt.WithAudience(jwtAudience), jwt.WithExpirationRequired())
 
 	if err != nil {
 		return nil, err // Handles expiration, invalid signature, etc.
@@ -69,5 +92,17 @@ func ValidateJWT(tokenString string) (*Claims, error) {
 		return nil, fmt.Errorf("invalid token")
 	}
 
+	if claims.Role == "" {
+		claims.Role = RoleUser // Tokens issued before roles existed
+	}
+
 	return claims, nil
 }
+
+// RemainingTTL returns how long the token of claims is still valid, or 0 if it has expired.
+func (c *Claims) RemainingTTL() time.Duration {
+	if c.ExpiresAt == nil {
+		return 0
+	}
+	return max(time.Until(c.ExpiresAt.Time), 0)
+}
@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/user/minicoinbase/backend/internal/config"
+)
+
+func TestValidateJWTChecksIssuerAndAudience(t *testing.T) {
+	cfg := &config.Config{JWTSecret: "test-secret", AccessTokenTTL: time.Minute, JWTIssuer: "minicoinbase", JWTAudience: "trading-api"}
+	Init(cfg)
+	token, err := GenerateJWT(uuid.New(), "alice", RoleUser)
+	if err != nil {
+		t.Fatalf("GenerateJWT: %v", err)
+	}
+	claims, err := ValidateJWT(token)
+	if err != nil {
+		t.Fatalf("ValidateJWT of a fresh token: %v", err)
+	}
+	if ttl := claims.RemainingTTL(); ttl <= 0 || ttl > time.Minute {
+		t.Errorf("RemainingTTL = %v, want within (0, 1m]", ttl)
+	}
+
+	// The same secret, but a token meant for another service or from another issuer
+	other := *cfg
+	other.JWTAudience = "admin-api"
+	Init(&other)
+	if _, err := ValidateJWT(token); err == nil {
+		t.Error("ValidateJWT accepted a token for another audience")
+	}
+	other = *cfg
+	other.JWTIssuer = "someone-else"
+	Init(&other)
+	if _, err := ValidateJWT(token); err == nil {
+		t.Error("ValidateJWT accepted a token from another issuer")
+	}
+}
@@ -0,0 +1,39 @@
+package auth
+
+import (
+	"errors"
+	"unicode"
+)
+
+// MinPasswordLength is the minimum number of characters in a password.
+const MinPasswordLength = 8
+
+// Password policy violations returned by ValidatePassword.
+var (
+	ErrPasswordTooShort = errors.New("password must be at least 8 characters long")
+	ErrPasswordTooWeak  = errors.New("password must contain an uppercase letter, a lowercase letter and a digit")
+)
+
+// ValidatePassword checks a password against the complexity policy:
+// at least MinPasswordLength characters, with upper and lower case letters and a digit.
+func ValidatePassword(password string) error {
+	if len([]rune(password)) < MinPasswordLength {
+		return ErrPasswordTooShort
+	}
+
+	var hasUpper, hasLower, hasDigit bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		}
+	}
+	if !hasUpper || !hasLower || !hasDigit {
+		return ErrPasswordTooWeak
+	}
+	return nil
+}
@@ -0,0 +1,29 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"time"
+)
+
+// RefreshTokenTTL is how long a refresh token can be used to get new access tokens. Set by Init.
+var RefreshTokenTTL = 30 * 24 * time.Hour
+
+// GenerateRefreshToken creates a new opaque refresh token.
+// Only its hash (see HashRefreshToken) should be stored server-side.
+func GenerateRefreshToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashRefreshToken returns the hex encoded SHA-256 of a refresh token, as stored in the database.
+// Refresh tokens are random and high-entropy, so a fast hash is enough (unlike passwords).
+func HashRefreshToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
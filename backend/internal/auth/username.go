@@ -0,0 +1,42 @@
+package auth
+
+import (
+	"errors"
+	"strings"
+)
+
+// Username length limits, in characters.
+const (
+	MinUsernameLength = 3
+	MaxUsernameLength = 32
+)
+
+// Username format violations returned by ValidateUsername.
+var (
+	ErrUsernameLength  = errors.New("username must be between 3 and 32 characters long")
+	ErrUsernameInvalid = errors.New("username may only contain lowercase letters, digits, '_', '.' and '-', and must start with a letter or digit")
+)
+
+// NormalizeUsername returns the canonical form of a username: trimmed and lowercased, so
+// "Alice" and " alice" name the same account. Usernames are normalized before they are
+// validated, stored or looked up.
+func NormalizeUsername(username string) string {
+	return strings.ToLower(strings.TrimSpace(username))
+}
+
+// ValidateUsername checks a normalized username against the format policy: MinUsernameLength to
+// MaxUsernameLength ASCII lowercase letters, digits, '_', '.' and '-', starting with a letter or digit.
+func ValidateUsername(username string) error {
+	if len(username) < MinUsernameLength || len(username) > MaxUsernameLength {
+		return ErrUsernameLength
+	}
+	for i, r := range username {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+		case (r == '_' || r == '.' || r == '-') && i > 0:
+		default:
+			return ErrUsernameInvalid
+		}
+	}
+	return nil
+}
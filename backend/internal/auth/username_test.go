@@ -0,0 +1,28 @@
+package auth
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestValidateUsername(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want error
+	}{
+		{"alice", nil},
+		{"  Alice.Smith-2 ", nil}, // Normalized first
+		{"bob_42", nil},
+		{"al", ErrUsernameLength},
+		{"a234567890123456789012345678901234", ErrUsernameLength},
+		{"_alice", ErrUsernameInvalid},
+		{"ali ce", ErrUsernameInvalid},
+		{"alicé", ErrUsernameInvalid},
+		{"alice@example.com", ErrUsernameInvalid},
+	}
+	for _, tt := range tests {
+		if err := ValidateUsername(NormalizeUsername(tt.raw)); !errors.Is(err, tt.want) {
+			t.Errorf("ValidateUsername(NormalizeUsername(%q)) = %v, want %v", tt.raw, err, tt.want)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/user/minicoinbase/backend/internal/models"
+)
+
+// CreateAPIKey inserts a new API key. Its ID and CreatedAt are set from the database.
+func CreateAPIKey(ctx context.Context, key *models.APIKey) error {
+	query := `INSERT INTO api_keys (user_id, key_id, secret_encrypted, scope) VALUES ($1, $2, $3, $4)
+			  RETURNING id, created_at`
+
+	err := DB.QueryRow(ctx, query, key.UserID, key.KeyID, key.SecretEncrypted, key.Scope).
+		Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		if _, ok := uniqueViolation(err); ok {
+			err = ErrDuplicateKey // Key IDs are random, so this is a (very unlikely) collision
+		}
+		return fmt.Errorf("error creating API key for user %s: %w", key.UserID, err)
+	}
+	return nil
+}
+
+// GetAPIKeyByKeyID retrieves an API key, including its encrypted secret, by its public key ID.
+// Returns nil, nil if no such key exists.
+func GetAPIKeyByKeyID(ctx context.Context, keyID string) (*models.APIKey, error) {
+	key := &models.APIKey{}
+	query := `SELECT id, user_id, key_id, secret_encrypted, scope, created_at FROM api_keys WHERE key_id = $1`
+
+	err := DB.QueryRow(ctx, query, keyID).
+		Scan(&key.ID, &key.UserID, &key.KeyID, &key.SecretEncrypted, &key.Scope, &key.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil // Key not found
+		}
+		return nil, fmt.Errorf("error retrieving API key %s: %w", keyID, err)
+	}
+	return key, nil
+}
+
+// GetUserAPIKeys lists a user's API keys, newest first (without secrets).
+func GetUserAPIKeys(ctx context.Context, userID uuid.UUID) ([]*models.APIKey, error) {
+	keys := make([]*models.APIKey, 0)
+	query := `SELECT id, user_id, key_id, scope, created_at FROM api_keys WHERE user_id = $1 ORDER BY created_at DESC`
+
+	rows, err := DB.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying API keys for user %s: %w", userID, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		key := &models.APIKey{}
+		if err := rows.Scan(&key.ID, &key.UserID, &key.KeyID, &key.Scope, &key.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning API key row for user %s: %w", userID, err)
+		}
+		keys = append(keys, key)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error iterating API key rows for user %s: %w", userID, rows.Err())
+	}
+	return keys, nil
+}
+
+// DeleteAPIKey deletes one of a user's API keys. Returns false if the user has no such key.
+func DeleteAPIKey(ctx context.Context, userID uuid.UUID, id uuid.UUID) (bool, error) {
+	cmdTag, err := DB.Exec(ctx, `DELETE FROM api_keys WHERE id = $1 AND user_id = $2`, id, userID)
+	if err != nil {
+		return false, fmt.Errorf("error deleting API key %s: %w", id, err)
+	}
+	return cmdTag.RowsAffected() == 1, nil
+}
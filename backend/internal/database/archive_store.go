@@ -0,0 +1,35 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// archivedOrderColumns are the columns copied from orders to orders_archive, see migrations 0016 to 0027.
+const archivedOrderColumns = `id, user_id, symbol, type, side, price, stop_price, time_in_force, post_only,
+					  quantity, original_quantity, filled_quantity, avg_fill_price, status, created_at, updated_at, expires_at, session_id, quote_quantity,
+					  locked_amount, locked_asset, display_quantity, protection_price`
+
+// ArchiveOrders moves up to limit orders in a final state (filled or cancelled) that were last
+// updated before the given time from orders to orders_archive, oldest first, and returns how many
+// it moved. Move and delete happen in one statement, so an order is never in both tables or neither.
+// Rows locked by a concurrent transaction are skipped and picked up by a later run.
+func ArchiveOrders(ctx context.Context, before time.Time, limit int) (int64, error) {
+	query := `WITH moved AS (
+				DELETE FROM orders
+				WHERE id IN (SELECT id FROM orders
+							 WHERE status IN ('filled', 'cancelled') AND updated_at < $1
+							 ORDER BY updated_at LIMIT $2
+							 FOR UPDATE SKIP LOCKED)
+				RETURNING ` + archivedOrderColumns + `
+			  )
+			  INSERT INTO orders_archive (` + archivedOrderColumns + `)
+			  SELECT ` + archivedOrderColumns + ` FROM moved`
+
+	cmdTag, err := DB.Exec(ctx, query, before, limit)
+	if err != nil {
+		return 0, fmt.Errorf("error archiving orders last updated before %s: %w", before.Format(time.RFC3339), err)
+	}
+	return cmdTag.RowsAffected(), nil
+}
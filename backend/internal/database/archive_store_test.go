@@ -0,0 +1,109 @@
+package database
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/user/minicoinbase/backend/internal/config"
+)
+
+func TestArchiveOrdersMovesOnlyOldFinalOrders(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set, skipping database test")
+	}
+	ctx := context.Background()
+	if err := InitDB(ctx, &config.Config{DatabaseURL: dsn}); err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	t.Cleanup(CloseDB)
+
+	user, err := CreateUser(ctx, "test_"+uuid.NewString()[:8], "x")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	// Inserted with an old updated_at directly, which the update trigger would otherwise overwrite;
+	// far enough in the past that no other test's orders are archived along with them
+	longAgo := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC)
+	insert := func(status string) uuid.UUID {
+		var id uuid.UUID
+		err := DB.QueryRow(ctx, `INSERT INTO orders (user_id, symbol, type, side, price, quantity, original_quantity, status, created_at, updated_at)
+								 VALUES ($1, 'BTC-USD', 'limit', 'buy', 100, 1, 1, $2, $3, $3) RETURNING id`,
+			user.ID, status, longAgo).Scan(&id)
+		if err != nil {
+			t.Fatalf("insert %s order: %v", status, err)
+		}
+		return id
+	}
+	filled, cancelled, open := insert("filled"), insert("cancelled"), insert("open")
+
+	if _, err := ArchiveOrders(ctx, longAgo.Add(time.Hour), 1000); err != nil {
+		t.Fatalf("ArchiveOrders: %v", err)
+	}
+
+	for _, id := range []uuid.UUID{filled, cancelled} {
+		if order, err := GetOrderByID(ctx, id); err != nil || order != nil {
+			t.Errorf("order %s still in orders (err %v)", id, err)
+		}
+	}
+	if order, err := GetOrderByID(ctx, open); err != nil || order == nil {
+		t.Errorf("open order %s was archived (err %v)", open, err)
+	}
+
+	hot, total, err := GetUserOrders(ctx, user.ID, OrderFilter{IncludeCancelled: true, Limit: 10})
+	if err != nil || total != 1 || len(hot) != 1 {
+		t.Errorf("GetUserOrders = %d orders, total %d, err %v; want only the open one", len(hot), total, err)
+	}
+	all, total, err := GetUserOrders(ctx, user.ID, OrderFilter{IncludeCancelled: true, IncludeArchive: true, Limit: 10})
+	if err != nil || total != 3 || len(all) != 3 {
+		t.Errorf("GetUserOrders with the archive = %d orders, total %d, err %v; want 3", len(all), total, err)
+	}
+}
+
+func TestGetUserOrdersByStatuses(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set, skipping database test")
+	}
+	ctx := context.Background()
+	if err := InitDB(ctx, &config.Config{DatabaseURL: dsn}); err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	t.Cleanup(CloseDB)
+
+	user, err := CreateUser(ctx, "test_"+uuid.NewString()[:8], "x")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	// Created in one order and last updated in the reverse one
+	now := time.Now()
+	insert := func(status string, created, updated time.Time) uuid.UUID {
+		var id uuid.UUID
+		err := DB.QueryRow(ctx, `INSERT INTO orders (user_id, symbol, type, side, price, quantity, original_quantity, status, created_at, updated_at)
+								 VALUES ($1, 'BTC-USD', 'limit', 'buy', 100, 1, 1, $2, $3, $4) RETURNING id`,
+			user.ID, status, created, updated).Scan(&id)
+		if err != nil {
+			t.Fatalf("insert %s order: %v", status, err)
+		}
+		return id
+	}
+	filled := insert("filled", now.Add(-3*time.Hour), now.Add(-time.Minute))
+	cancelled := insert("cancelled", now.Add(-2*time.Hour), now.Add(-2*time.Minute))
+	insert("open", now.Add(-time.Hour), now.Add(-time.Hour))
+
+	history, total, err := GetUserOrders(ctx, user.ID, OrderFilter{Statuses: []string{"filled", "cancelled"}, ByUpdateTime: true, Limit: 10})
+	if err != nil || total != 2 || len(history) != 2 {
+		t.Fatalf("history = %d orders, total %d, err %v; want the filled and cancelled ones", len(history), total, err)
+	}
+	if history[0].ID != filled || history[1].ID != cancelled {
+		t.Errorf("history = %s, %s; want the filled order (updated last) first", history[0].ID, history[1].ID)
+	}
+
+	active, total, err := GetUserOrders(ctx, user.ID, OrderFilter{Statuses: []string{"open", "partially_filled"}, Limit: 10})
+	if err != nil || total != 1 || len(active) != 1 || active[0].Status != "open" {
+		t.Errorf("active = %d orders, total %d, err %v; want only the open one", len(active), total, err)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"errors"
 	"fmt"
 	"log"
+	"math"
+	"sort"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
@@ -15,11 +17,11 @@ import (
 // Returns nil, nil if the balance record doesn't exist.
 func GetBalance(ctx context.Context, userID uuid.UUID, asset string) (*models.Balance, error) {
 	balance := &models.Balance{}
-	query := `SELECT user_id, asset, available, locked, updated_at
+	query := `SELECT user_id, asset, available, locked, version, updated_at
 			  FROM balances WHERE user_id = $1 AND asset = $2`
 
 	err := DB.QueryRow(ctx, query, userID, asset).
-		Scan(&balance.UserID, &balance.Asset, &balance.Available, &balance.Locked, &balance.UpdatedAt)
+		Scan(&balance.UserID, &balance.Asset, &balance.Available, &balance.Locked, &balance.Version, &balance.UpdatedAt)
 
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -72,7 +74,7 @@ func GetOrCreateBalance(ctx context.Context, userID uuid.UUID, asset string) (*m
 // GetUserBalances retrieves all balances for a given user.
 func GetUserBalances(ctx context.Context, userID uuid.UUID) ([]*models.Balance, error) {
 	balances := make([]*models.Balance, 0)
-	query := `SELECT user_id, asset, available, locked, updated_at
+	query := `SELECT user_id, asset, available, locked, version, updated_at
 			  FROM balances WHERE user_id = $1 ORDER BY asset`
 
 	rows, err := DB.Query(ctx, query, userID)
@@ -83,7 +85,7 @@ func GetUserBalances(ctx context.Context, userID uuid.UUID) ([]*models.Balance,
 
 	for rows.Next() {
 		balance := &models.Balance{}
-		err := rows.Scan(&balance.UserID, &balance.Asset, &balance.Available, &balance.Locked, &balance.UpdatedAt)
+		err := rows.Scan(&balance.UserID, &balance.Asset, &balance.Available, &balance.Locked, &balance.Version, &balance.UpdatedAt)
 		if err != nil {
 			return nil, fmt.Errorf("error scanning balance row for user %s: %w", userID, err)
 		}
@@ -97,12 +99,65 @@ func GetUserBalances(ctx context.Context, userID uuid.UUID) ([]*models.Balance,
 	return balances, nil
 }
 
+// BalanceKey identifies one balance row.
+type BalanceKey struct {
+	UserID uuid.UUID
+	Asset  string
+}
+
+// Lock ordering: a transaction that locks more than one row takes its order rows first
+// (see LockOrders), then its balance rows, then its position rows (see LockPositions), each in
+// ascending key order: orders by id, balances by user id then asset, positions by user id.
+// Two transactions following this order can't deadlock.
+// Functions that touch a single balance row (LockFunds, UnlockFunds, AddFunds) are safe
+// to call once the caller holds its locks, or as the only balance change of a transaction.
+
+// LockBalances locks the given balance rows (FOR UPDATE) in lock order, creating missing
+// ones with zero funds. Duplicate keys are fine. Requires an active transaction (tx).
+func LockBalances(ctx context.Context, tx pgx.Tx, keys ...BalanceKey) error {
+	sorted := append([]BalanceKey(nil), keys...)
+	sort.Slice(sorted, func(i, j int) bool {
+		// Canonical UUID strings sort the same way as PostgreSQL compares uuid values
+		ui, uj := sorted[i].UserID.String(), sorted[j].UserID.String()
+		if ui != uj {
+			return ui < uj
+		}
+		return sorted[i].Asset < sorted[j].Asset
+	})
+
+	for i, key := range sorted {
+		if i > 0 && key == sorted[i-1] {
+			continue
+		}
+		// Inserting a row locks it as well, so either statement leaves it locked
+		query := `INSERT INTO balances (user_id, asset, available, locked) VALUES ($1, $2, 0, 0)
+				  ON CONFLICT (user_id, asset) DO NOTHING`
+		if _, err := tx.Exec(ctx, query, key.UserID, key.Asset); err != nil {
+			return fmt.Errorf("error creating balance for user %s asset %s: %w", key.UserID, key.Asset, err)
+		}
+		query = `SELECT 1 FROM balances WHERE user_id = $1 AND asset = $2 FOR UPDATE`
+		if _, err := tx.Exec(ctx, query, key.UserID, key.Asset); err != nil {
+			return fmt.Errorf("error locking balance for user %s asset %s: %w", key.UserID, key.Asset, err)
+		}
+	}
+	return nil
+}
+
+// checkAmount rejects an amount to move that is not positive, or not a number at all: NaN and
+// infinities would pass the SQL balance checks in surprising ways and poison the balance.
+func checkAmount(op string, amount float64) error {
+	if !(amount > 0) || math.IsInf(amount, 0) {
+		return fmt.Errorf("%s amount must be positive and finite, got %v", op, amount)
+	}
+	return nil
+}
+
 // LockFunds decreases available balance and increases locked balance for an asset.
 // Requires an active transaction (tx) and checks for sufficient available funds.
-func LockFunds(ctx context.Context, tx pgx.Tx, userID uuid.UUID, asset string, amount float64) error {
-	// Ensure amount is positive
-	if amount <= 0 {
-		return fmt.Errorf("lock amount must be positive")
+// The change is recorded in the ledger under ref.
+func LockFunds(ctx context.Context, tx pgx.Tx, userID uuid.UUID, asset string, amount float64, ref LedgerRef) error {
+	if err := checkAmount("lock", amount); err != nil {
+		return err
 	}
 
 	query := `UPDATE balances
@@ -120,25 +175,24 @@ func LockFunds(ctx context.Context, tx pgx.Tx, userID uuid.UUID, asset string, a
 		// Note: This query runs within the SAME transaction tx
 		currBalance, getErr := GetBalanceInTx(ctx, tx, userID, asset)
 		if getErr != nil {
-			return fmt.Errorf("insufficient funds for user %s asset %s (balance check failed: %w)", userID, asset, getErr)
+			return fmt.Errorf("%w for user %s asset %s (balance check failed: %w)", ErrInsufficientFunds, userID, asset, getErr)
 		}
 		if currBalance == nil {
-			return fmt.Errorf("insufficient funds for user %s asset %s (balance not found)", userID, asset)
+			return fmt.Errorf("%w for user %s asset %s (balance not found)", ErrInsufficientFunds, userID, asset)
 		}
-		return fmt.Errorf("insufficient funds for user %s asset %s (available: %f, required: %f)",
-			userID, asset, currBalance.Available, amount)
+		return fmt.Errorf("%w for user %s asset %s (available: %f, required: %f)",
+			ErrInsufficientFunds, userID, asset, currBalance.Available, amount)
 	}
 
-	return nil
+	return recordLedger(ctx, tx, userID, asset, -amount, amount, ref)
 }
 
 // UnlockFunds increases available balance and decreases locked balance.
 // Typically used when an order is cancelled or partially filled.
-// Requires an active transaction (tx).
-func UnlockFunds(ctx context.Context, tx pgx.Tx, userID uuid.UUID, asset string, amount float64) error {
-	// Ensure amount is positive
-	if amount <= 0 {
-		return fmt.Errorf("unlock amount must be positive")
+// Requires an active transaction (tx). The change is recorded in the ledger under ref.
+func UnlockFunds(ctx context.Context, tx pgx.Tx, userID uuid.UUID, asset string, amount float64, ref LedgerRef) error {
+	if err := checkAmount("unlock", amount); err != nil {
+		return err
 	}
 
 	query := `UPDATE balances
@@ -156,16 +210,30 @@ func UnlockFunds(ctx context.Context, tx pgx.Tx, userID uuid.UUID, asset string,
 			userID, asset, amount)
 	}
 
-	return nil
+	return recordLedger(ctx, tx, userID, asset, amount, -amount, ref)
 }
 
 // UpdateBalances adjusts available/locked funds after an order fill.
 // Requires an active transaction (tx).
-// For a buy fill: decrease quote locked, increase base available.
-// For a sell fill: decrease base locked, increase quote available.
-func UpdateBalancesForFill(ctx context.Context, tx pgx.Tx, userID uuid.UUID, baseAsset, quoteAsset string, baseAmount, quoteAmount float64, side string) error {
-	var err error
+// For a buy fill: decrease quote locked, increase base available by baseAmount minus fee.
+// For a sell fill: decrease base locked, increase quote available by quoteAmount minus fee.
+// The fee is charged on the received asset; crediting it elsewhere is up to the caller.
+// The ledger gets the spent and received amounts under ref and the fee as a separate LedgerFee entry.
+func UpdateBalancesForFill(ctx context.Context, tx pgx.Tx, userID uuid.UUID, baseAsset, quoteAsset string, baseAmount, quoteAmount, fee float64, side string, ref LedgerRef) error {
+	for _, amount := range []float64{baseAmount, quoteAmount, fee} {
+		if math.IsNaN(amount) || math.IsInf(amount, 0) {
+			return fmt.Errorf("fill amounts must be finite, got base %v quote %v fee %v", baseAmount, quoteAmount, fee)
+		}
+	}
+	// Buys and sells touch the two rows in opposite order below, so take both locks in lock order first
+	err := LockBalances(ctx, tx, BalanceKey{userID, baseAsset}, BalanceKey{userID, quoteAsset})
+	if err != nil {
+		return err
+	}
+	var spentAsset, receivedAsset string
+	var spent, received float64
 	if side == "buy" {
+		spentAsset, spent, receivedAsset, received = quoteAsset, quoteAmount, baseAsset, baseAmount
 		// Decrease locked quote asset (amount spent)
 		query1 := `UPDATE balances SET locked = locked - $1 WHERE user_id = $2 AND asset = $3 AND locked >= $1`
 		cmdTag1, err1 := tx.Exec(ctx, query1, quoteAmount, userID, quoteAsset)
@@ -179,12 +247,13 @@ func UpdateBalancesForFill(ctx context.Context, tx pgx.Tx, userID uuid.UUID, bas
 		// Increase available base asset (amount bought)
 		query2 := `INSERT INTO balances (user_id, asset, available, locked) VALUES ($1, $2, $3, 0)
 				   ON CONFLICT (user_id, asset) DO UPDATE SET available = balances.available + $3`
-		_, err = tx.Exec(ctx, query2, userID, baseAsset, baseAmount)
+		_, err = tx.Exec(ctx, query2, userID, baseAsset, baseAmount-fee)
 		if err != nil {
 			return fmt.Errorf("buy fill: failed to increase available %s: %w", baseAsset, err)
 		}
 
 	} else if side == "sell" {
+		spentAsset, spent, receivedAsset, received = baseAsset, baseAmount, quoteAsset, quoteAmount
 		// Decrease locked base asset (amount sold)
 		query1 := `UPDATE balances SET locked = locked - $1 WHERE user_id = $2 AND asset = $3 AND locked >= $1`
 		cmdTag1, err1 := tx.Exec(ctx, query1, baseAmount, userID, baseAsset)
@@ -198,24 +267,106 @@ func UpdateBalancesForFill(ctx context.Context, tx pgx.Tx, userID uuid.UUID, bas
 		// Increase available quote asset (amount received)
 		query2 := `INSERT INTO balances (user_id, asset, available, locked) VALUES ($1, $2, $3, 0)
 				   ON CONFLICT (user_id, asset) DO UPDATE SET available = balances.available + $3`
-		_, err = tx.Exec(ctx, query2, userID, quoteAsset, quoteAmount)
+		_, err = tx.Exec(ctx, query2, userID, quoteAsset, quoteAmount-fee)
 		if err != nil {
 			return fmt.Errorf("sell fill: failed to increase available %s: %w", quoteAsset, err)
 		}
 	} else {
 		return fmt.Errorf("invalid side for fill update: %s", side)
 	}
+
+	if err := recordLedger(ctx, tx, userID, spentAsset, 0, -spent, ref); err != nil {
+		return err
+	}
+	if err := recordLedger(ctx, tx, userID, receivedAsset, received, 0, ref); err != nil {
+		return err
+	}
+	if fee > 0 {
+		feeRef := ref
+		feeRef.Reason = LedgerFee
+		return recordLedger(ctx, tx, userID, receivedAsset, -fee, 0, feeRef)
+	}
 	return nil
 }
 
+// AddFunds increases available balance, creating the balance row if needed.
+// Requires an active transaction (tx). The change is recorded in the ledger under ref.
+func AddFunds(ctx context.Context, tx pgx.Tx, userID uuid.UUID, asset string, amount float64, ref LedgerRef) error {
+	if err := checkAmount("add", amount); err != nil {
+		return err
+	}
+
+	query := `INSERT INTO balances (user_id, asset, available, locked) VALUES ($1, $2, $3, 0)
+			  ON CONFLICT (user_id, asset) DO UPDATE SET available = balances.available + $3`
+
+	if _, err := tx.Exec(ctx, query, userID, asset, amount); err != nil {
+		return fmt.Errorf("error adding funds for user %s asset %s: %w", userID, asset, err)
+	}
+	return recordLedger(ctx, tx, userID, asset, amount, 0, ref)
+}
+
+// SubtractFunds decreases available balance, checking for sufficient available funds.
+// Requires an active transaction (tx). The change is recorded in the ledger under ref.
+func SubtractFunds(ctx context.Context, tx pgx.Tx, userID uuid.UUID, asset string, amount float64, ref LedgerRef) error {
+	if err := checkAmount("subtract", amount); err != nil {
+		return err
+	}
+
+	query := `UPDATE balances SET available = available - $1
+			  WHERE user_id = $2 AND asset = $3 AND available >= $1`
+
+	cmdTag, err := tx.Exec(ctx, query, amount, userID, asset)
+	if err != nil {
+		return fmt.Errorf("error subtracting funds for user %s asset %s: %w", userID, asset, err)
+	}
+	if cmdTag.RowsAffected() != 1 {
+		return fmt.Errorf("%w for user %s asset %s (required: %f)", ErrInsufficientFunds, userID, asset, amount)
+	}
+	return recordLedger(ctx, tx, userID, asset, -amount, 0, ref)
+}
+
+// AdjustBalance credits (positive delta) or debits (negative delta) a user's available balance
+// on behalf of an admin and returns the balance it leaves. The change is recorded in the ledger
+// as an admin_adjustment with the admin's ID and note. A debit fails with ErrInsufficientFunds,
+// changing nothing, if it would take available below zero.
+func AdjustBalance(ctx context.Context, userID uuid.UUID, asset string, delta float64, adminID uuid.UUID, note string) (*models.Balance, error) {
+	tx, err := DB.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error beginning balance adjustment transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	if err := LockBalances(ctx, tx, BalanceKey{UserID: userID, Asset: asset}); err != nil {
+		return nil, err
+	}
+	ref := LedgerRef{Reason: LedgerAdminAdjust, ActorID: adminID, Note: note}
+	if delta > 0 {
+		err = AddFunds(ctx, tx, userID, asset, delta, ref)
+	} else {
+		err = SubtractFunds(ctx, tx, userID, asset, -delta, ref)
+	}
+	if err != nil {
+		return nil, err
+	}
+	balance, err := GetBalanceInTx(ctx, tx, userID, asset)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("error committing balance adjustment for user %s asset %s: %w", userID, asset, err)
+	}
+	return balance, nil
+}
+
 // GetBalanceInTx retrieves a balance within a specific transaction.
 func GetBalanceInTx(ctx context.Context, tx pgx.Tx, userID uuid.UUID, asset string) (*models.Balance, error) {
 	balance := &models.Balance{}
-	query := `SELECT user_id, asset, available, locked, updated_at
+	query := `SELECT user_id, asset, available, locked, version, updated_at
 			  FROM balances WHERE user_id = $1 AND asset = $2 FOR UPDATE` // Lock row within transaction
 
 	err := tx.QueryRow(ctx, query, userID, asset).
-		Scan(&balance.UserID, &balance.Asset, &balance.Available, &balance.Locked, &balance.UpdatedAt)
+		Scan(&balance.UserID, &balance.Asset, &balance.Available, &balance.Locked, &balance.Version, &balance.UpdatedAt)
 
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -262,22 +413,3 @@ func GetOrCreateBalanceInTx(ctx context.Context, tx pgx.Tx, userID uuid.UUID, as
 	}
 	return newBalance, nil
 }
-
-// TODO: Implement functions for updating balances (e.g., LockFunds, UnlockFunds, AddFunds, SubtractFunds)
-// These will likely require transactions (pgx.Tx) to ensure atomicity, especially when placing/filling orders.
-// Example structure (needs transaction handling):
-/*
-func LockFunds(ctx context.Context, tx pgx.Tx, userID uuid.UUID, asset string, amount float64) error {
-	query := `UPDATE balances
-			  SET available = available - $1, locked = locked + $1
-			  WHERE user_id = $2 AND asset = $3 AND available >= $1`
-	cmdTag, err := tx.Exec(ctx, query, amount, userID, asset)
-	if err != nil {
-		return fmt.Errorf("error locking funds: %w", err)
-	}
-	if cmdTag.RowsAffected() != 1 {
-		return fmt.Errorf("insufficient available balance or balance not found")
-	}
-	return nil
-}
-*/
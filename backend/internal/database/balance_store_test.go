@@ -0,0 +1,168 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/user/minicoinbase/backend/internal/config"
+)
+
+// TestConcurrentFillsDoNotDeadlock settles trades between two users in both directions at once.
+// Each trade touches both users' base and quote balances, in opposite order depending on who
+// buys; without a common lock order these transactions deadlock.
+func TestConcurrentFillsDoNotDeadlock(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set, skipping database test")
+	}
+	ctx := context.Background()
+	if err := InitDB(ctx, &config.Config{DatabaseURL: dsn}); err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	t.Cleanup(CloseDB)
+
+	const base, quote = "HAMMER", "USD"
+	const workers, tradesPerWorker = 16, 25
+	const price, quantity = 10.0, 1.0
+	deposit := LedgerRef{Reason: LedgerDeposit}
+	users := make([]uuid.UUID, 2)
+	for i := range users {
+		user, err := CreateUser(ctx, "test_"+uuid.NewString()[:8], "x")
+		if err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+		users[i] = user.ID
+		err = pgx.BeginFunc(ctx, DB, func(tx pgx.Tx) error {
+			if err := AddFunds(ctx, tx, user.ID, base, workers*tradesPerWorker*quantity, deposit); err != nil {
+				return err
+			}
+			return AddFunds(ctx, tx, user.ID, quote, workers*tradesPerWorker*price*quantity, deposit)
+		})
+		if err != nil {
+			t.Fatalf("AddFunds: %v", err)
+		}
+	}
+
+	// trade moves quantity base from seller to buyer for price*quantity quote, locking and
+	// settling in one transaction the way order placement and settlement do
+	trade := func(buyer, seller uuid.UUID) error {
+		return pgx.BeginFunc(ctx, DB, func(tx pgx.Tx) error {
+			// Keys deliberately passed in trade direction; LockBalances must reorder them
+			err := LockBalances(ctx, tx,
+				BalanceKey{buyer, quote}, BalanceKey{seller, base}, BalanceKey{buyer, base}, BalanceKey{seller, quote})
+			if err != nil {
+				return err
+			}
+			if err := LockFunds(ctx, tx, buyer, quote, price*quantity, LedgerRef{Reason: LedgerOrderLock}); err != nil {
+				return err
+			}
+			if err := LockFunds(ctx, tx, seller, base, quantity, LedgerRef{Reason: LedgerOrderLock}); err != nil {
+				return err
+			}
+			if err := UpdateBalancesForFill(ctx, tx, buyer, base, quote, quantity, price*quantity, 0, "buy", LedgerRef{Reason: LedgerFill}); err != nil {
+				return err
+			}
+			return UpdateBalancesForFill(ctx, tx, seller, base, quote, quantity, price*quantity, 0, "sell", LedgerRef{Reason: LedgerFill})
+		})
+	}
+
+	errs := make(chan error, workers*tradesPerWorker)
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			buyer, seller := users[w%2], users[(w+1)%2]
+			for i := 0; i < tradesPerWorker; i++ {
+				if err := trade(buyer, seller); err != nil {
+					errs <- err
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Errorf("trade failed: %v", err)
+	}
+
+	// Half of the workers bought and half sold for each user, so every balance is back where it started
+	for _, userID := range users {
+		for asset, want := range map[string]float64{
+			base:  workers * tradesPerWorker * quantity,
+			quote: workers * tradesPerWorker * price * quantity,
+		} {
+			balance, err := GetBalance(ctx, userID, asset)
+			if err != nil {
+				t.Fatalf("GetBalance: %v", err)
+			}
+			if balance.Available != want || balance.Locked != 0 {
+				t.Errorf("user %s %s = %v available / %v locked, want %v / 0",
+					userID, asset, balance.Available, balance.Locked, want)
+			}
+			// Every trade changes each of the user's rows at least once
+			if balance.Version < workers*tradesPerWorker {
+				t.Errorf("user %s %s version = %d, want at least %d", userID, asset, balance.Version, workers*tradesPerWorker)
+			}
+		}
+	}
+}
+
+// TestDomainErrorsAreTyped checks that running short of funds comes back as ErrInsufficientFunds
+// however it happens, and a missing order as ErrOrderNotFound, so callers can use errors.Is.
+func TestDomainErrorsAreTyped(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set, skipping database test")
+	}
+	ctx := context.Background()
+	if err := InitDB(ctx, &config.Config{DatabaseURL: dsn}); err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	t.Cleanup(CloseDB)
+
+	user, err := CreateUser(ctx, "test_"+uuid.NewString()[:8], "x")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	err = pgx.BeginFunc(ctx, DB, func(tx pgx.Tx) error {
+		return AddFunds(ctx, tx, user.ID, "USD", 5, LedgerRef{Reason: LedgerDeposit})
+	})
+	if err != nil {
+		t.Fatalf("AddFunds: %v", err)
+	}
+
+	tests := []struct {
+		name string
+		op   func(tx pgx.Tx) error
+	}{
+		{"lock more than available", func(tx pgx.Tx) error {
+			return LockFunds(ctx, tx, user.ID, "USD", 10, LedgerRef{Reason: LedgerOrderLock})
+		}},
+		{"lock without a balance", func(tx pgx.Tx) error {
+			return LockFunds(ctx, tx, user.ID, "NOPE", 1, LedgerRef{Reason: LedgerOrderLock})
+		}},
+		{"subtract more than available", func(tx pgx.Tx) error {
+			return SubtractFunds(ctx, tx, user.ID, "USD", 10, LedgerRef{Reason: LedgerTransferOut})
+		}},
+	}
+	for _, tt := range tests {
+		err := pgx.BeginFunc(ctx, DB, tt.op)
+		if !errors.Is(err, ErrInsufficientFunds) {
+			t.Errorf("%s: err = %v, want ErrInsufficientFunds", tt.name, err)
+		}
+	}
+
+	err = pgx.BeginFunc(ctx, DB, func(tx pgx.Tx) error {
+		_, err := CancelOrder(ctx, tx, user.ID, uuid.New())
+		return err
+	})
+	if !errors.Is(err, ErrOrderNotFound) {
+		t.Errorf("cancelling a missing order: err = %v, want ErrOrderNotFound", err)
+	}
+}
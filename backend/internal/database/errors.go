@@ -0,0 +1,46 @@
+package database
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+// Errors for unique constraint violations, so callers can tell them from other failures.
+var (
+	ErrUsernameTaken = errors.New("username already taken")
+	ErrDuplicateKey  = errors.New("duplicate key") // Any other unique constraint
+)
+
+// Domain errors, returned wrapped with the specifics so callers can tell them apart with errors.Is.
+var (
+	ErrInsufficientFunds   = errors.New("insufficient funds")
+	ErrOrderNotFound       = errors.New("order not found or permission denied") // Doesn't exist or isn't the user's
+	ErrOrderNotCancellable = errors.New("order is not in a cancellable state")
+)
+
+// SQLSTATEs of the errors told apart from other failures.
+const (
+	uniqueViolationCode = "23505"
+	queryCanceledCode   = "57014" // Also raised when statement_timeout expires
+)
+
+// uniqueViolation returns the name of the unique constraint err violates, if it is one.
+func uniqueViolation(err error) (constraint string, ok bool) {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolationCode {
+		return pgErr.ConstraintName, true
+	}
+	return "", false
+}
+
+// IsTimeout reports whether err is a query that ran out of time: its context's deadline passed
+// (see WithTimeout) or the server cancelled it for exceeding statement_timeout.
+func IsTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == queryCanceledCode
+}
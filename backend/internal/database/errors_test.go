@@ -0,0 +1,38 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/jackc/pgx/v5/pgconn"
+)
+
+func TestUniqueViolation(t *testing.T) {
+	wrapped := fmt.Errorf("inserting: %w", &pgconn.PgError{Code: "23505", ConstraintName: "users_username_key"})
+	if constraint, ok := uniqueViolation(wrapped); !ok || constraint != "users_username_key" {
+		t.Errorf("uniqueViolation(unique violation) = %q, %v, want users_username_key, true", constraint, ok)
+	}
+	if _, ok := uniqueViolation(&pgconn.PgError{Code: "23503"}); ok {
+		t.Error("uniqueViolation reported a foreign key violation")
+	}
+	if _, ok := uniqueViolation(errors.New("connection reset")); ok {
+		t.Error("uniqueViolation reported a non-database error")
+	}
+}
+
+func TestIsTimeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	<-ctx.Done()
+	if !IsTimeout(fmt.Errorf("querying: %w", ctx.Err())) {
+		t.Error("IsTimeout(deadline exceeded) = false")
+	}
+	if !IsTimeout(&pgconn.PgError{Code: "57014"}) {
+		t.Error("IsTimeout(statement timeout) = false")
+	}
+	if IsTimeout(context.Canceled) || IsTimeout(errors.New("connection reset")) {
+		t.Error("IsTimeout reported an error that isn't a timeout")
+	}
+}
@@ -0,0 +1,65 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// IdempotencyRecord is what an earlier request with the same idempotency key left behind.
+type IdempotencyRecord struct {
+	OrderID     uuid.UUID
+	RequestHash string
+}
+
+// ClaimIdempotencyKey claims a user's idempotency key for the current transaction.
+// Returns nil if the key is new (or its previous use is older than ttl) and now belongs to
+// this request; otherwise returns the record of the request that used it first.
+// If another transaction holds the key, this blocks until it commits or rolls back,
+// so concurrent duplicates can never both get through.
+func ClaimIdempotencyKey(ctx context.Context, tx pgx.Tx, userID uuid.UUID, key, requestHash string, ttl time.Duration) (*IdempotencyRecord, error) {
+	// Expired keys of this user are dropped here, which keeps the table from growing without bound
+	_, err := tx.Exec(ctx, `DELETE FROM idempotency_keys WHERE user_id = $1 AND created_at < $2`,
+		userID, time.Now().Add(-ttl))
+	if err != nil {
+		return nil, fmt.Errorf("error expiring idempotency keys for user %s: %w", userID, err)
+	}
+
+	tag, err := tx.Exec(ctx,
+		`INSERT INTO idempotency_keys (user_id, key, request_hash) VALUES ($1, $2, $3)
+		 ON CONFLICT (user_id, key) DO NOTHING`,
+		userID, key, requestHash)
+	if err != nil {
+		return nil, fmt.Errorf("error claiming idempotency key for user %s: %w", userID, err)
+	}
+	if tag.RowsAffected() == 1 {
+		return nil, nil
+	}
+
+	record := &IdempotencyRecord{}
+	var orderID *uuid.UUID
+	err = tx.QueryRow(ctx, `SELECT order_id, request_hash FROM idempotency_keys WHERE user_id = $1 AND key = $2`,
+		userID, key).Scan(&orderID, &record.RequestHash)
+	if err != nil {
+		return nil, fmt.Errorf("error reading idempotency key for user %s: %w", userID, err)
+	}
+	if orderID == nil {
+		// The order is set before the claiming transaction commits, so this can't be a finished request
+		return nil, fmt.Errorf("idempotency key for user %s has no order", userID)
+	}
+	record.OrderID = *orderID
+	return record, nil
+}
+
+// SetIdempotencyKeyOrder records the order created by the request that claimed the key.
+func SetIdempotencyKeyOrder(ctx context.Context, tx pgx.Tx, userID uuid.UUID, key string, orderID uuid.UUID) error {
+	query := `UPDATE idempotency_keys SET order_id = $3 WHERE user_id = $1 AND key = $2`
+
+	if _, err := tx.Exec(ctx, query, userID, key, orderID); err != nil {
+		return fmt.Errorf("error storing order %s for idempotency key: %w", orderID, err)
+	}
+	return nil
+}
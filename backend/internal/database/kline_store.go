@@ -0,0 +1,96 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/user/minicoinbase/backend/internal/models"
+)
+
+// KlineIntervals are the supported candlestick intervals.
+var KlineIntervals = map[string]time.Duration{
+	"1m": time.Minute,
+	"5m": 5 * time.Minute,
+	"1h": time.Hour,
+	"1d": 24 * time.Hour,
+}
+
+// GetKlines aggregates the trades of a symbol in [start, end) into candlesticks of the given interval,
+// oldest first. Intervals with no trades are omitted rather than forward-filled.
+//
+// Buckets are aligned to the Unix epoch: a trade falls in the bucket starting at
+// floor(epoch_seconds / interval_seconds) * interval_seconds. Since the epoch is midnight UTC,
+// this lines 1m/5m/1h candles up with the clock and makes 1d candles UTC days.
+// Open and close are the first and last trades of the bucket by execution time (ties broken by id).
+func GetKlines(ctx context.Context, symbol string, interval time.Duration, start, end time.Time) ([]*models.Kline, error) {
+	klines := make([]*models.Kline, 0)
+	query := `SELECT to_timestamp(floor(extract(epoch FROM created_at)::float8 / $2::float8) * $2::float8) AS bucket,
+					 (array_agg(price ORDER BY created_at, id))[1],
+					 MAX(price), MIN(price),
+					 (array_agg(price ORDER BY created_at DESC, id DESC))[1],
+					 SUM(quantity), COUNT(*)
+			  FROM trades
+			  WHERE symbol = $1 AND created_at >= $3 AND created_at < $4
+			  GROUP BY bucket
+			  ORDER BY bucket`
+
+	rows, err := DB.Query(ctx, query, symbol, interval.Seconds(), start, end)
+	if err != nil {
+		return nil, fmt.Errorf("error querying klines for %s: %w", symbol, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		kline := &models.Kline{}
+		err := rows.Scan(&kline.OpenTime, &kline.Open, &kline.High, &kline.Low, &kline.Close, &kline.Volume, &kline.Trades)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning kline row for %s: %w", symbol, err)
+		}
+		klines = append(klines, kline)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error iterating kline rows for %s: %w", symbol, rows.Err())
+	}
+
+	return klines, nil
+}
+
+// GetTickerStats aggregates the trades of every symbol in [start, end) into TickerStats,
+// keyed by symbol. Symbols without trades in the window are absent from the map.
+func GetTickerStats(ctx context.Context, start, end time.Time) (map[string]*models.TickerStats, error) {
+	query := `SELECT symbol,
+					 (array_agg(price ORDER BY created_at, id))[1],
+					 MAX(price), MIN(price),
+					 (array_agg(price ORDER BY created_at DESC, id DESC))[1],
+					 SUM(quantity), SUM(price * quantity), COUNT(*)
+			  FROM trades
+			  WHERE created_at >= $1 AND created_at < $2
+			  GROUP BY symbol`
+
+	rows, err := DB.Query(ctx, query, start, end)
+	if err != nil {
+		return nil, fmt.Errorf("error querying ticker stats: %w", err)
+	}
+	defer rows.Close()
+
+	stats := make(map[string]*models.TickerStats)
+	for rows.Next() {
+		s := &models.TickerStats{WindowStart: start, WindowEnd: end}
+		err := rows.Scan(&s.Symbol, &s.Open, &s.High, &s.Low, &s.Last, &s.BaseVolume, &s.QuoteVolume, &s.Trades)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning ticker stats row: %w", err)
+		}
+		if s.Open != 0 {
+			s.PriceChangePercent = (s.Last - s.Open) / s.Open * 100
+		}
+		stats[s.Symbol] = s
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error iterating ticker stats rows: %w", rows.Err())
+	}
+
+	return stats, nil
+}
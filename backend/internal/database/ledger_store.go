@@ -0,0 +1,132 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/user/minicoinbase/backend/internal/models"
+)
+
+// Ledger reasons, recorded with every balance change.
+const (
+	LedgerOrderLock      = "order_lock"       // Funds locked for an order (placed or enlarged)
+	LedgerOrderUnlock    = "order_unlock"     // Funds released from an order (cancelled, shrunk or filled at a better price)
+	LedgerFill           = "fill"             // Locked funds spent and the other asset received in a trade
+	LedgerFee            = "fee"              // Trading fee, charged to the trader and credited to the house account
+	LedgerDeposit        = "deposit"          // Funds added from outside
+	LedgerWithdrawal     = "withdrawal"       // Funds taken out
+	LedgerTransferOut    = "transfer_out"     // Funds sent to another user, see TransferFunds
+	LedgerTransferIn     = "transfer_in"      // Funds received from another user
+	LedgerReconcile      = "reconcile"        // Locked funds corrected to match open orders, see FixLockedDiscrepancy
+	LedgerAdminAdjust    = "admin_adjustment" // Available funds credited or debited by an admin, see AdjustBalance
+	LedgerOpeningBalance = "opening_balance"  // Balances that existed before the ledger, see migration 0013
+)
+
+// LedgerRef says why a balance changes and what caused it. OrderID, TradeID and TransferID
+// are optional (uuid.Nil when not applicable), as are ActorID and Note, the admin behind a
+// manual change and the reason they gave.
+type LedgerRef struct {
+	Reason     string
+	OrderID    uuid.UUID
+	TradeID    uuid.UUID
+	TransferID uuid.UUID
+	ActorID    uuid.UUID
+	Note       string
+}
+
+// recordLedger appends the ledger entry for a balance change. It must run in the same
+// transaction as the change, so the two are committed or rolled back together.
+func recordLedger(ctx context.Context, tx pgx.Tx, userID uuid.UUID, asset string, deltaAvailable, deltaLocked float64, ref LedgerRef) error {
+	query := `INSERT INTO ledger (user_id, asset, delta_available, delta_locked, reason, order_id, trade_id, transfer_id, actor_id, note)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, NULLIF($10, ''))`
+
+	_, err := tx.Exec(ctx, query, userID, asset, deltaAvailable, deltaLocked, ref.Reason,
+		nullableUUID(ref.OrderID), nullableUUID(ref.TradeID), nullableUUID(ref.TransferID), nullableUUID(ref.ActorID), ref.Note)
+	if err != nil {
+		return fmt.Errorf("error recording %s ledger entry for user %s asset %s: %w", ref.Reason, userID, asset, err)
+	}
+	return nil
+}
+
+// GetLedgerTotals sums a user's ledger entries for an asset, which reconstructs the
+// available and locked balance the ledger accounts for.
+func GetLedgerTotals(ctx context.Context, userID uuid.UUID, asset string) (available, locked float64, err error) {
+	query := `SELECT COALESCE(SUM(delta_available), 0), COALESCE(SUM(delta_locked), 0)
+			  FROM ledger WHERE user_id = $1 AND asset = $2`
+
+	if err := DB.QueryRow(ctx, query, userID, asset).Scan(&available, &locked); err != nil {
+		return 0, 0, fmt.Errorf("error summing ledger for user %s asset %s: %w", userID, asset, err)
+	}
+	return available, locked, nil
+}
+
+// StreamStatement calls fn with each of a user's statement entries in [from, to), oldest first:
+// the ledger entries that change their total balance, joined with the order and trade behind them.
+// Moves between available and locked (order locks and unlocks, reconciliation) are left out.
+// Zero from or to leaves that end open. Rows are read as fn consumes them, never all at once;
+// an error from fn stops the iteration and is returned.
+func StreamStatement(ctx context.Context, userID uuid.UUID, from, to time.Time, fn func(*models.StatementEntry) error) error {
+	query := `SELECT l.created_at, l.reason, l.asset, l.delta_available + l.delta_locked,
+					 COALESCE(o.symbol, ''), COALESCE(o.side, ''),
+					 COALESCE(t.price, 0), COALESCE(t.quantity, 0), l.order_id, l.trade_id
+			  FROM ledger l
+			  LEFT JOIN all_orders o ON o.id = l.order_id
+			  LEFT JOIN trades t ON t.id = l.trade_id
+			  WHERE l.user_id = $1 AND l.reason IN ('fill', 'fee', 'deposit', 'withdrawal', 'transfer_out', 'transfer_in', 'admin_adjustment', 'opening_balance')
+				AND ($2::timestamptz IS NULL OR l.created_at >= $2)
+				AND ($3::timestamptz IS NULL OR l.created_at < $3)
+			  ORDER BY l.id`
+
+	// The query runs for as long as fn takes to consume the rows, e.g. while a large export is
+	// written to a slow client, so it is exempt from the statement timeout
+	return pgx.BeginTxFunc(ctx, DB, pgx.TxOptions{AccessMode: pgx.ReadOnly}, func(tx pgx.Tx) error {
+		if _, err := tx.Exec(ctx, `SET LOCAL statement_timeout = 0`); err != nil {
+			return fmt.Errorf("error lifting statement timeout for user %s: %w", userID, err)
+		}
+		return streamStatementRows(ctx, tx, query, userID, from, to, fn)
+	})
+}
+
+// streamStatementRows runs the statement query of StreamStatement within tx and calls fn with each row.
+func streamStatementRows(ctx context.Context, tx pgx.Tx, query string, userID uuid.UUID, from, to time.Time, fn func(*models.StatementEntry) error) error {
+	rows, err := tx.Query(ctx, query, userID, nullableTime(from), nullableTime(to))
+	if err != nil {
+		return fmt.Errorf("error querying statement for user %s: %w", userID, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		entry := &models.StatementEntry{}
+		err := rows.Scan(&entry.Time, &entry.Type, &entry.Asset, &entry.Amount, &entry.Symbol, &entry.Side,
+			&entry.Price, &entry.Quantity, &entry.OrderID, &entry.TradeID)
+		if err != nil {
+			return fmt.Errorf("error scanning statement row for user %s: %w", userID, err)
+		}
+		if err := fn(entry); err != nil {
+			return err
+		}
+	}
+	if rows.Err() != nil {
+		return fmt.Errorf("error iterating statement rows for user %s: %w", userID, rows.Err())
+	}
+	return nil
+}
+
+// nullableTime maps the zero time to SQL NULL.
+func nullableTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
+
+// nullableUUID maps uuid.Nil to SQL NULL.
+func nullableUUID(id uuid.UUID) *uuid.UUID {
+	if id == uuid.Nil {
+		return nil
+	}
+	return &id
+}
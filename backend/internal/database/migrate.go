@@ -0,0 +1,190 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/user/minicoinbase/backend/migrations"
+)
+
+// migrationLockID is the Postgres advisory lock key held while migrating,
+// so several instances starting at once don't apply the same migration twice.
+const migrationLockID = 4242001
+
+// migration is one versioned schema change with its up and down SQL.
+type migration struct {
+	version int
+	name    string
+	up      string
+	down    string
+}
+
+// Migrate applies every migration newer than the database's current version, oldest first.
+// Each migration runs in its own transaction together with the version bump,
+// so a failing migration leaves the schema at the previous version.
+func Migrate(ctx context.Context, pool *pgxpool.Pool) error {
+	return withMigrationLock(ctx, pool, func(conn *pgxpool.Conn, current int, all []migration) error {
+		applied := 0
+		for _, m := range all {
+			if m.version <= current {
+				continue
+			}
+			if m.up == "" {
+				return fmt.Errorf("migration %04d_%s has no up file", m.version, m.name)
+			}
+			log.Printf("Applying migration %04d_%s", m.version, m.name)
+			err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+				if _, err := tx.Exec(ctx, m.up); err != nil {
+					return err
+				}
+				_, err := tx.Exec(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, m.version)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("error applying migration %04d_%s: %w", m.version, m.name, err)
+			}
+			applied++
+		}
+		if applied == 0 {
+			log.Printf("Database schema is up to date (version %d)", current)
+		}
+		return nil
+	})
+}
+
+// MigrateDown reverts migrations, newest first, until the database is at targetVersion
+// (0 reverts everything).
+func MigrateDown(ctx context.Context, pool *pgxpool.Pool, targetVersion int) error {
+	return withMigrationLock(ctx, pool, func(conn *pgxpool.Conn, current int, all []migration) error {
+		for i := len(all) - 1; i >= 0; i-- {
+			m := all[i]
+			if m.version > current || m.version <= targetVersion {
+				continue
+			}
+			if m.down == "" {
+				return fmt.Errorf("migration %04d_%s has no down file", m.version, m.name)
+			}
+			log.Printf("Reverting migration %04d_%s", m.version, m.name)
+			err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+				if _, err := tx.Exec(ctx, m.down); err != nil {
+					return err
+				}
+				_, err := tx.Exec(ctx, `DELETE FROM schema_migrations WHERE version = $1`, m.version)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("error reverting migration %04d_%s: %w", m.version, m.name, err)
+			}
+		}
+		return nil
+	})
+}
+
+// withMigrationLock takes the migration lock on a dedicated connection, makes sure the
+// schema_migrations table exists, and calls fn with the current version and all known migrations.
+func withMigrationLock(ctx context.Context, pool *pgxpool.Pool, fn func(conn *pgxpool.Conn, current int, all []migration) error) error {
+	all, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	conn, err := pool.Acquire(ctx)
+	if err != nil {
+		return fmt.Errorf("error acquiring connection for migrations: %w", err)
+	}
+	defer conn.Release()
+
+	// Waiting for another instance's migrations and the migrations themselves (e.g. backfills)
+	// may take longer than DB_STATEMENT_TIMEOUT allows; the connection gets its default back after
+	if _, err := conn.Exec(ctx, `SET statement_timeout = 0`); err != nil {
+		return fmt.Errorf("error lifting statement timeout for migrations: %w", err)
+	}
+	defer func() {
+		if _, err := conn.Exec(context.Background(), `RESET statement_timeout`); err != nil {
+			log.Printf("Error restoring statement timeout: %v", err)
+		}
+	}()
+
+	// Advisory locks belong to the session, so lock and unlock on the same connection
+	if _, err := conn.Exec(ctx, `SELECT pg_advisory_lock($1)`, migrationLockID); err != nil {
+		return fmt.Errorf("error taking migration lock: %w", err)
+	}
+	defer func() {
+		if _, err := conn.Exec(context.Background(), `SELECT pg_advisory_unlock($1)`, migrationLockID); err != nil {
+			log.Printf("Error releasing migration lock: %v", err)
+		}
+	}()
+
+	_, err = conn.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INTEGER PRIMARY KEY,
+		applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+	)`)
+	if err != nil {
+		return fmt.Errorf("error creating schema_migrations table: %w", err)
+	}
+
+	var current int
+	if err := conn.QueryRow(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return fmt.Errorf("error reading schema version: %w", err)
+	}
+
+	return fn(conn, current, all)
+}
+
+// loadMigrations reads the embedded migration files, sorted by version.
+func loadMigrations() ([]migration, error) {
+	entries, err := fs.ReadDir(migrations.FS, ".")
+	if err != nil {
+		return nil, fmt.Errorf("error listing migrations: %w", err)
+	}
+
+	byVersion := make(map[int]*migration)
+	for _, entry := range entries {
+		name := entry.Name()
+		var direction string
+		switch {
+		case strings.HasSuffix(name, ".up.sql"):
+			direction = "up"
+		case strings.HasSuffix(name, ".down.sql"):
+			direction = "down"
+		default:
+			continue
+		}
+
+		// NNNN_description.up.sql
+		versionStr, rest, found := strings.Cut(name, "_")
+		version, err := strconv.Atoi(versionStr)
+		if !found || err != nil || version <= 0 {
+			return nil, fmt.Errorf("invalid migration file name %s", name)
+		}
+		sql, err := fs.ReadFile(migrations.FS, name)
+		if err != nil {
+			return nil, fmt.Errorf("error reading migration %s: %w", name, err)
+		}
+
+		m, ok := byVersion[version]
+		if !ok {
+			m = &migration{version: version, name: strings.TrimSuffix(rest, "."+direction+".sql")}
+			byVersion[version] = m
+		}
+		if direction == "up" {
+			m.up = string(sql)
+		} else {
+			m.down = string(sql)
+		}
+	}
+
+	all := make([]migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		all = append(all, *m)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].version < all[j].version })
+	return all, nil
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
@@ -15,9 +16,9 @@ import (
 // Note: This function assumes balance checks and locking have happened *before* calling it,
 // ideally within a transaction.
 func CreateOrder(ctx context.Context, tx pgx.Tx, order *models.Order) error {
-	query := `INSERT INTO orders (user_id, symbol, type, side, price, quantity, status)
-			  VALUES ($1, $2, $3, $4, $5, $6, $7)
-			  RETURNING id, created_at, updated_at`
+	query := `INSERT INTO orders (user_id, symbol, type, side, price, stop_price, time_in_force, post_only, quantity, original_quantity, status, expires_at, session_id, quote_quantity, locked_amount, locked_asset, display_quantity, protection_price)
+			  VALUES ($1, $2, $3, $4, $5, NULLIF($6::DECIMAL, 0), $7, $8, $9, $9, $10, $11, $12, NULLIF($13::DECIMAL, 0), $14, $15, NULLIF($16::DECIMAL, 0), NULLIF($17::DECIMAL, 0))
+			  RETURNING id, original_quantity, created_at, updated_at`
 
 	// Use the transaction (tx) if provided, otherwise use the pool (DB)
 	querier := Querier(tx)
@@ -25,8 +26,10 @@ func CreateOrder(ctx context.Context, tx pgx.Tx, order *models.Order) error {
 	err := querier.QueryRow(ctx, query,
 		order.UserID, order.Symbol, order.Type, order.Side,
 		order.Price, // Note: Handle NULL for market orders if necessary in model/handler
-		order.Quantity, order.Status,
-	).Scan(&order.ID, &order.CreatedAt, &order.UpdatedAt)
+		order.StopPrice, order.TimeInForce, order.PostOnly,
+		order.Quantity, order.Status, order.ExpiresAt, order.SessionID, order.QuoteQuantity, order.LockedAmount, order.LockedAsset,
+		order.DisplayQuantity, order.ProtectionPrice,
+	).Scan(&order.ID, &order.OriginalQuantity, &order.CreatedAt, &order.UpdatedAt)
 
 	if err != nil {
 		return fmt.Errorf("error creating order for user %s: %w", order.UserID, err)
@@ -34,51 +37,112 @@ func CreateOrder(ctx context.Context, tx pgx.Tx, order *models.Order) error {
 	return nil
 }
 
-// GetUserOrders retrieves all non-cancelled orders for a specific user.
-func GetUserOrders(ctx context.Context, userID uuid.UUID) ([]*models.Order, error) {
+// orderColumns is the column list scanned by scanOrder.
+const orderColumns = `id, user_id, symbol, type, side, price, COALESCE(stop_price, 0), time_in_force, post_only,
+					  quantity, original_quantity, filled_quantity, avg_fill_price, status, created_at, updated_at, expires_at, session_id,
+					  COALESCE(quote_quantity, 0), COALESCE(locked_amount, 0), COALESCE(locked_asset, ''), COALESCE(display_quantity, 0),
+					  COALESCE(protection_price, 0)`
+
+// scanOrder scans a row selected with orderColumns.
+func scanOrder(row pgx.Row, order *models.Order) error {
+	return row.Scan(
+		&order.ID, &order.UserID, &order.Symbol, &order.Type, &order.Side,
+		&order.Price, &order.StopPrice, &order.TimeInForce, &order.PostOnly,
+		&order.Quantity, &order.OriginalQuantity, &order.FilledQuantity, &order.AvgFillPrice,
+		&order.Status, &order.CreatedAt, &order.UpdatedAt, &order.ExpiresAt, &order.SessionID,
+		&order.QuoteQuantity, &order.LockedAmount, &order.LockedAsset, &order.DisplayQuantity,
+		&order.ProtectionPrice,
+	)
+}
+
+// OrderFilter narrows down and pages the orders returned by GetUserOrders.
+// Zero values mean "no filter" for Symbol and Statuses.
+type OrderFilter struct {
+	Symbol           string   // e.g., "BTC-USD"
+	Statuses         []string // e.g., {"open", "partially_filled"}; takes precedence over IncludeCancelled
+	IncludeCancelled bool     // Cancelled orders are left out unless set
+	IncludeArchive   bool     // Also search orders moved to orders_archive, see ArchiveOrders
+	ByUpdateTime     bool     // Sort by when orders last changed rather than when they were placed
+	Limit            int
+	Offset           int
+}
+
+// GetUserOrders retrieves one page of a user's orders, newest first (by creation, or by last
+// update with ByUpdateTime), together with the total number of orders matching the filter.
+func GetUserOrders(ctx context.Context, userID uuid.UUID, filter OrderFilter) ([]*models.Order, int, error) {
 	orders := make([]*models.Order, 0)
-	// Exclude cancelled orders, sort by creation time descending
-	query := `SELECT id, user_id, symbol, type, side, price, quantity, status, created_at, updated_at
-			  FROM orders
-			  WHERE user_id = $1 AND status != 'cancelled'
-			  ORDER BY created_at DESC`
+	table := "orders"
+	if filter.IncludeArchive {
+		table = "all_orders"
+	}
+	where := ` WHERE user_id = $1`
+	args := []interface{}{userID}
 
-	rows, err := DB.Query(ctx, query, userID)
+	if filter.Symbol != "" {
+		args = append(args, filter.Symbol)
+		where += fmt.Sprintf(" AND symbol = $%d", len(args))
+	}
+	if len(filter.Statuses) > 0 {
+		args = append(args, filter.Statuses)
+		where += fmt.Sprintf(" AND status = ANY($%d)", len(args))
+	} else if !filter.IncludeCancelled {
+		where += " AND status != 'cancelled'"
+	}
+
+	var total int
+	if err := DB.QueryRow(ctx, `SELECT COUNT(*) FROM `+table+where, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("error counting orders for user %s: %w", userID, err)
+	}
+
+	orderBy := "created_at"
+	if filter.ByUpdateTime {
+		orderBy = "updated_at"
+	}
+	args = append(args, filter.Limit, filter.Offset)
+	query := `SELECT ` + orderColumns + ` FROM ` + table + where +
+		fmt.Sprintf(" ORDER BY %s DESC, id LIMIT $%d OFFSET $%d", orderBy, len(args)-1, len(args))
+
+	rows, err := DB.Query(ctx, query, args...)
 	if err != nil {
-		return nil, fmt.Errorf("error querying orders for user %s: %w", userID, err)
+		return nil, 0, fmt.Errorf("error querying orders for user %s: %w", userID, err)
 	}
 	defer rows.Close()
 
 	for rows.Next() {
 		order := &models.Order{}
-		err := rows.Scan(
-			&order.ID, &order.UserID, &order.Symbol, &order.Type, &order.Side,
-			&order.Price, &order.Quantity, &order.Status, &order.CreatedAt, &order.UpdatedAt,
-		)
-		if err != nil {
-			return nil, fmt.Errorf("error scanning order row for user %s: %w", userID, err)
+		if err := scanOrder(rows, order); err != nil {
+			return nil, 0, fmt.Errorf("error scanning order row for user %s: %w", userID, err)
 		}
 		orders = append(orders, order)
 	}
 
 	if rows.Err() != nil {
-		return nil, fmt.Errorf("error iterating order rows for user %s: %w", userID, rows.Err())
+		return nil, 0, fmt.Errorf("error iterating order rows for user %s: %w", userID, rows.Err())
 	}
 
-	return orders, nil
+	return orders, total, nil
+}
+
+// GetOrderOwner returns the user an order belongs to, looking in orders_archive too.
+// Returns uuid.Nil and no error if there is no such order.
+func GetOrderOwner(ctx context.Context, orderID uuid.UUID) (uuid.UUID, error) {
+	var userID uuid.UUID
+	err := DB.QueryRow(ctx, `SELECT user_id FROM all_orders WHERE id = $1`, orderID).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return uuid.Nil, nil
+		}
+		return uuid.Nil, fmt.Errorf("error getting owner of order %s: %w", orderID, err)
+	}
+	return userID, nil
 }
 
 // GetOrderByID retrieves a specific order by its ID.
 func GetOrderByID(ctx context.Context, orderID uuid.UUID) (*models.Order, error) {
 	order := &models.Order{}
-	query := `SELECT id, user_id, symbol, type, side, price, quantity, status, created_at, updated_at
-			  FROM orders WHERE id = $1`
-
-	err := DB.QueryRow(ctx, query, orderID).Scan(
-		&order.ID, &order.UserID, &order.Symbol, &order.Type, &order.Side,
-		&order.Price, &order.Quantity, &order.Status, &order.CreatedAt, &order.UpdatedAt,
-	)
+	query := `SELECT ` + orderColumns + ` FROM orders WHERE id = $1`
 
+	err := scanOrder(DB.QueryRow(ctx, query, orderID), order)
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, nil // Order not found
@@ -88,38 +152,42 @@ func GetOrderByID(ctx context.Context, orderID uuid.UUID) (*models.Order, error)
 	return order, nil
 }
 
+// GetOrderByIDInTx retrieves a specific order within a transaction, so it is read in the same
+// snapshot as the transaction's other reads and writes. It doesn't lock the row: take the lock
+// with LockOrders first (in lock order) when the order is about to be changed.
+func GetOrderByIDInTx(ctx context.Context, tx pgx.Tx, orderID uuid.UUID) (*models.Order, error) {
+	order := &models.Order{}
+	query := `SELECT ` + orderColumns + ` FROM orders WHERE id = $1`
+
+	err := scanOrder(tx.QueryRow(ctx, query, orderID), order)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, nil // Order not found
+		}
+		return nil, fmt.Errorf("tx error getting order by id %s: %w", orderID, err)
+	}
+	return order, nil
+}
+
 // CancelOrder updates an order's status to 'cancelled' within a transaction.
 // It returns the details of the order *before* cancellation (for fund unlocking).
 // It checks if the order belongs to the user and is currently cancellable (e.g., 'open').
 func CancelOrder(ctx context.Context, tx pgx.Tx, userID uuid.UUID, orderID uuid.UUID) (*models.Order, error) {
 	// 1. Get the order details first, ensuring it belongs to the user and is in a cancellable state.
-	//    Use FOR UPDATE to lock the row within the transaction.
-	order := &models.Order{}
-	get_query := `SELECT id, user_id, symbol, type, side, price, quantity, status
-				   FROM orders
-				   WHERE id = $1 AND user_id = $2 FOR UPDATE`
-
-	err := tx.QueryRow(ctx, get_query, orderID, userID).Scan(
-		&order.ID, &order.UserID, &order.Symbol, &order.Type, &order.Side,
-		&order.Price, &order.Quantity, &order.Status,
-	)
-
+	//    The row stays locked for the rest of the transaction.
+	order, err := GetOrderForUpdate(ctx, tx, userID, orderID)
 	if err != nil {
-		if errors.Is(err, pgx.ErrNoRows) {
-			// Order not found OR doesn't belong to the user
-			return nil, fmt.Errorf("order not found or permission denied")
-		}
-		return nil, fmt.Errorf("error retrieving order %s for cancellation: %w", orderID, err)
+		return nil, err
 	}
 
-	// 2. Check if the order is actually cancellable
-	if order.Status != "open" { // Only open orders can be cancelled (adjust if partial fills allowed cancellation)
-		return nil, fmt.Errorf("order %s is not in a cancellable state (status: %s)", orderID, order.Status)
+	// 2. Check if the order is actually cancellable (its unfilled remainder, for partially filled orders)
+	if order.Status != "open" && order.Status != "partially_filled" {
+		return nil, fmt.Errorf("order %s: %w (status: %s)", orderID, ErrOrderNotCancellable, order.Status)
 	}
 
 	// 3. Update the status to 'cancelled'
 	update_query := `UPDATE orders SET status = 'cancelled', updated_at = NOW()
-					 WHERE id = $1 AND status = 'open'` // Double check status
+					 WHERE id = $1 AND status IN ('open', 'partially_filled')` // Double check status
 
 	cmdTag, err := tx.Exec(ctx, update_query, orderID)
 	if err != nil {
@@ -132,10 +200,265 @@ func CancelOrder(ctx context.Context, tx pgx.Tx, userID uuid.UUID, orderID uuid.
 		return nil, fmt.Errorf("failed to update order %s status (concurrent modification?)", orderID)
 	}
 
-	// Return the order details *before* it was cancelled (status will be 'open' here)
+	// Return the order details *before* it was cancelled (status will be 'open' or 'partially_filled' here)
+	return order, nil
+}
+
+// GetCancellableOrderIDs returns the IDs of a user's open and partially filled orders, oldest first.
+// An empty symbol matches all symbols.
+func GetCancellableOrderIDs(ctx context.Context, tx pgx.Tx, userID uuid.UUID, symbol string) ([]uuid.UUID, error) {
+	query := `SELECT id FROM orders
+			  WHERE user_id = $1 AND status IN ('open', 'partially_filled') AND ($2 = '' OR symbol = $2)
+			  ORDER BY created_at, id`
+
+	rows, err := Querier(tx).Query(ctx, query, userID, symbol)
+	if err != nil {
+		return nil, fmt.Errorf("error querying cancellable orders for user %s: %w", userID, err)
+	}
+	defer rows.Close()
+
+	ids := make([]uuid.UUID, 0)
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("error scanning order id for user %s: %w", userID, err)
+		}
+		ids = append(ids, id)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error iterating cancellable orders for user %s: %w", userID, rows.Err())
+	}
+	return ids, nil
+}
+
+// GetSessionOrderIDs returns the IDs of a user's open and partially filled orders placed under
+// a WebSocket trading session, oldest first.
+func GetSessionOrderIDs(ctx context.Context, tx pgx.Tx, userID, sessionID uuid.UUID) ([]uuid.UUID, error) {
+	query := `SELECT id FROM orders
+			  WHERE user_id = $1 AND session_id = $2 AND status IN ('open', 'partially_filled')
+			  ORDER BY created_at, id`
+
+	rows, err := Querier(tx).Query(ctx, query, userID, sessionID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying orders of session %s: %w", sessionID, err)
+	}
+	defer rows.Close()
+
+	ids := make([]uuid.UUID, 0)
+	for rows.Next() {
+		var id uuid.UUID
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("error scanning order id of session %s: %w", sessionID, err)
+		}
+		ids = append(ids, id)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error iterating orders of session %s: %w", sessionID, rows.Err())
+	}
+	return ids, nil
+}
+
+// CountOpenOrders returns how many open and partially filled orders (pending stops included) a user has.
+// An empty symbol counts all symbols.
+func CountOpenOrders(ctx context.Context, userID uuid.UUID, symbol string) (int, error) {
+	query := `SELECT COUNT(*) FROM orders
+			  WHERE user_id = $1 AND status IN ('open', 'partially_filled') AND ($2 = '' OR symbol = $2)`
+
+	var count int
+	if err := DB.QueryRow(ctx, query, userID, symbol).Scan(&count); err != nil {
+		return 0, fmt.Errorf("error counting open orders for user %s: %w", userID, err)
+	}
+	return count, nil
+}
+
+// GetExpiredOrders returns up to limit open and partially filled orders whose expires_at is
+// at or before the given time, soonest expiry first. The rows are not locked: an order may
+// be filled or cancelled by the time the caller gets to it, see CancelOrder.
+func GetExpiredOrders(ctx context.Context, now time.Time, limit int) ([]*models.Order, error) {
+	query := `SELECT ` + orderColumns + ` FROM orders
+			  WHERE expires_at <= $1 AND status IN ('open', 'partially_filled')
+			  ORDER BY expires_at, id LIMIT $2`
+
+	rows, err := DB.Query(ctx, query, now, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error querying orders expired by %s: %w", now.Format(time.RFC3339), err)
+	}
+	defer rows.Close()
+
+	orders := make([]*models.Order, 0)
+	for rows.Next() {
+		order := &models.Order{}
+		if err := scanOrder(rows, order); err != nil {
+			return nil, fmt.Errorf("error scanning expired order: %w", err)
+		}
+		orders = append(orders, order)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error iterating expired orders: %w", rows.Err())
+	}
+	return orders, nil
+}
+
+// GetOrderForUpdateretrieves one of a user's orders and locks its row (FOR UPDATE) until tx ends.
+// An order that doesn't exist or belongs to someone else gives ErrOrderNotFound.
+func GetOrderForUpdate(ctx context.Context, tx pgx.Tx, userID uuid.UUID, orderID uuid.UUID) (*models.Order, error) {
+	order := &models.Order{}
+	query := `SELECT ` + orderColumns + ` FROM orders WHERE id = $1 AND user_id = $2 FOR UPDATE`
+
+	err := scanOrder(tx.QueryRow(ctx, query, orderID, userID), order)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			// Order not found OR doesn't belong to the user
+			return nil, fmt.Errorf("order %s: %w", orderID, ErrOrderNotFound)
+		}
+		return nil, fmt.Errorf("error retrieving order %s for update: %w", orderID, err)
+	}
 	return order, nil
 }
 
+// LockOrders locks the given order rows (FOR UPDATE) in id order, see LockBalances for the
+// lock ordering rule. Unknown ids are ignored. Requires an active transaction (tx).
+func LockOrders(ctx context.Context, tx pgx.Tx, orderIDs ...uuid.UUID) error {
+	query := `SELECT id FROM orders WHERE id = ANY($1) ORDER BY id FOR UPDATE`
+
+	if _, err := tx.Exec(ctx, query, orderIDs); err != nil {
+		return fmt.Errorf("error locking %d orders: %w", len(orderIDs), err)
+	}
+	return nil
+}
+
+// UpdateOrderPriceQuantity sets a modified order's limit price and (total, not remaining) quantity.
+// Requires an active transaction (tx), normally the one that locked the row with GetOrderForUpdate.
+func UpdateOrderPriceQuantity(ctx context.Context, tx pgx.Tx, orderID uuid.UUID, price, quantity float64) error {
+	query := `UPDATE orders SET price = $2, quantity = $3, updated_at = NOW()
+			  WHERE id = $1 AND status IN ('open', 'partially_filled')`
+
+	cmdTag, err := tx.Exec(ctx, query, orderID, price, quantity)
+	if err != nil {
+		return fmt.Errorf("error updating price and quantity of order %s: %w", orderID, err)
+	}
+	if cmdTag.RowsAffected() != 1 {
+		return fmt.Errorf("failed to update order %s (no longer open?)", orderID)
+	}
+	return nil
+}
+
+// AdjustOrderLock adds delta (negative to release) to the funds an order has locked, as recorded
+// in its locked_amount, within the transaction that locks or unlocks them. Requires an active
+// transaction (tx). Orders that never recorded their lock (closed before migration 0022) are left alone.
+func AdjustOrderLock(ctx context.Context, tx pgx.Tx, orderID uuid.UUID, delta float64) error {
+	query := `UPDATE orders SET locked_amount = GREATEST(locked_amount + $2, 0)
+			  WHERE id = $1 AND locked_asset IS NOT NULL`
+
+	if _, err := tx.Exec(ctx, query, orderID, delta); err != nil {
+		return fmt.Errorf("error adjusting locked amount of order %s: %w", orderID, err)
+	}
+	return nil
+}
+
+// RecordOrderFill adds a fill of quantity at price to an order's filled quantity and average fill price,
+// and sets it to 'filled' or 'partially_filled' accordingly. Requires an active transaction (tx).
+// Fills still count towards an order that is no longer open (e.g., matched just before it was cancelled),
+// but its status is left untouched, except for a quote-denominated order: ExpireOrder may have
+// finished it off as 'cancelled' before its fills settled (they were left in the outbox), and a
+// late fill makes it 'filled' as ExpireOrder would have.
+func RecordOrderFill(ctx context.Context, tx pgx.Tx, orderID uuid.UUID, price, quantity float64) error {
+	// All right-hand sides see the row as it was before the update
+	query := `UPDATE orders
+			  SET filled_quantity = filled_quantity + $3,
+				  avg_fill_price = (avg_fill_price * filled_quantity + $2 * $3) / (filled_quantity + $3),
+				  status = CASE
+					  WHEN status = 'cancelled' AND quote_quantity IS NOT NULL THEN 'filled'
+					  WHEN status NOT IN ('open', 'partially_filled') THEN status
+					  WHEN quote_quantity IS NOT NULL THEN 'partially_filled' -- Finished by ExpireOrder
+					  WHEN filled_quantity + $3 >= quantity THEN 'filled'
+					  ELSE 'partially_filled'
+				  END,
+				  updated_at = NOW()
+			  WHERE id = $1`
+
+	cmdTag, err := tx.Exec(ctx, query, orderID, price, quantity)
+	if err != nil {
+		return fmt.Errorf("error recording fill for order %s: %w", orderID, err)
+	}
+	if cmdTag.RowsAffected() != 1 {
+		return fmt.Errorf("order %s not found while recording fill", orderID)
+	}
+	return nil
+}
+
+// GetUserLockingOrders returns a user's open and partially filled orders that have funds locked,
+// by locked asset, oldest first.
+func GetUserLockingOrders(ctx context.Context, userID uuid.UUID) ([]*models.Order, error) {
+	orders := make([]*models.Order, 0)
+	query := `SELECT ` + orderColumns + ` FROM orders
+			  WHERE user_id = $1 AND status IN ('open', 'partially_filled') AND locked_amount > 0
+			  ORDER BY locked_asset, created_at, id`
+
+	rows, err := DB.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying locking orders for user %s: %w", userID, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		order := &models.Order{}
+		if err := scanOrder(rows, order); err != nil {
+			return nil, fmt.Errorf("error scanning order row for user %s: %w", userID, err)
+		}
+		orders = append(orders, order)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error iterating locking orders for user %s: %w", userID, rows.Err())
+	}
+	return orders, nil
+}
+
+// GetOpenOrders returns every open or partially filled order of every user, oldest first.
+// Used at startup to rebuild the order books.
+func GetOpenOrders(ctx context.Context) ([]*models.Order, error) {
+	orders := make([]*models.Order, 0)
+	query := `SELECT ` + orderColumns + ` FROM orders
+			  WHERE status IN ('open', 'partially_filled')
+			  ORDER BY created_at, id`
+
+	rows, err := DB.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("error querying open orders: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		order := &models.Order{}
+		if err := scanOrder(rows, order); err != nil {
+			return nil, fmt.Errorf("error scanning open order row: %w", err)
+		}
+		orders = append(orders, order)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error iterating open order rows: %w", rows.Err())
+	}
+	return orders, nil
+}
+
+// ExpireOrder marks an order whose unfilled remainder was discarded by the matching engine
+// (e.g., IOC/FOK) as 'cancelled' within a transaction. A quote-denominated market buy always
+// leaves the book this way, and is marked 'filled' instead if it bought anything.
+// Returns false if the order was no longer open or partially filled (e.g., already cancelled by the user),
+// in which case its funds have already been dealt with.
+func ExpireOrder(ctx context.Context, tx pgx.Tx, orderID uuid.UUID) (bool, error) {
+	query := `UPDATE orders
+			  SET status = CASE WHEN quote_quantity IS NOT NULL AND filled_quantity > 0 THEN 'filled' ELSE 'cancelled' END,
+				  updated_at = NOW()
+			  WHERE id = $1 AND status IN ('open', 'partially_filled')`
+
+	cmdTag, err := tx.Exec(ctx, query, orderID)
+	if err != nil {
+		return false, fmt.Errorf("error expiring order %s: %w", orderID, err)
+	}
+	return cmdTag.RowsAffected() == 1, nil
+}
+
 // Helper type to allow using either pgx.Pool or pgx.Tx
 type PgxQuerier interface {
 	Query(ctx context.Context, sql string, args ...interface{}) (pgx.Rows, error)
@@ -0,0 +1,60 @@
+package database
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/user/minicoinbase/backend/internal/config"
+)
+
+// TestLateFillFinishesExpiredQuoteOrder expires a quote-denominated market buy before its fill
+// settles, as happens when settlement fails and leaves the trade in the outbox: the fill settled
+// later must still turn it from 'cancelled' into 'filled'.
+func TestLateFillFinishesExpiredQuoteOrder(t *testing.T) {
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set, skipping database test")
+	}
+	ctx := context.Background()
+	if err := InitDB(ctx, &config.Config{DatabaseURL: dsn}); err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	t.Cleanup(CloseDB)
+
+	user, err := CreateUser(ctx, "test_"+uuid.NewString()[:8], "x")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	var quoteOrder uuid.UUID
+	err = DB.QueryRow(ctx, `INSERT INTO orders (user_id, symbol, type, side, price, quantity, original_quantity, status, quote_quantity)
+							VALUES ($1, 'BTC-USD', 'market', 'buy', 0, 0, 0, 'open', 100) RETURNING id`, user.ID).Scan(&quoteOrder)
+	if err != nil {
+		t.Fatalf("insert order: %v", err)
+	}
+
+	err = pgx.BeginFunc(ctx, DB, func(tx pgx.Tx) error {
+		if expired, err := ExpireOrder(ctx, tx, quoteOrder); err != nil || !expired {
+			t.Fatalf("ExpireOrder = %v, %v; want true", expired, err)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("expire: %v", err)
+	}
+	if order, err := GetOrderByID(ctx, quoteOrder); err != nil || order.Status != "cancelled" {
+		t.Fatalf("order before the fill settled = %+v, %v; want cancelled", order, err)
+	}
+
+	err = pgx.BeginFunc(ctx, DB, func(tx pgx.Tx) error {
+		return RecordOrderFill(ctx, tx, quoteOrder, 50, 1)
+	})
+	if err != nil {
+		t.Fatalf("RecordOrderFill: %v", err)
+	}
+	if order, err := GetOrderByID(ctx, quoteOrder); err != nil || order.Status != "filled" || order.FilledQuantity != 1 {
+		t.Errorf("order after the late fill = %+v, %v; want filled with 1", order, err)
+	}
+}
@@ -0,0 +1,164 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// OutboxTrade is a matched trade waiting in the trade outbox for settlement, see migration 0020.
+type OutboxTrade struct {
+	TradeID         uuid.UUID `json:"trade_id"`
+	Symbol          string    `json:"symbol"`
+	Seq             int64     `json:"seq"`
+	MakerOrderID    uuid.UUID `json:"maker_order_id"`
+	TakerOrderID    uuid.UUID `json:"taker_order_id"`
+	TakerSide       string    `json:"taker_side"`
+	Price           float64   `json:"price"`
+	Quantity        float64   `json:"quantity"`
+	TakerLimitPrice float64   `json:"taker_limit_price"`
+	MakerLimitPrice float64   `json:"maker_limit_price"` // 0 for trades enqueued before migration 0031
+	ExecutedAt      time.Time `json:"executed_at"`
+	Attempts        int       `json:"attempts"`             // Failed settlement attempts so far
+	LastError       string    `json:"last_error,omitempty"` // Why the last attempt failed; only read for trades held for review
+}
+
+// EnqueueTrades writes matched trades to the outbox in one transaction, due for a retry by the
+// outbox worker after retryAfter unless settled (and deleted, see DeleteOutboxTrade) before then.
+func EnqueueTrades(ctx context.Context, trades []*OutboxTrade, retryAfter time.Duration) error {
+	query := `INSERT INTO trade_outbox (trade_id, symbol, seq, maker_order_id, taker_order_id, taker_side,
+			                            price, quantity, taker_limit_price, maker_limit_price, executed_at, next_attempt_at)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`
+
+	nextAttempt := time.Now().Add(retryAfter)
+	batch := &pgx.Batch{}
+	for _, t := range trades {
+		batch.Queue(query, t.TradeID, t.Symbol, t.Seq, t.MakerOrderID, t.TakerOrderID, t.TakerSide,
+			t.Price, t.Quantity, t.TakerLimitPrice, t.MakerLimitPrice, t.ExecutedAt, nextAttempt)
+	}
+	tx, err := DB.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("error beginning outbox transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+	if err := tx.SendBatch(ctx, batch).Close(); err != nil {
+		return fmt.Errorf("error enqueueing %d trades: %w", len(trades), err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("error committing %d outbox trades: %w", len(trades), err)
+	}
+	return nil
+}
+
+// GetDueOutboxTrades returns up to limit outbox trades due for a settlement attempt at now,
+// in the order they were executed. Trades held for review are never due, see MarkOutboxTradeForReview.
+func GetDueOutboxTrades(ctx context.Context, now time.Time, limit int) ([]*OutboxTrade, error) {
+	trades := make([]*OutboxTrade, 0)
+	query := `SELECT trade_id, symbol, seq, maker_order_id, taker_order_id, taker_side,
+					 price, quantity, taker_limit_price, maker_limit_price, executed_at, attempts
+			  FROM trade_outbox
+			  WHERE next_attempt_at <= $1 AND NOT needs_review
+			  ORDER BY executed_at, symbol, seq
+			  LIMIT $2`
+
+	rows, err := DB.Query(ctx, query, now, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error querying outbox trades: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		t := &OutboxTrade{}
+		if err := rows.Scan(&t.TradeID, &t.Symbol, &t.Seq, &t.MakerOrderID, &t.TakerOrderID, &t.TakerSide,
+			&t.Price, &t.Quantity, &t.TakerLimitPrice, &t.MakerLimitPrice, &t.ExecutedAt, &t.Attempts); err != nil {
+			return nil, fmt.Errorf("error scanning outbox trade row: %w", err)
+		}
+		trades = append(trades, t)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error iterating outbox trade rows: %w", rows.Err())
+	}
+	return trades, nil
+}
+
+// DeleteOutboxTrade removes a trade from the outbox within the transaction that settles it.
+// A trade that was never enqueued (e.g., the outbox write failed) is not an error.
+func DeleteOutboxTrade(ctx context.Context, tx pgx.Tx, tradeID uuid.UUID) error {
+	if _, err := tx.Exec(ctx, `DELETE FROM trade_outbox WHERE trade_id = $1`, tradeID); err != nil {
+		return fmt.Errorf("error deleting outbox trade %s: %w", tradeID, err)
+	}
+	return nil
+}
+
+// RecordOutboxFailure counts a failed settlement attempt of an outbox trade and puts off
+// the next one by retryAfter.
+func RecordOutboxFailure(ctx context.Context, tradeID uuid.UUID, cause error, retryAfter time.Duration) error {
+	query := `UPDATE trade_outbox
+			  SET attempts = attempts + 1, last_error = $2, next_attempt_at = $3
+			  WHERE trade_id = $1`
+
+	if _, err := DB.Exec(ctx, query, tradeID, cause.Error(), time.Now().Add(retryAfter)); err != nil {
+		return fmt.Errorf("error recording settlement failure of outbox trade %s: %w", tradeID, err)
+	}
+	return nil
+}
+
+// MarkOutboxTradeForReview counts a failed settlement attempt of an outbox trade and holds it for
+// manual review: it is no longer retried until released with RetryOutboxTrade.
+func MarkOutboxTradeForReview(ctx context.Context, tradeID uuid.UUID, cause error) error {
+	query := `UPDATE trade_outbox
+			  SET attempts = attempts + 1, last_error = $2, needs_review = TRUE
+			  WHERE trade_id = $1`
+
+	if _, err := DB.Exec(ctx, query, tradeID, cause.Error()); err != nil {
+		return fmt.Errorf("error holding outbox trade %s for review: %w", tradeID, err)
+	}
+	return nil
+}
+
+// GetOutboxTradesForReview returns the outbox trades held for manual review, in the order they
+// were executed, with the error that got them held.
+func GetOutboxTradesForReview(ctx context.Context) ([]*OutboxTrade, error) {
+	trades := make([]*OutboxTrade, 0)
+	query := `SELECT trade_id, symbol, seq, maker_order_id, taker_order_id, taker_side,
+					 price, quantity, taker_limit_price, maker_limit_price, executed_at, attempts, COALESCE(last_error, '')
+			  FROM trade_outbox
+			  WHERE needs_review
+			  ORDER BY executed_at, symbol, seq`
+
+	rows, err := DB.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("error querying outbox trades held for review: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		t := &OutboxTrade{}
+		if err := rows.Scan(&t.TradeID, &t.Symbol, &t.Seq, &t.MakerOrderID, &t.TakerOrderID, &t.TakerSide,
+			&t.Price, &t.Quantity, &t.TakerLimitPrice, &t.MakerLimitPrice, &t.ExecutedAt, &t.Attempts, &t.LastError); err != nil {
+			return nil, fmt.Errorf("error scanning outbox trade row: %w", err)
+		}
+		trades = append(trades, t)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error iterating outbox trade rows: %w", rows.Err())
+	}
+	return trades, nil
+}
+
+// RetryOutboxTrade releases an outbox trade held for review: its attempts start over and it is
+// due right away. Returns false if the trade isn't held for review.
+func RetryOutboxTrade(ctx context.Context, tradeID uuid.UUID) (bool, error) {
+	query := `UPDATE trade_outbox
+			  SET attempts = 0, needs_review = FALSE, next_attempt_at = NOW()
+			  WHERE trade_id = $1 AND needs_review`
+
+	tag, err := DB.Exec(ctx, query, tradeID)
+	if err != nil {
+		return false, fmt.Errorf("error releasing outbox trade %s for retry: %w", tradeID, err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
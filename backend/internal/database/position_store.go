@@ -0,0 +1,75 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/user/minicoinbase/backend/internal/models"
+)
+
+// LockPositions locks the positions of the given users in symbol (FOR UPDATE) in lock order,
+// creating missing ones empty, and returns them by user. Requires an active transaction (tx).
+func LockPositions(ctx context.Context, tx pgx.Tx, symbol string, userIDs ...uuid.UUID) (map[uuid.UUID]*models.Position, error) {
+	sorted := append([]uuid.UUID(nil), userIDs...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].String() < sorted[j].String() })
+
+	positions := make(map[uuid.UUID]*models.Position, len(sorted))
+	for _, userID := range sorted {
+		if _, ok := positions[userID]; ok {
+			continue
+		}
+		query := `INSERT INTO positions (user_id, symbol) VALUES ($1, $2)
+				  ON CONFLICT (user_id, symbol) DO NOTHING`
+		if _, err := tx.Exec(ctx, query, userID, symbol); err != nil {
+			return nil, fmt.Errorf("error creating position for user %s in %s: %w", userID, symbol, err)
+		}
+		p := &models.Position{}
+		query = `SELECT user_id, symbol, quantity, cost_basis, realized_pnl, updated_at
+				 FROM positions WHERE user_id = $1 AND symbol = $2 FOR UPDATE`
+		err := tx.QueryRow(ctx, query, userID, symbol).Scan(&p.UserID, &p.Symbol, &p.Quantity, &p.CostBasis, &p.RealizedPnL, &p.UpdatedAt)
+		if err != nil {
+			return nil, fmt.Errorf("error locking position for user %s in %s: %w", userID, symbol, err)
+		}
+		positions[userID] = p
+	}
+	return positions, nil
+}
+
+// UpdatePosition stores a position locked with LockPositions. Requires an active transaction (tx).
+func UpdatePosition(ctx context.Context, tx pgx.Tx, p *models.Position) error {
+	query := `UPDATE positions SET quantity = $1, cost_basis = $2, realized_pnl = $3, updated_at = NOW()
+			  WHERE user_id = $4 AND symbol = $5`
+	if _, err := tx.Exec(ctx, query, p.Quantity, p.CostBasis, p.RealizedPnL, p.UserID, p.Symbol); err != nil {
+		return fmt.Errorf("error updating position for user %s in %s: %w", p.UserID, p.Symbol, err)
+	}
+	return nil
+}
+
+// GetUserPositions retrieves a user's positions that are open or have realized P&L, by symbol.
+func GetUserPositions(ctx context.Context, userID uuid.UUID) ([]*models.Position, error) {
+	positions := make([]*models.Position, 0)
+	query := `SELECT user_id, symbol, quantity, cost_basis, realized_pnl, updated_at
+			  FROM positions WHERE user_id = $1 AND (quantity > 0 OR realized_pnl <> 0)
+			  ORDER BY symbol`
+
+	rows, err := DB.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying positions for user %s: %w", userID, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		p := &models.Position{}
+		if err := rows.Scan(&p.UserID, &p.Symbol, &p.Quantity, &p.CostBasis, &p.RealizedPnL, &p.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning position row for user %s: %w", userID, err)
+		}
+		positions = append(positions, p)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error iterating position rows for user %s: %w", userID, rows.Err())
+	}
+	return positions, nil
+}
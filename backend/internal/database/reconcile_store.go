@@ -0,0 +1,163 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/user/minicoinbase/backend/internal/models"
+)
+
+// lockedTolerance absorbs float rounding; balances are stored with 8 decimals.
+const lockedTolerance = 1e-8
+
+// LockedDiscrepancy is a balance whose stored locked amount differs from what the user's
+// open orders need locked.
+type LockedDiscrepancy struct {
+	Asset          string  `json:"asset"`
+	StoredLocked   float64 `json:"stored_locked"`
+	ExpectedLocked float64 `json:"expected_locked"`
+	Difference     float64 `json:"difference"` // Stored minus expected: positive means funds are stuck in locked
+	Fixed          bool    `json:"fixed"`
+}
+
+// FindLockedDiscrepancies adds up the locks recorded on a user's open and partially filled orders
+// for each asset and returns the balances where the total differs from the stored one.
+// The orders and balances are locked (in lock order, see LockBalances) until tx ends, so settlement
+// can't change them in between and the result can safely be fixed with FixLockedDiscrepancy.
+func FindLockedDiscrepancies(ctx context.Context, tx pgx.Tx, userID uuid.UUID) ([]*LockedDiscrepancy, error) {
+	query := `SELECT ` + orderColumns + ` FROM orders
+			  WHERE user_id = $1 AND status IN ('open', 'partially_filled') ORDER BY id FOR UPDATE`
+	rows, err := tx.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying open orders for user %s: %w", userID, err)
+	}
+	orders := make([]*models.Order, 0)
+	for rows.Next() {
+		order := &models.Order{}
+		if err := scanOrder(rows, order); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error scanning order row for user %s: %w", userID, err)
+		}
+		orders = append(orders, order)
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error iterating order rows for user %s: %w", userID, rows.Err())
+	}
+
+	// What each order still has locked: its recorded lock (see migration 0022), which fills
+	// consume as they settle
+	expected := make(map[string]float64)
+	for _, order := range orders {
+		expected[order.LockedAsset] += order.LockedAmount
+	}
+
+	stored := make(map[string]float64)
+	rows, err = tx.Query(ctx, `SELECT asset, locked FROM balances WHERE user_id = $1 ORDER BY asset FOR UPDATE`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying balances for user %s: %w", userID, err)
+	}
+	for rows.Next() {
+		var asset string
+		var locked float64
+		if err := rows.Scan(&asset, &locked); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("error scanning balance row for user %s: %w", userID, err)
+		}
+		stored[asset] = locked
+	}
+	rows.Close()
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error iterating balance rows for user %s: %w", userID, rows.Err())
+	}
+
+	assets := make(map[string]bool)
+	for asset := range expected {
+		assets[asset] = true
+	}
+	for asset := range stored {
+		assets[asset] = true
+	}
+	discrepancies := make([]*LockedDiscrepancy, 0)
+	for asset := range assets {
+		if diff := stored[asset] - expected[asset]; math.Abs(diff) > lockedTolerance {
+			discrepancies = append(discrepancies, &LockedDiscrepancy{
+				Asset:          asset,
+				StoredLocked:   stored[asset],
+				ExpectedLocked: expected[asset],
+				Difference:     diff,
+			})
+		}
+	}
+	sort.Slice(discrepancies, func(i, j int) bool { return discrepancies[i].Asset < discrepancies[j].Asset })
+	return discrepancies, nil
+}
+
+// FixLockedDiscrepancy sets the stored locked amount to the expected one, moving the difference
+// between locked and available so the total is unchanged, and records it in the ledger.
+// Fails without changing anything if available can't cover an increase of locked.
+// Requires the transaction FindLockedDiscrepancies ran in.
+func FixLockedDiscrepancy(ctx context.Context, tx pgx.Tx, userID uuid.UUID, d *LockedDiscrepancy) error {
+	query := `UPDATE balances SET available = available + $1, locked = locked - $1
+			  WHERE user_id = $2 AND asset = $3 AND available + $1 >= 0`
+
+	tag, err := tx.Exec(ctx, query, d.Difference, userID, d.Asset)
+	if err != nil {
+		return fmt.Errorf("error fixing locked %s for user %s: %w", d.Asset, userID, err)
+	}
+	if tag.RowsAffected() != 1 {
+		return fmt.Errorf("cannot fix locked %s for user %s: insufficient available funds to lock %f more",
+			d.Asset, userID, -d.Difference)
+	}
+	if err := recordLedger(ctx, tx, userID, d.Asset, d.Difference, -d.Difference, LedgerRef{Reason: LedgerReconcile}); err != nil {
+		return err
+	}
+	d.Fixed = true
+	return nil
+}
+
+// DuplicateLedgerEntry is a balance change recorded more than once for the same trade: a trade
+// is settled in one transaction that writes each of its entries once, so this means it was applied twice.
+type DuplicateLedgerEntry struct {
+	TradeID uuid.UUID `json:"trade_id"`
+	OrderID uuid.UUID `json:"order_id"`
+	UserID  uuid.UUID `json:"user_id"`
+	Asset   string    `json:"asset"`
+	Reason  string    `json:"reason"`
+	Entries int       `json:"entries"`
+}
+
+// FindDuplicateTradeEntries returns up to limit ledger entries recorded more than once for the
+// same trade, order, user, asset and reason, most recent trade first.
+func FindDuplicateTradeEntries(ctx context.Context, limit int) ([]*DuplicateLedgerEntry, error) {
+	duplicates := make([]*DuplicateLedgerEntry, 0)
+	query := `SELECT trade_id, COALESCE(order_id, '00000000-0000-0000-0000-000000000000'), user_id, asset, reason, COUNT(*)
+			  FROM ledger
+			  WHERE trade_id IS NOT NULL
+			  GROUP BY trade_id, order_id, user_id, asset, reason
+			  HAVING COUNT(*) > 1
+			  ORDER BY MAX(id) DESC
+			  LIMIT $1`
+
+	rows, err := DB.Query(ctx, query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error querying duplicate trade ledger entries: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		d := &DuplicateLedgerEntry{}
+		if err := rows.Scan(&d.TradeID, &d.OrderID, &d.UserID, &d.Asset, &d.Reason, &d.Entries); err != nil {
+			return nil, fmt.Errorf("error scanning duplicate ledger entry row: %w", err)
+		}
+		duplicates = append(duplicates, d)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error iterating duplicate ledger entry rows: %w", rows.Err())
+	}
+	return duplicates, nil
+}
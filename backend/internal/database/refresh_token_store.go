@@ -0,0 +1,80 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+)
+
+// StoreRefreshToken saves the hash of a newly issued refresh token.
+func StoreRefreshToken(ctx context.Context, userID uuid.UUID, tokenHash string, expiresAt time.Time) error {
+	query := `INSERT INTO refresh_tokens (user_id, token_hash, expires_at) VALUES ($1, $2, $3)`
+
+	if _, err := DB.Exec(ctx, query, userID, tokenHash, expiresAt); err != nil {
+		if _, ok := uniqueViolation(err); ok {
+			err = ErrDuplicateKey
+		}
+		return fmt.Errorf("error storing refresh token for user %s: %w", userID, err)
+	}
+	return nil
+}
+
+// ConsumeRefreshToken revokes a valid (unexpired, unrevoked) refresh token and returns its user.
+// Revoking on use means each refresh token works once, so a stolen token that has already
+// been rotated is useless. Returns uuid.Nil, false if the token is unknown, expired or revoked.
+func ConsumeRefreshToken(ctx context.Context, tokenHash string) (uuid.UUID, bool, error) {
+	var userID uuid.UUID
+	query := `UPDATE refresh_tokens SET revoked_at = NOW()
+			  WHERE token_hash = $1 AND revoked_at IS NULL AND expires_at > NOW()
+			  RETURNING user_id`
+
+	err := DB.QueryRow(ctx, query, tokenHash).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return uuid.Nil, false, nil
+		}
+		return uuid.Nil, false, fmt.Errorf("error consuming refresh token: %w", err)
+	}
+	return userID, true, nil
+}
+
+// RefreshTokenUser returns the user of a valid refresh token without consuming it.
+// Returns uuid.Nil, false if the token is unknown, expired or revoked.
+func RefreshTokenUser(ctx context.Context, tokenHash string) (uuid.UUID, bool, error) {
+	var userID uuid.UUID
+	query := `SELECT user_id FROM refresh_tokens
+			  WHERE token_hash = $1 AND revoked_at IS NULL AND expires_at > NOW()`
+
+	err := DB.QueryRow(ctx, query, tokenHash).Scan(&userID)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return uuid.Nil, false, nil
+		}
+		return uuid.Nil, false, fmt.Errorf("error looking up refresh token: %w", err)
+	}
+	return userID, true, nil
+}
+
+// RevokeRefreshToken revokes a refresh token (e.g., on logout). Unknown or already revoked tokens are ignored.
+func RevokeRefreshToken(ctx context.Context, tokenHash string) error {
+	query := `UPDATE refresh_tokens SET revoked_at = NOW() WHERE token_hash = $1 AND revoked_at IS NULL`
+
+	if _, err := DB.Exec(ctx, query, tokenHash); err != nil {
+		return fmt.Errorf("error revoking refresh token: %w", err)
+	}
+	return nil
+}
+
+// RevokeUserRefreshTokens revokes every active refresh token of a user (e.g., after a password change).
+func RevokeUserRefreshTokens(ctx context.Context, userID uuid.UUID) error {
+	query := `UPDATE refresh_tokens SET revoked_at = NOW() WHERE user_id = $1 AND revoked_at IS NULL`
+
+	if _, err := DB.Exec(ctx, query, userID); err != nil {
+		return fmt.Errorf("error revoking refresh tokens for user %s: %w", userID, err)
+	}
+	return nil
+}
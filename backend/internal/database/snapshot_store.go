@@ -0,0 +1,35 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// SaveOrderBookSnapshot stores the serialized state of a symbol's order book,
+// replacing its previous snapshot.
+func SaveOrderBookSnapshot(ctx context.Context, symbol string, seq uint64, data []byte) error {
+	query := `INSERT INTO order_book_snapshots (symbol, seq, data) VALUES ($1, $2, $3)
+			  ON CONFLICT (symbol) DO UPDATE SET seq = EXCLUDED.seq, data = EXCLUDED.data, created_at = NOW()`
+
+	if _, err := DB.Exec(ctx, query, symbol, int64(seq), data); err != nil {
+		return fmt.Errorf("error saving order book snapshot for %s: %w", symbol, err)
+	}
+	return nil
+}
+
+// GetOrderBookSnapshot returns the serialized state of a symbol's order book,
+// or nil if no snapshot was ever saved for it.
+func GetOrderBookSnapshot(ctx context.Context, symbol string) ([]byte, error) {
+	var data []byte
+	err := DB.QueryRow(ctx, `SELECT data FROM order_book_snapshots WHERE symbol = $1`, symbol).Scan(&data)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error loading order book snapshot for %s: %w", symbol, err)
+	}
+	return data, nil
+}
@@ -0,0 +1,200 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/user/minicoinbase/backend/internal/models"
+)
+
+// TradeFilter narrows down the trades returned by GetUserTrades.
+// Zero values mean "no filter" for Symbol, Side, From and To.
+type TradeFilter struct {
+	Symbol string    // e.g., "BTC-USD"
+	Side   string    // The user's side, "buy" or "sell"
+	From   time.Time // Inclusive lower bound on trade time
+	To     time.Time // Exclusive upper bound on trade time
+	Limit  int
+	Offset int
+}
+
+// CreateTrade records an executed trade within a transaction.
+// The trade's CreatedAt is used as the execution time. Its ID is assigned by the order book,
+// so recording the same trade twice fails with ErrDuplicateKey.
+func CreateTrade(ctx context.Context, tx pgx.Tx, trade *models.Trade) error {
+	query := `INSERT INTO trades (id, symbol, seq, maker_order_id, taker_order_id, taker_side, price, quantity, maker_fee, taker_fee, created_at)
+			  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
+
+	_, err := tx.Exec(ctx, query,
+		trade.ID, trade.Symbol, trade.Seq, trade.MakerOrderID, trade.TakerOrderID, trade.TakerSide,
+		trade.Price, trade.Quantity, trade.MakerFee, trade.TakerFee, trade.CreatedAt,
+	)
+
+	if err != nil {
+		if _, ok := uniqueViolation(err); ok {
+			err = ErrDuplicateKey
+		}
+		return fmt.Errorf("error creating trade %s: %w", trade.ID, err)
+	}
+	return nil
+}
+
+// GetSymbolTradesSince returns up to limit trades of a symbol with a sequence number above seq,
+// in sequence order. Only the public fields (no order IDs or fees) are filled in.
+func GetSymbolTradesSince(ctx context.Context, symbol string, seq int64, limit int) ([]*models.Trade, error) {
+	trades := make([]*models.Trade, 0)
+	query := `SELECT seq, symbol, taker_side, price, quantity, created_at
+			  FROM trades
+			  WHERE symbol = $1 AND seq > $2
+			  ORDER BY seq
+			  LIMIT $3`
+
+	rows, err := DB.Query(ctx, query, symbol, seq, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error querying trades of %s since seq %d: %w", symbol, seq, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		trade := &models.Trade{}
+		if err := rows.Scan(&trade.Seq, &trade.Symbol, &trade.TakerSide, &trade.Price, &trade.Quantity, &trade.CreatedAt); err != nil {
+			return nil, fmt.Errorf("error scanning trade row of %s: %w", symbol, err)
+		}
+		trades = append(trades, trade)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error iterating trade rows of %s: %w", symbol, rows.Err())
+	}
+	return trades, nil
+}
+
+// GetMaxTradeSeqs returns the highest trade sequence number stored for each symbol,
+// counting trades still waiting in the outbox.
+func GetMaxTradeSeqs(ctx context.Context) (map[string]int64, error) {
+	rows, err := DB.Query(ctx, `SELECT symbol, MAX(seq) FROM (
+								SELECT symbol, seq FROM trades
+								UNION ALL
+								SELECT symbol, seq FROM trade_outbox
+							) t GROUP BY symbol`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying trade sequence numbers: %w", err)
+	}
+	defer rows.Close()
+
+	seqs := make(map[string]int64)
+	for rows.Next() {
+		var symbol string
+		var seq int64
+		if err := rows.Scan(&symbol, &seq); err != nil {
+			return nil, fmt.Errorf("error scanning trade sequence row: %w", err)
+		}
+		seqs[symbol] = seq
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error iterating trade sequence rows: %w", rows.Err())
+	}
+	return seqs, nil
+}
+
+// userTrades selects the trades of the user $1 from their side, see GetUserTrades. A trade is
+// joined against the user's orders (archived ones included) on either the maker or the taker side,
+// so a user who traded with themselves sees both sides of that trade.
+const userTrades = `SELECT t.id, o.id, t.symbol, o.side,
+						   CASE WHEN o.id = t.taker_order_id THEN 'taker' ELSE 'maker' END,
+						   t.price, t.quantity,
+						   CASE WHEN o.id = t.taker_order_id THEN t.taker_fee ELSE t.maker_fee END,
+						   split_part(t.symbol, '-', CASE WHEN o.side = 'buy' THEN 1 ELSE 2 END),
+						   t.created_at
+					FROM trades t
+					JOIN all_orders o ON o.id = t.maker_order_id OR o.id = t.taker_order_id
+					WHERE o.user_id = $1`
+
+// scanUserTrade scans a row selected with userTrades.
+func scanUserTrade(row pgx.Row, trade *models.UserTrade) error {
+	return row.Scan(
+		&trade.TradeID, &trade.OrderID, &trade.Symbol, &trade.Side,
+		&trade.Role, &trade.Price, &trade.Quantity, &trade.Fee, &trade.FeeAsset, &trade.Timestamp,
+	)
+}
+
+// GetUserTrades retrieves the executed trades of a user, newest first.
+func GetUserTrades(ctx context.Context, userID uuid.UUID, filter TradeFilter) ([]*models.UserTrade, error) {
+	trades := make([]*models.UserTrade, 0)
+	query := userTrades
+	args := []interface{}{userID}
+
+	if filter.Symbol != "" {
+		args = append(args, filter.Symbol)
+		query += fmt.Sprintf(" AND t.symbol = $%d", len(args))
+	}
+	if filter.Side != "" {
+		args = append(args, filter.Side)
+		query += fmt.Sprintf(" AND o.side = $%d", len(args))
+	}
+	if !filter.From.IsZero() {
+		args = append(args, filter.From)
+		query += fmt.Sprintf(" AND t.created_at >= $%d", len(args))
+	}
+	if !filter.To.IsZero() {
+		args = append(args, filter.To)
+		query += fmt.Sprintf(" AND t.created_at < $%d", len(args))
+	}
+	args = append(args, filter.Limit, filter.Offset)
+	query += fmt.Sprintf(" ORDER BY t.created_at DESC, t.id LIMIT $%d OFFSET $%d", len(args)-1, len(args))
+
+	rows, err := DB.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error querying trades for user %s: %w", userID, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		trade := &models.UserTrade{}
+		if err := scanUserTrade(rows, trade); err != nil {
+			return nil, fmt.Errorf("error scanning trade row for user %s: %w", userID, err)
+		}
+		trades = append(trades, trade)
+	}
+
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error iterating trade rows for user %s: %w", userID, rows.Err())
+	}
+
+	return trades, nil
+}
+
+// GetOrderFills returns the fills of an order in execution order, see the fills view.
+// Trades still waiting in the outbox are not included until they are settled.
+func GetOrderFills(ctx context.Context, orderID uuid.UUID) ([]*models.Fill, error) {
+	fills := make([]*models.Fill, 0)
+	query := `SELECT trade_id, order_id, symbol, side, role, price, quantity, fee, fee_asset,
+					 cumulative_quantity, avg_price, cumulative_fee, created_at
+			  FROM fills
+			  WHERE order_id = $1
+			  ORDER BY seq, trade_id`
+
+	rows, err := DB.Query(ctx, query, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("error querying fills of order %s: %w", orderID, err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		fill := &models.Fill{}
+		err := rows.Scan(
+			&fill.TradeID, &fill.OrderID, &fill.Symbol, &fill.Side, &fill.Role, &fill.Price, &fill.Quantity,
+			&fill.Fee, &fill.FeeAsset, &fill.CumulativeQuantity, &fill.AvgPrice, &fill.CumulativeFee, &fill.Timestamp,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning fill row of order %s: %w", orderID, err)
+		}
+		fills = append(fills, fill)
+	}
+	if rows.Err() != nil {
+		return nil, fmt.Errorf("error iterating fill rows of order %s: %w", orderID, rows.Err())
+	}
+	return fills, nil
+}
@@ -0,0 +1,51 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/user/minicoinbase/backend/internal/models"
+)
+
+// TransferFunds moves transfer.Amount of transfer.Asset from the sender's available balance to
+// the recipient's in one transaction, records the transfer and writes both sides to the ledger.
+// Fills in the transfer's ID and CreatedAt. Fails with ErrInsufficientFunds if the sender doesn't
+// have the amount available.
+func TransferFunds(ctx context.Context, transfer *models.Transfer) error {
+	tx, err := DB.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("error beginning transfer transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Both balances are taken up front, in lock order, so two users sending to each other can't deadlock
+	err = LockBalances(ctx, tx,
+		BalanceKey{UserID: transfer.SenderID, Asset: transfer.Asset},
+		BalanceKey{UserID: transfer.RecipientID, Asset: transfer.Asset})
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO transfers (sender_id, recipient_id, asset, amount)
+			  VALUES ($1, $2, $3, $4)
+			  RETURNING id, created_at`
+	err = tx.QueryRow(ctx, query, transfer.SenderID, transfer.RecipientID, transfer.Asset, transfer.Amount).
+		Scan(&transfer.ID, &transfer.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("error recording transfer from user %s: %w", transfer.SenderID, err)
+	}
+
+	out := LedgerRef{Reason: LedgerTransferOut, TransferID: transfer.ID}
+	if err := SubtractFunds(ctx, tx, transfer.SenderID, transfer.Asset, transfer.Amount, out); err != nil {
+		return err
+	}
+	in := LedgerRef{Reason: LedgerTransferIn, TransferID: transfer.ID}
+	if err := AddFunds(ctx, tx, transfer.RecipientID, transfer.Asset, transfer.Amount, in); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return fmt.Errorf("error committing transfer from user %s: %w", transfer.SenderID, err)
+	}
+	return nil
+}
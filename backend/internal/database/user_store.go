@@ -3,13 +3,16 @@ package database
 import (
 	"context"
 	"errors"
+	"fmt"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
 	"github.com/user/minicoinbase/backend/internal/models" // Import models package
 )
 
-// CreateUser inserts a new user into the database.
+// CreateUser inserts a new user into the database. The username is expected normalized
+// (see auth.NormalizeUsername), but is compared without regard to case either way.
+// Returns ErrUsernameTaken if the username is already in use, in any case.
 func CreateUser(ctx context.Context, username string, passwordHash string) (*models.User, error) {
 	user := &models.User{
 		Username: username,
@@ -17,26 +20,31 @@ func CreateUser(ctx context.Context, username string, passwordHash string) (*mod
 	}
 
 	query := `INSERT INTO users (username, password_hash) VALUES ($1, $2)
-			  RETURNING id, created_at`
+			  RETURNING id, role, created_at`
 
 	err := DB.QueryRow(ctx, query, username, passwordHash).
-		Scan(&user.ID, &user.CreatedAt)
+		Scan(&user.ID, &user.Role, &user.CreatedAt)
 
 	if err != nil {
-		// TODO: Check for specific errors like unique constraint violation
-		return nil, err
+		if constraint, ok := uniqueViolation(err); ok {
+			if constraint == "users_username_key" || constraint == "users_username_lower_key" {
+				return nil, ErrUsernameTaken
+			}
+			return nil, fmt.Errorf("error creating user %s: %w", username, ErrDuplicateKey)
+		}
+		return nil, fmt.Errorf("error creating user %s: %w", username, err)
 	}
 
 	return user, nil
 }
 
-// GetUserByUsername retrieves a user by their username.
+// GetUserByUsername retrieves a user by their username, in any case.
 func GetUserByUsername(ctx context.Context, username string) (*models.User, error) {
 	user := &models.User{}
-	query := `SELECT id, username, password_hash, created_at FROM users WHERE username = $1`
+	query := `SELECT id, username, password_hash, role, created_at FROM users WHERE LOWER(username) = LOWER($1)`
 
 	err := DB.QueryRow(ctx, query, username).
-		Scan(&user.ID, &user.Username, &user.Password, &user.CreatedAt)
+		Scan(&user.ID, &user.Username, &user.Password, &user.Role, &user.CreatedAt)
 
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -51,10 +59,10 @@ func GetUserByUsername(ctx context.Context, username string) (*models.User, erro
 // GetUserByID retrieves a user by their ID.
 func GetUserByID(ctx context.Context, userID uuid.UUID) (*models.User, error) {
 	user := &models.User{}
-	query := `SELECT id, username, password_hash, created_at FROM users WHERE id = $1`
+	query := `SELECT id, username, password_hash, role, created_at FROM users WHERE id = $1`
 
 	err := DB.QueryRow(ctx, query, userID).
-		Scan(&user.ID, &user.Username, &user.Password, &user.CreatedAt)
+		Scan(&user.ID, &user.Username, &user.Password, &user.Role, &user.CreatedAt)
 
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
@@ -65,3 +73,39 @@ func GetUserByID(ctx context.Context, userID uuid.UUID) (*models.User, error) {
 
 	return user, nil
 }
+
+// UpdateUserPassword replaces a user's password hash.
+func UpdateUserPassword(ctx context.Context, userID uuid.UUID, passwordHash string) error {
+	query := `UPDATE users SET password_hash = $1 WHERE id = $2`
+
+	tag, err := DB.Exec(ctx, query, passwordHash, userID)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return pgx.ErrNoRows
+	}
+	return nil
+}
+
+// ListUsers retrieves all users, oldest first (password hashes are not loaded).
+func ListUsers(ctx context.Context) ([]*models.User, error) {
+	users := make([]*models.User, 0)
+	query := `SELECT id, username, role, created_at FROM users ORDER BY created_at`
+
+	rows, err := DB.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		user := &models.User{}
+		if err := rows.Scan(&user.ID, &user.Username, &user.Role, &user.CreatedAt); err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+
+	return users, rows.Err()
+}
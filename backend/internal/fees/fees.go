@@ -0,0 +1,31 @@
+package fees
+
+import (
+	"github.com/google/uuid"
+	"github.com/user/minicoinbase/backend/internal/config"
+)
+
+// HouseUserID is the account trading fees are credited to (created by migration 0004).
+var HouseUserID = uuid.MustParse("00000000-0000-0000-0000-000000000001")
+
+// Fee rates in basis points (1 bps = 0.01%), set once at startup by Init.
+var (
+	makerFeeBps = 10.0
+	takerFeeBps = 20.0
+)
+
+// Init sets the fee rates from the configuration.
+func Init(cfg *config.Config) {
+	makerFeeBps = cfg.MakerFeeBps
+	takerFeeBps = cfg.TakerFeeBps
+}
+
+// MakerFee returns the fee a maker pays on the amount of the asset it receives.
+func MakerFee(receivedAmount float64) float64 {
+	return receivedAmount * makerFeeBps / 10000
+}
+
+// TakerFee returns the fee a taker pays on the amount of the asset it receives.
+func TakerFee(receivedAmount float64) float64 {
+	return receivedAmount * takerFeeBps / 10000
+}
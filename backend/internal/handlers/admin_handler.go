@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/user/minicoinbase/backend/internal/apierror"
+	"github.com/user/minicoinbase/backend/internal/archive"
+	"github.com/user/minicoinbase/backend/internal/database"
+	"github.com/user/minicoinbase/backend/internal/logging"
+	"github.com/user/minicoinbase/backend/internal/markets"
+	"github.com/user/minicoinbase/backend/internal/middleware"
+	"github.com/user/minicoinbase/backend/internal/orderbook"
+	ws "github.com/user/minicoinbase/backend/internal/websocket"
+)
+
+// ListUsers returns all user accounts. Admin only.
+func ListUsers(c *fiber.Ctx) error {
+	users, err := database.ListUsers(c.Context())
+	if err != nil {
+		log.Printf("Error listing users: %v", err)
+		return apierror.Send(c, apierror.Internal, "Failed to retrieve users")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(users)
+}
+
+// Reconcile compares the locked balances of a user (?user_id=) with what their open orders need
+// and reports the discrepancies. With ?fix=true each one is corrected, moving the difference
+// between locked and available and recording it in the ledger. Admin only.
+func Reconcile(c *fiber.Ctx) error {
+	userID, err := uuid.Parse(c.Query("user_id"))
+	if err != nil {
+		return apierror.Send(c, apierror.BadRequest, "user_id must be a valid user ID")
+	}
+	fix := c.QueryBool("fix", false)
+	logger := logging.FromContext(c.Context()).With("user_id", userID)
+
+	tx, err := database.DB.Begin(c.Context())
+	if err != nil {
+		logger.Error("Reconcile: Failed to begin transaction", "err", err)
+		return apierror.Send(c, apierror.Internal, "Database error starting transaction")
+	}
+	defer tx.Rollback(c.Context())
+
+	discrepancies, err := database.FindLockedDiscrepancies(c.Context(), tx, userID)
+	if err != nil {
+		logger.Error("Reconcile: Failed to check balances", "err", err)
+		return apierror.Send(c, apierror.Internal, "Failed to check balances")
+	}
+	for _, d := range discrepancies {
+		logger.Warn("Locked balance does not match open orders",
+			"asset", d.Asset, "stored_locked", d.StoredLocked, "expected_locked", d.ExpectedLocked)
+	}
+
+	if fix && len(discrepancies) > 0 {
+		for _, d := range discrepancies {
+			if err := database.FixLockedDiscrepancy(c.Context(), tx, userID, d); err != nil {
+				logger.Error("Reconcile: Failed to fix locked balance", "asset", d.Asset, "err", err)
+				return apierror.SendDetails(c, apierror.Conflict, fmt.Sprintf("Failed to fix %s, nothing was changed: %v", d.Asset, err),
+					fiber.Map{"discrepancies": discrepancies})
+			}
+		}
+		if err := tx.Commit(c.Context()); err != nil {
+			logger.Error("Reconcile: Failed to commit fixes", "err", err)
+			return apierror.Send(c, apierror.Internal, "Database error committing fixes")
+		}
+		logger.Info("Fixed locked balances", "count", len(discrepancies))
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"user_id":       userID,
+		"discrepancies": discrepancies,
+	})
+}
+
+// AdjustBalanceRequest defines the expected JSON body for an admin balance adjustment.
+type AdjustBalanceRequest struct {
+	UserID uuid.UUID `json:"user_id"`
+	Asset  string    `json:"asset"`  // e.g., "USD"
+	Delta  float64   `json:"delta"`  // Positive credits, negative debits the available balance
+	Reason string    `json:"reason"` // Why, e.g. the support ticket; kept in the ledger
+}
+
+// maxAdjustReasonLength caps the reason given for a balance adjustment, in characters.
+const maxAdjustReasonLength = 500
+
+// AdjustBalance credits or debits a user's available balance for support operations (refunds,
+// corrections), in one transaction that records it in the ledger as an admin_adjustment with
+// the acting admin's ID and the reason given. Debits that would take available below zero are
+// rejected. Admin only.
+func AdjustBalance(c *fiber.Ctx) error {
+	adminID, ok := c.Locals("userID").(uuid.UUID)
+	if !ok {
+		return apierror.Send(c, apierror.Unauthorized, "Invalid user ID in token")
+	}
+	req, err := validateAndBind[AdjustBalanceRequest](c)
+	if err != nil {
+		return apierror.Send(c, apierror.BadRequest, err.Error())
+	}
+	req.Asset = strings.ToUpper(strings.TrimSpace(req.Asset))
+	req.Reason = strings.TrimSpace(req.Reason)
+	if req.UserID == uuid.Nil || req.Asset == "" || req.Reason == "" {
+		return apierror.Send(c, apierror.BadRequest, "user_id, asset and reason are required")
+	}
+	if len([]rune(req.Reason)) > maxAdjustReasonLength {
+		return apierror.Send(c, apierror.BadRequest, fmt.Sprintf("reason must be at most %d characters", maxAdjustReasonLength))
+	}
+	if err := checkAmount("delta", req.Delta); err != nil {
+		return apierror.Send(c, apierror.BadRequest, err.Error())
+	}
+	if req.Delta == 0 {
+		return apierror.Send(c, apierror.BadRequest, "delta must not be zero")
+	}
+	if !markets.IsAsset(req.Asset) {
+		return apierror.Send(c, apierror.BadRequest, "Unknown asset "+req.Asset)
+	}
+
+	logger := logging.FromContext(c.Context()).With("user_id", req.UserID, "asset", req.Asset, "delta", req.Delta, "admin_id", adminID)
+	user, err := database.GetUserByID(c.Context(), req.UserID)
+	if err != nil {
+		logger.Error("Failed to look up user to adjust", "err", err)
+		return apierror.Send(c, apierror.Internal, "Database error looking up user")
+	}
+	if user == nil {
+		return apierror.Send(c, apierror.NotFound, "User not found")
+	}
+
+	balance, err := database.AdjustBalance(c.Context(), req.UserID, req.Asset, req.Delta, adminID, req.Reason)
+	if err != nil {
+		if errors.Is(err, database.ErrInsufficientFunds) {
+			return apierror.Send(c, apierror.InsufficientFunds, "Insufficient available "+req.Asset+" balance to debit")
+		}
+		logger.Error("Failed to adjust balance", "err", err)
+		return apierror.Send(c, apierror.Internal, "Failed to adjust balance")
+	}
+	logger.Warn("Balance adjusted by admin", "reason", req.Reason, "available", balance.Available)
+	return c.Status(fiber.StatusOK).JSON(balance)
+}
+
+// duplicateEntriesLimit caps the duplicate ledger entries SettlementReview reports.
+const duplicateEntriesLimit = 100
+
+// SettlementReview lists the trades held for manual review after failing to settle too many
+// times, and any trade the ledger shows was applied twice (its balance changes recorded more
+// than once), which settlement should make impossible. Admin only.
+func SettlementReview(c *fiber.Ctx) error {
+	logger := logging.FromContext(c.Context())
+	held, err := database.GetOutboxTradesForReview(c.Context())
+	if err != nil {
+		logger.Error("Failed to list trades held for review", "err", err)
+		return apierror.Send(c, apierror.Internal, "Failed to retrieve trades held for review")
+	}
+	duplicates, err := database.FindDuplicateTradeEntries(c.Context(), duplicateEntriesLimit)
+	if err != nil {
+		logger.Error("Failed to check the ledger for double-applied trades", "err", err)
+		return apierror.Send(c, apierror.Internal, "Failed to check the ledger")
+	}
+	for _, d := range duplicates {
+		logger.Error("Trade applied more than once", "trade_id", d.TradeID, "order_id", d.OrderID,
+			"user_id", d.UserID, "asset", d.Asset, "reason", d.Reason, "entries", d.Entries)
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"held_for_review":   held,
+		"duplicate_entries": duplicates,
+	})
+}
+
+// RetrySettlement releases a trade held for review (:id) back to the outbox worker, which retries
+// it on its next run with its attempts starting over. Admin only.
+func RetrySettlement(c *fiber.Ctx) error {
+	tradeID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return apierror.Send(c, apierror.BadRequest, "Invalid trade ID format")
+	}
+	logger := logging.FromContext(c.Context()).With("trade_id", tradeID)
+
+	released, err := database.RetryOutboxTrade(c.Context(), tradeID)
+	if err != nil {
+		logger.Error("Failed to release trade for retry", "err", err)
+		return apierror.Send(c, apierror.Internal, "Failed to release trade for retry")
+	}
+	if !released {
+		return apierror.Send(c, apierror.NotFound, "Trade not held for review")
+	}
+	logger.Warn("Trade released for settlement retry by admin", "admin_id", c.Locals("userID"))
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"trade_id": tradeID, "released": true})
+}
+
+// EnableMaintenance puts the whole service in maintenance mode: every request but the health
+// probes and those of admins gets 503 until it is disabled again. Admin only.
+func EnableMaintenance(c *fiber.Ctx) error {
+	return setMaintenance(c, true)
+}
+
+// DisableMaintenance takes the service out of maintenance mode. Admin only.
+func DisableMaintenance(c *fiber.Ctx) error {
+	return setMaintenance(c, false)
+}
+
+func setMaintenance(c *fiber.Ctx, on bool) error {
+	middleware.SetMaintenance(on)
+	logging.FromContext(c.Context()).Warn("Maintenance mode changed by admin", "maintenance", on, "admin_id", c.Locals("userID"))
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"maintenance": on})
+}
+
+// HaltSymbol halts trading on a symbol (:symbol): new orders and modifications are rejected
+// with 503 until it is resumed, cancellations still work. Admin only.
+func HaltSymbol(c *fiber.Ctx) error {
+	return setHalted(c, true)
+}
+
+// ResumeSymbol resumes trading on a halted symbol (:symbol). Admin only.
+func ResumeSymbol(c *fiber.Ctx) error {
+	return setHalted(c, false)
+}
+
+func setHalted(c *fiber.Ctx, halted bool) error {
+	symbol := strings.ToUpper(c.Params("symbol"))
+	var err error
+	if halted {
+		err = orderbook.GlobalOrderBookManager.HaltSymbol(symbol)
+	} else {
+		err = orderbook.GlobalOrderBookManager.ResumeSymbol(symbol)
+	}
+	if errors.Is(err, orderbook.ErrUnknownSymbol) {
+		return unknownSymbolResponse(c, symbol)
+	}
+	if err != nil {
+		logging.FromContext(c.Context()).Error("Failed to change trading halt", "symbol", symbol, "halted", halted, "err", err)
+		return apierror.Send(c, apierror.Internal, "Failed to change trading halt")
+	}
+	logging.FromContext(c.Context()).Warn("Trading halt changed by admin", "symbol", symbol, "halted", halted,
+		"admin_id", c.Locals("userID"))
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"symbol": symbol, "halted": halted})
+}
+
+// GetBookOrders lists every order the in-memory book of a symbol (:symbol) holds, with their
+// owners: the live view of the book to compare with the orders in the database. Admin only,
+// as it exposes who has which orders.
+func GetBookOrders(c *fiber.Ctx) error {
+	symbol := strings.ToUpper(c.Params("symbol"))
+	orders, err := orderbook.GlobalOrderBookManager.GetLiveOrders(symbol)
+	if errors.Is(err, orderbook.ErrUnknownSymbol) {
+		return unknownSymbolResponse(c, symbol)
+	}
+	if err != nil {
+		logging.FromContext(c.Context()).Error("Failed to list book orders", "symbol", symbol, "err", err)
+		return apierror.Send(c, apierror.Internal, "Failed to list book orders")
+	}
+	return c.Status(fiber.StatusOK).JSON(orders)
+}
+
+// Metrics reports the WebSocket hub's backpressure: messages dropped and clients disconnected
+// for not keeping up, and the connected clients falling behind, by address. Admin only.
+func Metrics(c *fiber.Ctx) error {
+	if ws.GlobalHub == nil {
+		return apierror.Send(c, apierror.Unavailable, "WebSocket hub not running")
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"websocket": ws.GlobalHub.Stats()})
+}
+
+// ArchiveOrders moves filled and cancelled orders older than ?older_than (a duration such as 720h,
+// default the configured retention) from the orders table to the archive. Admin only.
+func ArchiveOrders(c *fiber.Ctx) error {
+	olderThan := archive.Retention
+	if v := c.Query("older_than"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return apierror.Send(c, apierror.BadRequest, "older_than must be a non-negative duration, e.g. 720h")
+		}
+		olderThan = d
+	}
+	before := time.Now().Add(-olderThan)
+	logger := logging.FromContext(c.Context())
+
+	archived, err := archive.ArchiveOrders(c.Context(), before)
+	if err != nil {
+		logger.Error("Failed to archive orders", "before", before, "archived", archived, "err", err)
+		return apierror.SendDetails(c, apierror.Internal, "Failed to archive orders", fiber.Map{"archived": archived})
+	}
+	logger.Info("Orders archived by admin", "before", before, "archived", archived, "admin_id", c.Locals("userID"))
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"archived": archived, "before": before})
+}
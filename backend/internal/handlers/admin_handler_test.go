@@ -0,0 +1,146 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/user/minicoinbase/backend/internal/config"
+	"github.com/user/minicoinbase/backend/internal/database"
+	"github.com/user/minicoinbase/backend/internal/orderbook"
+)
+
+func TestTradeFailingToSettleIsHeldForReview(t *testing.T) {
+	setupTestDB(t)
+	orderbook.InitManager(&config.Config{SelfTradePolicy: "cancel_newest", SettlementRetryInterval: time.Second, SettlementMaxAttempts: 2})
+	app := newTestApp()
+	app.Get("/api/admin/settlement/review", SettlementReview)
+	app.Post("/api/admin/settlement/review/:id/retry", RetrySettlement)
+	ctx := context.Background()
+
+	// Its orders don't exist, so it can never settle
+	_, symbol := newTestMarket(t)
+	trade := &database.OutboxTrade{
+		TradeID: uuid.New(), Symbol: symbol, Seq: 1, MakerOrderID: uuid.New(), TakerOrderID: uuid.New(),
+		TakerSide: "buy", Price: 100, Quantity: 1, ExecutedAt: time.Now(),
+	}
+	if err := database.EnqueueTrades(ctx, []*database.OutboxTrade{trade}, 0); err != nil {
+		t.Fatalf("EnqueueTrades: %v", err)
+	}
+	t.Cleanup(func() {
+		database.DB.Exec(context.Background(), `DELETE FROM trade_outbox WHERE trade_id = $1`, trade.TradeID)
+	})
+
+	held := func() *database.OutboxTrade {
+		t.Helper()
+		var review struct {
+			HeldForReview []*database.OutboxTrade `json:"held_for_review"`
+		}
+		if status := doRequest(t, app, uuid.New(), http.MethodGet, "/api/admin/settlement/review", nil, &review); status != fiber.StatusOK {
+			t.Fatalf("settlement review: status %d", status)
+		}
+		for _, h := range review.HeldForReview {
+			if h.TradeID == trade.TradeID {
+				return h
+			}
+		}
+		return nil
+	}
+
+	// Past every back-off delay, so each run tries it
+	later := time.Now().Add(time.Hour)
+	for attempt := 1; attempt <= 2; attempt++ {
+		if _, _, err := orderbook.GlobalOrderBookManager.SettleOutbox(ctx, later); err != nil {
+			t.Fatalf("SettleOutbox: %v", err)
+		}
+		if h := held(); (h != nil) != (attempt == 2) {
+			t.Fatalf("after attempt %d held for review = %v, want %v", attempt, h != nil, attempt == 2)
+		}
+	}
+	h := held()
+	if h.Attempts != 2 || h.LastError == "" {
+		t.Errorf("held trade = %+v, want 2 attempts and the last error", h)
+	}
+
+	// Held trades are no longer due, however late it gets
+	due, err := database.GetDueOutboxTrades(ctx, later.Add(24*time.Hour), 10000)
+	if err != nil {
+		t.Fatalf("GetDueOutboxTrades: %v", err)
+	}
+	for _, d := range due {
+		if d.TradeID == trade.TradeID {
+			t.Fatal("trade held for review is still due for settlement")
+		}
+	}
+
+	path := "/api/admin/settlement/review/" + trade.TradeID.String() + "/retry"
+	if status := doRequest(t, app, uuid.New(), http.MethodPost, path, nil, nil); status != fiber.StatusOK {
+		t.Fatalf("retry: status %d", status)
+	}
+	if h := held(); h != nil {
+		t.Errorf("trade still held for review after retry: %+v", h)
+	}
+	if status := doRequest(t, app, uuid.New(), http.MethodPost, path, nil, nil); status != fiber.StatusNotFound {
+		t.Errorf("retry of a trade not held: status %d, want 404", status)
+	}
+}
+
+func TestAdjustBalance(t *testing.T) {
+	setupTestDB(t)
+	app := newTestApp()
+	app.Post("/api/admin/balances/adjust", AdjustBalance)
+	ctx := context.Background()
+
+	base, _ := newTestMarket(t)
+	user := newTestUser(t, map[string]float64{base: 1})
+	admin := newTestUser(t, nil)
+
+	adjust := func(delta float64) int {
+		t.Helper()
+		return doRequest(t, app, admin.ID, http.MethodPost, "/api/admin/balances/adjust", fiber.Map{
+			"user_id": user.ID, "asset": base, "delta": delta, "reason": "ticket 42",
+		}, nil)
+	}
+	if status := adjust(2.5); status != fiber.StatusOK {
+		t.Fatalf("credit: status %d", status)
+	}
+	if status := adjust(-3); status != fiber.StatusOK {
+		t.Fatalf("debit: status %d", status)
+	}
+	assertBalance(t, user.ID, base, 0.5, 0)
+
+	// Available can't go negative
+	if status := adjust(-1); status != fiber.StatusBadRequest {
+		t.Errorf("overdraft debit: status %d, want 400", status)
+	}
+	assertBalance(t, user.ID, base, 0.5, 0)
+
+	var entries int
+	err := database.DB.QueryRow(ctx, `SELECT COUNT(*) FROM ledger
+		WHERE user_id = $1 AND asset = $2 AND reason = 'admin_adjustment' AND actor_id = $3 AND note = 'ticket 42'`,
+		user.ID, base, admin.ID).Scan(&entries)
+	if err != nil {
+		t.Fatalf("counting ledger entries: %v", err)
+	}
+	if entries != 2 {
+		t.Errorf("%d admin_adjustment ledger entries by the admin, want 2", entries)
+	}
+
+	for _, body := range []fiber.Map{
+		{"user_id": user.ID, "asset": base, "delta": 0, "reason": "ticket 42"},
+		{"user_id": user.ID, "asset": base, "delta": 1, "reason": " "},
+		{"user_id": user.ID, "asset": "NOPE", "delta": 1, "reason": "ticket 42"},
+	} {
+		if status := doRequest(t, app, admin.ID, http.MethodPost, "/api/admin/balances/adjust", body, nil); status != fiber.StatusBadRequest {
+			t.Errorf("adjust %v: status %d, want 400", body, status)
+		}
+	}
+	if status := doRequest(t, app, admin.ID, http.MethodPost, "/api/admin/balances/adjust", fiber.Map{
+		"user_id": uuid.New(), "asset": base, "delta": 1, "reason": "ticket 42",
+	}, nil); status != fiber.StatusNotFound {
+		t.Errorf("adjust unknown user: status %d, want 404", status)
+	}
+}
@@ -0,0 +1,104 @@
+package handlers
+
+import (
+	"log"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/user/minicoinbase/backend/internal/apierror"
+	"github.com/user/minicoinbase/backend/internal/auth"
+	"github.com/user/minicoinbase/backend/internal/database"
+	"github.com/user/minicoinbase/backend/internal/models"
+)
+
+// CreateAPIKeyRequest defines the expected JSON body for creating an API key
+type CreateAPIKeyRequest struct {
+	Scope string `json:"scope"` // "read" or "trade", defaults to "read"
+}
+
+// CreateAPIKey generates a new API key for the authenticated user.
+// The secret is only returned in this response; it can't be retrieved later.
+func CreateAPIKey(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(uuid.UUID)
+	if !ok {
+		return apierror.Send(c, apierror.Unauthorized, "Invalid user ID in token")
+	}
+
+	req := new(CreateAPIKeyRequest)
+	if len(c.Body()) > 0 {
+		var err error
+		if req, err = validateAndBind[CreateAPIKeyRequest](c); err != nil {
+			return apierror.Send(c, apierror.BadRequest, err.Error())
+		}
+	}
+	req.Scope = strings.ToLower(strings.TrimSpace(req.Scope))
+	if req.Scope == "" {
+		req.Scope = auth.APIKeyScopeRead
+	}
+	if req.Scope != auth.APIKeyScopeRead && req.Scope != auth.APIKeyScopeTrade {
+		return apierror.Send(c, apierror.BadRequest, "Invalid scope, must be 'read' or 'trade'")
+	}
+
+	keyID, secret, err := auth.GenerateAPIKey()
+	if err != nil {
+		log.Printf("Error generating API key for user %s: %v", userID, err)
+		return apierror.Send(c, apierror.Internal, "Failed to generate API key")
+	}
+	encrypted, err := auth.EncryptAPISecret(secret)
+	if err != nil {
+		log.Printf("Error encrypting API secret for user %s: %v", userID, err)
+		return apierror.Send(c, apierror.Internal, "Failed to generate API key")
+	}
+
+	key := &models.APIKey{UserID: userID, KeyID: keyID, Scope: req.Scope, SecretEncrypted: encrypted}
+	if err := database.CreateAPIKey(c.Context(), key); err != nil {
+		log.Printf("Error storing API key for user %s: %v", userID, err)
+		return apierror.Send(c, apierror.Internal, "Failed to create API key")
+	}
+
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{
+		"api_key": key,
+		"secret":  secret,
+	})
+}
+
+// GetAPIKeys lists the authenticated user's API keys (without secrets).
+func GetAPIKeys(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(uuid.UUID)
+	if !ok {
+		return apierror.Send(c, apierror.Unauthorized, "Invalid user ID in token")
+	}
+
+	keys, err := database.GetUserAPIKeys(c.Context(), userID)
+	if err != nil {
+		log.Printf("Error fetching API keys for user %s: %v", userID, err)
+		return apierror.Send(c, apierror.Internal, "Failed to retrieve API keys")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(keys)
+}
+
+// DeleteAPIKey revokes one of the authenticated user's API keys.
+func DeleteAPIKey(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(uuid.UUID)
+	if !ok {
+		return apierror.Send(c, apierror.Unauthorized, "Invalid user ID in token")
+	}
+
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return apierror.Send(c, apierror.BadRequest, "Invalid API key ID format")
+	}
+
+	deleted, err := database.DeleteAPIKey(c.Context(), userID, id)
+	if err != nil {
+		log.Printf("Error deleting API key %s for user %s: %v", id, userID, err)
+		return apierror.Send(c, apierror.Internal, "Failed to delete API key")
+	}
+	if !deleted {
+		return apierror.Send(c, apierror.NotFound, "API key not found")
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
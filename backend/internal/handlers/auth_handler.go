@@ -1,16 +1,40 @@
 package handlers
 
 import (
-	"log"
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/user/minicoinbase/backend/internal/apierror"
 	"github.com/user/minicoinbase/backend/internal/auth"
+	"github.com/user/minicoinbase/backend/internal/config"
 	"github.com/user/minicoinbase/backend/internal/database"
+	"github.com/user/minicoinbase/backend/internal/logging"
+	"github.com/user/minicoinbase/backend/internal/middleware"
 	"github.com/user/minicoinbase/backend/internal/models"
+	"github.com/user/minicoinbase/backend/internal/ratelimit"
 )
 
+// Login brute-force protection. Failures are counted per username and per client IP;
+// either one going over its limit blocks further attempts with 429 until the window moves on.
+// ChangePassword counts wrong old passwords against the username as well. Set up by InitAuth.
+var (
+	LoginUserLimiter ratelimit.Limiter
+	LoginIPLimiter   ratelimit.Limiter
+)
+
+// InitAuth creates the login limiters from the configuration.
+func InitAuth(cfg *config.Config) {
+	LoginUserLimiter = ratelimit.NewMemoryLimiter(cfg.LoginUserLimit)
+	LoginIPLimiter = ratelimit.NewMemoryLimiter(cfg.LoginIPLimit)
+}
+
 // SignupRequest defines the expected JSON body for signup
 type SignupRequest struct {
 	Username string `json:"username"`
@@ -23,109 +47,375 @@ type LoginRequest struct {
 	Password string `json:"password"`
 }
 
+// ChangePasswordRequest defines the expected JSON body for a password change
+type ChangePasswordRequest struct {
+	OldPassword  string `json:"old_password"`
+	NewPassword  string `json:"new_password"`
+	RevokeTokens bool   `json:"revoke_tokens"` // Log out other sessions by revoking all refresh tokens
+}
+
+// RefreshRequest defines the expected JSON body for refresh and logout
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
+
 // AuthResponse defines the JSON response for successful auth
 type AuthResponse struct {
-	Token    string       `json:"token"`
-	User     *models.User `json:"user"` // Return basic user info (excluding password hash)
-	IssuedAt time.Time    `json:"issued_at"`
+	Token        string       `json:"token"`         // Short-lived access token (JWT)
+	RefreshToken string       `json:"refresh_token"` // Single-use token for POST /api/auth/refresh
+	User         *models.User `json:"user"`          // Return basic user info (excluding password hash)
+	IssuedAt     time.Time    `json:"issued_at"`
+}
+
+// issueTokens generates an access token and a stored refresh token for the user.
+func issueTokens(ctx context.Context, user *models.User) (*AuthResponse, error) {
+	token, err := auth.GenerateJWT(user.ID, user.Username, user.Role)
+	if err != nil {
+		return nil, fmt.Errorf("generating access token: %w", err)
+	}
+
+	refreshToken, err := auth.GenerateRefreshToken()
+	if err != nil {
+		return nil, fmt.Errorf("generating refresh token: %w", err)
+	}
+	expiresAt := time.Now().Add(auth.RefreshTokenTTL)
+	if err := database.StoreRefreshToken(ctx, user.ID, auth.HashRefreshToken(refreshToken), expiresAt); err != nil {
+		return nil, err
+	}
+
+	// Don't send password hash back
+	user.Password = ""
+
+	return &AuthResponse{
+		Token:        token,
+		RefreshToken: refreshToken,
+		User:         user,
+		IssuedAt:     time.Now(),
+	}, nil
 }
 
 // Signup handles user registration.
 func Signup(c *fiber.Ctx) error {
-	req := new(SignupRequest)
-	if err := c.BodyParser(req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse request body"})
+	req, err := validateAndBind[SignupRequest](c)
+	if err != nil {
+		return apierror.Send(c, apierror.BadRequest, err.Error())
 	}
 
 	// Basic validation
-	if strings.TrimSpace(req.Username) == "" || strings.TrimSpace(req.Password) == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Username and password cannot be empty"})
+	req.Username = auth.NormalizeUsername(req.Username)
+	if req.Username == "" || strings.TrimSpace(req.Password) == "" {
+		return apierror.Send(c, apierror.BadRequest, "Username and password cannot be empty")
+	}
+	if err := auth.ValidateUsername(req.Username); err != nil {
+		return apierror.Send(c, apierror.BadRequest, err.Error())
+	}
+	if err := auth.ValidatePassword(req.Password); err != nil {
+		return apierror.Send(c, apierror.BadRequest, err.Error())
 	}
-	// TODO: Add more robust validation (e.g., password complexity, username format)
 
 	// Check if user already exists
 	existingUser, err := database.GetUserByUsername(c.Context(), req.Username)
 	if err != nil {
-		log.Printf("Error checking username %s: %v", req.Username, err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Database error checking username"})
+		logging.FromContext(c.Context()).Error("checking username", "username", req.Username, "err", err)
+		return apierror.Send(c, apierror.Internal, "Database error checking username")
 	}
 	if existingUser != nil {
-		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "Username already taken"})
+		return apierror.Send(c, apierror.Conflict, "Username already taken")
 	}
 
 	// Hash password
 	hashedPassword, err := auth.HashPassword(req.Password)
 	if err != nil {
-		log.Printf("Error hashing password for %s: %v", req.Username, err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to process password"})
+		logging.FromContext(c.Context()).Error("hashing password", "username", req.Username, "err", err)
+		return apierror.Send(c, apierror.Internal, "Failed to process password")
 	}
 
 	// Create user in database
 	newUser, err := database.CreateUser(c.Context(), req.Username, hashedPassword)
+	if errors.Is(err, database.ErrUsernameTaken) {
+		// Signed up concurrently, after the check above
+		return apierror.Send(c, apierror.Conflict, "Username already taken")
+	}
 	if err != nil {
-		// TODO: Handle specific DB errors like unique constraint violation potentially missed by first check
-		log.Printf("Error creating user %s: %v", req.Username, err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to create user"})
+		logging.FromContext(c.Context()).Error("creating user", "username", req.Username, "err", err)
+		return apierror.Send(c, apierror.Internal, "Failed to create user")
 	}
 
-	// Generate JWT
-	token, err := auth.GenerateJWT(newUser.ID, newUser.Username)
+	// Generate tokens
+	resp, err := issueTokens(c.Context(), newUser)
 	if err != nil {
-		log.Printf("Error generating JWT for user %s: %v", newUser.Username, err)
+		logging.FromContext(c.Context()).Error("issuing tokens for new user", "user_id", newUser.ID, "err", err)
 		// User was created, but token failed - problematic state. Log carefully.
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "User created, but failed to generate token"})
+		return apierror.Send(c, apierror.Internal, "User created, but failed to generate token")
 	}
 
-	// Don't send password hash back
-	newUser.Password = ""
-
-	return c.Status(fiber.StatusCreated).JSON(AuthResponse{
-		Token:    token,
-		User:     newUser,
-		IssuedAt: time.Now(),
-	})
+	return c.Status(fiber.StatusCreated).JSON(resp)
 }
 
 // Login handles user authentication.
 func Login(c *fiber.Ctx) error {
-	req := new(LoginRequest)
-	if err := c.BodyParser(req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse request body"})
+	req, err := validateAndBind[LoginRequest](c)
+	if err != nil {
+		return apierror.Send(c, apierror.BadRequest, err.Error())
 	}
 
-	// Basic validation
-	if strings.TrimSpace(req.Username) == "" || strings.TrimSpace(req.Password) == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Username and password cannot be empty"})
+	// Basic validation. Normalized so every spelling of a username shares its failure count
+	req.Username = auth.NormalizeUsername(req.Username)
+	if req.Username == "" || strings.TrimSpace(req.Password) == "" {
+		return apierror.Send(c, apierror.BadRequest, "Username and password cannot be empty")
+	}
+
+	// Throttle before doing any (expensive) password check
+	ip := c.IP()
+	for _, check := range []struct {
+		limiter ratelimit.Limiter
+		key     string
+	}{{LoginIPLimiter, ip}, {LoginUserLimiter, req.Username}} {
+		retryAfter, err := check.limiter.Check(c.Context(), check.key)
+		if err != nil {
+			logging.FromContext(c.Context()).Error("checking login rate limit", "key", check.key, "err", err)
+			return apierror.Send(c, apierror.Internal, "Failed to process login")
+		}
+		if retryAfter > 0 {
+			seconds := int(math.Ceil(retryAfter.Seconds()))
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(seconds))
+			return apierror.SendDetails(c, apierror.RateLimited, "Too many failed login attempts, try again later",
+				fiber.Map{"retry_after": seconds})
+		}
 	}
 
 	// Find user by username
 	user, err := database.GetUserByUsername(c.Context(), req.Username)
 	if err != nil {
-		log.Printf("Error finding user %s: %v", req.Username, err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Database error finding user"})
+		logging.FromContext(c.Context()).Error("finding user", "username", req.Username, "err", err)
+		return apierror.Send(c, apierror.Internal, "Database error finding user")
+	}
+
+	// Check password
+	if user == nil || !auth.CheckPasswordHash(req.Password, user.Password) {
+		// Unknown usernames count too, so probing for accounts is throttled the same way
+		recordLoginFailure(c.Context(), ip, req.Username)
+		return apierror.Send(c, apierror.Unauthorized, "Invalid username or password")
+	}
+
+	// Checked only once the password is known to be right, so it gives nothing away about accounts
+	if middleware.InMaintenance() && user.Role != auth.RoleAdmin {
+		return apierror.Send(c, apierror.Maintenance, middleware.MaintenanceMessage)
+	}
+
+	// Only the username counter is reset: resetting the IP counter would let an attacker
+	// with one valid account keep guessing other accounts' passwords from the same IP
+	if err := LoginUserLimiter.Reset(c.Context(), req.Username); err != nil {
+		logging.FromContext(c.Context()).Warn("resetting login rate limit", "username", req.Username, "err", err)
+	}
+	upgradePasswordHash(c.Context(), user, req.Password)
+
+	// Generate tokens
+	resp, err := issueTokens(c.Context(), user)
+	if err != nil {
+		logging.FromContext(c.Context()).Error("issuing tokens", "user_id", user.ID, "err", err)
+		return apierror.Send(c, apierror.Internal, "Failed to generate token")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(resp)
+}
+
+// upgradePasswordHash re-hashes the user's (just verified) password if the stored hash is from
+// before BCRYPT_COST was raised. Failing to is logged and otherwise ignored: the old hash still
+// works, and the next login tries again.
+func upgradePasswordHash(ctx context.Context, user *models.User, password string) {
+	if !auth.PasswordNeedsRehash(user.Password) {
+		return
+	}
+	hashedPassword, err := auth.HashPassword(password)
+	if err != nil {
+		logging.FromContext(ctx).Warn("re-hashing password", "user_id", user.ID, "err", err)
+		return
+	}
+	if err := database.UpdateUserPassword(ctx, user.ID, hashedPassword); err != nil {
+		logging.FromContext(ctx).Warn("storing re-hashed password", "user_id", user.ID, "err", err)
+		return
+	}
+	user.Password = hashedPassword
+	logging.FromContext(ctx).Info("upgraded password hash", "user_id", user.ID)
+}
+
+// recordLoginFailure counts a failed login against both the client IP and the username.
+func recordLoginFailure(ctx context.Context, ip, username string) {
+	if err := LoginIPLimiter.RecordFailure(ctx, ip); err != nil {
+		logging.FromContext(ctx).Warn("recording login failure", "ip", ip, "err", err)
+	}
+	if err := LoginUserLimiter.RecordFailure(ctx, username); err != nil {
+		logging.FromContext(ctx).Warn("recording login failure", "username", username, "err", err)
+	}
+}
+
+// Refresh exchanges a refresh token for a new access token.
+// The refresh token is rotated: the one presented is revoked and a new one is returned.
+func Refresh(c *fiber.Ctx) error {
+	req, err := validateAndBind[RefreshRequest](c)
+	if err != nil {
+		return apierror.Send(c, apierror.BadRequest, err.Error())
+	}
+	if req.RefreshToken == "" {
+		return apierror.Send(c, apierror.BadRequest, "refresh_token is required")
+	}
+
+	// Checked before the token is consumed, so a user turned away can still refresh afterwards
+	if middleware.InMaintenance() {
+		if err := refreshAllowedInMaintenance(c.Context(), auth.HashRefreshToken(req.RefreshToken)); err != nil {
+			return apierror.Respond(c, err)
+		}
+	}
+
+	userID, ok, err := database.ConsumeRefreshToken(c.Context(), auth.HashRefreshToken(req.RefreshToken))
+	if err != nil {
+		logging.FromContext(c.Context()).Error("consuming refresh token", "err", err)
+		return apierror.Send(c, apierror.Internal, "Failed to refresh token")
+	}
+	if !ok {
+		return apierror.Send(c, apierror.Unauthorized, "Invalid or expired refresh token")
+	}
+
+	user, err := database.GetUserByID(c.Context(), userID)
+	if err != nil {
+		logging.FromContext(c.Context()).Error("finding user for refresh", "user_id", userID, "err", err)
+		return apierror.Send(c, apierror.Internal, "Database error finding user")
 	}
 	if user == nil {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid username or password"})
+		return apierror.Send(c, apierror.Unauthorized, "Invalid or expired refresh token")
 	}
 
-	// Check password
-	if !auth.CheckPasswordHash(req.Password, user.Password) {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid username or password"})
+	resp, err := issueTokens(c.Context(), user)
+	if err != nil {
+		logging.FromContext(c.Context()).Error("issuing tokens", "user_id", user.ID, "err", err)
+		return apierror.Send(c, apierror.Internal, "Failed to generate token")
 	}
 
-	// Generate JWT
-	token, err := auth.GenerateJWT(user.ID, user.Username)
+	return c.Status(fiber.StatusOK).JSON(resp)
+}
+
+// refreshAllowedInMaintenance turns away the refresh of anyone but an admin in maintenance mode.
+func refreshAllowedInMaintenance(ctx context.Context, tokenHash string) *apierror.Error {
+	userID, ok, err := database.RefreshTokenUser(ctx, tokenHash)
 	if err != nil {
-		log.Printf("Error generating JWT for user %s: %v", user.Username, err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to generate token"})
+		logging.FromContext(ctx).Error("looking up refresh token", "err", err)
+		return apierror.New(apierror.Internal, "Failed to refresh token")
 	}
+	if !ok {
+		return apierror.New(apierror.Unauthorized, "Invalid or expired refresh token")
+	}
+	user, err := database.GetUserByID(ctx, userID)
+	if err != nil {
+		logging.FromContext(ctx).Error("finding user for refresh", "user_id", userID, "err", err)
+		return apierror.New(apierror.Internal, "Database error finding user")
+	}
+	if user == nil || user.Role != auth.RoleAdmin {
+		return apierror.New(apierror.Maintenance, middleware.MaintenanceMessage)
+	}
+	return nil
+}
 
-	// Don't send password hash back
-	user.Password = ""
+// Logout revokes the access token used for this request and, if given, a refresh token.
+// Requires authentication; the refresh_token body field is optional.
+func Logout(c *fiber.Ctx) error {
+	claims, ok := c.Locals("claims").(*auth.Claims)
+	if !ok {
+		return apierror.Send(c, apierror.Unauthorized, "Invalid token")
+	}
+
+	req := new(RefreshRequest)
+	if len(c.Body()) > 0 {
+		var err error
+		if req, err = validateAndBind[RefreshRequest](c); err != nil {
+			return apierror.Send(c, apierror.BadRequest, err.Error())
+		}
+	}
+
+	// Blacklist the access token until it would have expired anyway
+	if err := auth.TokenBlacklist.Revoke(c.Context(), claims.ID, claims.ExpiresAt.Time); err != nil {
+		logging.FromContext(c.Context()).Error("revoking access token", "user_id", claims.UserID, "err", err)
+		return apierror.Send(c, apierror.Internal, "Failed to log out")
+	}
+
+	if req.RefreshToken != "" {
+		if err := database.RevokeRefreshToken(c.Context(), auth.HashRefreshToken(req.RefreshToken)); err != nil {
+			logging.FromContext(c.Context()).Error("revoking refresh token", "user_id", claims.UserID, "err", err)
+			return apierror.Send(c, apierror.Internal, "Failed to log out")
+		}
+	}
+
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// ChangePassword changes the authenticated user's password after verifying the old one.
+// With revoke_tokens set, all of the user's refresh tokens are revoked as well;
+// access tokens already issued stay valid until they expire (at most auth.AccessTokenTTL).
+func ChangePassword(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(uuid.UUID)
+	if !ok {
+		return apierror.Send(c, apierror.Unauthorized, "Invalid user ID in token")
+	}
+
+	req, err := validateAndBind[ChangePasswordRequest](c)
+	if err != nil {
+		return apierror.Send(c, apierror.BadRequest, err.Error())
+	}
+	if req.OldPassword == "" || req.NewPassword == "" {
+		return apierror.Send(c, apierror.BadRequest, "old_password and new_password are required")
+	}
+	if err := auth.ValidatePassword(req.NewPassword); err != nil {
+		return apierror.Send(c, apierror.BadRequest, err.Error())
+	}
+
+	// Wrong old passwords count against the same limit as failed logins, so a stolen
+	// access token can't be used to guess the password either
+	username, _ := c.Locals("username").(string)
+	username = auth.NormalizeUsername(username)
+	retryAfter, err := LoginUserLimiter.Check(c.Context(), username)
+	if err != nil {
+		logging.FromContext(c.Context()).Error("checking login rate limit", "key", username, "err", err)
+		return apierror.Send(c, apierror.Internal, "Failed to process password")
+	}
+	if retryAfter > 0 {
+		seconds := int(math.Ceil(retryAfter.Seconds()))
+		c.Set(fiber.HeaderRetryAfter, strconv.Itoa(seconds))
+		return apierror.SendDetails(c, apierror.RateLimited, "Too many failed password attempts, try again later",
+			fiber.Map{"retry_after": seconds})
+	}
+
+	user, err := database.GetUserByID(c.Context(), userID)
+	if err != nil {
+		logging.FromContext(c.Context()).Error("finding user", "user_id", userID, "err", err)
+		return apierror.Send(c, apierror.Internal, "Database error finding user")
+	}
+	if user == nil {
+		return apierror.Send(c, apierror.Unauthorized, "User not found")
+	}
+
+	if !auth.CheckPasswordHash(req.OldPassword, user.Password) {
+		if err := LoginUserLimiter.RecordFailure(c.Context(), username); err != nil {
+			logging.FromContext(c.Context()).Warn("recording password failure", "username", username, "err", err)
+		}
+		return apierror.Send(c, apierror.Unauthorized, "Old password is incorrect")
+	}
+
+	hashedPassword, err := auth.HashPassword(req.NewPassword)
+	if err != nil {
+		logging.FromContext(c.Context()).Error("hashing password", "user_id", userID, "err", err)
+		return apierror.Send(c, apierror.Internal, "Failed to process password")
+	}
+	if err := database.UpdateUserPassword(c.Context(), userID, hashedPassword); err != nil {
+		logging.FromContext(c.Context()).Error("updating password", "user_id", userID, "err", err)
+		return apierror.Send(c, apierror.Internal, "Failed to update password")
+	}
+
+	if req.RevokeTokens {
+		if err := database.RevokeUserRefreshTokens(c.Context(), userID); err != nil {
+			logging.FromContext(c.Context()).Error("revoking tokens after password change", "user_id", userID, "err", err)
+			return apierror.Send(c, apierror.Internal, "Password changed, but failed to revoke existing tokens")
+		}
+	}
 
-	return c.Status(fiber.StatusOK).JSON(AuthResponse{
-		Token:    token,
-		User:     user,
-		IssuedAt: time.Now(),
-	})
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Password changed"})
 }
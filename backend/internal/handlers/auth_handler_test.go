@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/user/minicoinbase/backend/internal/apierror"
+	"github.com/user/minicoinbase/backend/internal/auth"
+	"github.com/user/minicoinbase/backend/internal/config"
+	"github.com/user/minicoinbase/backend/internal/database"
+	"github.com/user/minicoinbase/backend/internal/middleware"
+	"github.com/user/minicoinbase/backend/internal/ratelimit"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestUsernamesAreCaseInsensitive(t *testing.T) {
+	setupTestDB(t)
+	app := newLifecycleApp()
+	app.Post("/api/auth/login", Login)
+	InitAuth(&config.Config{LoginUserLimit: ratelimit.Config{MaxFailures: 5, Window: time.Minute}, LoginIPLimit: ratelimit.Config{MaxFailures: 20, Window: time.Minute}})
+
+	name := "Carol_" + uuid.NewString()[:8]
+	var resp AuthResponse
+	if status := doRequest(t, app, uuid.Nil, http.MethodPost, "/api/auth/signup", fiber.Map{
+		"username": "  " + name + " ", "password": "Carol-Test-1",
+	}, &resp); status != fiber.StatusCreated {
+		t.Fatalf("signup: status %d", status)
+	}
+	if want := strings.ToLower(name); resp.User.Username != want {
+		t.Errorf("stored username %q, want %q", resp.User.Username, want)
+	}
+
+	// The same name in another case is the same account
+	if status := doRequest(t, app, uuid.Nil, http.MethodPost, "/api/auth/signup", fiber.Map{
+		"username": strings.ToUpper(name), "password": "Carol-Test-1",
+	}, nil); status != fiber.StatusConflict {
+		t.Errorf("signup in upper case: status %d, want 409", status)
+	}
+	var login AuthResponse
+	if status := doRequest(t, app, uuid.Nil, http.MethodPost, "/api/auth/login", fiber.Map{
+		"username": strings.ToUpper(name), "password": "Carol-Test-1",
+	}, &login); status != fiber.StatusOK || login.User == nil || login.User.ID != resp.User.ID {
+		t.Errorf("login in upper case: status %d, user %+v, want 200 as %v", status, login.User, resp.User.ID)
+	}
+
+	for _, bad := range []string{"ab", "_" + name, name + "!", "car ol" + uuid.NewString()[:8]} {
+		if status := doRequest(t, app, uuid.Nil, http.MethodPost, "/api/auth/signup", fiber.Map{
+			"username": bad, "password": "Carol-Test-1",
+		}, nil); status != fiber.StatusBadRequest {
+			t.Errorf("signup as %q: status %d, want 400", bad, status)
+		}
+	}
+}
+
+func TestLoginUpgradesPasswordHashCost(t *testing.T) {
+	setupTestDB(t)
+	app := newLifecycleApp()
+	app.Post("/api/auth/login", Login)
+	InitAuth(&config.Config{LoginUserLimit: ratelimit.Config{MaxFailures: 5, Window: time.Minute}, LoginIPLimit: ratelimit.Config{MaxFailures: 20, Window: time.Minute}})
+
+	// Sign up with hashes at the lowest cost, then raise it as a BCRYPT_COST change would
+	cfg := &config.Config{JWTSecret: "lifecycle-test-secret", AccessTokenTTL: time.Minute, RefreshTokenTTL: time.Hour, BcryptCost: bcrypt.MinCost}
+	auth.Init(cfg)
+	user, _ := signup(t, app)
+	cfg.BcryptCost = bcrypt.MinCost + 1
+	auth.Init(cfg)
+	defer auth.Init(&config.Config{JWTSecret: "lifecycle-test-secret", AccessTokenTTL: time.Minute, RefreshTokenTTL: time.Hour})
+
+	storedCost := func() int {
+		t.Helper()
+		stored, err := database.GetUserByID(context.Background(), user.ID)
+		if err != nil || stored == nil {
+			t.Fatalf("GetUserByID: %v", err)
+		}
+		cost, err := bcrypt.Cost([]byte(stored.Password))
+		if err != nil {
+			t.Fatalf("bcrypt.Cost: %v", err)
+		}
+		return cost
+	}
+	if cost := storedCost(); cost != bcrypt.MinCost {
+		t.Fatalf("hash cost after signup = %d, want %d", cost, bcrypt.MinCost)
+	}
+
+	// A failed login must leave the hash alone, a successful one upgrades it
+	doRequest(t, app, uuid.Nil, http.MethodPost, "/api/auth/login", fiber.Map{"username": user.Username, "password": "Wrong-Password-1"}, nil)
+	if cost := storedCost(); cost != bcrypt.MinCost {
+		t.Errorf("hash cost after failed login = %d, want %d", cost, bcrypt.MinCost)
+	}
+	doRequest(t, app, uuid.Nil, http.MethodPost, "/api/auth/login", fiber.Map{"username": user.Username, "password": "Lifecycle-Test-1"}, nil)
+	if cost := storedCost(); cost != bcrypt.MinCost+1 {
+		t.Errorf("hash cost after login = %d, want %d", cost, bcrypt.MinCost+1)
+	}
+	if status := doRequest(t, app, uuid.Nil, http.MethodPost, "/api/auth/login", fiber.Map{
+		"username": user.Username, "password": "Lifecycle-Test-1",
+	}, nil); status != fiber.StatusOK {
+		t.Errorf("login with the upgraded hash: status %d, want 200", status)
+	}
+}
+
+func TestLoginAndRefreshInMaintenance(t *testing.T) {
+	setupTestDB(t)
+	app := newLifecycleApp()
+	app.Post("/api/auth/login", Login)
+	app.Post("/api/auth/refresh", Refresh)
+	InitAuth(&config.Config{LoginUserLimit: ratelimit.Config{MaxFailures: 5, Window: time.Minute}, LoginIPLimit: ratelimit.Config{MaxFailures: 20, Window: time.Minute}})
+
+	signupWithRefresh := func() AuthResponse {
+		t.Helper()
+		var resp AuthResponse
+		if status := doRequest(t, app, uuid.Nil, http.MethodPost, "/api/auth/signup", fiber.Map{
+			"username": "maint_" + uuid.NewString()[:8], "password": "Maint-Test-1",
+		}, &resp); status != fiber.StatusCreated {
+			t.Fatalf("signup: status %d", status)
+		}
+		return resp
+	}
+	admin, user := signupWithRefresh(), signupWithRefresh()
+	if _, err := database.DB.Exec(context.Background(), `UPDATE users SET role = $1 WHERE id = $2`, auth.RoleAdmin, admin.User.ID); err != nil {
+		t.Fatalf("promoting admin: %v", err)
+	}
+
+	middleware.SetMaintenance(true)
+	defer middleware.SetMaintenance(false)
+	for _, tt := range []struct {
+		name string
+		resp AuthResponse
+		want int
+	}{
+		{"admin", admin, fiber.StatusOK},
+		{"user", user, fiber.StatusServiceUnavailable},
+	} {
+		if status := doRequest(t, app, uuid.Nil, http.MethodPost, "/api/auth/login", fiber.Map{
+			"username": tt.resp.User.Username, "password": "Maint-Test-1",
+		}, nil); status != tt.want {
+			t.Errorf("%s login in maintenance: status %d, want %d", tt.name, status, tt.want)
+		}
+		if status := doRequest(t, app, uuid.Nil, http.MethodPost, "/api/auth/refresh", fiber.Map{
+			"refresh_token": tt.resp.RefreshToken,
+		}, nil); status != tt.want {
+			t.Errorf("%s refresh in maintenance: status %d, want %d", tt.name, status, tt.want)
+		}
+	}
+
+	// The user's refresh token wasn't used up by being turned away
+	middleware.SetMaintenance(false)
+	if status := doRequest(t, app, uuid.Nil, http.MethodPost, "/api/auth/refresh", fiber.Map{
+		"refresh_token": user.RefreshToken,
+	}, nil); status != fiber.StatusOK {
+		t.Errorf("user refresh after maintenance: status %d, want 200", status)
+	}
+}
+
+func TestLoginThrottled(t *testing.T) {
+	InitAuth(&config.Config{
+		LoginUserLimit: ratelimit.Config{MaxFailures: 2, Window: time.Minute},
+		LoginIPLimit:   ratelimit.Config{MaxFailures: 20, Window: time.Minute},
+	})
+	app := fiber.New()
+	app.Post("/api/auth/login", Login)
+
+	// Checked before the user is looked up, so no database is needed to be turned away
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := LoginUserLimiter.RecordFailure(ctx, "dave"); err != nil {
+			t.Fatalf("RecordFailure: %v", err)
+		}
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/login", strings.NewReader(`{"username": " Dave ", "password": "Dave-Test-1"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("POST /api/auth/login: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != fiber.StatusTooManyRequests {
+		t.Fatalf("status %d, want 429", resp.StatusCode)
+	}
+	if retryAfter, err := strconv.Atoi(resp.Header.Get(fiber.HeaderRetryAfter)); err != nil || retryAfter < 1 || retryAfter > 60 {
+		t.Errorf("Retry-After = %q, want 1 to 60 seconds", resp.Header.Get(fiber.HeaderRetryAfter))
+	}
+	var body apierror.Error
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if body.Code != apierror.RateLimited {
+		t.Errorf("error code %q, want %q", body.Code, apierror.RateLimited)
+	}
+}
+
+func TestChangePasswordThrottled(t *testing.T) {
+	InitAuth(&config.Config{
+		LoginUserLimit: ratelimit.Config{MaxFailures: 2, Window: time.Minute},
+		LoginIPLimit:   ratelimit.Config{MaxFailures: 20, Window: time.Minute},
+	})
+	app := fiber.New()
+	app.Post("/api/auth/change-password", func(c *fiber.Ctx) error {
+		c.Locals("userID", uuid.New())
+		c.Locals("username", "erin")
+		return c.Next()
+	}, ChangePassword)
+
+	// Failed logins and wrong old passwords share the count, checked before the user is looked up
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := LoginUserLimiter.RecordFailure(ctx, "erin"); err != nil {
+			t.Fatalf("RecordFailure: %v", err)
+		}
+	}
+	req := httptest.NewRequest(http.MethodPost, "/api/auth/change-password",
+		strings.NewReader(`{"old_password": "Erin-Guess-1", "new_password": "Erin-Test-22"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("POST /api/auth/change-password: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != fiber.StatusTooManyRequests {
+		t.Fatalf("status %d, want 429", resp.StatusCode)
+	}
+	if retryAfter, err := strconv.Atoi(resp.Header.Get(fiber.HeaderRetryAfter)); err != nil || retryAfter < 1 || retryAfter > 60 {
+		t.Errorf("Retry-After = %q, want 1 to 60 seconds", resp.Header.Get(fiber.HeaderRetryAfter))
+	}
+	var body apierror.Error
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatalf("decoding response: %v", err)
+	}
+	if body.Code != apierror.RateLimited {
+		t.Errorf("error code %q, want %q", body.Code, apierror.RateLimited)
+	}
+}
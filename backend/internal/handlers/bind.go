@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// validateAndBind decodes the JSON request body into a new T. Unlike BodyParser it rejects
+// bodies that aren't a single JSON object, name fields T doesn't have, or carry values of the
+// wrong type (e.g., a quantity sent as a string). The returned error is meant for the client.
+// The body size itself is capped by the app's BodyLimit.
+func validateAndBind[T any](c *fiber.Ctx) (*T, error) {
+	if !strings.HasPrefix(strings.ToLower(c.Get(fiber.HeaderContentType)), fiber.MIMEApplicationJSON) {
+		return nil, errors.New("Content-Type must be application/json")
+	}
+
+	req := new(T)
+	dec := json.NewDecoder(bytes.NewReader(c.Body()))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(req); err != nil {
+		return nil, describeJSONError(err)
+	}
+	if err := dec.Decode(&struct{}{}); !errors.Is(err, io.EOF) {
+		return nil, errors.New("Request body must contain a single JSON object")
+	}
+	return req, nil
+}
+
+// maxRequestAmount bounds the magnitude of every price, quantity and amount in a request. Far
+// above anything real, it keeps the products computed from them (price times quantity, fill
+// totals) finite.
+const maxRequestAmount = 1e15
+
+// checkAmount rejects a NaN, infinite or absurdly large value of a request field. The returned
+// error is meant for the client.
+func checkAmount(field string, v float64) error {
+	if math.IsNaN(v) || math.IsInf(v, 0) || math.Abs(v) > maxRequestAmount {
+		return fmt.Errorf("%s must be a finite number of magnitude at most %g", field, float64(maxRequestAmount))
+	}
+	return nil
+}
+
+// describeJSONError turns a decoding error into a message for the client.
+func describeJSONError(err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.Is(err, io.EOF):
+		return errors.New("Request body must not be empty")
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return errors.New("Malformed JSON: unexpected end of body")
+	case errors.As(err, &syntaxErr):
+		return fmt.Errorf("Malformed JSON at offset %d", syntaxErr.Offset)
+	case errors.As(err, &typeErr):
+		if typeErr.Field == "" {
+			return fmt.Errorf("Request body must be a JSON object")
+		}
+		return fmt.Errorf("Invalid value for field %q: expected %s", typeErr.Field, typeErr.Type)
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		// encoding/json has no typed error for this one
+		return fmt.Errorf("Unknown field %s", strings.TrimPrefix(err.Error(), "json: unknown field "))
+	default:
+		return errors.New("Cannot parse request body")
+	}
+}
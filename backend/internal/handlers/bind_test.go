@@ -0,0 +1,58 @@
+package handlers
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestValidateAndBind(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		wantErr string // Substring of the expected error, empty for success
+	}{
+		{name: "valid", body: `{"symbol":"BTC-USD","side":"buy","type":"limit","price":100,"quantity":1}`},
+		{name: "unknown field", body: `{"symbol":"BTC-USD","qty":1}`, wantErr: `Unknown field "qty"`},
+		{name: "number as string", body: `{"quantity":"1"}`, wantErr: `field "quantity"`},
+		{name: "malformed", body: `{"quantity":1,}`, wantErr: "Malformed JSON"},
+		{name: "truncated", body: `{"quantity":1`, wantErr: "Malformed JSON"},
+		{name: "empty", body: ``, wantErr: "must not be empty"},
+		{name: "not an object", body: `[1,2]`, wantErr: "must be a JSON object"},
+		{name: "trailing data", body: `{"quantity":1}{"quantity":2}`, wantErr: "single JSON object"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := fiber.New()
+			app.Post("/", func(c *fiber.Ctx) error {
+				req, err := validateAndBind[CreateOrderRequest](c)
+				if err != nil {
+					return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+				}
+				return c.SendString(req.Symbol)
+			})
+
+			httpReq := httptest.NewRequest("POST", "/", strings.NewReader(tt.body))
+			httpReq.Header.Set("Content-Type", "application/json")
+			resp, err := app.Test(httpReq)
+			if err != nil {
+				t.Fatalf("app.Test: %v", err)
+			}
+			body, _ := io.ReadAll(resp.Body)
+
+			if tt.wantErr == "" {
+				if resp.StatusCode != fiber.StatusOK {
+					t.Fatalf("status %d (%s), want 200", resp.StatusCode, body)
+				}
+				return
+			}
+			if resp.StatusCode != fiber.StatusBadRequest || !strings.Contains(string(body), tt.wantErr) {
+				t.Errorf("got %d %q, want 400 containing %q", resp.StatusCode, body, tt.wantErr)
+			}
+		})
+	}
+}
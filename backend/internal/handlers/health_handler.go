@@ -0,0 +1,91 @@
+package handlers
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/user/minicoinbase/backend/internal/database"
+	"github.com/user/minicoinbase/backend/internal/middleware"
+	"github.com/user/minicoinbase/backend/internal/orderbook"
+	"github.com/user/minicoinbase/backend/internal/ticker"
+	ws "github.com/user/minicoinbase/backend/internal/websocket"
+)
+
+// dbPingTimeout bounds the database check so a hung database fails the probe instead of hanging it.
+const dbPingTimeout = 2 * time.Second
+
+// ready is set once startup has finished and cleared when shutdown begins.
+var ready atomic.Bool
+
+// SetReady marks the service as ready (or not) to receive traffic, see Ready.
+func SetReady(r bool) {
+	ready.Store(r)
+}
+
+// componentStatus is the health of one dependency in a health/ready response.
+type componentStatus struct {
+	Status string `json:"status"`          // "ok" or "unhealthy"
+	Error  string `json:"error,omitempty"` // Why it is unhealthy
+}
+
+// Health is the liveness probe: the database answers a ping and the hub and ticker loops are running.
+// Returns 200 if every component is ok, 503 otherwise, with per-component status in the body.
+// Symbols whose trading is halted are listed too, and whether the service is in maintenance mode;
+// neither makes the service unhealthy.
+func Health(c *fiber.Ctx) error {
+	halted := make([]string, 0)
+	if orderbook.GlobalOrderBookManager != nil {
+		halted = orderbook.GlobalOrderBookManager.HaltedSymbols()
+	}
+	return respondHealth(c, map[string]componentStatus{
+		"database": checkDatabase(c.Context()),
+		"hub":      check(ws.GlobalHub != nil && ws.GlobalHub.Alive(), "event loop not running"),
+		"ticker":   check(ticker.Alive(), "ticker not running"),
+	}, fiber.Map{"halted_symbols": halted, "maintenance": middleware.InMaintenance()})
+}
+
+// Ready is the readiness probe: the service has finished starting up (order books loaded)
+// and isn't shutting down, and the database is reachable.
+func Ready(c *fiber.Ctx) error {
+	return respondHealth(c, map[string]componentStatus{
+		"database":   checkDatabase(c.Context()),
+		"orderbooks": check(orderbook.GlobalOrderBookManager != nil, "order books not loaded"),
+		"startup":    check(ready.Load(), "starting up or shutting down"),
+	}, nil)
+}
+
+func checkDatabase(ctx context.Context) componentStatus {
+	ctx, cancel := context.WithTimeout(ctx, dbPingTimeout)
+	defer cancel()
+	if err := database.DB.Ping(ctx); err != nil {
+		return componentStatus{Status: "unhealthy", Error: err.Error()}
+	}
+	return componentStatus{Status: "ok"}
+}
+
+func check(ok bool, reason string) componentStatus {
+	if !ok {
+		return componentStatus{Status: "unhealthy", Error: reason}
+	}
+	return componentStatus{Status: "ok"}
+}
+
+// respondHealth writes the overall status and the components, plus any extra fields.
+func respondHealth(c *fiber.Ctx, components map[string]componentStatus, extra fiber.Map) error {
+	status, code := "ok", fiber.StatusOK
+	for _, component := range components {
+		if component.Status != "ok" {
+			status, code = "unhealthy", fiber.StatusServiceUnavailable
+		}
+	}
+	body := fiber.Map{
+		"status":     status,
+		"components": components,
+	}
+	for k, v := range extra {
+		body[k] = v
+	}
+	return c.Status(code).JSON(body)
+}
@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/user/minicoinbase/backend/internal/apierror"
+	"github.com/user/minicoinbase/backend/internal/database"
+)
+
+const (
+	defaultKlinesLimit = 100
+	maxKlinesLimit     = 1000
+)
+
+// GetKlines returns the most recent candlesticks for a symbol, oldest first.
+// Query params: interval (1m, 5m, 1h or 1d, default 1m), limit (default 100, max 1000).
+// The window covers the last `limit` intervals up to and including the current, still open one;
+// intervals without trades are omitted, so fewer than `limit` candles may be returned.
+// This endpoint is public.
+func GetKlines(c *fiber.Ctx) error {
+	symbol := strings.ToUpper(c.Params("symbol"))
+	if symbol == "" {
+		return apierror.Send(c, apierror.BadRequest, "Symbol parameter is required")
+	}
+
+	intervalName := c.Query("interval", "1m")
+	interval, ok := database.KlineIntervals[intervalName]
+	if !ok {
+		return apierror.Send(c, apierror.BadRequest, "Invalid interval, must be one of 1m, 5m, 1h, 1d")
+	}
+
+	limit := c.QueryInt("limit", defaultKlinesLimit)
+	if limit <= 0 || limit > maxKlinesLimit {
+		return apierror.Send(c, apierror.BadRequest, "Invalid limit, must be between 1 and 1000")
+	}
+
+	// Truncate is epoch-aligned like the SQL bucketing, so the window starts on a bucket boundary
+	end := time.Now().UTC()
+	start := end.Truncate(interval).Add(-time.Duration(limit-1) * interval)
+
+	klines, err := database.GetKlines(c.Context(), symbol, interval, start, end)
+	if err != nil {
+		log.Printf("Error fetching %s klines for %s: %v", intervalName, symbol, err)
+		return apierror.Send(c, apierror.Internal, "Failed to retrieve klines")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"symbol":   symbol,
+		"interval": intervalName,
+		"klines":   klines,
+	})
+}
@@ -0,0 +1,166 @@
+package handlers
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/user/minicoinbase/backend/internal/auth"
+	"github.com/user/minicoinbase/backend/internal/config"
+	"github.com/user/minicoinbase/backend/internal/database"
+	"github.com/user/minicoinbase/backend/internal/middleware"
+	"github.com/user/minicoinbase/backend/internal/models"
+	"github.com/user/minicoinbase/backend/internal/orderbook"
+)
+
+// newLifecycleApp returns an app wired like the server's: signup is public, and the order and
+// trade routes authenticate with the access token signup hands out.
+func newLifecycleApp() *fiber.App {
+	auth.Init(&config.Config{JWTSecret: "lifecycle-test-secret", AccessTokenTTL: time.Minute, RefreshTokenTTL: time.Hour})
+	app := fiber.New()
+	app.Post("/api/auth/signup", Signup)
+	api := app.Group("/api", middleware.Protected())
+	api.Post("/orders", CreateOrder)
+	api.Get("/trades", GetTrades)
+	return app
+}
+
+// signup registers a new user through the API and returns it with its bearer token header.
+func signup(t *testing.T, app *fiber.App) (*models.User, map[string]string) {
+	t.Helper()
+	var resp AuthResponse
+	status := doRequest(t, app, uuid.Nil, http.MethodPost, "/api/auth/signup", fiber.Map{
+		"username": "life_" + uuid.NewString()[:8], "password": "Lifecycle-Test-1",
+	}, &resp)
+	if status != fiber.StatusCreated {
+		t.Fatalf("signup: status %d", status)
+	}
+	return resp.User, map[string]string{"Authorization": "Bearer " + resp.Token}
+}
+
+// deposit credits a user the way a deposit is booked: available balance plus a ledger entry.
+func deposit(t *testing.T, userID uuid.UUID, asset string, amount float64) {
+	t.Helper()
+	ctx := context.Background()
+	err := pgx.BeginFunc(ctx, database.DB, func(tx pgx.Tx) error {
+		return database.AddFunds(ctx, tx, userID, asset, amount, database.LedgerRef{Reason: database.LedgerDeposit})
+	})
+	if err != nil {
+		t.Fatalf("deposit %v %s: %v", amount, asset, err)
+	}
+}
+
+// assertReconciled checks that a user's locked balances match what their open orders need.
+func assertReconciled(t *testing.T, userID uuid.UUID) {
+	t.Helper()
+	ctx := context.Background()
+	err := pgx.BeginFunc(ctx, database.DB, func(tx pgx.Tx) error {
+		discrepancies, err := database.FindLockedDiscrepancies(ctx, tx, userID)
+		for _, d := range discrepancies {
+			t.Errorf("%s locked %v, open orders need %v", d.Asset, d.StoredLocked, d.ExpectedLocked)
+		}
+		return err
+	})
+	if err != nil {
+		t.Fatalf("FindLockedDiscrepancies: %v", err)
+	}
+}
+
+// TestOrderLifecycle follows two users from signup through a matched trade: funds are locked
+// when the buy rests, the sell fills it, and settlement moves the assets and fees (maker 10 bps,
+// taker 20 bps, each charged in the asset received) with the ledger accounting for every balance.
+func TestOrderLifecycle(t *testing.T) {
+	setupTestDB(t)
+	app := newLifecycleApp()
+	ctx := context.Background()
+
+	base, symbol := newTestMarket(t)
+
+	buyer, buyerAuth := signup(t, app)
+	seller, sellerAuth := signup(t, app)
+	deposit(t, buyer.ID, "USD", 1000)
+	deposit(t, seller.ID, base, 2)
+	assertBalance(t, buyer.ID, "USD", 1000, 0)
+	assertBalance(t, seller.ID, base, 2, 0)
+
+	// The buy rests on the book with its cost locked
+	var buy models.Order
+	status := doRequestWithHeaders(t, app, uuid.Nil, http.MethodPost, "/api/orders", buyerAuth, fiber.Map{
+		"symbol": symbol, "side": "buy", "type": "limit", "price": 100, "quantity": 1,
+	}, &buy)
+	if status != fiber.StatusCreated {
+		t.Fatalf("placing buy order: status %d", status)
+	}
+	assertBalance(t, buyer.ID, "USD", 900, 100)
+	assertReconciled(t, buyer.ID)
+
+	// A larger sell below the bid trades at the buy's (maker's) price and rests with the rest
+	var sell models.Order
+	status = doRequestWithHeaders(t, app, uuid.Nil, http.MethodPost, "/api/orders", sellerAuth, fiber.Map{
+		"symbol": symbol, "side": "sell", "type": "limit", "price": 95, "quantity": 1.5,
+	}, &sell)
+	if status != fiber.StatusCreated {
+		t.Fatalf("placing sell order: status %d", status)
+	}
+	orderbook.GlobalOrderBookManager.WaitForSettlement()
+
+	// Buyer: paid 100 USD out of locked, received 1 base less the 0.001 maker fee
+	assertBalance(t, buyer.ID, "USD", 900, 0)
+	assertBalance(t, buyer.ID, base, 0.999, 0)
+	// Seller: 1 base went out of locked, 0.5 stays locked for the resting remainder;
+	// received 100 USD less the 0.2 taker fee
+	assertBalance(t, seller.ID, base, 0.5, 0.5)
+	assertBalance(t, seller.ID, "USD", 99.8, 0)
+	assertReconciled(t, buyer.ID)
+	assertReconciled(t, seller.ID)
+
+	for _, want := range []struct {
+		id          uuid.UUID
+		status      string
+		filled, avg float64
+	}{
+		{buy.ID, "filled", 1, 100},
+		{sell.ID, "partially_filled", 1, 100},
+	} {
+		order, err := database.GetOrderByID(ctx, want.id)
+		if err != nil {
+			t.Fatalf("GetOrderByID: %v", err)
+		}
+		if order.Status != want.status || order.FilledQuantity != want.filled || order.AvgFillPrice != want.avg {
+			t.Errorf("order %s status/filled/avg = %s/%v/%v, want %s/%v/%v", order.Side, order.Status,
+				order.FilledQuantity, order.AvgFillPrice, want.status, want.filled, want.avg)
+		}
+	}
+
+	// The trade is persisted and shows up in both users' histories
+	for _, u := range []struct {
+		auth     map[string]string
+		orderID  uuid.UUID
+		role     string
+		fee      float64
+		feeAsset string
+	}{
+		{buyerAuth, buy.ID, "maker", 0.001, base},
+		{sellerAuth, sell.ID, "taker", 0.2, "USD"},
+	} {
+		var resp struct {
+			Trades []models.UserTrade `json:"trades"`
+		}
+		status := doRequestWithHeaders(t, app, uuid.Nil, http.MethodGet, "/api/trades?symbol="+symbol, u.auth, nil, &resp)
+		if status != fiber.StatusOK {
+			t.Fatalf("GET /api/trades: status %d", status)
+		}
+		if len(resp.Trades) != 1 {
+			t.Fatalf("%s has %d trades, want 1", u.role, len(resp.Trades))
+		}
+		trade := resp.Trades[0]
+		if trade.OrderID != u.orderID || trade.Role != u.role || trade.Price != 100 || trade.Quantity != 1 ||
+			trade.Fee != u.fee || trade.FeeAsset != u.feeAsset {
+			t.Errorf("%s trade = %+v, want order %s at 100 x 1, fee %v %s", u.role, trade, u.orderID, u.fee, u.feeAsset)
+		}
+	}
+}
@@ -0,0 +1,44 @@
+package handlers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/user/minicoinbase/backend/internal/markets"
+	"github.com/user/minicoinbase/backend/internal/orderbook"
+	"github.com/user/minicoinbase/backend/internal/ticker"
+)
+
+// MarketInfo is a market with its current trading state, as listed by GetMarkets.
+type MarketInfo struct {
+	markets.Market
+	LastPrice float64 `json:"last_price"` // 0 if the ticker has no price yet
+	Volume24h float64 `json:"volume_24h"` // Traded base quantity over the last 24 hours
+	Halted    bool    `json:"halted"`
+}
+
+// GetSymbols lists the tradable symbols with their order size limits, so clients can
+// validate orders before placing them. This endpoint is public.
+func GetSymbols(c *fiber.Ctx) error {
+	return c.JSON(fiber.Map{"symbols": markets.All()})
+}
+
+// GetMarkets lists every market with its trading rules (tick and step size, order limits),
+// last price, 24h volume and halt status: what a client needs to know at startup.
+// The volumes come from the order book manager's in-memory statistics. This endpoint is public.
+func GetMarkets(c *fiber.Ctx) error {
+	all := markets.All()
+	infos := make([]MarketInfo, 0, len(all))
+	for _, m := range all {
+		lastPrice, _ := ticker.LastPrice(m.Symbol)
+		var volume float64
+		if stats, err := orderbook.GlobalOrderBookManager.GetSymbolStats(m.Symbol); err == nil {
+			volume = stats.Volume
+		}
+		infos = append(infos, MarketInfo{
+			Market:    m,
+			LastPrice: lastPrice,
+			Volume24h: volume,
+			Halted:    orderbook.GlobalOrderBookManager.IsHalted(m.Symbol),
+		})
+	}
+	return c.JSON(fiber.Map{"markets": infos})
+}
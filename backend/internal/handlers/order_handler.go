@@ -1,282 +1,970 @@
 package handlers
 
 import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
 	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/user/minicoinbase/backend/internal/apierror"
+	"github.com/user/minicoinbase/backend/internal/config"
 	"github.com/user/minicoinbase/backend/internal/database"
+	"github.com/user/minicoinbase/backend/internal/logging"
+	"github.com/user/minicoinbase/backend/internal/markets"
 	"github.com/user/minicoinbase/backend/internal/models"
 	"github.com/user/minicoinbase/backend/internal/orderbook" // Import orderbook
-	// TODO: Import orderbook package when created
+	"github.com/user/minicoinbase/backend/internal/ticker"
 )
 
+const (
+	defaultOrdersLimit = 50
+	maxOrdersLimit     = 500
+)
+
+// IdempotencyKeyHeader lets a client retry CreateOrder safely: repeating a request with the same
+// key returns the order the first one created instead of placing another.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeyLength matches the idempotency_keys.key column.
+const maxIdempotencyKeyLength = 255
+
+// IdempotencyKeyTTL is how long an idempotency key is remembered, see InitOrders.
+var IdempotencyKeyTTL = 24 * time.Hour
+
+// Caps on a user's open orders, overall and per symbol, checked by CreateOrder; 0 means no cap.
+var (
+	maxOpenOrders          int
+	maxOpenOrdersPerSymbol int
+)
+
+// InitOrders applies the order settings from the configuration.
+func InitOrders(cfg *config.Config) {
+	IdempotencyKeyTTL = cfg.IdempotencyKeyTTL
+	maxOpenOrders = cfg.MaxOpenOrders
+	maxOpenOrdersPerSymbol = cfg.MaxOpenOrdersPerSymbol
+}
+
 // CreateOrderRequest defines the expected JSON body for creating an order
 type CreateOrderRequest struct {
-	Symbol   string  `json:"symbol"`   // e.g., "BTC-USD"
-	Type     string  `json:"type"`     // e.g., "limit", "market"
-	Side     string  `json:"side"`     // e.g., "buy", "sell"
-	Price    float64 `json:"price"`    // Required for limit orders
-	Quantity float64 `json:"quantity"` // Amount of base asset (e.g., BTC)
+	Symbol      string  `json:"symbol"`        // e.g., "BTC-USD"
+	Type        string  `json:"type"`          // e.g., "limit", "market", "stop", "stop_limit"
+	Side        string  `json:"side"`          // e.g., "buy", "sell"
+	Price       float64 `json:"price"`         // Required for limit and stop_limit orders
+	StopPrice   float64 `json:"stop_price"`    // Required for stop and stop_limit orders
+	TimeInForce string  `json:"time_in_force"` // "GTC" (default), "IOC" or "FOK"
+	PostOnly    bool    `json:"post_only"`     // Limit GTC orders only: reject instead of taking liquidity
+	Quantity    float64 `json:"quantity"`      // Amount of base asset (e.g., BTC)
+	// QuoteQuantity sizes a market buy in the quote asset instead of Quantity ("spend 100 USD")
+	QuoteQuantity float64 `json:"quote_quantity"`
+	// DisplayQuantity makes a GTC limit order an iceberg showing only this much of it on the book
+	// at a time; less than Quantity. The full quantity is locked up front.
+	DisplayQuantity float64 `json:"display_quantity"`
+	// LimitProtection is the worst price a market or stop order may trade at (the highest for a
+	// buy, the lowest for a sell); matching stops there and the rest is cancelled
+	LimitProtection float64 `json:"limit_protection"`
+	// ExpiresAt (RFC 3339) makes a GTC limit or stop_limit order good-till-date; must be in the future
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// ModifyOrderRequest defines the expected JSON body for modifying an order.
+// Omitted fields keep their current value.
+type ModifyOrderRequest struct {
+	Price    *float64 `json:"price"`    // New limit price
+	Quantity *float64 `json:"quantity"` // New total quantity, including what has already filled
 }
 
 // CreateOrder handles the creation of new trading orders.
+// With an Idempotency-Key header, a repeat of an earlier request (same key and body, within
+// IdempotencyKeyTTL) gets 200 with the order it created, in its current state, and no new order
+// is placed; reusing a key for a different request is rejected with 422.
 func CreateOrder(c *fiber.Ctx) error {
 	userID, ok := c.Locals("userID").(uuid.UUID)
 	if !ok {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid user ID in token"})
-	}
-
-	req := new(CreateOrderRequest)
-	if err := c.BodyParser(req); err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Cannot parse request body"})
+		return apierror.Send(c, apierror.Unauthorized, "Invalid user ID in token")
 	}
+	logger := logging.FromContext(c.Context()).With("user_id", userID)
 
-	// --- Basic Validation ---
-	req.Symbol = strings.ToUpper(strings.TrimSpace(req.Symbol))
-	req.Type = strings.ToLower(strings.TrimSpace(req.Type))
-	req.Side = strings.ToLower(strings.TrimSpace(req.Side))
-
-	if req.Symbol == "" || req.Quantity <= 0 {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Symbol and positive quantity are required"})
-	}
-	parts := strings.Split(req.Symbol, "-")
-	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid symbol format, expected BASE-QUOTE"})
+	req, err := validateAndBind[CreateOrderRequest](c)
+	if err != nil {
+		return apierror.Send(c, apierror.BadRequest, err.Error())
 	}
-	baseAsset := parts[0]
-	quoteAsset := parts[1]
 
-	if req.Side != "buy" && req.Side != "sell" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid side, must be 'buy' or 'sell'"})
+	order, err := buildOrder(req, userID)
+	if err != nil {
+		return apierror.Send(c, apierror.InvalidOrder, err.Error())
 	}
-	if req.Type != "limit" && req.Type != "market" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid type, must be 'limit' or 'market'"})
+	// Under a cancel-on-disconnect session (see handleAuth) the order is cancelled if the session's
+	// WebSocket connection goes away
+	if h := c.Get(SessionHeader); h != "" {
+		id, err := uuid.Parse(h)
+		if err != nil || !sessionActive(userID, id) {
+			return apierror.Send(c, apierror.BadRequest, "Unknown or expired session")
+		}
+		order.SessionID = &id
 	}
-	if req.Type == "limit" && req.Price <= 0 {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Positive price is required for limit orders"})
+	if orderbook.GlobalOrderBookManager.IsHalted(order.Symbol) {
+		return haltedResponse(c, order.Symbol)
 	}
-	// TODO: Add more validation (precision, allowed symbols?)
 
-	order := &models.Order{
-		UserID:   userID,
-		Symbol:   req.Symbol,
-		Type:     req.Type,
-		Side:     req.Side,
-		Quantity: req.Quantity,
-		Status:   "open", // Will be created with this status if validation/locking succeeds
-	}
-	if req.Type == "limit" {
-		order.Price = req.Price
+	idempotencyKey := c.Get(IdempotencyKeyHeader)
+	if len(idempotencyKey) > maxIdempotencyKeyLength {
+		return apierror.Send(c, apierror.BadRequest, fmt.Sprintf("%s must be at most %d characters", IdempotencyKeyHeader, maxIdempotencyKeyLength))
 	}
+	// Fingerprint of the normalized request, so a key can't be replayed for a different order
+	normalized, err := json.Marshal(req)
+	if err != nil {
+		logger.Error("Failed to encode order request", "err", err)
+		return apierror.Send(c, apierror.Internal, "Failed to process order")
+	}
+	sum := sha256.Sum256(normalized)
+	requestHash := hex.EncodeToString(sum[:])
 
 	// --- Transactional Logic ---
-	tx, err := database.DB.Begin(c.Context())
+	// Bounded, so a hung query rolls the order back and releases its row locks and funds
+	// instead of holding them; the order only reaches the book after a successful commit
+	ctx, cancel := database.WithTimeout(c.Context())
+	defer cancel()
+	tx, err := database.DB.Begin(ctx)
 	if err != nil {
-		log.Printf("Failed to begin transaction for user %s: %v", userID, err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Database error starting transaction"})
+		logger.Error("Failed to begin transaction", "err", err)
+		return apierror.Send(c, apierror.Internal, "Database error starting transaction")
 	}
 	// Ensure rollback happens if anything goes wrong before commit
-	defer tx.Rollback(c.Context())
+	defer tx.Rollback(ctx)
+
+	// 0. Claim the idempotency key, or replay the request that already used it
+	if idempotencyKey != "" {
+		previous, err := database.ClaimIdempotencyKey(ctx, tx, userID, idempotencyKey, requestHash, IdempotencyKeyTTL)
+		if err != nil {
+			logger.Error("Failed to claim idempotency key", "err", err)
+			return apierror.Send(c, apierror.Internal, "Database error checking idempotency key")
+		}
+		if previous != nil {
+			if previous.RequestHash != requestHash {
+				return apierror.Send(c, apierror.IdempotencyKeyReused, "Idempotency-Key was already used for a different request")
+			}
+			existing, err := database.GetOrderByID(ctx, previous.OrderID)
+			if err != nil {
+				logger.Error("Failed to load order for idempotency key", "order_id", previous.OrderID, "err", err)
+				return apierror.Send(c, apierror.Internal, "Database error fetching order")
+			}
+			logger.Info("Replaying order for idempotency key", "order_id", existing.ID)
+			return c.Status(fiber.StatusOK).JSON(existing)
+		}
+	}
 
-	// 1. Check and Lock Funds
-	var lockAsset string
-	var lockAmount float64
+	// Cap the user's open orders before locking any funds for another one. A soft cap:
+	// concurrent requests are counted without each other and can overshoot it slightly.
+	if msg, err := checkOpenOrderLimits(ctx, userID, req.Symbol); err != nil {
+		logger.Error("Failed to count open orders", "err", err)
+		return apierror.Send(c, apierror.Internal, "Database error checking open orders")
+	} else if msg != "" {
+		logger.Info("Open order limit reached", "symbol", req.Symbol)
+		return apierror.Send(c, apierror.OrderLimitReached, msg)
+	}
 
-	if req.Side == "buy" {
-		lockAsset = quoteAsset
-		if req.Type == "limit" {
-			lockAmount = req.Price * req.Quantity
-		} else { // Market Buy
-			// TODO: Implement market order cost estimation & locking
-			// This is complex: need current market price, potential slippage buffer.
-			// For now, reject market buys.
-			log.Printf("Market buy orders not yet supported (user %s)", userID)
-			return c.Status(fiber.StatusNotImplemented).JSON(fiber.Map{"error": "Market buy orders are not yet supported"})
+	// 1. Work out which funds to lock
+	lockAsset, lockAmount, ok := orderLock(order)
+	if !ok {
+		// TODO: Lock market buys sized in the base asset without a limit_protection
+		// Needs the current market price and a slippage buffer; quote-sized and protected market
+		// buys already lock what they may spend.
+		logger.Info("Unprotected market buy orders sized in the base asset not yet supported")
+		return apierror.Send(c, apierror.NotImplemented, marketBuyUnsupported)
+	}
+
+	order.LockedAmount, order.LockedAsset = lockAmount, lockAsset
+
+	// 2. Create Order Record, before locking so the ledger entry of the lock can refer to it
+	// (if locking fails, the transaction rolls the order back)
+	if err := database.CreateOrder(ctx, tx, order); err != nil {
+		logger.Error("Error creating order", "err", err)
+		return apierror.Send(c, apierror.Internal, "Failed to save order")
+	}
+	if idempotencyKey != "" {
+		if err := database.SetIdempotencyKeyOrder(ctx, tx, userID, idempotencyKey, order.ID); err != nil {
+			logger.Error("Failed to store idempotency key", "order_id", order.ID, "err", err)
+			return apierror.Send(c, apierror.Internal, "Failed to save order")
 		}
-	} else { // Sell side
-		lockAsset = baseAsset
-		lockAmount = req.Quantity
 	}
 
 	// Ensure the balance exists before trying to lock (avoids confusing errors)
-	_, err = database.GetOrCreateBalanceInTx(c.Context(), tx, userID, lockAsset)
+	_, err = database.GetOrCreateBalanceInTx(ctx, tx, userID, lockAsset)
 	if err != nil {
-		log.Printf("Failed to get/create %s balance for user %s in tx: %v", lockAsset, userID, err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": fmt.Sprintf("Database error accessing %s balance", lockAsset)})
+		logger.Error("Failed to get/create balance", "asset", lockAsset, "err", err)
+		return apierror.Send(c, apierror.Internal, fmt.Sprintf("Database error accessing %s balance", lockAsset))
 	}
 
-	// Attempt to lock the required funds
-	err = database.LockFunds(c.Context(), tx, userID, lockAsset, lockAmount)
+	// 3. Lock the required funds
+	err = database.LockFunds(ctx, tx, userID, lockAsset, lockAmount,
+		database.LedgerRef{Reason: database.LedgerOrderLock, OrderID: order.ID})
 	if err != nil {
-		log.Printf("Failed to lock %f %s for user %s order: %v", lockAmount, lockAsset, userID, err)
-		// Return a user-friendly insufficient funds error or the specific lock error
-		userMsg := fmt.Sprintf("Failed to lock funds: %s", err.Error())
-		if strings.Contains(err.Error(), "insufficient funds") { // Make error more generic for client
-			userMsg = fmt.Sprintf("Insufficient %s balance to place order", lockAsset)
+		logger.Warn("Failed to lock funds", "amount", lockAmount, "asset", lockAsset, "err", err)
+		if errors.Is(err, database.ErrInsufficientFunds) {
+			return apierror.Send(c, apierror.InsufficientFunds, fmt.Sprintf("Insufficient %s balance to place order", lockAsset))
 		}
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": userMsg})
+		return apierror.Send(c, apierror.Internal, "Failed to lock funds")
 	}
-	log.Printf("Successfully locked %f %s for user %s", lockAmount, lockAsset, userID)
+	logger.Debug("Locked funds", "amount", lockAmount, "asset", lockAsset)
 
-	// 2. Create Order Record
-	if err := database.CreateOrder(c.Context(), tx, order); err != nil {
-		log.Printf("Error creating order in DB for user %s (after locking funds): %v", userID, err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to save order after locking funds"})
-	}
-
-	// 3. Commit Transaction
-	if err := tx.Commit(c.Context()); err != nil {
-		log.Printf("Failed to commit transaction for user %s order %s: %v", userID, order.ID, err)
+	// 4. Commit Transaction
+	if err := tx.Commit(ctx); err != nil {
+		logger.Error("Failed to commit order", "order_id", order.ID, "err", err)
 		// Attempted to lock funds and create order, but commit failed. Funds are rolled back.
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Database error finalizing order"})
+		if database.IsTimeout(err) {
+			return apierror.Send(c, apierror.Timeout, "Database timed out, the order was not placed, please retry")
+		}
+		return apierror.Send(c, apierror.Internal, "Database error finalizing order")
 	}
 
 	// Transaction successful!
-	log.Printf("Order %s created and funds locked successfully for user %s", order.ID, userID)
+	logger.Info("Order created", "order_id", order.ID, "symbol", order.Symbol, "side", order.Side, "type", order.Type,
+		"price", order.Price, "quantity", order.Quantity, "locked", lockAmount, "locked_asset", lockAsset)
 
 	// Submit order to matching engine/order book AFTER successful commit
-	if err := orderbook.GlobalOrderBookManager.SubmitOrder(order); err != nil {
+	err = orderbook.GlobalOrderBookManager.SubmitOrder(c.Context(), order)
+	if errors.Is(err, orderbook.ErrPostOnlyWouldCross) {
+		// The manager has already cancelled the order and unlocked its funds
+		return apierror.Send(c, apierror.OrderWouldCross, "post-only order would cross the book")
+	}
+	if errors.Is(err, orderbook.ErrSymbolHalted) {
+		// Halted since the check above; cancelled and unlocked like a post-only rejection
+		return haltedResponse(c, order.Symbol)
+	}
+	if errors.Is(err, orderbook.ErrBookBusy) {
+		// Turned away by the matching engine's backpressure; cancelled and unlocked as well
+		return busyResponse(c, order.Symbol)
+	}
+	if err != nil {
 		// Log error, but don't necessarily fail the HTTP request as the order IS in the DB.
 		// This indicates an issue submitting to the live matching engine.
-		log.Printf("CRITICAL: Failed to submit committed order %s to order book: %v", order.ID, err)
+		logger.Log(c.Context(), logging.LevelCritical, "Failed to submit committed order to order book", "order_id", order.ID, "err", err)
 		// Maybe return a specific status or message indicating this?
 	}
 
 	return c.Status(fiber.StatusCreated).JSON(order)
 }
 
-// GetOrders retrieves the list of active orders for the authenticated user.
+// haltedResponse rejects an order for a symbol whose trading is halted.
+func haltedResponse(c *fiber.Ctx, symbol string) error {
+	return apierror.Send(c, apierror.SymbolHalted, fmt.Sprintf("Trading is halted for %s", symbol))
+}
+
+// unknownSymbolResponse answers a request for a symbol that has no market (see orderbook.ErrUnknownSymbol).
+func unknownSymbolResponse(c *fiber.Ctx, symbol string) error {
+	return apierror.Send(c, apierror.SymbolUnknown, fmt.Sprintf("Unknown symbol %s", symbol))
+}
+
+// busyResponse rejects an order because the symbol's order book has too many orders waiting to be matched.
+func busyResponse(c *fiber.Ctx, symbol string) error {
+	c.Set(fiber.HeaderRetryAfter, "1")
+	return apierror.Send(c, apierror.Unavailable, fmt.Sprintf("Order book for %s is busy, please retry", symbol))
+}
+
+// ValidateOrder handles POST /api/orders/validate, a dry run of CreateOrder: the order gets the
+// same checks, including the open order caps and the balance it would lock, and on success an
+// estimate of how it would fill against the book right now. Nothing is locked or stored.
+func ValidateOrder(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(uuid.UUID)
+	if !ok {
+		return apierror.Send(c, apierror.Unauthorized, "Invalid user ID in token")
+	}
+	logger := logging.FromContext(c.Context()).With("user_id", userID)
+
+	req, err := validateAndBind[CreateOrderRequest](c)
+	if err != nil {
+		return apierror.Send(c, apierror.BadRequest, err.Error())
+	}
+	order, err := buildOrder(req, userID)
+	if err != nil {
+		return apierror.Send(c, apierror.InvalidOrder, err.Error())
+	}
+	if orderbook.GlobalOrderBookManager.IsHalted(order.Symbol) {
+		return haltedResponse(c, order.Symbol)
+	}
+	lockAsset, lockAmount, ok := orderLock(order)
+	if !ok {
+		return apierror.Send(c, apierror.NotImplemented, marketBuyUnsupported)
+	}
+
+	ctx := c.Context()
+	if msg, err := checkOpenOrderLimits(ctx, userID, order.Symbol); err != nil {
+		logger.Error("Failed to count open orders", "err", err)
+		return apierror.Send(c, apierror.Internal, "Database error checking open orders")
+	} else if msg != "" {
+		return apierror.Send(c, apierror.OrderLimitReached, msg)
+	}
+	balance, err := database.GetBalance(ctx, userID, lockAsset)
+	if err != nil {
+		logger.Error("Failed to get balance", "asset", lockAsset, "err", err)
+		return apierror.Send(c, apierror.Internal, fmt.Sprintf("Database error accessing %s balance", lockAsset))
+	}
+	if balance == nil || balance.Available < lockAmount {
+		return apierror.Send(c, apierror.InsufficientFunds, fmt.Sprintf("Insufficient %s balance to place order", lockAsset))
+	}
+
+	estimate, err := orderbook.GlobalOrderBookManager.SimulateMatch(order)
+	if errors.Is(err, orderbook.ErrPostOnlyWouldCross) {
+		return apierror.Send(c, apierror.OrderWouldCross, "post-only order would cross the book")
+	}
+	if errors.Is(err, orderbook.ErrSymbolHalted) {
+		return haltedResponse(c, order.Symbol)
+	}
+	if errors.Is(err, orderbook.ErrUnknownSymbol) {
+		return unknownSymbolResponse(c, order.Symbol)
+	}
+	if err != nil {
+		logger.Error("Failed to simulate order", "err", err)
+		return apierror.Send(c, apierror.Internal, "Failed to simulate order")
+	}
+
+	return c.JSON(fiber.Map{
+		"valid":       true,
+		"symbol":      order.Symbol,
+		"side":        order.Side,
+		"type":        order.Type,
+		"quantity":    order.Quantity,
+		"lock_asset":  lockAsset,
+		"lock_amount": lockAmount,
+		"estimate":    estimate,
+	})
+}
+
+// buildOrder normalizes and validates an order request, as CreateOrder and ValidateOrder take it,
+// and returns the order it describes. The error says what is wrong with the request.
+func buildOrder(req *CreateOrderRequest, userID uuid.UUID) (*models.Order, error) {
+	req.Symbol = strings.ToUpper(strings.TrimSpace(req.Symbol))
+	req.Type = strings.ToLower(strings.TrimSpace(req.Type))
+	req.Side = strings.ToLower(strings.TrimSpace(req.Side))
+	req.TimeInForce = strings.ToUpper(strings.TrimSpace(req.TimeInForce))
+	if req.TimeInForce == "" {
+		req.TimeInForce = "GTC"
+	}
+
+	// Before any comparison below: NaN compares false with everything and would slip through them
+	for _, field := range []struct {
+		name  string
+		value float64
+	}{
+		{"price", req.Price}, {"stop_price", req.StopPrice}, {"quantity", req.Quantity},
+		{"quote_quantity", req.QuoteQuantity}, {"display_quantity", req.DisplayQuantity}, {"limit_protection", req.LimitProtection},
+	} {
+		if err := checkAmount(field.name, field.value); err != nil {
+			return nil, err
+		}
+	}
+
+	byQuote := req.QuoteQuantity != 0
+	if req.Symbol == "" || (req.Quantity <= 0 && !byQuote) {
+		return nil, errors.New("Symbol and positive quantity are required")
+	}
+	parts := strings.Split(req.Symbol, "-")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, errors.New("Invalid symbol format, expected BASE-QUOTE")
+	}
+	// Checked before anything is locked or a book is created for the symbol
+	if _, ok := markets.Get(req.Symbol); !ok {
+		return nil, fmt.Errorf("Unknown symbol %s", req.Symbol)
+	}
+
+	if req.Side != "buy" && req.Side != "sell" {
+		return nil, errors.New("Invalid side, must be 'buy' or 'sell'")
+	}
+	if req.Type != "limit" && req.Type != "market" && req.Type != "stop" && req.Type != "stop_limit" {
+		return nil, errors.New("Invalid type, must be 'limit', 'market', 'stop' or 'stop_limit'")
+	}
+	if (req.Type == "limit" || req.Type == "stop_limit") && req.Price <= 0 {
+		return nil, errors.New("Positive price is required for limit and stop_limit orders")
+	}
+	isStop := req.Type == "stop" || req.Type == "stop_limit"
+	if isStop && req.StopPrice <= 0 {
+		return nil, errors.New("Positive stop_price is required for stop and stop_limit orders")
+	}
+	if !isStop && req.StopPrice != 0 {
+		return nil, errors.New("stop_price is only allowed for stop and stop_limit orders")
+	}
+	if req.TimeInForce != "GTC" && req.TimeInForce != "IOC" && req.TimeInForce != "FOK" {
+		return nil, errors.New("Invalid time_in_force, must be 'GTC', 'IOC' or 'FOK'")
+	}
+	if isStop && req.TimeInForce != "GTC" {
+		return nil, errors.New("Stop orders only support time_in_force 'GTC'")
+	}
+	if req.PostOnly && (req.Type != "limit" || req.TimeInForce != "GTC") {
+		return nil, errors.New("post_only is only allowed for GTC limit orders")
+	}
+	if byQuote {
+		if req.Type != "market" || req.Side != "buy" {
+			return nil, errors.New("quote_quantity is only allowed for market buys")
+		}
+		if req.Quantity != 0 {
+			return nil, errors.New("Only one of quantity and quote_quantity may be set")
+		}
+		if req.QuoteQuantity < 0 {
+			return nil, errors.New("quote_quantity must be positive")
+		}
+		if req.TimeInForce == "FOK" {
+			return nil, errors.New("quote_quantity orders don't support time_in_force 'FOK'")
+		}
+	}
+	if req.DisplayQuantity != 0 {
+		// Only resting orders have anything to hide
+		if req.Type != "limit" || req.TimeInForce != "GTC" {
+			return nil, errors.New("display_quantity is only allowed for GTC limit orders")
+		}
+		if req.DisplayQuantity < 0 || req.DisplayQuantity >= req.Quantity {
+			return nil, errors.New("display_quantity must be positive and less than quantity")
+		}
+		if err := markets.CheckOrderSize(req.Symbol, 0, req.DisplayQuantity); err != nil {
+			return nil, fmt.Errorf("display_quantity: %w", err)
+		}
+		if err := markets.CheckOrderPrecision(req.Symbol, 0, req.DisplayQuantity); err != nil {
+			return nil, fmt.Errorf("display_quantity: %w", err)
+		}
+	}
+	if req.LimitProtection != 0 {
+		// Limit orders already have a worst price
+		if req.Type != "market" && req.Type != "stop" {
+			return nil, errors.New("limit_protection is only allowed for market and stop orders")
+		}
+		if req.LimitProtection < 0 {
+			return nil, errors.New("limit_protection must be positive")
+		}
+		if err := markets.CheckOrderPrecision(req.Symbol, req.LimitProtection, 0); err != nil {
+			return nil, fmt.Errorf("limit_protection: %w", err)
+		}
+	}
+	if req.ExpiresAt != nil {
+		// Only orders that can rest on the book can expire; cancelling a market buy isn't supported
+		if (req.Type != "limit" && req.Type != "stop_limit") || req.TimeInForce != "GTC" {
+			return nil, errors.New("expires_at is only allowed for GTC limit and stop_limit orders")
+		}
+		if !req.ExpiresAt.After(time.Now()) {
+			return nil, errors.New("expires_at must be in the future")
+		}
+	}
+	// Size limits are checked against the limit price, or for market and stop orders the
+	// price they are expected to trade near
+	refPrice := req.Price
+	if refPrice == 0 {
+		refPrice = req.StopPrice
+	}
+	if refPrice == 0 {
+		refPrice, _ = ticker.LastPrice(req.Symbol)
+	}
+	if byQuote {
+		// The quantity bought is only known once matched, and the engine buys in whole steps
+		if err := markets.CheckOrderValue(req.Symbol, req.QuoteQuantity); err != nil {
+			return nil, err
+		}
+	} else {
+		if err := markets.CheckOrderSize(req.Symbol, refPrice, req.Quantity); err != nil {
+			return nil, err
+		}
+		if err := markets.CheckOrderPrecision(req.Symbol, req.Price, req.Quantity); err != nil {
+			return nil, err
+		}
+		if err := markets.CheckOrderPrecision(req.Symbol, req.StopPrice, req.Quantity); err != nil {
+			return nil, err
+		}
+	}
+
+	order := &models.Order{
+		UserID:          userID,
+		Symbol:          req.Symbol,
+		Type:            req.Type,
+		Side:            req.Side,
+		TimeInForce:     req.TimeInForce,
+		PostOnly:        req.PostOnly,
+		Quantity:        req.Quantity,
+		QuoteQuantity:   req.QuoteQuantity,
+		DisplayQuantity: req.DisplayQuantity,
+		ProtectionPrice: req.LimitProtection,
+		ExpiresAt:       req.ExpiresAt,
+		Status:          "open", // Will be created with this status if validation/locking succeeds
+	}
+	if req.Type == "limit" || req.Type == "stop_limit" {
+		order.Price = req.Price
+	}
+	if isStop {
+		order.StopPrice = req.StopPrice
+	}
+	return order, nil
+}
+
+// marketBuyUnsupported rejects the orders orderLock can't fund.
+const marketBuyUnsupported = "Market and stop buy orders must be sized with quote_quantity or given a limit_protection"
+
+// orderLock returns the funds an order locks while it is open, or false for an order that can't
+// be funded up front: market and stop buys sized in the base asset without a limit_protection.
+// Quote-denominated market buys lock the quote they may spend.
+// Pending stop orders lock funds exactly like the order they turn into, so a triggered stop can
+// never fail for lack of funds: stop_limit buys lock Price*Quantity of quote, stop buys
+// ProtectionPrice*Quantity, all sells lock Quantity of base.
+// Trading fees are taken from the asset an order receives, never from the locked asset,
+// so settlement only ever consumes what was locked and no fee headroom is needed.
+func orderLock(order *models.Order) (asset string, amount float64, ok bool) {
+	parts := strings.Split(order.Symbol, "-")
+	if order.Side == "sell" {
+		return parts[0], order.Quantity, true
+	}
+	if order.QuoteQuantity > 0 {
+		return parts[1], order.QuoteQuantity, true
+	}
+	if price := orderbook.LockPrice(order); price > 0 {
+		// The limit price, or for market and stop buys the protection price they never trade beyond
+		return parts[1], price * order.Quantity, true
+	}
+	return "", 0, false
+}
+
+// checkOpenOrderLimits returns why the user may not open another order in symbol,
+// or "" if the open order caps allow it.
+func checkOpenOrderLimits(ctx context.Context, userID uuid.UUID, symbol string) (string, error) {
+	if maxOpenOrdersPerSymbol > 0 {
+		n, err := database.CountOpenOrders(ctx, userID, symbol)
+		if err != nil {
+			return "", err
+		}
+		if n >= maxOpenOrdersPerSymbol {
+			return fmt.Sprintf("Too many open orders for %s (limit %d)", symbol, maxOpenOrdersPerSymbol), nil
+		}
+	}
+	if maxOpenOrders > 0 {
+		n, err := database.CountOpenOrders(ctx, userID, "")
+		if err != nil {
+			return "", err
+		}
+		if n >= maxOpenOrders {
+			return fmt.Sprintf("Too many open orders (limit %d)", maxOpenOrders), nil
+		}
+	}
+	return "", nil
+}
+
+// Order states of GET /api/orders?state=: the statuses each lists.
+var orderStates = map[string][]string{
+	"active":  {"open", "partially_filled"},
+	"history": {"filled", "cancelled"},
+}
+
+// GetOrders retrieves one page of the authenticated user's orders, newest first.
+// Query params: state (active: open and partially filled orders; history: filled and cancelled
+// orders, most recently updated first), symbol, status (comma separated, instead of state),
+// include_cancelled (default false), include_archive (default false, also search orders moved to
+// the archive), limit (default 50, max 500), offset.
 func GetOrders(c *fiber.Ctx) error {
 	userID, ok := c.Locals("userID").(uuid.UUID)
 	if !ok {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid user ID in token"})
+		return apierror.Send(c, apierror.Unauthorized, "Invalid user ID in token")
+	}
+
+	filter := database.OrderFilter{
+		Symbol:           strings.ToUpper(strings.TrimSpace(c.Query("symbol"))),
+		IncludeCancelled: c.QueryBool("include_cancelled", false),
+		IncludeArchive:   c.QueryBool("include_archive", false),
+		Limit:            c.QueryInt("limit", defaultOrdersLimit),
+		Offset:           c.QueryInt("offset", 0),
+	}
+
+	for _, status := range strings.Split(c.Query("status"), ",") {
+		status = strings.ToLower(strings.TrimSpace(status))
+		switch status {
+		case "":
+		case "open", "partially_filled", "filled", "cancelled":
+			filter.Statuses = append(filter.Statuses, status)
+		default:
+			return apierror.Send(c, apierror.BadRequest, "Invalid status, must be one of open, partially_filled, filled, cancelled")
+		}
+	}
+	if state := strings.ToLower(strings.TrimSpace(c.Query("state"))); state != "" {
+		statuses, ok := orderStates[state]
+		if !ok {
+			return apierror.Send(c, apierror.BadRequest, "Invalid state, must be active or history")
+		}
+		if len(filter.Statuses) > 0 {
+			return apierror.Send(c, apierror.BadRequest, "state and status can't be combined")
+		}
+		filter.Statuses = statuses
+		filter.ByUpdateTime = state == "history"
+	}
+	if filter.Limit <= 0 || filter.Limit > maxOrdersLimit {
+		return apierror.Send(c, apierror.BadRequest, "Invalid limit, must be between 1 and 500")
+	}
+	if filter.Offset < 0 {
+		return apierror.Send(c, apierror.BadRequest, "Invalid offset, must not be negative")
 	}
 
-	orders, err := database.GetUserOrders(c.Context(), userID)
+	orders, total, err := database.GetUserOrders(c.Context(), userID, filter)
 	if err != nil {
-		log.Printf("Error fetching orders for user %s: %v", userID, err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve orders"})
+		logging.FromContext(c.Context()).Error("Error fetching orders", "user_id", userID, "err", err)
+		return apierror.Send(c, apierror.Internal, "Failed to retrieve orders")
 	}
 
-	return c.Status(fiber.StatusOK).JSON(orders)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"orders": orders,
+		"total":  total,
+		"limit":  filter.Limit,
+		"offset": filter.Offset,
+	})
 }
 
 // GetOrderByID retrieves a specific order by its ID.
 func GetOrderByID(c *fiber.Ctx) error {
 	userID, ok := c.Locals("userID").(uuid.UUID)
 	if !ok {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid user ID in token"})
+		return apierror.Send(c, apierror.Unauthorized, "Invalid user ID in token")
 	}
 
 	orderIDParam := c.Params("id")
 	orderID, err := uuid.Parse(orderIDParam)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid order ID format"})
+		return apierror.Send(c, apierror.BadRequest, "Invalid order ID format")
 	}
 
 	order, err := database.GetOrderByID(c.Context(), orderID)
 	if err != nil {
-		log.Printf("Error fetching order %s: %v", orderID, err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve order details"})
+		logging.FromContext(c.Context()).Error("Error fetching order", "order_id", orderID, "err", err)
+		return apierror.Send(c, apierror.Internal, "Failed to retrieve order details")
 	}
 
 	if order == nil {
-		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "Order not found"})
+		return apierror.Send(c, apierror.OrderNotFound, "Order not found")
 	}
 
 	// Ensure the user owns this order
 	if order.UserID != userID {
-		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "You do not have permission to view this order"})
+		return apierror.Send(c, apierror.Forbidden, "You do not have permission to view this order")
 	}
 
 	return c.Status(fiber.StatusOK).JSON(order)
 }
 
+// GetOrderFills lists the executions of one of the user's orders, oldest first, each with the
+// order's filled quantity, average price and fees up to that fill. Archived orders are included.
+func GetOrderFills(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(uuid.UUID)
+	if !ok {
+		return apierror.Send(c, apierror.Unauthorized, "Invalid user ID in token")
+	}
+
+	orderID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return apierror.Send(c, apierror.BadRequest, "Invalid order ID format")
+	}
+
+	owner, err := database.GetOrderOwner(c.Context(), orderID)
+	if err != nil {
+		logging.FromContext(c.Context()).Error("Error fetching order", "order_id", orderID, "err", err)
+		return apierror.Send(c, apierror.Internal, "Failed to retrieve order fills")
+	}
+	if owner == uuid.Nil {
+		return apierror.Send(c, apierror.OrderNotFound, "Order not found")
+	}
+	if owner != userID {
+		return apierror.Send(c, apierror.Forbidden, "You do not have permission to view this order")
+	}
+
+	fills, err := database.GetOrderFills(c.Context(), orderID)
+	if err != nil {
+		logging.FromContext(c.Context()).Error("Error fetching order fills", "order_id", orderID, "err", err)
+		return apierror.Send(c, apierror.Internal, "Failed to retrieve order fills")
+	}
+	return c.Status(fiber.StatusOK).JSON(fills)
+}
+
 // CancelOrder handles the cancellation of an existing order.
 func CancelOrder(c *fiber.Ctx) error {
 	userID, ok := c.Locals("userID").(uuid.UUID)
 	if !ok {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid user ID in token"})
+		return apierror.Send(c, apierror.Unauthorized, "Invalid user ID in token")
 	}
 
 	orderIDParam := c.Params("id")
 	orderID, err := uuid.Parse(orderIDParam)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Invalid order ID format"})
+		return apierror.Send(c, apierror.BadRequest, "Invalid order ID format")
 	}
 
+	logger := logging.FromContext(c.Context()).With("user_id", userID, "order_id", orderID)
+
 	// --- Transactional Logic ---
+	// Not bounded by database.WithTimeout: the order leaves the book before commit, and a rollback
+	// after that would leave it open in the database; the server's statement timeout still applies
 	tx, err := database.DB.Begin(c.Context())
 	if err != nil {
-		log.Printf("CancelOrder: Failed to begin transaction for user %s: %v", userID, err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Database error starting transaction"})
+		logger.Error("CancelOrder: Failed to begin transaction", "err", err)
+		return apierror.Send(c, apierror.Internal, "Database error starting transaction")
 	}
 	defer tx.Rollback(c.Context())
 
-	// 1. Attempt to cancel the order in the DB (locks row, checks ownership & status)
-	originalOrder, err := database.CancelOrder(c.Context(), tx, userID, orderID)
-	if err != nil {
-		log.Printf("CancelOrder: Failed for user %s, order %s: %v", userID, orderID, err)
-		userMsg := err.Error()
-		status := fiber.StatusInternalServerError
-		if strings.Contains(userMsg, "not found or permission denied") {
-			status = fiber.StatusNotFound // Or StatusForbidden depending on desired behavior
-			userMsg = "Order not found or you do not have permission to cancel it"
-		} else if strings.Contains(userMsg, "not in a cancellable state") {
-			status = fiber.StatusBadRequest
-		} else {
-			userMsg = "Failed to cancel order"
-		}
-		return c.Status(status).JSON(fiber.Map{"error": userMsg})
-	}
-
-	// 2. Determine which funds to unlock
-	parts := strings.Split(originalOrder.Symbol, "-")
-	baseAsset := parts[0]
-	quoteAsset := parts[1]
-	var unlockAsset string
-	var unlockAmount float64
-
-	if originalOrder.Side == "buy" {
-		unlockAsset = quoteAsset
-		if originalOrder.Type == "limit" {
-			unlockAmount = originalOrder.Price * originalOrder.Quantity
-		} else {
-			// Market buy cancellation logic if market buys were supported
-			log.Printf("CancelOrder: Market buy cancellation logic needed user %s, order %s", userID, orderID)
-			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Cannot cancel market buy order (logic pending)"})
-		}
-	} else { // Sell side
-		unlockAsset = baseAsset
-		unlockAmount = originalOrder.Quantity
-	}
-
-	// 3. Unlock the previously locked funds
-	if err := database.UnlockFunds(c.Context(), tx, userID, unlockAsset, unlockAmount); err != nil {
-		log.Printf("CancelOrder: CRITICAL: Failed to unlock %f %s for user %s, order %s after status update: %v",
-			unlockAmount, unlockAsset, userID, orderID, err)
-		// Order status is 'cancelled', but funds might still be locked! Requires manual intervention.
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Order cancelled, but failed to unlock funds. Please contact support."}) // Critical error
-	}
-	log.Printf("CancelOrder: Unlocked %f %s for user %s, order %s", unlockAmount, unlockAsset, userID, orderID)
+	if _, err := orderbook.GlobalOrderBookManager.CancelOrderInTx(c.Context(), tx, userID, orderID); err != nil {
+		logger.Warn("CancelOrder: Failed", "err", err)
+		switch {
+		case errors.Is(err, database.ErrOrderNotFound):
+			return apierror.Send(c, apierror.OrderNotFound, "Order not found or you do not have permission to cancel it")
+		case errors.Is(err, database.ErrOrderNotCancellable):
+			return apierror.Send(c, apierror.OrderNotCancellable, err.Error())
+		}
+		return apierror.Send(c, apierror.Internal, "Failed to cancel order")
+	}
 
-	// 4. Commit Transaction
+	// Commit Transaction
 	if err := tx.Commit(c.Context()); err != nil {
-		log.Printf("CancelOrder: Failed to commit transaction for user %s order %s: %v", userID, orderID, err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Database error finalizing order cancellation"})
+		logger.Log(c.Context(), logging.LevelCritical, "CancelOrder: Failed to commit after removing order from book", "err", err)
+		return apierror.Send(c, apierror.Internal, "Database error finalizing order cancellation")
 	}
 
 	// Transaction successful!
-	log.Printf("Order %s cancelled successfully in DB for user %s", orderID, userID)
+	logger.Info("Order cancelled")
 
-	// Notify order book/matching engine AFTER successful commit
-	if err := orderbook.GlobalOrderBookManager.CancelOrder(originalOrder); err != nil {
-		// Order is cancelled in DB, but failed to remove from live book. Log critically.
-		log.Printf("CRITICAL: Failed to cancel order %s from order book after DB commit: %v", originalOrder.ID, err)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Order cancelled successfully"})
+}
+
+// ModifyOrder changes the price and/or quantity of an open limit order (cancel-replace).
+// Within one transaction the locked funds are adjusted for the difference, the order row is
+// updated and the order is replaced in the live book. The quantity is the new total, so it
+// can't go below what has already filled.
+// A price change or a quantity increase sends the order to the back of the queue at its price
+// (and a price that crosses the book trades right away); a pure quantity decrease keeps its priority.
+func ModifyOrder(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(uuid.UUID)
+	if !ok {
+		return apierror.Send(c, apierror.Unauthorized, "Invalid user ID in token")
 	}
 
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "Order cancelled successfully"})
+	orderID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return apierror.Send(c, apierror.BadRequest, "Invalid order ID format")
+	}
+
+	req, err := validateAndBind[ModifyOrderRequest](c)
+	if err != nil {
+		return apierror.Send(c, apierror.BadRequest, err.Error())
+	}
+	if req.Price == nil && req.Quantity == nil {
+		return apierror.Send(c, apierror.BadRequest, "Nothing to modify, provide price and/or quantity")
+	}
+	if req.Price != nil {
+		if err := checkAmount("price", *req.Price); err != nil {
+			return apierror.Send(c, apierror.BadRequest, err.Error())
+		}
+		if *req.Price <= 0 {
+			return apierror.Send(c, apierror.BadRequest, "Price must be positive")
+		}
+	}
+	if req.Quantity != nil {
+		if err := checkAmount("quantity", *req.Quantity); err != nil {
+			return apierror.Send(c, apierror.BadRequest, err.Error())
+		}
+		if *req.Quantity <= 0 {
+			return apierror.Send(c, apierror.BadRequest, "Quantity must be positive")
+		}
+	}
+
+	logger := logging.FromContext(c.Context()).With("user_id", userID, "order_id", orderID)
+
+	ctx, cancel := database.WithTimeout(c.Context())
+	defer cancel()
+
+	tx, err := database.DB.Begin(ctx)
+	if err != nil {
+		logger.Error("ModifyOrder: Failed to begin transaction", "err", err)
+		return apierror.Send(c, apierror.Internal, "Database error starting transaction")
+	}
+	defer tx.Rollback(ctx)
+
+	// 1. Lock the order row (checks ownership)
+	order, err := database.GetOrderForUpdate(ctx, tx, userID, orderID)
+	if err != nil {
+		logger.Warn("ModifyOrder: Failed", "err", err)
+		if errors.Is(err, database.ErrOrderNotFound) {
+			return apierror.Send(c, apierror.OrderNotFound, "Order not found or you do not have permission to modify it")
+		}
+		return apierror.Send(c, apierror.Internal, "Failed to modify order")
+	}
+	if order.Status != "open" && order.Status != "partially_filled" {
+		return apierror.Send(c, apierror.OrderNotModifiable, fmt.Sprintf("Order is not in a modifiable state (status: %s)", order.Status))
+	}
+	if order.Type != "limit" {
+		return apierror.Send(c, apierror.OrderNotModifiable, "Only limit orders can be modified")
+	}
+
+	// 2. The live book has the remaining quantity, including fills that are not settled yet
+	live, remaining, ok := orderbook.GlobalOrderBookManager.GetOrder(order.Symbol, orderID)
+	if !ok {
+		return apierror.Send(c, apierror.Conflict, "Order is not live on the order book")
+	}
+	filled := order.Quantity - remaining
+
+	newPrice, newQuantity := order.Price, order.Quantity
+	if req.Price != nil {
+		newPrice = *req.Price
+	}
+	if req.Quantity != nil {
+		newQuantity = *req.Quantity
+	}
+	if newQuantity <= filled {
+		return apierror.SendDetails(c, apierror.OrderNotModifiable, fmt.Sprintf("Quantity must be greater than the already filled quantity (%g)", filled),
+			fiber.Map{"filled_quantity": filled})
+	}
+	if err := markets.CheckOrderSize(order.Symbol, newPrice, newQuantity); err != nil {
+		return apierror.Send(c, apierror.BadRequest, err.Error())
+	}
+	if err := markets.CheckOrderPrecision(order.Symbol, newPrice, newQuantity); err != nil {
+		return apierror.Send(c, apierror.BadRequest, err.Error())
+	}
+	newRemaining := newQuantity - filled
+
+	// 3. Adjust the locked funds from what backs the remaining quantity now to what the new one needs
+	parts := strings.Split(order.Symbol, "-")
+	lockAsset, delta := parts[0], newRemaining-remaining
+	if order.Side == "buy" {
+		lockAsset, delta = parts[1], newPrice*newRemaining-live.Price*remaining
+	}
+	if delta > 0 {
+		ref := database.LedgerRef{Reason: database.LedgerOrderLock, OrderID: orderID}
+		if err := database.LockFunds(ctx, tx, userID, lockAsset, delta, ref); err != nil {
+			logger.Warn("ModifyOrder: Failed to lock additional funds", "amount", delta, "asset", lockAsset, "err", err)
+			if errors.Is(err, database.ErrInsufficientFunds) {
+				return apierror.Send(c, apierror.InsufficientFunds, fmt.Sprintf("Insufficient %s balance to modify order", lockAsset))
+			}
+			return apierror.Send(c, apierror.Internal, "Failed to lock funds")
+		}
+	} else if delta < 0 {
+		ref := database.LedgerRef{Reason: database.LedgerOrderUnlock, OrderID: orderID}
+		if err := database.UnlockFunds(ctx, tx, userID, lockAsset, -delta, ref); err != nil {
+			logger.Error("ModifyOrder: Failed to unlock funds", "amount", -delta, "asset", lockAsset, "err", err)
+			return apierror.Send(c, apierror.Internal, "Failed to unlock funds")
+		}
+	}
+
+	// 4. Update the order row
+	if err := database.UpdateOrderPriceQuantity(ctx, tx, orderID, newPrice, newQuantity); err != nil {
+		logger.Error("ModifyOrder: Failed to update order", "err", err)
+		return apierror.Send(c, apierror.Internal, "Failed to modify order")
+	}
+	if err := database.AdjustOrderLock(ctx, tx, orderID, delta); err != nil {
+		logger.Error("ModifyOrder: Failed to update locked amount", "err", err)
+		return apierror.Send(c, apierror.Internal, "Failed to modify order")
+	}
+
+	// 5. Replace it in the live book last, so nothing above can fail once it trades at the new terms
+	err = orderbook.GlobalOrderBookManager.ReplaceOrder(ctx, order, remaining, newPrice, newRemaining)
+	if errors.Is(err, orderbook.ErrOrderChanged) {
+		return apierror.Send(c, apierror.Conflict, "Order was filled while being modified, please retry")
+	}
+	if errors.Is(err, orderbook.ErrPostOnlyWouldCross) {
+		return apierror.Send(c, apierror.OrderWouldCross, "post-only order would cross the book")
+	}
+	if errors.Is(err, orderbook.ErrSymbolHalted) {
+		return haltedResponse(c, order.Symbol)
+	}
+	if errors.Is(err, orderbook.ErrBookBusy) {
+		return busyResponse(c, order.Symbol)
+	}
+	if err != nil {
+		return apierror.Send(c, apierror.Internal, "Failed to modify order on the order book")
+	}
+
+	// Not bounded: the order has changed in the book, so the change must not be rolled back now
+	if err := tx.Commit(context.WithoutCancel(ctx)); err != nil {
+		logger.Log(c.Context(), logging.LevelCritical, "ModifyOrder: Failed to commit after replacing order on book", "err", err)
+		return apierror.Send(c, apierror.Internal, "Database error finalizing order modification")
+	}
+	logger.Info("Order modified", "old_price", order.Price, "price", newPrice, "old_quantity", order.Quantity, "quantity", newQuantity)
+
+	order.Price, order.Quantity = newPrice, newQuantity
+	return c.Status(fiber.StatusOK).JSON(order)
+}
+
+// CancelFailure reports an order that CancelAllOrders could not cancel.
+type CancelFailure struct {
+	OrderID uuid.UUID `json:"order_id"`
+	Error   string    `json:"error"`
+}
+
+// CancelAllOrders cancels all of the user's open and partially filled orders,
+// optionally only those of the symbol given by the "symbol" query param.
+// All cancellations share one transaction, but each runs in its own savepoint,
+// so an order that fails to cancel is reported without aborting the rest.
+func CancelAllOrders(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(uuid.UUID)
+	if !ok {
+		return apierror.Send(c, apierror.Unauthorized, "Invalid user ID in token")
+	}
+	symbol := strings.ToUpper(strings.TrimSpace(c.Query("symbol")))
+	logger := logging.FromContext(c.Context()).With("user_id", userID)
+
+	tx, err := database.DB.Begin(c.Context())
+	if err != nil {
+		logger.Error("CancelAllOrders: Failed to begin transaction", "err", err)
+		return apierror.Send(c, apierror.Internal, "Database error starting transaction")
+	}
+	defer tx.Rollback(c.Context())
+
+	orderIDs, err := database.GetCancellableOrderIDs(c.Context(), tx, userID, symbol)
+	if err != nil {
+		logger.Error("CancelAllOrders: Failed to list orders", "err", err)
+		return apierror.Send(c, apierror.Internal, "Failed to retrieve orders")
+	}
+	// Take every order row before the first balance change, as settlement does, so the two can't deadlock
+	if err := database.LockOrders(c.Context(), tx, orderIDs...); err != nil {
+		logger.Error("CancelAllOrders: Failed to lock orders", "err", err)
+		return apierror.Send(c, apierror.Internal, "Failed to retrieve orders")
+	}
+
+	cancelled, failures, err := cancelOrdersInTx(c.Context(), tx, userID, orderIDs)
+	if err != nil {
+		logger.Error("CancelAllOrders: Failed", "err", err)
+		return apierror.Send(c, apierror.Internal, "Database error cancelling orders")
+	}
+
+	if err := tx.Commit(c.Context()); err != nil {
+		logger.Log(c.Context(), logging.LevelCritical, "CancelAllOrders: Failed to commit after removing orders from books",
+			"orders", len(cancelled), "err", err)
+		return apierror.Send(c, apierror.Internal, "Database error finalizing order cancellation")
+	}
+	logger.Info("Cancelled all orders", "symbol", symbol, "cancelled", len(cancelled), "failed", len(failures))
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"cancelled": len(cancelled),
+		"order_ids": cancelled,
+		"failed":    failures,
+	})
+}
+
+// cancelOrdersInTx cancels each of the user's orders within tx, each in its own savepoint, so an
+// order that fails to cancel is reported in the failures without aborting the rest. The order rows
+// should already be locked, see CancelAllOrders. An error means tx is unusable and must be rolled
+// back; otherwise tx must be committed, the cancelled orders are out of the book.
+func cancelOrdersInTx(ctx context.Context, tx pgx.Tx, userID uuid.UUID, orderIDs []uuid.UUID) ([]uuid.UUID, []CancelFailure, error) {
+	logger := logging.FromContext(ctx).With("user_id", userID)
+	cancelled := make([]uuid.UUID, 0, len(orderIDs))
+	failures := make([]CancelFailure, 0)
+	for _, orderID := range orderIDs {
+		savepoint, err := tx.Begin(ctx)
+		if err != nil {
+			return nil, nil, fmt.Errorf("error creating savepoint for order %s: %w", orderID, err)
+		}
+		if _, err := orderbook.GlobalOrderBookManager.CancelOrderInTx(ctx, savepoint, userID, orderID); err != nil {
+			logger.Warn("Failed to cancel order", "order_id", orderID, "err", err)
+			if rbErr := savepoint.Rollback(ctx); rbErr != nil {
+				return nil, nil, fmt.Errorf("error rolling back savepoint of order %s: %w", orderID, rbErr)
+			}
+			failures = append(failures, CancelFailure{OrderID: orderID, Error: err.Error()})
+			continue
+		}
+		if err := savepoint.Commit(ctx); err != nil {
+			return nil, nil, fmt.Errorf("error releasing savepoint of order %s: %w", orderID, err)
+		}
+		cancelled = append(cancelled, orderID)
+	}
+	return cancelled, failures, nil
 }
@@ -0,0 +1,711 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/user/minicoinbase/backend/internal/apierror"
+	"github.com/user/minicoinbase/backend/internal/config"
+	"github.com/user/minicoinbase/backend/internal/database"
+	"github.com/user/minicoinbase/backend/internal/fees"
+	"github.com/user/minicoinbase/backend/internal/markets"
+	"github.com/user/minicoinbase/backend/internal/models"
+	"github.com/user/minicoinbase/backend/internal/orderbook"
+	ws "github.com/user/minicoinbase/backend/internal/websocket"
+)
+
+// setupTestDB connects to the database named by TEST_DATABASE_URL, migrates it and
+// initializes the order book manager. Tests using it are skipped when the variable is unset.
+func setupTestDB(t *testing.T) {
+	t.Helper()
+	dsn := os.Getenv("TEST_DATABASE_URL")
+	if dsn == "" {
+		t.Skip("TEST_DATABASE_URL not set, skipping database test")
+	}
+
+	cfg := &config.Config{
+		DatabaseURL:     dsn,
+		MakerFeeBps:     10,
+		TakerFeeBps:     20,
+		SelfTradePolicy: "cancel_newest",
+	}
+	if err := database.InitDB(context.Background(), cfg); err != nil {
+		t.Fatalf("InitDB: %v", err)
+	}
+	t.Cleanup(database.CloseDB)
+	fees.Init(cfg)
+	orderbook.InitManager(cfg)
+}
+
+// testMarkets are the markets registered by newTestMarket, kept across tests since
+// markets.Init replaces the whole registry.
+var testMarkets = map[string]config.SymbolConfig{}
+
+// newTestMarket registers a market with a fresh base asset, which keeps the test's book and
+// balances isolated from earlier runs, and returns the base asset and the symbol.
+func newTestMarket(t *testing.T) (base, symbol string) {
+	t.Helper()
+	base = "T" + strings.ToUpper(uuid.NewString()[:6])
+	symbol = base + "-USD"
+	testMarkets[symbol] = config.SymbolConfig{InitialPrice: 100}
+	markets.Init(&config.Config{Symbols: testMarkets})
+	return base, symbol
+}
+
+// newTestUser creates a user with a unique name and credits it with the given funds.
+func newTestUser(t *testing.T, funds map[string]float64) *models.User {
+	t.Helper()
+	ctx := context.Background()
+	user, err := database.CreateUser(ctx, "test_"+uuid.NewString()[:8], "x")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	err = pgx.BeginFunc(ctx, database.DB, func(tx pgx.Tx) error {
+		for asset, amount := range funds {
+			if err := database.AddFunds(ctx, tx, user.ID, asset, amount, database.LedgerRef{Reason: database.LedgerDeposit}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("AddFunds: %v", err)
+	}
+	return user
+}
+
+// newTestApp returns an app serving the order routes, authenticating requests by the X-Test-User header.
+func newTestApp() *fiber.App {
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		if id, err := uuid.Parse(c.Get("X-Test-User")); err == nil {
+			c.Locals("userID", id)
+		}
+		return c.Next()
+	})
+	app.Post("/api/orders", CreateOrder)
+	app.Delete("/api/orders/:id", CancelOrder)
+	app.Get("/api/orders/:id/fills", GetOrderFills)
+	return app
+}
+
+// doRequest sends a request as the given user and decodes the JSON response into out (if not nil).
+func doRequest(t *testing.T, app *fiber.App, userID uuid.UUID, method, path string, body interface{}, out interface{}) int {
+	t.Helper()
+	return doRequestWithHeaders(t, app, userID, method, path, nil, body, out)
+}
+
+// doRequestWithHeaders is doRequest with extra request headers.
+func doRequestWithHeaders(t *testing.T, app *fiber.App, userID uuid.UUID, method, path string, headers map[string]string, body interface{}, out interface{}) int {
+	t.Helper()
+	var reqBody bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&reqBody).Encode(body); err != nil {
+			t.Fatalf("encoding request: %v", err)
+		}
+	}
+	req := httptest.NewRequest(method, path, &reqBody)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Test-User", userID.String())
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := app.Test(req, -1)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, path, err)
+	}
+	defer resp.Body.Close()
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			t.Fatalf("decoding %s %s response: %v", method, path, err)
+		}
+	}
+	return resp.StatusCode
+}
+
+// assertBalance checks a balance, and that the ledger accounts for it.
+func assertBalance(t *testing.T, userID uuid.UUID, asset string, available, locked float64) {
+	t.Helper()
+	balance, err := database.GetBalance(context.Background(), userID, asset)
+	if err != nil {
+		t.Fatalf("GetBalance %s: %v", asset, err)
+	}
+	if balance.Available != available || balance.Locked != locked {
+		t.Errorf("%s balance = %v available / %v locked, want %v / %v",
+			asset, balance.Available, balance.Locked, available, locked)
+	}
+	ledgerAvailable, ledgerLocked, err := database.GetLedgerTotals(context.Background(), userID, asset)
+	if err != nil {
+		t.Fatalf("GetLedgerTotals %s: %v", asset, err)
+	}
+	if ledgerAvailable != balance.Available || ledgerLocked != balance.Locked {
+		t.Errorf("%s ledger totals = %v available / %v locked, balance is %v / %v",
+			asset, ledgerAvailable, ledgerLocked, balance.Available, balance.Locked)
+	}
+}
+
+func TestCancelPartiallyFilledOrderUnlocksRemainder(t *testing.T) {
+	setupTestDB(t)
+	app := newTestApp()
+
+	base, symbol := newTestMarket(t)
+	buyer := newTestUser(t, map[string]float64{"USD": 1000})
+	seller := newTestUser(t, map[string]float64{base: 1})
+
+	var buy models.Order
+	status := doRequest(t, app, buyer.ID, http.MethodPost, "/api/orders", fiber.Map{
+		"symbol": symbol, "side": "buy", "type": "limit", "price": 100, "quantity": 1,
+	}, &buy)
+	if status != fiber.StatusCreated {
+		t.Fatalf("placing buy order: status %d", status)
+	}
+	assertBalance(t, buyer.ID, "USD", 900, 100)
+
+	// Fill half of the buy order
+	status = doRequest(t, app, seller.ID, http.MethodPost, "/api/orders", fiber.Map{
+		"symbol": symbol, "side": "sell", "type": "limit", "price": 100, "quantity": 0.5,
+	}, nil)
+	if status != fiber.StatusCreated {
+		t.Fatalf("placing sell order: status %d", status)
+	}
+	orderbook.GlobalOrderBookManager.WaitForSettlement()
+
+	order, err := database.GetOrderByID(context.Background(), buy.ID)
+	if err != nil {
+		t.Fatalf("GetOrderByID: %v", err)
+	}
+	if order.Status != "partially_filled" {
+		t.Fatalf("buy order status = %q after half fill, want partially_filled", order.Status)
+	}
+	if order.Quantity != 1 || order.FilledQuantity != 0.5 || order.AvgFillPrice != 100 {
+		t.Errorf("buy order quantity/filled/avg = %v/%v/%v, want 1/0.5/100", order.Quantity, order.FilledQuantity, order.AvgFillPrice)
+	}
+	if order.LockedAmount != 50 || order.LockedAsset != "USD" {
+		t.Errorf("buy order locked = %v %s after half fill, want 50 USD", order.LockedAmount, order.LockedAsset)
+	}
+	assertBalance(t, buyer.ID, "USD", 900, 50)
+
+	status = doRequest(t, app, buyer.ID, http.MethodDelete, "/api/orders/"+buy.ID.String(), nil, nil)
+	if status != fiber.StatusOK {
+		t.Fatalf("cancelling partially filled order: status %d", status)
+	}
+
+	// Only the unfilled half (0.5 * 100) is released; the filled half was spent on the trade
+	assertBalance(t, buyer.ID, "USD", 950, 0)
+	order, err = database.GetOrderByID(context.Background(), buy.ID)
+	if err != nil {
+		t.Fatalf("GetOrderByID: %v", err)
+	}
+	if order.Status != "cancelled" || order.LockedAmount != 0 {
+		t.Errorf("buy order status = %q with %v locked after cancel, want cancelled with nothing locked", order.Status, order.LockedAmount)
+	}
+
+	// Cancelling again must fail rather than unlock anything twice
+	var apiErr apierror.Error
+	status = doRequest(t, app, buyer.ID, http.MethodDelete, "/api/orders/"+buy.ID.String(), nil, &apiErr)
+	if status != fiber.StatusBadRequest || apiErr.Code != apierror.OrderNotCancellable {
+		t.Errorf("second cancel: status %d code %q, want %d %q", status, apiErr.Code, fiber.StatusBadRequest, apierror.OrderNotCancellable)
+	}
+	assertBalance(t, buyer.ID, "USD", 950, 0)
+}
+
+func TestCancelStuckMarketBuyUnlocksResidual(t *testing.T) {
+	setupTestDB(t)
+	app := newTestApp()
+	ctx := context.Background()
+
+	_, symbol := newTestMarket(t)
+	buyer := newTestUser(t, map[string]float64{"USD": 1000})
+
+	// A market buy that never made it to the book, with a worst-case cost of 150 locked
+	order := &models.Order{UserID: buyer.ID, Symbol: symbol, Type: "market", Side: "buy", TimeInForce: "IOC",
+		Quantity: 1, Status: "open", LockedAmount: 150, LockedAsset: "USD"}
+	tx, err := database.DB.Begin(ctx)
+	if err != nil {
+		t.Fatalf("Begin: %v", err)
+	}
+	defer tx.Rollback(ctx)
+	if err := database.CreateOrder(ctx, tx, order); err != nil {
+		t.Fatalf("CreateOrder: %v", err)
+	}
+	if err := database.LockFunds(ctx, tx, buyer.ID, "USD", 150, database.LedgerRef{Reason: database.LedgerOrderLock, OrderID: order.ID}); err != nil {
+		t.Fatalf("LockFunds: %v", err)
+	}
+	if err := tx.Commit(ctx); err != nil {
+		t.Fatalf("Commit: %v", err)
+	}
+
+	// Half of it filled at 100 before it got stuck, spending 50 of the lock
+	_, err = database.DB.Exec(ctx, `UPDATE orders SET filled_quantity = 0.5, avg_fill_price = 100, status = 'partially_filled', locked_amount = 100
+		 WHERE id = $1`, order.ID)
+	if err != nil {
+		t.Fatalf("filling order: %v", err)
+	}
+	_, err = database.DB.Exec(ctx, `UPDATE balances SET locked = locked - 50 WHERE user_id = $1 AND asset = 'USD'`, buyer.ID)
+	if err != nil {
+		t.Fatalf("spending lock: %v", err)
+	}
+
+	status := doRequest(t, app, buyer.ID, http.MethodDelete, "/api/orders/"+order.ID.String(), nil, nil)
+	if status != fiber.StatusOK {
+		t.Fatalf("cancelling stuck market buy: status %d", status)
+	}
+	// The 100 its fills didn't spend is released. (The ledger doesn't add up here, the fill was faked.)
+	balance, err := database.GetBalance(ctx, buyer.ID, "USD")
+	if err != nil {
+		t.Fatalf("GetBalance: %v", err)
+	}
+	if balance.Available != 950 || balance.Locked != 0 {
+		t.Errorf("USD balance after cancel = %v / %v, want 950 / 0", balance.Available, balance.Locked)
+	}
+}
+
+func TestQuoteQuantityMarketBuy(t *testing.T) {
+	setupTestDB(t)
+	app := newTestApp()
+
+	base, symbol := newTestMarket(t)
+	buyer := newTestUser(t, map[string]float64{"USD": 1000})
+	seller := newTestUser(t, map[string]float64{base: 1})
+
+	status := doRequest(t, app, buyer.ID, http.MethodPost, "/api/orders", fiber.Map{
+		"symbol": symbol, "side": "buy", "type": "market", "quantity": 1, "quote_quantity": 150,
+	}, nil)
+	if status != fiber.StatusBadRequest {
+		t.Errorf("both quantity and quote_quantity: status %d, want %d", status, fiber.StatusBadRequest)
+	}
+
+	status = doRequest(t, app, seller.ID, http.MethodPost, "/api/orders", fiber.Map{
+		"symbol": symbol, "side": "sell", "type": "limit", "price": 100, "quantity": 1,
+	}, nil)
+	if status != fiber.StatusCreated {
+		t.Fatalf("placing sell order: status %d", status)
+	}
+
+	// Spend 150 USD: the book only has 1 for 100, the rest is released
+	var buy models.Order
+	status = doRequest(t, app, buyer.ID, http.MethodPost, "/api/orders", fiber.Map{
+		"symbol": symbol, "side": "buy", "type": "market", "quote_quantity": 150,
+	}, &buy)
+	if status != fiber.StatusCreated {
+		t.Fatalf("placing quote market buy: status %d", status)
+	}
+	orderbook.GlobalOrderBookManager.WaitForSettlement()
+
+	order, err := database.GetOrderByID(context.Background(), buy.ID)
+	if err != nil {
+		t.Fatalf("GetOrderByID: %v", err)
+	}
+	if order.Status != "filled" || order.QuoteQuantity != 150 || order.FilledQuantity != 1 || order.AvgFillPrice != 100 {
+		t.Errorf("buy order status/quote/filled/avg = %s/%v/%v/%v, want filled/150/1/100",
+			order.Status, order.QuoteQuantity, order.FilledQuantity, order.AvgFillPrice)
+	}
+	assertBalance(t, buyer.ID, "USD", 900, 0)
+	assertBalance(t, buyer.ID, base, 0.998, 0) // Less the 20 bps taker fee
+}
+
+func TestProtectedStopBuy(t *testing.T) {
+	setupTestDB(t)
+	app := newTestApp()
+
+	base, symbol := newTestMarket(t)
+	buyer := newTestUser(t, map[string]float64{"USD": 1000})
+	seller := newTestUser(t, map[string]float64{base: 2})
+	taker := newTestUser(t, map[string]float64{"USD": 1000})
+
+	// Without a worst price there is nothing to lock for a stop buy
+	status := doRequest(t, app, buyer.ID, http.MethodPost, "/api/orders", fiber.Map{
+		"symbol": symbol, "side": "buy", "type": "stop", "stop_price": 100, "quantity": 2,
+	}, nil)
+	if status != fiber.StatusNotImplemented {
+		t.Errorf("unprotected stop buy: status %d, want %d", status, fiber.StatusNotImplemented)
+	}
+
+	for _, price := range []float64{100, 105} {
+		status = doRequest(t, app, seller.ID, http.MethodPost, "/api/orders", fiber.Map{
+			"symbol": symbol, "side": "sell", "type": "limit", "price": price, "quantity": 1,
+		}, nil)
+		if status != fiber.StatusCreated {
+			t.Fatalf("placing sell order at %v: status %d", price, status)
+		}
+	}
+
+	// Locks its protection price for the whole quantity while pending
+	var stop models.Order
+	status = doRequest(t, app, buyer.ID, http.MethodPost, "/api/orders", fiber.Map{
+		"symbol": symbol, "side": "buy", "type": "stop", "stop_price": 100, "limit_protection": 110, "quantity": 2,
+	}, &stop)
+	if status != fiber.StatusCreated {
+		t.Fatalf("placing protected stop buy: status %d", status)
+	}
+	assertBalance(t, buyer.ID, "USD", 780, 220)
+
+	// A trade at 100 triggers it: it buys the 1 left at 105, and the rest of its lock is released
+	status = doRequest(t, app, taker.ID, http.MethodPost, "/api/orders", fiber.Map{
+		"symbol": symbol, "side": "buy", "type": "limit", "price": 100, "quantity": 1,
+	}, nil)
+	if status != fiber.StatusCreated {
+		t.Fatalf("placing triggering buy: status %d", status)
+	}
+	orderbook.GlobalOrderBookManager.WaitForSettlement()
+
+	order, err := database.GetOrderByID(context.Background(), stop.ID)
+	if err != nil {
+		t.Fatalf("GetOrderByID: %v", err)
+	}
+	if order.Status != "cancelled" || order.FilledQuantity != 1 || order.AvgFillPrice != 105 {
+		t.Errorf("stop buy status/filled/avg = %s/%v/%v, want cancelled/1/105", order.Status, order.FilledQuantity, order.AvgFillPrice)
+	}
+	assertBalance(t, buyer.ID, "USD", 895, 0)
+	assertBalance(t, buyer.ID, base, 0.998, 0) // Less the 20 bps taker fee
+}
+
+func TestCreateOrderIdempotencyKey(t *testing.T) {
+	setupTestDB(t)
+	app := newTestApp()
+
+	_, symbol := newTestMarket(t)
+	buyer := newTestUser(t, map[string]float64{"USD": 1000})
+	headers := map[string]string{IdempotencyKeyHeader: uuid.NewString()}
+	body := fiber.Map{"symbol": symbol, "side": "buy", "type": "limit", "price": 100, "quantity": 1}
+
+	var first, retry models.Order
+	status := doRequestWithHeaders(t, app, buyer.ID, http.MethodPost, "/api/orders", headers, body, &first)
+	if status != fiber.StatusCreated {
+		t.Fatalf("placing order: status %d", status)
+	}
+	status = doRequestWithHeaders(t, app, buyer.ID, http.MethodPost, "/api/orders", headers, body, &retry)
+	if status != fiber.StatusOK {
+		t.Fatalf("retrying order: status %d, want %d", status, fiber.StatusOK)
+	}
+	if retry.ID != first.ID {
+		t.Errorf("retry returned order %s, want the original %s", retry.ID, first.ID)
+	}
+	// Funds are locked for one order only
+	assertBalance(t, buyer.ID, "USD", 900, 100)
+
+	// The same key for a different order is refused
+	body["quantity"] = 2
+	status = doRequestWithHeaders(t, app, buyer.ID, http.MethodPost, "/api/orders", headers, body, nil)
+	if status != fiber.StatusUnprocessableEntity {
+		t.Errorf("reusing key for another order: status %d, want %d", status, fiber.StatusUnprocessableEntity)
+	}
+	assertBalance(t, buyer.ID, "USD", 900, 100)
+}
+
+func TestGoodTillDateOrderExpires(t *testing.T) {
+	setupTestDB(t)
+	app := newTestApp()
+	ctx := context.Background()
+
+	_, symbol := newTestMarket(t)
+	buyer := newTestUser(t, map[string]float64{"USD": 1000})
+	body := fiber.Map{"symbol": symbol, "side": "buy", "type": "limit", "price": 100, "quantity": 1}
+
+	body["expires_at"] = time.Now().Add(-time.Minute)
+	if status := doRequest(t, app, buyer.ID, http.MethodPost, "/api/orders", body, nil); status != fiber.StatusBadRequest {
+		t.Errorf("placing order expiring in the past: status %d, want %d", status, fiber.StatusBadRequest)
+	}
+
+	expiresAt := time.Now().Add(time.Minute)
+	body["expires_at"] = expiresAt
+	var gtd, other models.Order
+	if status := doRequest(t, app, buyer.ID, http.MethodPost, "/api/orders", body, &gtd); status != fiber.StatusCreated {
+		t.Fatalf("placing good-till-date order: status %d", status)
+	}
+	if status := doRequest(t, app, buyer.ID, http.MethodPost, "/api/orders", body, &other); status != fiber.StatusCreated {
+		t.Fatalf("placing second good-till-date order: status %d", status)
+	}
+	assertBalance(t, buyer.ID, "USD", 800, 200)
+
+	// Nothing has expired yet
+	if n, err := orderbook.GlobalOrderBookManager.ExpireOrders(ctx, time.Now()); err != nil || n != 0 {
+		t.Fatalf("ExpireOrders before expiry = %d, %v, want 0", n, err)
+	}
+
+	// The user gets to one order first, the sweeper must leave it alone
+	if status := doRequest(t, app, buyer.ID, http.MethodDelete, "/api/orders/"+other.ID.String(), nil, nil); status != fiber.StatusOK {
+		t.Fatalf("cancelling order: status %d", status)
+	}
+	if _, err := orderbook.GlobalOrderBookManager.ExpireOrders(ctx, expiresAt); err != nil {
+		t.Fatalf("ExpireOrders: %v", err)
+	}
+
+	order, err := database.GetOrderByID(ctx, gtd.ID)
+	if err != nil {
+		t.Fatalf("GetOrderByID: %v", err)
+	}
+	if order.Status != "cancelled" {
+		t.Errorf("expired order status = %q, want cancelled", order.Status)
+	}
+	if _, _, ok := orderbook.GlobalOrderBookManager.GetOrder(symbol, gtd.ID); ok {
+		t.Error("expired order still on the book")
+	}
+	// Each order's funds are unlocked exactly once
+	assertBalance(t, buyer.ID, "USD", 1000, 0)
+}
+
+func TestCancelOnDisconnectSession(t *testing.T) {
+	setupTestDB(t)
+	app := newTestApp()
+	ctx := context.Background()
+
+	defer func(grace time.Duration) { cancelOnDisconnectGrace = grace }(cancelOnDisconnectGrace)
+	cancelOnDisconnectGrace = 50 * time.Millisecond
+
+	_, symbol := newTestMarket(t)
+	buyer := newTestUser(t, map[string]float64{"USD": 1000})
+	other := newTestUser(t, nil)
+	body := fiber.Map{"symbol": symbol, "side": "buy", "type": "limit", "price": 100, "quantity": 1}
+
+	session := startSession(&ws.Client{}, buyer.ID)
+	sessionHeader := map[string]string{SessionHeader: session.id.String()}
+	if status := doRequestWithHeaders(t, app, other.ID, http.MethodPost, "/api/orders", sessionHeader, body, nil); status != fiber.StatusBadRequest {
+		t.Errorf("placing order under another user's session: status %d, want %d", status, fiber.StatusBadRequest)
+	}
+	var scoped, unscoped models.Order
+	if status := doRequestWithHeaders(t, app, buyer.ID, http.MethodPost, "/api/orders", sessionHeader, body, &scoped); status != fiber.StatusCreated {
+		t.Fatalf("placing session order: status %d", status)
+	}
+	if status := doRequest(t, app, buyer.ID, http.MethodPost, "/api/orders", body, &unscoped); status != fiber.StatusCreated {
+		t.Fatalf("placing order: status %d", status)
+	}
+
+	// A session resumed within the grace period keeps its orders, and only its user can resume it
+	session.detach()
+	if _, err := resumeSession(&ws.Client{}, other.ID, session.id); err != errSessionNotFound {
+		t.Errorf("resuming another user's session: err = %v, want %v", err, errSessionNotFound)
+	}
+	if _, err := resumeSession(&ws.Client{}, buyer.ID, session.id); err != nil {
+		t.Fatalf("resumeSession: %v", err)
+	}
+	if _, err := resumeSession(&ws.Client{}, buyer.ID, session.id); err != errSessionInUse {
+		t.Errorf("resuming a connected session: err = %v, want %v", err, errSessionInUse)
+	}
+	time.Sleep(2 * cancelOnDisconnectGrace)
+	assertBalance(t, buyer.ID, "USD", 800, 200)
+
+	// Once the grace period runs out, only the session's order is cancelled
+	session.detach()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		order, err := database.GetOrderByID(ctx, scoped.ID)
+		if err != nil {
+			t.Fatalf("GetOrderByID: %v", err)
+		}
+		if order.Status == "cancelled" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("session order status = %q, want cancelled", order.Status)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if order, err := database.GetOrderByID(ctx, unscoped.ID); err != nil || order.Status != "open" {
+		t.Errorf("order outside the session: %+v, %v, want open", order, err)
+	}
+	if sessionActive(buyer.ID, session.id) {
+		t.Error("session still active after cancelling its orders")
+	}
+	assertBalance(t, buyer.ID, "USD", 900, 100)
+}
+
+func TestReconcileFixesDriftedLockedBalance(t *testing.T) {
+	setupTestDB(t)
+	app := newTestApp()
+	app.Get("/api/admin/reconcile", Reconcile)
+
+	_, symbol := newTestMarket(t)
+	buyer := newTestUser(t, map[string]float64{"USD": 1000})
+	status := doRequest(t, app, buyer.ID, http.MethodPost, "/api/orders", fiber.Map{
+		"symbol": symbol, "side": "buy", "type": "limit", "price": 100, "quantity": 1,
+	}, nil)
+	if status != fiber.StatusCreated {
+		t.Fatalf("placing buy order: status %d", status)
+	}
+
+	// Simulate a failed unlock elsewhere: 5 USD stuck in locked that no order accounts for
+	_, err := database.DB.Exec(context.Background(),
+		`UPDATE balances SET available = available - 5, locked = locked + 5 WHERE user_id = $1 AND asset = 'USD'`, buyer.ID)
+	if err != nil {
+		t.Fatalf("corrupting balance: %v", err)
+	}
+
+	var report struct {
+		Discrepancies []database.LockedDiscrepancy `json:"discrepancies"`
+	}
+	path := "/api/admin/reconcile?user_id=" + buyer.ID.String()
+	if status := doRequest(t, app, buyer.ID, http.MethodGet, path, nil, &report); status != fiber.StatusOK {
+		t.Fatalf("reconcile: status %d", status)
+	}
+	if len(report.Discrepancies) != 1 || report.Discrepancies[0].Asset != "USD" ||
+		report.Discrepancies[0].Difference != 5 || report.Discrepancies[0].Fixed {
+		t.Fatalf("discrepancies = %+v, want one unfixed USD discrepancy of 5", report.Discrepancies)
+	}
+
+	if status := doRequest(t, app, buyer.ID, http.MethodGet, path+"&fix=true", nil, &report); status != fiber.StatusOK {
+		t.Fatalf("reconcile with fix: status %d", status)
+	}
+	if len(report.Discrepancies) != 1 || !report.Discrepancies[0].Fixed {
+		t.Fatalf("discrepancies = %+v, want the USD one fixed", report.Discrepancies)
+	}
+	balance, err := database.GetBalance(context.Background(), buyer.ID, "USD")
+	if err != nil {
+		t.Fatalf("GetBalance: %v", err)
+	}
+	if balance.Available != 900 || balance.Locked != 100 {
+		t.Errorf("USD balance after fix = %v / %v, want 900 / 100", balance.Available, balance.Locked)
+	}
+
+	if status := doRequest(t, app, buyer.ID, http.MethodGet, path, nil, &report); status != fiber.StatusOK || len(report.Discrepancies) != 0 {
+		t.Errorf("reconcile after fix: status %d, discrepancies %+v, want none", status, report.Discrepancies)
+	}
+}
+
+func TestBuildOrderRejectsUnknownSymbol(t *testing.T) {
+	_, symbol := newTestMarket(t)
+	req := &CreateOrderRequest{Symbol: strings.ToLower(symbol), Side: "buy", Type: "limit", Price: 100, Quantity: 1}
+	if _, err := buildOrder(req, uuid.New()); err != nil && strings.HasPrefix(err.Error(), "Unknown symbol") {
+		t.Fatalf("buildOrder(%s) = %v, want the market found", symbol, err)
+	}
+
+	req = &CreateOrderRequest{Symbol: "NOPE-USD", Side: "buy", Type: "limit", Price: 100, Quantity: 1}
+	if _, err := buildOrder(req, uuid.New()); err == nil || err.Error() != "Unknown symbol NOPE-USD" {
+		t.Fatalf("buildOrder(NOPE-USD) = %v, want unknown symbol", err)
+	}
+}
+
+func TestGetOrderFills(t *testing.T) {
+	setupTestDB(t)
+	app := newTestApp()
+
+	base, symbol := newTestMarket(t)
+	buyer := newTestUser(t, map[string]float64{"USD": 1000})
+	seller := newTestUser(t, map[string]float64{base: 1})
+
+	for _, level := range []struct{ price, quantity float64 }{{99, 0.4}, {100, 0.6}} {
+		status := doRequest(t, app, seller.ID, http.MethodPost, "/api/orders", fiber.Map{
+			"symbol": symbol, "side": "sell", "type": "limit", "price": level.price, "quantity": level.quantity,
+		}, nil)
+		if status != fiber.StatusCreated {
+			t.Fatalf("placing sell order: status %d", status)
+		}
+	}
+	var buy models.Order
+	status := doRequest(t, app, buyer.ID, http.MethodPost, "/api/orders", fiber.Map{
+		"symbol": symbol, "side": "buy", "type": "limit", "price": 100, "quantity": 1,
+	}, &buy)
+	if status != fiber.StatusCreated {
+		t.Fatalf("placing buy order: status %d", status)
+	}
+	orderbook.GlobalOrderBookManager.WaitForSettlement()
+
+	var fills []models.Fill
+	status = doRequest(t, app, buyer.ID, http.MethodGet, "/api/orders/"+buy.ID.String()+"/fills", nil, &fills)
+	if status != fiber.StatusOK {
+		t.Fatalf("getting fills: status %d", status)
+	}
+	if len(fills) != 2 {
+		t.Fatalf("got %d fills, want 2", len(fills))
+	}
+	if fills[0].Price != 99 || fills[0].Quantity != 0.4 || fills[0].CumulativeQuantity != 0.4 || fills[0].Role != "taker" {
+		t.Errorf("first fill = %+v, want taker 0.4 @ 99", fills[0])
+	}
+	if fills[1].Price != 100 || fills[1].CumulativeQuantity != 1 || math.Abs(fills[1].AvgPrice-99.6) > 1e-9 {
+		t.Errorf("second fill = %+v, want 0.6 @ 100 bringing the order to 1 @ 99.6", fills[1])
+	}
+	if fills[1].FeeAsset != base {
+		t.Errorf("fee asset = %q, want %q", fills[1].FeeAsset, base)
+	}
+
+	status = doRequest(t, app, seller.ID, http.MethodGet, "/api/orders/"+buy.ID.String()+"/fills", nil, nil)
+	if status != fiber.StatusForbidden {
+		t.Errorf("another user's fills: status %d, want %d", status, fiber.StatusForbidden)
+	}
+}
+
+func TestBuildOrderDisplayQuantity(t *testing.T) {
+	_, symbol := newTestMarket(t)
+	tests := []struct {
+		req     CreateOrderRequest
+		wantErr bool
+	}{
+		{CreateOrderRequest{Type: "limit", Price: 100, Quantity: 1, DisplayQuantity: 0.25}, false},
+		{CreateOrderRequest{Type: "limit", Price: 100, Quantity: 1, DisplayQuantity: 1}, true},  // Nothing hidden
+		{CreateOrderRequest{Type: "limit", Price: 100, Quantity: 1, DisplayQuantity: -1}, true}, // Negative
+		{CreateOrderRequest{Type: "limit", Price: 100, Quantity: 1, DisplayQuantity: 0.5, TimeInForce: "IOC"}, true},
+		{CreateOrderRequest{Type: "stop_limit", Price: 100, StopPrice: 90, Quantity: 1, DisplayQuantity: 0.5}, true},
+	}
+	for _, tt := range tests {
+		req := tt.req
+		req.Symbol, req.Side = symbol, "sell"
+		order, err := buildOrder(&req, uuid.New())
+		if (err != nil) != tt.wantErr {
+			t.Errorf("buildOrder(%+v) error = %v, want error %v", tt.req, err, tt.wantErr)
+			continue
+		}
+		if err == nil && order.DisplayQuantity != tt.req.DisplayQuantity {
+			t.Errorf("order display quantity = %v, want %v", order.DisplayQuantity, tt.req.DisplayQuantity)
+		}
+	}
+}
+
+func TestBuildOrderLimitProtection(t *testing.T) {
+	_, symbol := newTestMarket(t)
+	tests := []struct {
+		req     CreateOrderRequest
+		wantErr bool
+	}{
+		{CreateOrderRequest{Type: "market", Quantity: 1, LimitProtection: 90}, false},
+		{CreateOrderRequest{Type: "stop", StopPrice: 95, Quantity: 1, LimitProtection: 90}, false},
+		{CreateOrderRequest{Type: "market", Quantity: 1, LimitProtection: -90}, true},
+		{CreateOrderRequest{Type: "limit", Price: 100, Quantity: 1, LimitProtection: 90}, true}, // Has a limit already
+		{CreateOrderRequest{Type: "stop_limit", Price: 100, StopPrice: 95, Quantity: 1, LimitProtection: 90}, true},
+	}
+	for _, tt := range tests {
+		req := tt.req
+		req.Symbol, req.Side, req.TimeInForce = symbol, "sell", "GTC"
+		order, err := buildOrder(&req, uuid.New())
+		if (err != nil) != tt.wantErr {
+			t.Errorf("buildOrder(%+v) error = %v, want error %v", tt.req, err, tt.wantErr)
+			continue
+		}
+		if err == nil && order.ProtectionPrice != tt.req.LimitProtection {
+			t.Errorf("order protection price = %v, want %v", order.ProtectionPrice, tt.req.LimitProtection)
+		}
+	}
+}
+
+func TestBuildOrderRejectsNonFiniteAmounts(t *testing.T) {
+	valid := CreateOrderRequest{Symbol: "BTC-USD", Side: "buy", Type: "limit", Price: 100, Quantity: 1}
+	tests := []struct {
+		name string
+		edit func(req *CreateOrderRequest)
+	}{
+		{"NaN price", func(req *CreateOrderRequest) { req.Price = math.NaN() }},
+		{"infinite quantity", func(req *CreateOrderRequest) { req.Quantity = math.Inf(1) }},
+		{"huge quantity", func(req *CreateOrderRequest) { req.Quantity = 1e300 }},
+		{"negative infinite stop_price", func(req *CreateOrderRequest) { req.StopPrice = math.Inf(-1) }},
+		{"NaN quote_quantity", func(req *CreateOrderRequest) { req.QuoteQuantity = math.NaN() }},
+	}
+	for _, tt := range tests {
+		req := valid
+		tt.edit(&req)
+		_, err := buildOrder(&req, uuid.New())
+		if err == nil || !strings.Contains(err.Error(), "must be a finite number") {
+			t.Errorf("%s: error = %v, want a finite number error", tt.name, err)
+		}
+	}
+}
@@ -1,35 +1,153 @@
 package handlers
 
 import (
+	"errors"
 	"log"
+	"math"
+	"strconv"
 	"strings"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/user/minicoinbase/backend/internal/apierror"
+	"github.com/user/minicoinbase/backend/internal/config"
 	"github.com/user/minicoinbase/backend/internal/orderbook"
 )
 
+const (
+	defaultDepthLimit = 50
+	maxDepthLimit     = 500
+
+	defaultRawBookLimit = 100
+	maxRawBookLimit     = 1000
+)
+
+// rawBookOrderIDs is whether GetRawBook lists order ids, set by InitMarketData.
+var rawBookOrderIDs bool
+
+// InitMarketData applies the market data settings from the configuration.
+func InitMarketData(cfg *config.Config) {
+	rawBookOrderIDs = cfg.RawBookOrderIDs
+}
+
 // GetOrderBookDepth retrieves the aggregated depth for a given symbol.
+// Query params: limit, the number of price levels per side (default 50, max 500).
+// The response's checksum covers the top orderbook.ChecksumLevels levels per side whatever the limit.
 // This endpoint is typically public.
 func GetOrderBookDepth(c *fiber.Ctx) error {
 	symbol := c.Params("symbol")
 	if symbol == "" {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Symbol parameter is required"})
+		return apierror.Send(c, apierror.BadRequest, "Symbol parameter is required")
 	}
 	symbol = strings.ToUpper(symbol)
 
-	// Use the global manager to get the book depth
-	depth, err := orderbook.GlobalOrderBookManager.GetBookDepth(symbol)
+	limit := c.QueryInt("limit", defaultDepthLimit)
+	if limit <= 0 || limit > maxDepthLimit {
+		return apierror.Send(c, apierror.BadRequest, "Invalid limit, must be between 1 and 500")
+	}
+
+	// Use the global manager to get the book depth; an unknown symbol gets no book
+	depth, err := orderbook.GlobalOrderBookManager.GetBookDepth(symbol, limit)
+	if errors.Is(err, orderbook.ErrUnknownSymbol) {
+		return unknownSymbolResponse(c, symbol)
+	}
 	if err != nil {
-		// This error likely means the manager itself failed, not just an empty book
 		log.Printf("Error getting order book depth for symbol %s: %v", symbol, err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve order book depth"})
+		return apierror.Send(c, apierror.Internal, "Failed to retrieve order book depth")
 	}
 
-	if depth == nil {
-		// Should not happen with GetOrCreateBook logic, but handle defensively
-		log.Printf("Nil depth returned for symbol %s", symbol)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve order book depth data"})
+	return c.Status(fiber.StatusOK).JSON(depth)
+}
+
+// GetRawBook retrieves the individual resting orders of a symbol, best price first and in time
+// priority within a price, for clients doing their own aggregation or estimating queue position.
+// Only the price and remaining quantity of each order are shown, plus its id if the order ids
+// are configured to be published; never who placed it.
+// Query params: limit, the number of orders per side (default 100, max 1000).
+// This endpoint is public.
+func GetRawBook(c *fiber.Ctx) error {
+	symbol := strings.ToUpper(c.Params("symbol"))
+	if symbol == "" {
+		return apierror.Send(c, apierror.BadRequest, "Symbol parameter is required")
+	}
+	limit := c.QueryInt("limit", defaultRawBookLimit)
+	if limit <= 0 || limit > maxRawBookLimit {
+		return apierror.Send(c, apierror.BadRequest, "Invalid limit, must be between 1 and 1000")
 	}
 
-	return c.Status(fiber.StatusOK).JSON(depth)
+	book, err := orderbook.GlobalOrderBookManager.GetRawBook(symbol, limit)
+	if err != nil {
+		return unknownSymbolResponse(c, symbol)
+	}
+	if !rawBookOrderIDs {
+		for _, orders := range [][]orderbook.RawOrder{book.Bids, book.Asks} {
+			for i := range orders {
+				orders[i].OrderID = nil
+			}
+		}
+	}
+	return c.JSON(book)
+}
+
+// GetBookTicker returns the best bid and ask of a symbol, with the quantity at each, and the
+// spread between them: the top of the book without the cost of full depth.
+// This endpoint is public.
+func GetBookTicker(c *fiber.Ctx) error {
+	symbol := strings.ToUpper(c.Params("symbol"))
+	if symbol == "" {
+		return apierror.Send(c, apierror.BadRequest, "Symbol parameter is required")
+	}
+	bookTicker, err := orderbook.GlobalOrderBookManager.GetBookTicker(symbol)
+	if err != nil {
+		return unknownSymbolResponse(c, symbol)
+	}
+	return c.JSON(bookTicker)
+}
+
+// GetQuote estimates the execution of a market order against the current book: the average and
+// worst price it would fill at and its total cost (or proceeds, for a sell) before fees.
+// Query params: side ("buy" or "sell") and quantity, in the base asset.
+// If the book can't fill it all, filled_quantity says how much it can. This endpoint is public.
+func GetQuote(c *fiber.Ctx) error {
+	symbol := strings.ToUpper(c.Params("symbol"))
+	if symbol == "" {
+		return apierror.Send(c, apierror.BadRequest, "Symbol parameter is required")
+	}
+	side := strings.ToLower(c.Query("side"))
+	if side != "buy" && side != "sell" {
+		return apierror.Send(c, apierror.BadRequest, "Invalid side, must be 'buy' or 'sell'")
+	}
+	quantity, err := strconv.ParseFloat(c.Query("quantity"), 64)
+	if err != nil || quantity <= 0 || math.IsNaN(quantity) || math.IsInf(quantity, 0) {
+		return apierror.Send(c, apierror.BadRequest, "Invalid quantity, must be a positive number")
+	}
+	quote, err := orderbook.GlobalOrderBookManager.Quote(symbol, side, quantity)
+	if err != nil {
+		return unknownSymbolResponse(c, symbol)
+	}
+	return c.JSON(quote)
+}
+
+// GetDepthUpdates returns the depth updates of a symbol after a sequence number, oldest first,
+// so a depth feed client that reconnects can apply what it missed to the book it has instead
+// of starting over. Query param: since_seq, the seq of the last update (or snapshot) applied.
+// Only recent updates are kept: if some are gone, responds 410 and the client must fetch a
+// new snapshot. This endpoint is public.
+func GetDepthUpdates(c *fiber.Ctx) error {
+	symbol := strings.ToUpper(c.Params("symbol"))
+	if symbol == "" {
+		return apierror.Send(c, apierror.BadRequest, "Symbol parameter is required")
+	}
+	sinceSeq, err := parseSinceSeq(c)
+	if err != nil {
+		return apierror.Send(c, apierror.BadRequest, err.Error())
+	}
+
+	updates, ok, err := orderbook.GlobalOrderBookManager.DepthUpdatesSince(symbol, sinceSeq)
+	if err != nil {
+		return unknownSymbolResponse(c, symbol)
+	}
+	if !ok {
+		return apierror.Send(c, apierror.Gone, "Depth updates since this seq are no longer available, fetch a new snapshot")
+	}
+	return c.JSON(fiber.Map{"symbol": symbol, "updates": updates})
 }
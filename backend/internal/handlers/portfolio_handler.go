@@ -2,26 +2,30 @@ package handlers
 
 import (
 	"log"
+	"strings"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
+	"github.com/user/minicoinbase/backend/internal/apierror"
 	"github.com/user/minicoinbase/backend/internal/database"
+	"github.com/user/minicoinbase/backend/internal/markets"
 	"github.com/user/minicoinbase/backend/internal/models"
-	// TODO: Import ticker package if calculating P&L requires current prices
+	"github.com/user/minicoinbase/backend/internal/portfolio"
+	"github.com/user/minicoinbase/backend/internal/ticker"
 )
 
 // GetPortfolio retrieves the user's current asset balances.
-// TODO: Enhance to calculate P&L based on holdings and current market prices.
+// Cost basis and unrealized P&L are per position, see GetPositions.
 func GetPortfolio(c *fiber.Ctx) error {
 	userID, ok := c.Locals("userID").(uuid.UUID)
 	if !ok {
-		return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid user ID in token"})
+		return apierror.Send(c, apierror.Unauthorized, "Invalid user ID in token")
 	}
 
 	balances, err := database.GetUserBalances(c.Context(), userID)
 	if err != nil {
 		log.Printf("Error fetching balances for user %s: %v", userID, err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "Failed to retrieve portfolio balances"})
+		return apierror.Send(c, apierror.Internal, "Failed to retrieve portfolio balances")
 	}
 
 	// If no balances found, return empty array, not null
@@ -29,14 +33,136 @@ func GetPortfolio(c *fiber.Ctx) error {
 		balances = make([]*models.Balance, 0)
 	}
 
-	// TODO: Calculate portfolio value and P&L
+	// TODO: Calculate portfolio value
 	// 1. Get current market prices (e.g., from ticker.GetCurrentPrices())
 	// 2. Iterate through balances
 	// 3. For each non-quote asset (e.g., BTC, ETH), calculate its value in the quote currency (e.g., USD)
 	//    value = (balance.Available + balance.Locked) * currentPrice[asset+"-USD"]
 	// 4. Sum up values + quote currency balance for total portfolio value.
-	// 5. P&L calculation requires tracking cost basis (more complex, needs trade history or avg cost)
 
 	// For now, just return the raw balances
 	return c.Status(fiber.StatusOK).JSON(balances)
 }
+
+// GetBalance returns the authenticated user's balance of one asset (:asset), for refreshing a
+// single asset without fetching the whole portfolio. An asset no market trades is a bad request;
+// one the user has never held comes back with zero available and locked rather than as not found.
+func GetBalance(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(uuid.UUID)
+	if !ok {
+		return apierror.Send(c, apierror.Unauthorized, "Invalid user ID in token")
+	}
+	asset := strings.ToUpper(c.Params("asset"))
+	if asset == "" {
+		return apierror.Send(c, apierror.BadRequest, "Asset parameter is required")
+	}
+	if !markets.IsAsset(asset) {
+		return apierror.Send(c, apierror.BadRequest, "Unknown asset")
+	}
+
+	balance, err := database.GetBalance(c.Context(), userID, asset)
+	if err != nil {
+		log.Printf("Error fetching %s balance for user %s: %v", asset, userID, err)
+		return apierror.Send(c, apierror.Internal, "Failed to retrieve balance")
+	}
+	if balance == nil {
+		balance = &models.Balance{UserID: userID, Asset: asset}
+	}
+	return c.Status(fiber.StatusOK).JSON(balance)
+}
+
+// AssetHolds is what a user has locked of one asset and the open orders it is locked for.
+type AssetHolds struct {
+	Asset  string      `json:"asset"`
+	Locked float64     `json:"locked"` // The balance's locked amount
+	Orders []OrderHold `json:"orders"`
+}
+
+// OrderHold is the amount an open order has locked, see models.Order.LockedAmount.
+type OrderHold struct {
+	OrderID   uuid.UUID `json:"order_id"`
+	Symbol    string    `json:"symbol"`
+	Side      string    `json:"side"`
+	Type      string    `json:"type"`
+	Price     float64   `json:"price,omitempty"`
+	Remaining float64   `json:"remaining"` // Quantity not yet filled, 0 for quote-denominated orders
+	Amount    float64   `json:"amount"`
+}
+
+// GetBalanceHolds breaks down the authenticated user's locked funds: per asset with anything
+// locked, by asset, the open orders holding it and how much each holds.
+func GetBalanceHolds(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(uuid.UUID)
+	if !ok {
+		return apierror.Send(c, apierror.Unauthorized, "Invalid user ID in token")
+	}
+
+	balances, err := database.GetUserBalances(c.Context(), userID)
+	if err != nil {
+		log.Printf("Error fetching balances for user %s: %v", userID, err)
+		return apierror.Send(c, apierror.Internal, "Failed to retrieve holds")
+	}
+	orders, err := database.GetUserLockingOrders(c.Context(), userID)
+	if err != nil {
+		log.Printf("Error fetching locking orders for user %s: %v", userID, err)
+		return apierror.Send(c, apierror.Internal, "Failed to retrieve holds")
+	}
+
+	holds := make(map[string][]OrderHold)
+	for _, order := range orders {
+		remaining := 0.0
+		if order.QuoteQuantity == 0 {
+			remaining = order.Quantity - order.FilledQuantity
+		}
+		holds[order.LockedAsset] = append(holds[order.LockedAsset], OrderHold{
+			OrderID:   order.ID,
+			Symbol:    order.Symbol,
+			Side:      order.Side,
+			Type:      order.Type,
+			Price:     order.Price,
+			Remaining: remaining,
+			Amount:    order.LockedAmount,
+		})
+	}
+
+	// Balances come by asset; every order's asset has a balance row, as locking created it
+	result := make([]AssetHolds, 0)
+	for _, balance := range balances {
+		if balance.Locked == 0 && len(holds[balance.Asset]) == 0 {
+			continue
+		}
+		assetOrders := holds[balance.Asset]
+		if assetOrders == nil {
+			assetOrders = make([]OrderHold, 0)
+		}
+		result = append(result, AssetHolds{Asset: balance.Asset, Locked: balance.Locked, Orders: assetOrders})
+	}
+	return c.Status(fiber.StatusOK).JSON(result)
+}
+
+// GetPositions returns the user's positions, one per market they hold the base asset of by
+// trading it or have realized P&L in: quantity, average entry price and lifetime realized P&L as
+// settlement keeps them, valued at the market's current price with the unrealized P&L.
+// Positions of markets without a price yet come without the market fields.
+func GetPositions(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(uuid.UUID)
+	if !ok {
+		return apierror.Send(c, apierror.Unauthorized, "Invalid user ID in token")
+	}
+
+	stored, err := database.GetUserPositions(c.Context(), userID)
+	if err != nil {
+		log.Printf("Error fetching positions for user %s: %v", userID, err)
+		return apierror.Send(c, apierror.Internal, "Failed to retrieve positions")
+	}
+
+	positions := make([]*portfolio.Position, 0, len(stored))
+	for _, s := range stored {
+		p := portfolio.FromStored(s)
+		if price, ok := ticker.LastPrice(p.Symbol); ok {
+			p.Mark(price)
+		}
+		positions = append(positions, p)
+	}
+	return c.Status(fiber.StatusOK).JSON(positions)
+}
@@ -0,0 +1,151 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/user/minicoinbase/backend/internal/fees"
+	"github.com/user/minicoinbase/backend/internal/models"
+	"github.com/user/minicoinbase/backend/internal/orderbook"
+	"github.com/user/minicoinbase/backend/internal/portfolio"
+)
+
+func TestGetBalanceOfOneAsset(t *testing.T) {
+	setupTestDB(t)
+	app := newTestApp()
+	app.Get("/api/balances/:asset", GetBalance)
+
+	user := newTestUser(t, map[string]float64{"USD": 250})
+
+	var balance models.Balance
+	if status := doRequest(t, app, user.ID, http.MethodGet, "/api/balances/usd", nil, &balance); status != fiber.StatusOK {
+		t.Fatalf("get USD balance: status %d", status)
+	}
+	if balance.Asset != "USD" || balance.Available != 250 || balance.Locked != 0 {
+		t.Errorf("USD balance = %+v, want 250 available", balance)
+	}
+
+	// Never held: zeroed, not 404
+	base, _ := newTestMarket(t)
+	balance = models.Balance{}
+	if status := doRequest(t, app, user.ID, http.MethodGet, "/api/balances/"+base, nil, &balance); status != fiber.StatusOK {
+		t.Fatalf("get %s balance: status %d", base, status)
+	}
+	if balance.Asset != base || balance.UserID != user.ID || balance.Available != 0 || balance.Locked != 0 {
+		t.Errorf("%s balance = %+v, want zero", base, balance)
+	}
+
+	// Not an asset at all
+	if status := doRequest(t, app, user.ID, http.MethodGet, "/api/balances/XYZ", nil, nil); status != fiber.StatusBadRequest {
+		t.Errorf("get XYZ balance: status %d, want 400", status)
+	}
+}
+
+func TestPositionsTrackRealizedPnL(t *testing.T) {
+	setupTestDB(t)
+	app := newTestApp()
+	app.Get("/api/positions", GetPositions)
+
+	base, symbol := newTestMarket(t)
+	trader := newTestUser(t, map[string]float64{"USD": 1000})
+	seller := newTestUser(t, map[string]float64{base: 1})
+	buyer := newTestUser(t, map[string]float64{"USD": 1000})
+
+	place := func(userID uuid.UUID, side string, price, quantity float64) {
+		t.Helper()
+		status := doRequest(t, app, userID, http.MethodPost, "/api/orders", fiber.Map{
+			"symbol": symbol, "side": side, "type": "limit", "price": price, "quantity": quantity,
+		}, nil)
+		if status != fiber.StatusCreated {
+			t.Fatalf("placing %s order: status %d", side, status)
+		}
+		orderbook.GlobalOrderBookManager.WaitForSettlement()
+	}
+	place(seller.ID, "sell", 100, 1)
+	place(trader.ID, "buy", 100, 1) // Taker: the fee comes out of the 1 bought
+	place(trader.ID, "sell", 120, 0.5)
+	place(buyer.ID, "buy", 120, 0.5) // Fills the trader's sell as maker
+
+	var positions []portfolio.Position
+	if status := doRequest(t, app, trader.ID, http.MethodGet, "/api/positions", nil, &positions); status != fiber.StatusOK {
+		t.Fatalf("get positions: status %d", status)
+	}
+	if len(positions) != 1 || positions[0].Symbol != symbol {
+		t.Fatalf("positions = %+v, want one in %s", positions, symbol)
+	}
+	held := 1 - fees.TakerFee(1)
+	avgCost := 100 / held
+	want := portfolio.Position{
+		Quantity:    held - 0.5,
+		RealizedPnL: 0.5*(120-avgCost) - fees.MakerFee(60),
+	}
+	p := positions[0]
+	if math.Abs(p.Quantity-want.Quantity) > 1e-8 || math.Abs(p.AvgEntryPrice-avgCost) > 1e-6 || math.Abs(p.RealizedPnL-want.RealizedPnL) > 1e-6 {
+		t.Errorf("position = %v @ %v with %v realized, want %v @ %v with %v realized",
+			p.Quantity, p.AvgEntryPrice, p.RealizedPnL, want.Quantity, avgCost, want.RealizedPnL)
+	}
+}
+
+func TestGetBalanceHoldsListsLockingOrders(t *testing.T) {
+	setupTestDB(t)
+	app := newTestApp()
+	app.Get("/api/balances/holds", GetBalanceHolds)
+
+	base, symbol := newTestMarket(t)
+	user := newTestUser(t, map[string]float64{"USD": 1000, base: 2})
+
+	var placed []models.Order
+	for _, order := range []fiber.Map{
+		{"symbol": symbol, "side": "buy", "type": "limit", "price": 10, "quantity": 3},
+		{"symbol": symbol, "side": "buy", "type": "limit", "price": 20, "quantity": 1},
+		{"symbol": symbol, "side": "sell", "type": "limit", "price": 50, "quantity": 1.5},
+	} {
+		var created models.Order
+		if status := doRequest(t, app, user.ID, http.MethodPost, "/api/orders", order, &created); status != fiber.StatusCreated {
+			t.Fatalf("placing %v: status %d", order, status)
+		}
+		placed = append(placed, created)
+	}
+
+	var holds []AssetHolds
+	if status := doRequest(t, app, user.ID, http.MethodGet, "/api/balances/holds", nil, &holds); status != fiber.StatusOK {
+		t.Fatalf("get holds: status %d", status)
+	}
+	amounts := make(map[uuid.UUID]float64)
+	locked := make(map[string]float64)
+	for _, asset := range holds {
+		sum := 0.0
+		for _, hold := range asset.Orders {
+			amounts[hold.OrderID] = hold.Amount
+			sum += hold.Amount
+		}
+		if math.Abs(sum-asset.Locked) > 1e-9 {
+			t.Errorf("%s holds add up to %v, balance has %v locked", asset.Asset, sum, asset.Locked)
+		}
+		locked[asset.Asset] = asset.Locked
+	}
+	if len(holds) != 2 || len(amounts) != 3 {
+		t.Fatalf("holds = %+v, want 3 orders over USD and %s", holds, base)
+	}
+	if locked[base] != 1.5 || amounts[placed[2].ID] != 1.5 {
+		t.Errorf("%s locked = %v, sell holds %v, want 1.5", base, locked[base], amounts[placed[2].ID])
+	}
+	if amounts[placed[0].ID] < 30 || amounts[placed[1].ID] < 20 {
+		t.Errorf("buys hold %v and %v, want at least their notional 30 and 20", amounts[placed[0].ID], amounts[placed[1].ID])
+	}
+
+	// A cancelled order releases its hold and drops out
+	if status := doRequest(t, app, user.ID, http.MethodDelete, "/api/orders/"+placed[2].ID.String(), nil, nil); status != fiber.StatusOK {
+		t.Fatalf("cancel sell: status %d", status)
+	}
+	holds = nil
+	if status := doRequest(t, app, user.ID, http.MethodGet, "/api/balances/holds", nil, &holds); status != fiber.StatusOK {
+		t.Fatalf("get holds: status %d", status)
+	}
+	if len(holds) != 1 || holds[0].Asset != "USD" || len(holds[0].Orders) != 2 {
+		t.Errorf("holds after cancel = %+v, want only the two USD buys", holds)
+	}
+}
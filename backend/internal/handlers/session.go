@@ -0,0 +1,134 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/user/minicoinbase/backend/internal/database"
+	"github.com/user/minicoinbase/backend/internal/logging"
+	ws "github.com/user/minicoinbase/backend/internal/websocket"
+)
+
+// SessionHeader puts an order placed over REST under a WebSocket trading session with
+// cancel-on-disconnect, see startSession.
+const SessionHeader = "X-WS-Session"
+
+// cancelOnDisconnectGrace is how long a trading session's orders survive its connection dropping,
+// giving the client time to reconnect and resume the session. Set by InitWebSocket.
+var cancelOnDisconnectGrace = 10 * time.Second
+
+// Errors of resumeSession.
+var (
+	errSessionNotFound = errors.New("unknown or expired session")
+	errSessionInUse    = errors.New("session is in use by another connection")
+)
+
+// tradingSession is a dead man's switch over a WebSocket connection: orders placed under it
+// (with SessionHeader) are cancelled once the connection has been gone for the grace period.
+type tradingSession struct {
+	id     uuid.UUID
+	userID uuid.UUID
+	client *ws.Client  // Connection holding the session, nil while disconnected
+	timer  *time.Timer // Pending cancellation while disconnected
+}
+
+// sessions holds the live trading sessions by ID, guarded by sessionsMu.
+var (
+	sessionsMu sync.Mutex
+	sessions   = make(map[uuid.UUID]*tradingSession)
+)
+
+// startSession opens a trading session for the client, authenticated as userID.
+func startSession(client *ws.Client, userID uuid.UUID) *tradingSession {
+	s := &tradingSession{id: uuid.New(), userID: userID, client: client}
+	sessionsMu.Lock()
+	sessions[s.id] = s
+	sessionsMu.Unlock()
+	return s
+}
+
+// resumeSession hands a disconnected session of the user back to a new connection, calling off
+// the cancellation of its orders.
+func resumeSession(client *ws.Client, userID, id uuid.UUID) (*tradingSession, error) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	s, ok := sessions[id]
+	if !ok || s.userID != userID {
+		return nil, errSessionNotFound
+	}
+	if s.client != nil {
+		return nil, errSessionInUse
+	}
+	s.timer.Stop()
+	s.timer = nil
+	s.client = client
+	return s, nil
+}
+
+// detach is called when the session's connection goes away: unless the session is resumed within
+// cancelOnDisconnectGrace, it ends and its open orders are cancelled.
+func (s *tradingSession) detach() {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	s.client = nil
+	s.timer = time.AfterFunc(cancelOnDisconnectGrace, func() {
+		sessionsMu.Lock()
+		if s.client != nil || sessions[s.id] != s {
+			sessionsMu.Unlock()
+			return // Resumed just in time
+		}
+		delete(sessions, s.id)
+		sessionsMu.Unlock()
+		s.cancelOrders()
+	})
+}
+
+// sessionActive reports whether id is a live (connected or within its grace period) session of the user.
+// Orders may only be placed under a live session.
+func sessionActive(userID, id uuid.UUID) bool {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	s, ok := sessions[id]
+	return ok && s.userID == userID
+}
+
+// cancelOrders cancels the session's open orders, like CancelAllOrders does for all of a user's.
+// An order placed under the session at the very moment it ends can escape; the session's
+// connection is gone by then, so only a request already in flight can do that.
+func (s *tradingSession) cancelOrders() {
+	ctx := context.Background()
+	logger := slog.With("user_id", s.userID, "session_id", s.id)
+	ctx = logging.WithLogger(ctx, logger)
+
+	tx, err := database.DB.Begin(ctx)
+	if err != nil {
+		logger.Error("Failed to begin transaction cancelling session orders", "err", err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	orderIDs, err := database.GetSessionOrderIDs(ctx, tx, s.userID, s.id)
+	if err == nil {
+		// Take every order row before the first balance change, as settlement does, so the two can't deadlock
+		err = database.LockOrders(ctx, tx, orderIDs...)
+	}
+	if err != nil {
+		logger.Error("Failed to load session orders", "err", err)
+		return
+	}
+	cancelled, failures, err := cancelOrdersInTx(ctx, tx, s.userID, orderIDs)
+	if err != nil {
+		logger.Error("Failed to cancel session orders", "err", err)
+		return
+	}
+	if err := tx.Commit(ctx); err != nil {
+		logger.Log(ctx, logging.LevelCritical, "Failed to commit after removing session orders from books",
+			"orders", len(cancelled), "err", err)
+		return
+	}
+	logger.Info("Cancelled orders of disconnected session", "cancelled", len(cancelled), "failed", len(failures))
+}
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/user/minicoinbase/backend/internal/apierror"
+	"github.com/user/minicoinbase/backend/internal/database"
+	"github.com/user/minicoinbase/backend/internal/logging"
+	"github.com/user/minicoinbase/backend/internal/models"
+)
+
+// statementCSVHeader is the first row of a CSV statement, matching statementCSVRecord.
+var statementCSVHeader = []string{"time", "type", "asset", "amount", "symbol", "side", "price", "quantity", "order_id", "trade_id"}
+
+// GetStatement streams the authenticated user's account statement as a download: every trade,
+// fee, deposit, withdrawal and transfer that changed their balances, oldest first.
+// Query params: format (json (default) or csv), from/to (RFC3339, both optional).
+// Rows are written as they are read from the database. Once streaming has started the status
+// can't change anymore, so a failure part way through shows up as a truncated file (for JSON,
+// one that doesn't parse) and in the server log.
+func GetStatement(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(uuid.UUID)
+	if !ok {
+		return apierror.Send(c, apierror.Unauthorized, "Invalid user ID in token")
+	}
+
+	format := strings.ToLower(c.Query("format", "json"))
+	if format != "json" && format != "csv" {
+		return apierror.Send(c, apierror.BadRequest, "Invalid format, must be 'json' or 'csv'")
+	}
+	var from, to time.Time
+	var err error
+	if value := c.Query("from"); value != "" {
+		if from, err = time.Parse(time.RFC3339, value); err != nil {
+			return apierror.Send(c, apierror.BadRequest, "Invalid from time, expected RFC3339")
+		}
+	}
+	if value := c.Query("to"); value != "" {
+		if to, err = time.Parse(time.RFC3339, value); err != nil {
+			return apierror.Send(c, apierror.BadRequest, "Invalid to time, expected RFC3339")
+		}
+	}
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		return apierror.Send(c, apierror.BadRequest, "from must be before to")
+	}
+
+	// c must not be used from the stream writer, which runs after this handler has returned
+	logger := logging.FromContext(c.Context()).With("user_id", userID, "format", format)
+	c.Attachment(fmt.Sprintf("statement-%s.%s", time.Now().UTC().Format("20060102"), format))
+	if format == "csv" {
+		c.Set(fiber.HeaderContentType, "text/csv; charset=utf-8")
+	}
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		var err error
+		if format == "csv" {
+			err = writeCSVStatement(w, userID, from, to)
+		} else {
+			err = writeJSONStatement(w, userID, from, to)
+		}
+		if err != nil {
+			logger.Error("Failed to stream statement", "err", err)
+		}
+	})
+	return nil
+}
+
+// statementFlushEvery is how many rows are buffered before they are sent to the client.
+const statementFlushEvery = 100
+
+// writeCSVStatement writes a user's statement to w as CSV.
+func writeCSVStatement(w *bufio.Writer, userID uuid.UUID, from, to time.Time) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(statementCSVHeader); err != nil {
+		return err
+	}
+	rows := 0
+	err := database.StreamStatement(context.Background(), userID, from, to, func(entry *models.StatementEntry) error {
+		if err := cw.Write(statementCSVRecord(entry)); err != nil {
+			return err
+		}
+		if rows++; rows%statementFlushEvery == 0 {
+			cw.Flush()
+			return w.Flush()
+		}
+		return nil
+	})
+	cw.Flush()
+	if err != nil {
+		return err
+	}
+	if err := cw.Error(); err != nil {
+		return err
+	}
+	return w.Flush()
+}
+
+// statementCSVRecord formats an entry as a CSV row, see statementCSVHeader.
+func statementCSVRecord(e *models.StatementEntry) []string {
+	formatFloat := func(v float64) string {
+		if v == 0 {
+			return ""
+		}
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	}
+	formatID := func(id *uuid.UUID) string {
+		if id == nil {
+			return ""
+		}
+		return id.String()
+	}
+	return []string{
+		e.Time.UTC().Format(time.RFC3339Nano), e.Type, e.Asset, strconv.FormatFloat(e.Amount, 'f', -1, 64),
+		e.Symbol, e.Side, formatFloat(e.Price), formatFloat(e.Quantity), formatID(e.OrderID), formatID(e.TradeID),
+	}
+}
+
+// writeJSONStatement writes a user's statement to w as {"entries": [...]}.
+func writeJSONStatement(w *bufio.Writer, userID uuid.UUID, from, to time.Time) error {
+	if _, err := w.WriteString(`{"entries":[`); err != nil {
+		return err
+	}
+	rows := 0
+	err := database.StreamStatement(context.Background(), userID, from, to, func(entry *models.StatementEntry) error {
+		if rows > 0 {
+			if err := w.WriteByte(','); err != nil {
+				return err
+			}
+		}
+		data, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		if _, err := w.Write(data); err != nil {
+			return err
+		}
+		if rows++; rows%statementFlushEvery == 0 {
+			return w.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := w.WriteString(`]}`); err != nil {
+		return err
+	}
+	return w.Flush()
+}
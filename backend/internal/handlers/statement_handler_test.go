@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/user/minicoinbase/backend/internal/models"
+	"github.com/user/minicoinbase/backend/internal/orderbook"
+)
+
+func TestStatementListsTradesFeesAndDeposits(t *testing.T) {
+	setupTestDB(t)
+	app := newTestApp()
+	app.Get("/api/account/statement", GetStatement)
+
+	base, symbol := newTestMarket(t)
+	buyer := newTestUser(t, map[string]float64{"USD": 1000})
+	seller := newTestUser(t, map[string]float64{base: 1})
+	for _, o := range []struct {
+		user *models.User
+		side string
+	}{{buyer, "buy"}, {seller, "sell"}} {
+		status := doRequest(t, app, o.user.ID, http.MethodPost, "/api/orders", fiber.Map{
+			"symbol": symbol, "side": o.side, "type": "limit", "price": 100, "quantity": 1,
+		}, nil)
+		if status != fiber.StatusCreated {
+			t.Fatalf("placing %s order: status %d", o.side, status)
+		}
+	}
+	orderbook.GlobalOrderBookManager.WaitForSettlement()
+
+	get := func(query string) (*http.Response, string) {
+		req := httptest.NewRequest(http.MethodGet, "/api/account/statement"+query, nil)
+		req.Header.Set("X-Test-User", buyer.ID.String())
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("GET statement%s: %v", query, err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatalf("reading statement: %v", err)
+		}
+		return resp, string(body)
+	}
+
+	// The buyer's statement: the deposit, USD spent and base received in the fill, the fee on the base
+	resp, body := get("?format=csv")
+	if resp.StatusCode != fiber.StatusOK || !strings.HasPrefix(resp.Header.Get(fiber.HeaderContentDisposition), "attachment") {
+		t.Fatalf("csv statement: status %d, Content-Disposition %q", resp.StatusCode, resp.Header.Get(fiber.HeaderContentDisposition))
+	}
+	records, err := csv.NewReader(strings.NewReader(body)).ReadAll()
+	if err != nil {
+		t.Fatalf("parsing csv: %v", err)
+	}
+	var types []string
+	for _, record := range records[1:] {
+		types = append(types, record[1]+":"+record[2])
+	}
+	if got, want := strings.Join(types, ","), "deposit:USD,fill:USD,fill:"+base+",fee:"+base; got != want {
+		t.Errorf("csv rows = %s, want %s", got, want)
+	}
+
+	_, body = get("?format=json")
+	var statement struct {
+		Entries []models.StatementEntry `json:"entries"`
+	}
+	if err := json.Unmarshal([]byte(body), &statement); err != nil {
+		t.Fatalf("parsing json statement %q: %v", body, err)
+	}
+	if len(statement.Entries) != 4 || statement.Entries[1].Amount != -100 || statement.Entries[1].Symbol != symbol {
+		t.Errorf("json entries = %+v, want 4 with a -100 USD fill on %s second", statement.Entries, symbol)
+	}
+
+	_, body = get("?format=json&from=" + time.Now().Add(time.Hour).UTC().Format(time.RFC3339))
+	if body != `{"entries":[]}` {
+		t.Errorf("statement from the future = %s, want no entries", body)
+	}
+}
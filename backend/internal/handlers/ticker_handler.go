@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/user/minicoinbase/backend/internal/apierror"
+	"github.com/user/minicoinbase/backend/internal/database"
+	"github.com/user/minicoinbase/backend/internal/logging"
+	"github.com/user/minicoinbase/backend/internal/markets"
+	"github.com/user/minicoinbase/backend/internal/models"
+)
+
+// tickerStatsTTL is how long 24h statistics are served from cache before the trades are aggregated again.
+const tickerStatsTTL = 5 * time.Second
+
+// tickerStatsCache holds the last 24h statistics of all symbols. Every symbol is aggregated in
+// one query, so one refresh serves both the single-symbol and the all-symbols endpoint.
+var tickerStatsCache struct {
+	mu        sync.Mutex
+	stats     map[string]*models.TickerStats
+	start     time.Time
+	end       time.Time
+	fetchedAt time.Time
+}
+
+// cachedTickerStats returns the 24h statistics of all traded symbols, refreshing them if older than tickerStatsTTL.
+// The lock is held during the refresh so concurrent requests wait for one query instead of each running it.
+func cachedTickerStats(ctx context.Context) (map[string]*models.TickerStats, time.Time, time.Time, error) {
+	tickerStatsCache.mu.Lock()
+	defer tickerStatsCache.mu.Unlock()
+
+	now := time.Now()
+	if tickerStatsCache.stats == nil || now.Sub(tickerStatsCache.fetchedAt) >= tickerStatsTTL {
+		start := now.Add(-24 * time.Hour)
+		stats, err := database.GetTickerStats(ctx, start, now)
+		if err != nil {
+			return nil, time.Time{}, time.Time{}, err
+		}
+		tickerStatsCache.stats, tickerStatsCache.start, tickerStatsCache.end = stats, start, now
+		tickerStatsCache.fetchedAt = now
+	}
+	return tickerStatsCache.stats, tickerStatsCache.start, tickerStatsCache.end, nil
+}
+
+// tickerStatsFor returns the statistics of a symbol, all zero if it had no trades in the window.
+func tickerStatsFor(stats map[string]*models.TickerStats, symbol string, start, end time.Time) *models.TickerStats {
+	if s, ok := stats[symbol]; ok {
+		return s
+	}
+	return &models.TickerStats{Symbol: symbol, WindowStart: start, WindowEnd: end}
+}
+
+// GetTicker24h returns open, high, low, last, price change and volume of a symbol over the
+// last 24 hours. Results may be up to tickerStatsTTL old. This endpoint is public.
+func GetTicker24h(c *fiber.Ctx) error {
+	symbol := strings.ToUpper(c.Params("symbol"))
+	if symbol == "" {
+		return apierror.Send(c, apierror.BadRequest, "Symbol parameter is required")
+	}
+
+	stats, start, end, err := cachedTickerStats(c.Context())
+	if err != nil {
+		logging.FromContext(c.Context()).Error("Failed to get 24h ticker stats", "symbol", symbol, "err", err)
+		return apierror.Send(c, apierror.Internal, "Failed to retrieve ticker statistics")
+	}
+	return c.JSON(tickerStatsFor(stats, symbol, start, end))
+}
+
+// GetAllTickers24h returns the 24 hour statistics of every market, plus any other symbol that
+// traded in the window, sorted by symbol. This endpoint is public.
+func GetAllTickers24h(c *fiber.Ctx) error {
+	stats, start, end, err := cachedTickerStats(c.Context())
+	if err != nil {
+		logging.FromContext(c.Context()).Error("Failed to get 24h ticker stats", "err", err)
+		return apierror.Send(c, apierror.Internal, "Failed to retrieve ticker statistics")
+	}
+
+	symbols := make(map[string]bool, len(stats))
+	for symbol := range stats {
+		symbols[symbol] = true
+	}
+	for _, m := range markets.All() {
+		symbols[m.Symbol] = true
+	}
+	tickers := make([]*models.TickerStats, 0, len(symbols))
+	for symbol := range symbols {
+		tickers = append(tickers, tickerStatsFor(stats, symbol, start, end))
+	}
+	sort.Slice(tickers, func(i, j int) bool { return tickers[i].Symbol < tickers[j].Symbol })
+	return c.JSON(fiber.Map{"tickers": tickers})
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"errors"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/user/minicoinbase/backend/internal/apierror"
+	"github.com/user/minicoinbase/backend/internal/database"
+	"github.com/user/minicoinbase/backend/internal/logging"
+	"github.com/user/minicoinbase/backend/internal/orderbook"
+)
+
+const (
+	defaultTradesLimit = 50
+	maxTradesLimit     = 500
+)
+
+// GetTrades retrieves the authenticated user's executed trades, newest first.
+// Query params: symbol, side, from/to (RFC3339), limit (default 50, max 500), offset.
+func GetTrades(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(uuid.UUID)
+	if !ok {
+		return apierror.Send(c, apierror.Unauthorized, "Invalid user ID in token")
+	}
+
+	filter := database.TradeFilter{
+		Symbol: strings.ToUpper(strings.TrimSpace(c.Query("symbol"))),
+		Side:   strings.ToLower(strings.TrimSpace(c.Query("side"))),
+		Limit:  c.QueryInt("limit", defaultTradesLimit),
+		Offset: c.QueryInt("offset", 0),
+	}
+
+	if filter.Side != "" && filter.Side != "buy" && filter.Side != "sell" {
+		return apierror.Send(c, apierror.BadRequest, "Invalid side, must be 'buy' or 'sell'")
+	}
+	if filter.Limit <= 0 || filter.Limit > maxTradesLimit {
+		return apierror.Send(c, apierror.BadRequest, "Invalid limit, must be between 1 and 500")
+	}
+	if filter.Offset < 0 {
+		return apierror.Send(c, apierror.BadRequest, "Invalid offset, must not be negative")
+	}
+
+	var err error
+	if from := c.Query("from"); from != "" {
+		if filter.From, err = time.Parse(time.RFC3339, from); err != nil {
+			return apierror.Send(c, apierror.BadRequest, "Invalid from time, expected RFC3339")
+		}
+	}
+	if to := c.Query("to"); to != "" {
+		if filter.To, err = time.Parse(time.RFC3339, to); err != nil {
+			return apierror.Send(c, apierror.BadRequest, "Invalid to time, expected RFC3339")
+		}
+	}
+
+	trades, err := database.GetUserTrades(c.Context(), userID, filter)
+	if err != nil {
+		log.Printf("Error fetching trades for user %s: %v", userID, err)
+		return apierror.Send(c, apierror.Internal, "Failed to retrieve trades")
+	}
+
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"trades": trades,
+		"limit":  filter.Limit,
+		"offset": filter.Offset,
+	})
+}
+
+// GetSymbolTrades returns the public trades of a symbol after a trade sequence number, oldest
+// first, in the same format as the trade feed: a client that reconnects fetches what it missed
+// since the last seq it saw, then resumes the stream, skipping trades it already has.
+// Query params: since_seq (default 0), limit (default 50, max 500); page by passing the last seq
+// returned as since_seq. This endpoint is public.
+func GetSymbolTrades(c *fiber.Ctx) error {
+	symbol := strings.ToUpper(c.Params("symbol"))
+	if symbol == "" {
+		return apierror.Send(c, apierror.BadRequest, "Symbol parameter is required")
+	}
+	sinceSeq, err := parseSinceSeq(c)
+	if err != nil {
+		return apierror.Send(c, apierror.BadRequest, err.Error())
+	}
+	limit := c.QueryInt("limit", defaultTradesLimit)
+	if limit <= 0 || limit > maxTradesLimit {
+		return apierror.Send(c, apierror.BadRequest, "Invalid limit, must be between 1 and 500")
+	}
+
+	trades, err := orderbook.GlobalOrderBookManager.TradesSince(c.Context(), symbol, sinceSeq, limit)
+	if errors.Is(err, orderbook.ErrUnknownSymbol) {
+		return unknownSymbolResponse(c, symbol)
+	}
+	if err != nil {
+		logging.FromContext(c.Context()).Error("Failed to get trades since seq", "symbol", symbol, "since_seq", sinceSeq, "err", err)
+		return apierror.Send(c, apierror.Internal, "Failed to retrieve trades")
+	}
+	return c.JSON(fiber.Map{"symbol": symbol, "trades": trades})
+}
+
+// parseSinceSeq reads the since_seq query parameter, 0 if absent.
+func parseSinceSeq(c *fiber.Ctx) (uint64, error) {
+	value := c.Query("since_seq")
+	if value == "" {
+		return 0, nil
+	}
+	seq, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, errors.New("Invalid since_seq, must be a non-negative integer")
+	}
+	return seq, nil
+}
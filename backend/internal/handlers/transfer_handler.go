@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/user/minicoinbase/backend/internal/apierror"
+	"github.com/user/minicoinbase/backend/internal/auth"
+	"github.com/user/minicoinbase/backend/internal/database"
+	"github.com/user/minicoinbase/backend/internal/logging"
+	"github.com/user/minicoinbase/backend/internal/markets"
+	"github.com/user/minicoinbase/backend/internal/models"
+)
+
+// CreateTransferRequest defines the expected JSON body for sending funds to another user.
+type CreateTransferRequest struct {
+	Recipient string  `json:"recipient"` // Username or user ID of the recipient
+	Asset     string  `json:"asset"`     // e.g., "BTC"
+	Amount    float64 `json:"amount"`
+}
+
+// CreateTransfer sends available funds of the authenticated user to another user: the sender's
+// available balance goes down and the recipient's up by the amount, atomically, and both sides
+// are written to the ledger. Locked funds can't be sent. Routed behind middleware.SessionOnly:
+// API keys can't send funds away.
+func CreateTransfer(c *fiber.Ctx) error {
+	userID, ok := c.Locals("userID").(uuid.UUID)
+	if !ok {
+		return apierror.Send(c, apierror.Unauthorized, "Invalid user ID in token")
+	}
+
+	req, err := validateAndBind[CreateTransferRequest](c)
+	if err != nil {
+		return apierror.Send(c, apierror.BadRequest, err.Error())
+	}
+	req.Recipient = strings.TrimSpace(req.Recipient)
+	req.Asset = strings.ToUpper(strings.TrimSpace(req.Asset))
+	if req.Recipient == "" || req.Asset == "" {
+		return apierror.Send(c, apierror.BadRequest, "Recipient and asset are required")
+	}
+	if err := checkAmount("amount", req.Amount); err != nil {
+		return apierror.Send(c, apierror.BadRequest, err.Error())
+	}
+	if req.Amount <= 0 {
+		return apierror.Send(c, apierror.BadRequest, "Amount must be positive")
+	}
+	if !markets.IsAsset(req.Asset) {
+		return apierror.Send(c, apierror.BadRequest, "Unknown asset "+req.Asset)
+	}
+
+	logger := logging.FromContext(c.Context()).With("user_id", userID)
+	ctx, cancel := database.WithTimeout(c.Context())
+	defer cancel()
+
+	// A recipient that parses as a user ID is looked up as one, anything else as a username
+	var recipient *models.User
+	if id, err := uuid.Parse(req.Recipient); err == nil {
+		recipient, err = database.GetUserByID(ctx, id)
+		if err != nil {
+			logger.Error("Failed to look up transfer recipient", "recipient", req.Recipient, "err", err)
+			return apierror.Send(c, apierror.Internal, "Database error looking up recipient")
+		}
+	} else {
+		recipient, err = database.GetUserByUsername(ctx, auth.NormalizeUsername(req.Recipient))
+		if err != nil {
+			logger.Error("Failed to look up transfer recipient", "recipient", req.Recipient, "err", err)
+			return apierror.Send(c, apierror.Internal, "Database error looking up recipient")
+		}
+	}
+	if recipient == nil {
+		return apierror.Send(c, apierror.NotFound, "Recipient not found")
+	}
+	if recipient.ID == userID {
+		return apierror.Send(c, apierror.BadRequest, "Cannot transfer to yourself")
+	}
+
+	transfer := &models.Transfer{SenderID: userID, RecipientID: recipient.ID, Asset: req.Asset, Amount: req.Amount}
+	if err := database.TransferFunds(ctx, transfer); err != nil {
+		if errors.Is(err, database.ErrInsufficientFunds) {
+			return apierror.Send(c, apierror.InsufficientFunds, "Insufficient "+req.Asset+" balance to transfer")
+		}
+		logger.Error("Failed to transfer funds", "recipient_id", recipient.ID, "asset", req.Asset, "amount", req.Amount, "err", err)
+		if database.IsTimeout(err) {
+			return apierror.Send(c, apierror.Timeout, "Database timed out, the transfer was not made, please retry")
+		}
+		return apierror.Send(c, apierror.Internal, "Failed to transfer funds")
+	}
+
+	logger.Info("Funds transferred", "transfer_id", transfer.ID, "recipient_id", recipient.ID,
+		"asset", transfer.Asset, "amount", transfer.Amount)
+	return c.Status(fiber.StatusCreated).JSON(transfer)
+}
@@ -0,0 +1,56 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/user/minicoinbase/backend/internal/models"
+)
+
+func TestTransferMovesAvailableFunds(t *testing.T) {
+	setupTestDB(t)
+	app := newTestApp()
+	app.Post("/api/transfers", CreateTransfer)
+
+	base, _ := newTestMarket(t)
+	sender := newTestUser(t, map[string]float64{base: 3})
+	recipient := newTestUser(t, nil)
+
+	// By username, then by user ID
+	var transfer models.Transfer
+	status := doRequest(t, app, sender.ID, http.MethodPost, "/api/transfers",
+		fiber.Map{"recipient": recipient.Username, "asset": base, "amount": 1}, &transfer)
+	if status != fiber.StatusCreated {
+		t.Fatalf("transfer by username: status %d", status)
+	}
+	if transfer.SenderID != sender.ID || transfer.RecipientID != recipient.ID || transfer.Amount != 1 {
+		t.Errorf("transfer = %+v, want 1 %s from %s to %s", transfer, base, sender.ID, recipient.ID)
+	}
+	status = doRequest(t, app, sender.ID, http.MethodPost, "/api/transfers",
+		fiber.Map{"recipient": recipient.ID.String(), "asset": base, "amount": 0.5}, nil)
+	if status != fiber.StatusCreated {
+		t.Fatalf("transfer by user ID: status %d", status)
+	}
+	assertBalance(t, sender.ID, base, 1.5, 0)
+	assertBalance(t, recipient.ID, base, 1.5, 0)
+
+	rejected := []struct {
+		name string
+		body fiber.Map
+		want int
+	}{
+		{"more than available", fiber.Map{"recipient": recipient.Username, "asset": base, "amount": 2}, fiber.StatusBadRequest},
+		{"non-positive amount", fiber.Map{"recipient": recipient.Username, "asset": base, "amount": 0}, fiber.StatusBadRequest},
+		{"to themselves", fiber.Map{"recipient": sender.Username, "asset": base, "amount": 1}, fiber.StatusBadRequest},
+		{"unknown asset", fiber.Map{"recipient": recipient.Username, "asset": "NOPE", "amount": 1}, fiber.StatusBadRequest},
+		{"unknown recipient", fiber.Map{"recipient": "nobody_" + base, "asset": base, "amount": 1}, fiber.StatusNotFound},
+	}
+	for _, tt := range rejected {
+		if status := doRequest(t, app, sender.ID, http.MethodPost, "/api/transfers", tt.body, nil); status != tt.want {
+			t.Errorf("transfer %s: status %d, want %d", tt.name, status, tt.want)
+		}
+	}
+	assertBalance(t, sender.ID, base, 1.5, 0)
+	assertBalance(t, recipient.ID, base, 1.5, 0)
+}
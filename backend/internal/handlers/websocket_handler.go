@@ -1,76 +1,313 @@
 package handlers
 
 import (
+	"context"
+	"encoding/json"
 	"log"
+	"strings"
+	"time"
 
 	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/user/minicoinbase/backend/internal/auth"
+	"github.com/user/minicoinbase/backend/internal/config"
+	"github.com/user/minicoinbase/backend/internal/orderbook"
 	ws "github.com/user/minicoinbase/backend/internal/websocket" // Alias websocket package
 )
 
+// WebSocket connection timing and limits.
+const (
+	writeWait      = 10 * time.Second    // Time allowed to write a message to the client
+	pongWait       = 60 * time.Second    // Time allowed to read the next pong from the client
+	pingPeriod     = (pongWait * 9) / 10 // Send pings at this interval, must be less than pongWait
+	maxMessageSize = 2048                // Maximum size of a message read from the client, room for an auth token
+	authTimeout    = 5 * time.Second     // Time a client of a private feed has to authenticate
+
+	maxPendingPongs = 8 // Pongs waiting to be written; pings beyond that go unanswered
+)
+
+// Depth feed snapshot mode, see handleSubscribe.
+const (
+	depthSnapshotLevels          = 20          // Levels per side in each periodic snapshot
+	defaultDepthSnapshotInterval = time.Second // When the client doesn't ask for an interval
+	minDepthSnapshotInterval     = 500 * time.Millisecond
+	maxDepthSnapshotInterval     = time.Minute
+)
+
+// flushInterval is how long a client's write pump collects messages before writing them as one frame
+// holding a JSON array of them, set by InitWebSocket. 0 writes every message as soon as it arrives,
+// in a frame of its own. Snapshots sent on connect are always a frame of their own.
+var flushInterval = 100 * time.Millisecond
+
+// InitWebSocket configures the WebSocket feeds. Call once at startup.
+func InitWebSocket(cfg *config.Config) {
+	flushInterval = cfg.WSFlushInterval
+	cancelOnDisconnectGrace = cfg.WSCancelOnDisconnectGrace
+}
+
 // PriceWSEndpoint is the handler for the WebSocket price feed.
 func PriceWSEndpoint(c *websocket.Conn) {
-	// c.Locals is fiber.Ctx specific, Conn doesn't have direct access.
-	// If you need authentication for WS, it needs to be handled differently,
-	// often via a token passed in the connection URL or an initial message.
-	// For now, we assume public access to the price feed.
+	// Public: no authentication needed (see UserWSEndpoint for the private feed)
+	serveClient(c, ws.ChannelPrices, "", nil)
+}
+
+// TradeWSEndpoint is the handler for the public trade feed of one symbol (/ws/trades/:symbol).
+// Every executed trade on that symbol is pushed as it happens, with a per-symbol seq;
+// trades missed while disconnected can be fetched from GET /api/trades/:symbol?since_seq=.
+func TradeWSEndpoint(c *websocket.Conn) {
+	symbol := strings.ToUpper(c.Params("symbol"))
+	serveClient(c, ws.ChannelTrades, symbol, nil)
+}
+
+// DepthWSEndpoint is the handler for the order book depth feed of one symbol (/ws/depth/:symbol).
+// The client first receives a full snapshot, then incremental level updates.
+// Updates with a seq at or below the snapshot's seq are already included in it and can be skipped;
+// a gap in seq after that means updates were dropped: the client can fetch them from
+// GET /api/book/:symbol/updates?since_seq=, or reconnect to re-snapshot if that answers 410.
+// Updates merged by batching carry first_seq, the seq of the first update they cover.
+// Snapshots and updates carry the checksum of the book's top levels as of their seq (see
+// orderbook.OrderBook.Checksum for how to compute it): a local copy that doesn't match is out of sync.
+//
+// Clients that would rather have periodic snapshots than updates (e.g. on poor networks) send
+// {"action":"subscribe","channel":"depth","symbol":"BTC-USD","mode":"snapshot","interval_ms":1000}
+// and from then on receive a snapshot of the top levels every interval and no updates;
+// "mode":"diff" switches back, see handleSubscribe.
+func DepthWSEndpoint(c *websocket.Conn) {
+	symbol := strings.ToUpper(c.Params("symbol"))
+	serveClient(c, ws.ChannelDepth, symbol, func() (interface{}, error) {
+		// The full book: updates may touch any level, so a truncated snapshot would go stale
+		return depthSnapshot(symbol, 0)
+	})
+}
+
+// depthSnapshot returns the depth feed snapshot message of a book, with up to maxLevels
+// levels per side (0 for all).
+func depthSnapshot(symbol string, maxLevels int) (fiber.Map, error) {
+	depth, err := orderbook.GlobalOrderBookManager.GetBookDepth(symbol, maxLevels)
+	if err != nil {
+		return nil, err
+	}
+	return fiber.Map{
+		"type":     "snapshot",
+		"symbol":   depth.Symbol,
+		"seq":      depth.Seq,
+		"bids":     depth.Bids,
+		"asks":     depth.Asks,
+		"checksum": depth.Checksum, // Of the top levels whatever maxLevels is, see orderbook.OrderBook.Checksum
+	}, nil
+}
+
+// UserWSEndpoint is the handler for the private feed of the authenticated user (/ws/user).
+// The client authenticates by sending {"action":"auth","token":"<access token>"} as its first
+// message, which keeps the token out of the URL (and so out of access logs). It then receives
+// a "fill" message for each settled fill of its own orders. A connection that hasn't
+// authenticated within authTimeout is closed with a policy violation.
+func UserWSEndpoint(c *websocket.Conn) {
+	serveClient(c, ws.ChannelUser, "", nil)
+}
 
+// serveClient registers the connection with the hub for the given feed and pumps messages
+// until the client disconnects. If snapshot is set, its result is written to the client
+// right after registering, before any queued feed message.
+func serveClient(c *websocket.Conn, channel, symbol string, snapshot func() (interface{}, error)) {
 	client := &ws.Client{
-		Conn: c,
-		Send: make(chan []byte, 256), // Buffered channel for outgoing messages to this client
+		Conn:    c,
+		Send:    make(chan ws.Message, 256), // Buffered channel for outgoing messages to this client
+		Pongs:   make(chan []byte, maxPendingPongs),
+		Channel: channel,
+		Symbol:  symbol,
 	}
 
 	// Register the client with the hub
-	ws.GlobalHub.Register <- client
+	if !ws.GlobalHub.RegisterClient(client) {
+		// Shutting down, turn the client away
+		c.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down"))
+		return
+	}
+
+	log.Printf("WebSocket connection established: %s (%s %s)", c.RemoteAddr(), channel, symbol)
 
-	// Allow collection of memory referenced by the caller by doing all work in new goroutines.
+	if snapshot != nil {
+		// Taken after registering so no update is missed; the write pump isn't running yet,
+		// so the snapshot is guaranteed to be the first message
+		msg, err := snapshot()
+		if err == nil {
+			c.SetWriteDeadline(time.Now().Add(writeWait))
+			err = c.WriteJSON(msg)
+		}
+		if err != nil {
+			log.Printf("Error sending snapshot to %s: %v", c.RemoteAddr(), err)
+			ws.GlobalHub.UnregisterClient(client)
+			return
+		}
+	}
 
 	// Goroutine to handle writing messages from the hub to the client
 	go clientWritePump(client)
 
-	// Goroutine to handle reading messages from the client (e.g., ping/pong, subscriptions)
-	go clientReadPump(client)
-
-	log.Printf("WebSocket connection established: %s", c.RemoteAddr())
-	// The handler function returns here, but the goroutines keep running.
+	// Read on this goroutine: the connection is closed as soon as the handler returns,
+	// so we must block here until the client goes away.
+	clientReadPump(client)
 }
 
-// clientWritePump pumps messages from the hub to the websocket connection.
+// clientWritePump pumps messages from the hub to the websocket connection,
+// pinging the client every pingPeriod so a dead connection is noticed by clientReadPump.
+// With a flush interval, the messages arriving within it are coalesced (see ws.Batch) and
+// written as one frame holding a JSON array; without, each message is a frame of its own.
 func clientWritePump(client *ws.Client) {
+	ticker := time.NewTicker(pingPeriod)
+	var batch *ws.Batch
+	var flushTimer *time.Timer
+	var flush <-chan time.Time // Fires when the pending batch is due, nil while there is none
+	if flushInterval > 0 {
+		batch = ws.NewBatch()
+		flushTimer = time.NewTimer(flushInterval)
+		flushTimer.Stop()
+	}
 	defer func() {
+		ticker.Stop()
+		if flushTimer != nil {
+			flushTimer.Stop()
+		}
 		// Ensure connection is closed on exit
 		client.Conn.Close()
 		log.Printf("Write pump stopped for %s", client.Conn.RemoteAddr())
 	}()
 
-	for message := range client.Send {
-		if err := client.Conn.WriteMessage(websocket.TextMessage, message); err != nil {
+	// write sends one frame, unregistering the client if that fails
+	write := func(data []byte) bool {
+		client.Conn.SetWriteDeadline(time.Now().Add(writeWait))
+		if err := client.Conn.WriteMessage(websocket.TextMessage, data); err != nil {
 			log.Printf("Error writing message to %s: %v", client.Conn.RemoteAddr(), err)
 			// If write fails, assume client disconnected
-			ws.GlobalHub.Unregister <- client
-			return
+			ws.GlobalHub.UnregisterClient(client)
+			return false
+		}
+		return true
+	}
+	// writeBatch sends the pending batch, if any, as one frame
+	writeBatch := func() bool {
+		flush = nil
+		if batch == nil || batch.Len() == 0 {
+			return true
+		}
+		data, err := batch.Flush()
+		if err != nil {
+			log.Printf("Error encoding messages for %s: %v", client.Conn.RemoteAddr(), err)
+			return true
+		}
+		return write(data)
+	}
+
+	for {
+		select {
+		case message, ok := <-client.Send:
+			if !ok {
+				// client.Send was closed by the hub (e.g., on shutdown or for being too slow): deliver what is
+				// pending, then say goodbye with a close frame saying why, so the client can tell being
+				// disconnected from the connection just dropping and knows to reconnect.
+				writeBatch()
+				closeMsg := websocket.FormatCloseMessage(client.CloseReason())
+				if err := client.Conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(writeWait)); err != nil {
+					log.Printf("Error sending close frame to %s: %v", client.Conn.RemoteAddr(), err)
+				}
+				return
+			}
+			if batch == nil {
+				if !write(message.Data) {
+					return
+				}
+				continue
+			}
+			batch.Add(message)
+			if flush == nil {
+				flushTimer.Reset(flushInterval)
+				flush = flushTimer.C
+			}
+
+		case data := <-client.Pongs:
+			// Ahead of any pending batch: the client is timing the round trip
+			if !write(data) {
+				return
+			}
+
+		case <-flush:
+			if !writeBatch() {
+				return
+			}
+
+		case <-ticker.C:
+			if err := client.Conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeWait)); err != nil {
+				log.Printf("Error sending ping to %s: %v", client.Conn.RemoteAddr(), err)
+				ws.GlobalHub.UnregisterClient(client)
+				return
+			}
 		}
 	}
-	// If client.Send channel is closed by the hub, this loop terminates
 }
 
-// clientReadPump pumps messages from the websocket connection to the hub (or handles them).
-// Currently, it just handles disconnects and ping/pong.
+// clientMessage is a message sent by a client, e.g. {"action":"auth","token":"..."}.
+type clientMessage struct {
+	Action string `json:"action"`
+	Token  string `json:"token"` // auth
+
+	// auth: open a trading session with cancel-on-disconnect, or resume one by ID
+	CancelOnDisconnect bool   `json:"cancel_on_disconnect"`
+	SessionID          string `json:"session_id"`
+
+	// subscribe
+	Channel    string `json:"channel"`
+	Symbol     string `json:"symbol"`
+	Mode       string `json:"mode"`        // "diff" (default) or "snapshot"
+	IntervalMS int    `json:"interval_ms"` // Snapshot mode only
+
+	// ping: echoed back as is in the pong
+	ID json.RawMessage `json:"id"`
+}
+
+// clientReadPump reads and handles the client's messages, closing the connection if no pong
+// arrives within pongWait. Clients of the private user feed must authenticate within authTimeout;
+// clients of the public feeds may authenticate too, but don't have to.
 func clientReadPump(client *ws.Client) {
 	defer func() {
 		// When this function exits (e.g., client disconnects), unregister the client
-		ws.GlobalHub.Unregister <- client
+		ws.GlobalHub.UnregisterClient(client)
 		client.Conn.Close()
 		log.Printf("Read pump stopped for %s", client.Conn.RemoteAddr())
 	}()
 
-	// Configure connection properties (optional)
-	// client.Conn.SetReadLimit(maxMessageSize)
-	// client.Conn.SetReadDeadline(time.Now().Add(pongWait))
-	// client.Conn.SetPongHandler(func(string) error { client.Conn.SetReadDeadline(time.Now().Add(pongWait)); return nil })
+	if client.Channel == ws.ChannelUser {
+		authTimer := time.AfterFunc(authTimeout, func() {
+			if client.UserID() == uuid.Nil {
+				closeClient(client, websocket.ClosePolicyViolation, "authentication timeout")
+			}
+		})
+		defer authTimer.Stop()
+	}
+
+	// The client's trading session, if it opened or resumed one, see handleAuth
+	var session *tradingSession
+	defer func() {
+		if session != nil {
+			session.detach()
+		}
+	}()
+
+	// Stops the client's depth snapshots, if it asked for them, see handleSubscribe
+	stopSnapshots := func() {}
+	defer func() { stopSnapshots() }()
+
+	// A client that stops answering pings hits the read deadline, which ends this loop
+	client.Conn.SetReadLimit(maxMessageSize)
+	client.Conn.SetReadDeadline(time.Now().Add(pongWait))
+	client.Conn.SetPongHandler(func(string) error {
+		return client.Conn.SetReadDeadline(time.Now().Add(pongWait))
+	})
 
 	for {
 		// ReadMessage blocks until a message is received or an error occurs
-		messageType, message, err := client.Conn.ReadMessage()
+		_, message, err := client.Conn.ReadMessage()
 		if err != nil {
 			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseAbnormalClosure) {
 				log.Printf("Client disconnected unexpectedly %s: %v", client.Conn.RemoteAddr(), err)
@@ -80,9 +317,200 @@ func clientReadPump(client *ws.Client) {
 			break // Exit loop on error
 		}
 
-		// Process received message (optional)
-		// Currently, we don't expect messages from the client for the price feed,
-		// but you could handle subscription messages here.
-		log.Printf("Received message type %d from %s: %s", messageType, client.Conn.RemoteAddr(), message)
+		var msg clientMessage
+		if err := json.Unmarshal(message, &msg); err != nil {
+			sendToClient(client, fiber.Map{"type": "error", "error": "Invalid message"})
+			continue
+		}
+		switch msg.Action {
+		case "auth":
+			if s := handleAuth(client, msg); s != nil {
+				session = s
+			}
+		case "subscribe":
+			handleSubscribe(client, msg, &stopSnapshots)
+		case "ping":
+			handlePing(client, msg)
+		default:
+			sendToClient(client, fiber.Map{"type": "error", "error": "Unknown action"})
+		}
+	}
+}
+
+// handlePing answers an application-level ping, {"action":"ping","id":123}, with
+// {"type":"pong","id":123,"server_time":<unix ms>} so the client can measure its round-trip latency.
+// Unlike feed messages the pong doesn't go through the hub and isn't held back for batching: the
+// write pump writes it next. A client with maxPendingPongs pongs still unwritten gets no more.
+func handlePing(client *ws.Client, msg clientMessage) {
+	pong := fiber.Map{"type": "pong", "server_time": time.Now().UnixMilli()}
+	if len(msg.ID) > 0 {
+		pong["id"] = msg.ID
+	}
+	data, err := json.Marshal(pong)
+	if err != nil {
+		log.Printf("Error marshalling pong to %s: %v", client.Conn.RemoteAddr(), err)
+		return
+	}
+	select {
+	case client.Pongs <- data:
+	default:
+	}
+}
+
+// handleAuth authenticates the client with an access token, as the Protected middleware would,
+// and tells it the outcome. A client can't switch users once authenticated.
+// With cancel_on_disconnect the client also opens a trading session, or with session_id resumes
+// its session from a dropped connection; the session is returned. Orders placed over REST with
+// the session's ID in SessionHeader are cancelled if the connection goes away and isn't resumed
+// within cancelOnDisconnectGrace.
+func handleAuth(client *ws.Client, msg clientMessage) *tradingSession {
+	if client.UserID() != uuid.Nil {
+		sendToClient(client, fiber.Map{"type": "error", "error": "Already authenticated"})
+		return nil
+	}
+	var resumeID uuid.UUID
+	if msg.SessionID != "" {
+		id, err := uuid.Parse(msg.SessionID)
+		if err != nil {
+			sendToClient(client, fiber.Map{"type": "error", "error": "Invalid session_id"})
+			return nil
+		}
+		resumeID = id
+	}
+	claims, err := auth.ValidateJWT(msg.Token)
+	if err != nil {
+		sendToClient(client, fiber.Map{"type": "error", "error": "Invalid or expired token"})
+		return nil
+	}
+	revoked, err := auth.TokenBlacklist.IsRevoked(context.Background(), claims.ID)
+	if err != nil {
+		log.Printf("Error checking token blacklist for user %s: %v", claims.UserID, err)
+		sendToClient(client, fiber.Map{"type": "error", "error": "Failed to validate token"})
+		return nil
+	}
+	if revoked {
+		sendToClient(client, fiber.Map{"type": "error", "error": "Invalid or expired token"})
+		return nil
+	}
+
+	var session *tradingSession
+	switch {
+	case resumeID != uuid.Nil:
+		if session, err = resumeSession(client, claims.UserID, resumeID); err != nil {
+			sendToClient(client, fiber.Map{"type": "error", "error": err.Error()})
+			return nil
+		}
+	case msg.CancelOnDisconnect:
+		session = startSession(client, claims.UserID)
+	}
+
+	client.SetUserID(claims.UserID)
+	log.Printf("WebSocket client %s authenticated as user %s", client.Conn.RemoteAddr(), claims.UserID)
+	reply := fiber.Map{"type": "auth", "status": "ok", "user_id": claims.UserID}
+	if session != nil {
+		reply["session_id"] = session.id
+		reply["cancel_on_disconnect_grace_ms"] = cancelOnDisconnectGrace.Milliseconds()
+	}
+	sendToClient(client, reply)
+	return session
+}
+
+// handleSubscribe switches a depth feed client between incremental updates ("diff", what every
+// client starts with) and periodic snapshots of the top depthSnapshotLevels levels ("snapshot"),
+// sent every interval_ms clamped to [minDepthSnapshotInterval, maxDepthSnapshotInterval].
+// Snapshots come from a goroutine of the client's own; stopSnapshots stops the current one
+// and is replaced when a new one starts.
+// Back in diff mode the client is sent a full snapshot, which reaches it in no particular order
+// with the first updates: it should buffer updates until the snapshot arrives, then apply those
+// with a seq above the snapshot's.
+func handleSubscribe(client *ws.Client, msg clientMessage, stopSnapshots *func()) {
+	if msg.Channel != ws.ChannelDepth || client.Channel != ws.ChannelDepth {
+		sendToClient(client, fiber.Map{"type": "error", "error": "Only the depth feed supports subscribe"})
+		return
+	}
+	if symbol := strings.ToUpper(strings.TrimSpace(msg.Symbol)); symbol != "" && symbol != client.Symbol {
+		sendToClient(client, fiber.Map{"type": "error", "error": "Symbol does not match this feed's symbol " + client.Symbol})
+		return
+	}
+
+	switch msg.Mode {
+	case "snapshot":
+		interval := defaultDepthSnapshotInterval
+		if msg.IntervalMS > 0 {
+			interval = time.Duration(msg.IntervalMS) * time.Millisecond
+		}
+		interval = min(max(interval, minDepthSnapshotInterval), maxDepthSnapshotInterval)
+
+		(*stopSnapshots)()
+		client.SetSnapshotMode(true)
+		sendToClient(client, fiber.Map{"type": "subscribed", "channel": ws.ChannelDepth, "symbol": client.Symbol,
+			"mode": "snapshot", "interval_ms": interval.Milliseconds()})
+		ctx, cancel := context.WithCancel(context.Background())
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			streamDepthSnapshots(ctx, client, interval)
+		}()
+		*stopSnapshots = func() {
+			cancel()
+			<-done // No snapshot may follow the reply to the next subscribe
+		}
+
+	case "diff", "":
+		(*stopSnapshots)()
+		*stopSnapshots = func() {}
+		client.SetSnapshotMode(false)
+		sendToClient(client, fiber.Map{"type": "subscribed", "channel": ws.ChannelDepth, "symbol": client.Symbol, "mode": "diff"})
+		snapshot, err := depthSnapshot(client.Symbol, 0)
+		if err != nil {
+			log.Printf("Error taking depth snapshot for %s: %v", client.Conn.RemoteAddr(), err)
+			sendToClient(client, fiber.Map{"type": "error", "error": "Failed to take order book snapshot"})
+			return
+		}
+		sendToClient(client, snapshot)
+
+	default:
+		sendToClient(client, fiber.Map{"type": "error", "error": "Invalid mode, must be 'diff' or 'snapshot'"})
+	}
+}
+
+// streamDepthSnapshots sends the client a snapshot of the top of its book right away and then
+// every interval, until ctx is done.
+func streamDepthSnapshots(ctx context.Context, client *ws.Client, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		snapshot, err := depthSnapshot(client.Symbol, depthSnapshotLevels)
+		if err != nil {
+			log.Printf("Error taking depth snapshot for %s, stopping snapshots: %v", client.Conn.RemoteAddr(), err)
+			return
+		}
+		sendToClient(client, snapshot)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// sendToClient queues a reply for the client through the hub, behind any feed messages already queued.
+func sendToClient(client *ws.Client, reply fiber.Map) {
+	data, err := json.Marshal(reply)
+	if err != nil {
+		log.Printf("Error marshalling reply to %s: %v", client.Conn.RemoteAddr(), err)
+		return
+	}
+	ws.GlobalHub.SendTo(client, data)
+}
+
+// closeClient sends the client a close frame with code and reason, then closes the connection,
+// which ends its read pump. Safe to call from any goroutine.
+func closeClient(client *ws.Client, code int, reason string) {
+	closeMsg := websocket.FormatCloseMessage(code, reason)
+	if err := client.Conn.WriteControl(websocket.CloseMessage, closeMsg, time.Now().Add(writeWait)); err != nil {
+		log.Printf("Error sending close frame to %s: %v", client.Conn.RemoteAddr(), err)
 	}
+	client.Conn.Close()
 }
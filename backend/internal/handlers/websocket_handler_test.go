@@ -0,0 +1,39 @@
+package handlers
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	ws "github.com/user/minicoinbase/backend/internal/websocket"
+)
+
+func TestHandlePingEchoesID(t *testing.T) {
+	client := &ws.Client{Pongs: make(chan []byte, 1)}
+	before := time.Now().UnixMilli()
+	handlePing(client, clientMessage{Action: "ping", ID: json.RawMessage(`123`)})
+
+	var pong struct {
+		Type       string          `json:"type"`
+		ID         json.RawMessage `json:"id"`
+		ServerTime int64           `json:"server_time"`
+	}
+	select {
+	case data := <-client.Pongs:
+		if err := json.Unmarshal(data, &pong); err != nil {
+			t.Fatalf("pong %s: %v", data, err)
+		}
+	default:
+		t.Fatal("no pong queued")
+	}
+	if pong.Type != "pong" || string(pong.ID) != "123" || pong.ServerTime < before || pong.ServerTime > time.Now().UnixMilli() {
+		t.Errorf("pong = %+v, want type pong, id 123 and the server time", pong)
+	}
+
+	// Unanswered pongs pile up to the buffer, then pings are ignored rather than block the read pump
+	handlePing(client, clientMessage{Action: "ping", ID: json.RawMessage(`"a"`)})
+	handlePing(client, clientMessage{Action: "ping", ID: json.RawMessage(`"b"`)})
+	if len(client.Pongs) != 1 {
+		t.Errorf("%d pongs queued, want 1", len(client.Pongs))
+	}
+}
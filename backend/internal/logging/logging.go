@@ -0,0 +1,60 @@
+// Package logging sets up the service's structured (slog) logger and carries
+// request-scoped loggers through contexts.
+package logging
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"github.com/user/minicoinbase/backend/internal/config"
+)
+
+// LevelCritical marks failures that leave funds or orders inconsistent and need manual intervention.
+const LevelCritical = slog.LevelError + 4
+
+type ctxKey struct{}
+
+// LoggerKey is the context key FromContext looks the logger up under.
+// Fiber's Locals share storage with c.Context().Value, so after c.Locals(LoggerKey, logger)
+// FromContext(c.Context()) finds it too.
+var LoggerKey interface{} = ctxKey{}
+
+// Init installs the default slog logger at the configured level and format.
+// Output of the standard log package goes through it too, at info level.
+func Init(cfg *config.Config) {
+	opts := &slog.HandlerOptions{
+		Level: cfg.LogLevel,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.LevelKey && len(groups) == 0 {
+				if level, ok := a.Value.Any().(slog.Level); ok && level == LevelCritical {
+					a.Value = slog.StringValue("CRITICAL")
+				}
+			}
+			return a
+		},
+	}
+
+	var handler slog.Handler
+	if cfg.LogFormat == "json" {
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	} else {
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(handler))
+}
+
+// WithLogger returns a copy of ctx carrying logger.
+func WithLogger(ctx context.Context, logger *slog.Logger) context.Context {
+	return context.WithValue(ctx, LoggerKey, logger)
+}
+
+// FromContext returns the logger carried by ctx (e.g., with the request ID), or the default logger.
+func FromContext(ctx context.Context) *slog.Logger {
+	if ctx != nil {
+		if logger, ok := ctx.Value(LoggerKey).(*slog.Logger); ok {
+			return logger
+		}
+	}
+	return slog.Default()
+}
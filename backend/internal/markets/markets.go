@@ -0,0 +1,137 @@
+package markets
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/user/minicoinbase/backend/internal/config"
+)
+
+// Market is a tradable symbol with its order size limits. A zero limit, tick or step size means no limit.
+type Market struct {
+	Symbol      string  `json:"symbol"`
+	BaseAsset   string  `json:"base_asset"`
+	QuoteAsset  string  `json:"quote_asset"`
+	TickSize    float64 `json:"tick_size"`
+	StepSize    float64 `json:"step_size"`
+	MinQuantity float64 `json:"min_quantity"`
+	MaxQuantity float64 `json:"max_quantity"`
+	MinNotional float64 `json:"min_notional"`
+	MaxNotional float64 `json:"max_notional"`
+}
+
+// registry holds the configured markets by symbol, set once at startup by Init.
+var registry = map[string]Market{}
+
+// Init builds the market registry from the configured symbols.
+func Init(cfg *config.Config) {
+	registry = make(map[string]Market, len(cfg.Symbols))
+	for symbol, sc := range cfg.Symbols {
+		base, quote, _ := strings.Cut(symbol, "-")
+		registry[symbol] = Market{
+			Symbol:      symbol,
+			BaseAsset:   base,
+			QuoteAsset:  quote,
+			TickSize:    sc.TickSize,
+			StepSize:    sc.StepSize,
+			MinQuantity: sc.MinQuantity,
+			MaxQuantity: sc.MaxQuantity,
+			MinNotional: sc.MinNotional,
+			MaxNotional: sc.MaxNotional,
+		}
+	}
+}
+
+// Get returns the market of a symbol, and whether it is configured.
+func Get(symbol string) (Market, bool) {
+	m, ok := registry[symbol]
+	return m, ok
+}
+
+// All returns every configured market, sorted by symbol.
+func All() []Market {
+	all := make([]Market, 0, len(registry))
+	for _, m := range registry {
+		all = append(all, m)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Symbol < all[j].Symbol })
+	return all
+}
+
+// IsAsset reports whether asset is the base or quote asset of a configured market.
+func IsAsset(asset string) bool {
+	for _, m := range registry {
+		if m.BaseAsset == asset || m.QuoteAsset == asset {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckOrderSize checks an order of quantity at price against the limits of its market and
+// returns an error describing the first limit it breaks. A price of 0 (unknown, e.g. a market
+// order without a reference price) skips the notional limits. Symbols without a market have no limits.
+func CheckOrderSize(symbol string, price, quantity float64) error {
+	m, ok := registry[symbol]
+	if !ok {
+		return nil
+	}
+	if m.MinQuantity > 0 && quantity < m.MinQuantity {
+		return fmt.Errorf("quantity %g is below the minimum of %g for %s", quantity, m.MinQuantity, symbol)
+	}
+	if m.MaxQuantity > 0 && quantity > m.MaxQuantity {
+		return fmt.Errorf("quantity %g exceeds the maximum of %g for %s", quantity, m.MaxQuantity, symbol)
+	}
+	if price <= 0 {
+		return nil
+	}
+	return m.checkNotional(price * quantity)
+}
+
+// CheckOrderValue checks an order sized in the quote asset (a quote-denominated market buy)
+// against the notional limits of its market, like CheckOrderSize.
+func CheckOrderValue(symbol string, notional float64) error {
+	m, ok := registry[symbol]
+	if !ok {
+		return nil
+	}
+	return m.checkNotional(notional)
+}
+
+func (m Market) checkNotional(notional float64) error {
+	if m.MinNotional > 0 && notional < m.MinNotional {
+		return fmt.Errorf("order value %g %s is below the minimum of %g %s for %s", notional, m.QuoteAsset, m.MinNotional, m.QuoteAsset, m.Symbol)
+	}
+	if m.MaxNotional > 0 && notional > m.MaxNotional {
+		return fmt.Errorf("order value %g %s exceeds the maximum of %g %s for %s", notional, m.QuoteAsset, m.MaxNotional, m.QuoteAsset, m.Symbol)
+	}
+	return nil
+}
+
+// CheckOrderPrecision checks that price is a multiple of the tick size and quantity a multiple
+// of the step size of the symbol's market. A price of 0 (market orders) is not checked.
+func CheckOrderPrecision(symbol string, price, quantity float64) error {
+	m, ok := registry[symbol]
+	if !ok {
+		return nil
+	}
+	if price > 0 && !isMultiple(price, m.TickSize) {
+		return fmt.Errorf("price %g is not a multiple of the tick size %g for %s", price, m.TickSize, symbol)
+	}
+	if !isMultiple(quantity, m.StepSize) {
+		return fmt.Errorf("quantity %g is not a multiple of the step size %g for %s", quantity, m.StepSize, symbol)
+	}
+	return nil
+}
+
+// isMultiple reports whether x is a whole multiple of step, allowing for float rounding
+// (0.3 is a multiple of 0.1 even though 0.3/0.1 is 2.9999999999999996). Any x is a multiple of 0.
+func isMultiple(x, step float64) bool {
+	if step <= 0 {
+		return true
+	}
+	n := x / step
+	return math.Abs(n-math.Round(n)) <= 1e-9*math.Max(1, math.Abs(n))
+}
@@ -0,0 +1,65 @@
+package markets
+
+import (
+	"testing"
+
+	"github.com/user/minicoinbase/backend/internal/config"
+)
+
+func TestCheckOrderSize(t *testing.T) {
+	Init(&config.Config{Symbols: map[string]config.SymbolConfig{
+		"BTC-USD": {InitialPrice: 60000, MinQuantity: 0.0001, MaxQuantity: 10, MinNotional: 10, MaxNotional: 100000},
+		"ETH-USD": {InitialPrice: 3000},
+	}})
+
+	tests := []struct {
+		name     string
+		symbol   string
+		price    float64
+		quantity float64
+		wantErr  bool
+	}{
+		{"within limits", "BTC-USD", 60000, 0.5, false},
+		{"dust quantity", "BTC-USD", 60000, 0.00001, true},
+		{"fat-finger quantity", "BTC-USD", 1, 11, true},
+		{"below min notional", "BTC-USD", 50000, 0.0001, true},
+		{"above max notional", "BTC-USD", 60000, 2, true},
+		{"unknown price skips notional", "BTC-USD", 0, 2, false},
+		{"no limits configured", "ETH-USD", 3000, 1000, false},
+		{"unconfigured symbol", "FOO-BAR", 1, 1e9, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckOrderSize(tt.symbol, tt.price, tt.quantity)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckOrderSize(%s, %v, %v) = %v, want error %v", tt.symbol, tt.price, tt.quantity, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCheckOrderPrecision(t *testing.T) {
+	Init(&config.Config{Symbols: map[string]config.SymbolConfig{
+		"BTC-USD": {InitialPrice: 60000, TickSize: 0.01, StepSize: 0.0001},
+	}})
+
+	tests := []struct {
+		name     string
+		price    float64
+		quantity float64
+		wantErr  bool
+	}{
+		{"on tick and step", 60000.01, 0.3, false},
+		{"market order", 0, 0.0003, false},
+		{"off tick", 60000.005, 1, true},
+		{"off step", 60000, 0.00015, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := CheckOrderPrecision("BTC-USD", tt.price, tt.quantity)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("CheckOrderPrecision(%v, %v) = %v, want error %v", tt.price, tt.quantity, err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestSessionOnly(t *testing.T) {
+	app := fiber.New()
+	// Stands in for Authenticated: API key requests carry the key ID in the locals
+	app.Use(func(c *fiber.Ctx) error {
+		if keyID := c.Get("X-API-KEY"); keyID != "" {
+			c.Locals("apiKeyID", keyID)
+		}
+		return c.Next()
+	})
+	app.Post("/api/transfers", SessionOnly(), func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusCreated) })
+
+	tests := []struct {
+		keyID string
+		want  int
+	}{
+		{"", fiber.StatusCreated},
+		{"ak_test", fiber.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("POST", "/api/transfers", nil)
+		if tt.keyID != "" {
+			req.Header.Set("X-API-KEY", tt.keyID)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("POST: %v", err)
+		}
+		if resp.StatusCode != tt.want {
+			t.Errorf("with API key %q: status %d, want %d", tt.keyID, resp.StatusCode, tt.want)
+		}
+	}
+}
@@ -1,39 +1,64 @@
 package middleware
 
 import (
+	"log"
 	"strings"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/user/minicoinbase/backend/internal/apierror"
 	"github.com/user/minicoinbase/backend/internal/auth"
 )
 
 // Protected is a middleware function to verify JWT authentication.
 func Protected() fiber.Handler {
 	return func(c *fiber.Ctx) error {
-		authHeader := c.Get("Authorization")
-		if authHeader == "" {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Missing authorization header"})
-		}
-
-		// Expecting "Bearer <token>"
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid authorization header format"})
-		}
-
-		tokenString := parts[1]
-		claims, err := auth.ValidateJWT(tokenString)
-		if err != nil {
-			// Log the specific error for debugging, but return a generic message
-			// log.Printf("JWT validation error: %v", err)
-			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "Invalid or expired token"})
+		claims, apiErr := bearerClaims(c)
+		if apiErr != nil {
+			return apierror.Respond(c, apiErr)
 		}
 
 		// Store user information in context for downstream handlers
 		c.Locals("userID", claims.UserID)
 		c.Locals("username", claims.Username)
+		c.Locals("role", claims.Role)
+		c.Locals("claims", claims)
 		// You can add more claims info to locals if needed
 
 		return c.Next()
 	}
 }
+
+// bearerClaims validates the access token of the request's Authorization header and returns its
+// claims, or the error to reject the request with. Shared by everything that accepts a JWT, so
+// they all agree on which tokens are valid.
+func bearerClaims(c *fiber.Ctx) (*auth.Claims, *apierror.Error) {
+	authHeader := c.Get("Authorization")
+	if authHeader == "" {
+		return nil, apierror.New(apierror.Unauthorized, "Missing authorization header")
+	}
+
+	// Expecting "Bearer <token>"
+	parts := strings.Split(authHeader, " ")
+	if len(parts) != 2 || strings.ToLower(parts[0]) != "bearer" {
+		return nil, apierror.New(apierror.Unauthorized, "Invalid authorization header format")
+	}
+
+	tokenString := parts[1]
+	claims, err := auth.ValidateJWT(tokenString)
+	if err != nil {
+		// Log the specific error for debugging, but return a generic message
+		// log.Printf("JWT validation error: %v", err)
+		return nil, apierror.New(apierror.Unauthorized, "Invalid or expired token")
+	}
+
+	// Reject tokens revoked by logout
+	revoked, err := auth.TokenBlacklist.IsRevoked(c.Context(), claims.ID)
+	if err != nil {
+		log.Printf("Error checking token blacklist for user %s: %v", claims.UserID, err)
+		return nil, apierror.New(apierror.Internal, "Failed to validate token")
+	}
+	if revoked {
+		return nil, apierror.New(apierror.Unauthorized, "Invalid or expired token")
+	}
+	return claims, nil
+}
@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"strings"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/cors"
+	"github.com/user/minicoinbase/backend/internal/apierror"
+	"github.com/user/minicoinbase/backend/internal/config"
+)
+
+// CORS lets browsers on the configured origins call the API. Register it with app.Use before
+// any route so it also answers preflight OPTIONS requests, which never reach a handler.
+// Credentials are not allowed: clients authenticate with the Authorization header, not cookies.
+func CORS(cfg *config.Config) fiber.Handler {
+	return cors.New(cors.Config{
+		AllowOrigins:  strings.Join(cfg.CORSAllowOrigins, ","),
+		AllowMethods:  strings.Join(cfg.CORSAllowMethods, ","),
+		AllowHeaders:  strings.Join(cfg.CORSAllowHeaders, ","),
+		ExposeHeaders: strings.Join([]string{RequestIDHeader, fiber.HeaderRetryAfter}, ","),
+		MaxAge:        600,
+	})
+}
+
+// WebSocketUpgrade only lets WebSocket upgrade requests through, and only from the configured
+// origins: browsers don't apply CORS to WebSockets, so without this check any site could open
+// a feed on a visitor's behalf. Requests without an Origin header don't come from a browser
+// and are allowed.
+func WebSocketUpgrade(cfg *config.Config) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !websocket.IsWebSocketUpgrade(c) {
+			return fiber.ErrUpgradeRequired
+		}
+		if origin := c.Get(fiber.HeaderOrigin); origin != "" && !originAllowed(cfg.CORSAllowOrigins, origin) {
+			return apierror.Send(c, apierror.Forbidden, "Origin not allowed")
+		}
+		c.Locals("allowed", true)
+		return c.Next()
+	}
+}
+
+// originAllowed reports whether origin is one of allowed, or allowed contains "*".
+func originAllowed(allowed []string, origin string) bool {
+	for _, o := range allowed {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/user/minicoinbase/backend/internal/config"
+)
+
+func TestCORS(t *testing.T) {
+	cfg := &config.Config{
+		CORSAllowOrigins: []string{"https://app.example.com"},
+		CORSAllowMethods: []string{"GET", "POST"},
+		CORSAllowHeaders: []string{"Content-Type", "Authorization"},
+	}
+	app := fiber.New()
+	app.Use(CORS(cfg))
+	api := app.Group("/api")
+	api.Post("/orders", func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusCreated) })
+	app.Group("/ws").Use("/", WebSocketUpgrade(cfg))
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		header     map[string]string
+		wantStatus int
+		wantOrigin string
+	}{
+		{"preflight from allowed origin", http.MethodOptions, "/api/orders", map[string]string{
+			"Origin": "https://app.example.com", "Access-Control-Request-Method": "POST",
+		}, fiber.StatusNoContent, "https://app.example.com"},
+		{"preflight from other origin", http.MethodOptions, "/api/orders", map[string]string{
+			"Origin": "https://evil.example.com", "Access-Control-Request-Method": "POST",
+		}, fiber.StatusNoContent, ""},
+		{"simple request from allowed origin", http.MethodPost, "/api/orders", map[string]string{
+			"Origin": "https://app.example.com",
+		}, fiber.StatusCreated, "https://app.example.com"},
+		{"websocket from other origin", http.MethodGet, "/ws/prices", map[string]string{
+			"Origin": "https://evil.example.com", "Connection": "Upgrade", "Upgrade": "websocket",
+		}, fiber.StatusForbidden, ""},
+		{"plain GET on websocket path", http.MethodGet, "/ws/prices", nil, fiber.StatusUpgradeRequired, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, nil)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			resp, err := app.Test(req, -1)
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if got := resp.Header.Get("Access-Control-Allow-Origin"); got != tt.wantOrigin {
+				t.Errorf("Access-Control-Allow-Origin = %q, want %q", got, tt.wantOrigin)
+			}
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"sync/atomic"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/user/minicoinbase/backend/internal/apierror"
+	"github.com/user/minicoinbase/backend/internal/auth"
+)
+
+// maintenance is set while the service is in maintenance mode, see Maintenance.
+var maintenance atomic.Bool
+
+// SetMaintenance turns maintenance mode on or off.
+func SetMaintenance(on bool) {
+	maintenance.Store(on)
+}
+
+// InMaintenance reports whether the service is in maintenance mode.
+func InMaintenance() bool {
+	return maintenance.Load()
+}
+
+// MaintenanceMessage is the message requests are turned away with in maintenance mode.
+const MaintenanceMessage = "Service is under maintenance, please try again later"
+
+// maintenanceExempt are the paths still served in maintenance mode: the probes, and login and
+// refresh, which turn away anyone but admins themselves.
+var maintenanceExempt = map[string]bool{
+	"/api/health":       true,
+	"/api/ready":        true,
+	"/api/auth/login":   true,
+	"/api/auth/refresh": true,
+}
+
+// Maintenance turns requests away with 503 while the service is in maintenance mode, except
+// health and readiness probes and requests bearing an admin's access token, so admins can check
+// on things and turn it off again. Login and refresh are let through too, so an admin whose
+// access token expires can get a new one; the handlers refuse everyone else. The token is only
+// looked at for its role here; the routes still authenticate it as usual. Settlement of trades already matched goes on regardless, and so do
+// WebSocket connections that were already open.
+func Maintenance() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if !maintenance.Load() || maintenanceExempt[c.Path()] || isAdminRequest(c) {
+			return c.Next()
+		}
+		return apierror.Send(c, apierror.Maintenance, MaintenanceMessage)
+	}
+}
+
+// isAdminRequest reports whether the request bears a valid admin access token, by the same
+// checks as Protected: one that was revoked at logout doesn't count.
+func isAdminRequest(c *fiber.Ctx) bool {
+	claims, apiErr := bearerClaims(c)
+	return apiErr == nil && claims.Role == auth.RoleAdmin
+}
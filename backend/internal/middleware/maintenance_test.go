@@ -0,0 +1,94 @@
+package middleware
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/user/minicoinbase/backend/internal/auth"
+	"github.com/user/minicoinbase/backend/internal/config"
+)
+
+func TestMaintenance(t *testing.T) {
+	auth.Init(&config.Config{JWTSecret: "test-secret", AccessTokenTTL: time.Minute})
+	admin, err := auth.GenerateJWT(uuid.New(), "root", auth.RoleAdmin)
+	if err != nil {
+		t.Fatalf("GenerateJWT: %v", err)
+	}
+	user, err := auth.GenerateJWT(uuid.New(), "alice", auth.RoleUser)
+	if err != nil {
+		t.Fatalf("GenerateJWT: %v", err)
+	}
+
+	app := fiber.New()
+	app.Use(Maintenance())
+	ok := func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) }
+	app.Get("/api/health", ok)
+	app.Get("/api/orders", ok)
+	// Login and refresh check for admins themselves
+	app.Get("/api/auth/login", ok)
+	app.Get("/api/auth/refresh", ok)
+
+	getWithHeader := func(path, header string) int {
+		t.Helper()
+		req := httptest.NewRequest("GET", path, nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		resp, err := app.Test(req)
+		if err != nil {
+			t.Fatalf("GET %s: %v", path, err)
+		}
+		return resp.StatusCode
+	}
+	get := func(path, token string) int {
+		t.Helper()
+		if token == "" {
+			return getWithHeader(path, "")
+		}
+		return getWithHeader(path, "Bearer "+token)
+	}
+
+	if status := get("/api/orders", user); status != fiber.StatusOK {
+		t.Errorf("outside maintenance: status %d, want 200", status)
+	}
+
+	SetMaintenance(true)
+	defer SetMaintenance(false)
+	tests := []struct {
+		path, token string
+		want        int
+	}{
+		{"/api/orders", user, fiber.StatusServiceUnavailable},
+		{"/api/orders", "", fiber.StatusServiceUnavailable},
+		{"/api/orders", "not-a-token", fiber.StatusServiceUnavailable},
+		{"/api/orders", admin, fiber.StatusOK},
+		{"/api/health", "", fiber.StatusOK},
+		{"/api/auth/login", "", fiber.StatusOK},
+		{"/api/auth/refresh", "", fiber.StatusOK},
+	}
+	for _, tt := range tests {
+		if status := get(tt.path, tt.token); status != tt.want {
+			t.Errorf("in maintenance, GET %s with token %.10q: status %d, want %d", tt.path, tt.token, status, tt.want)
+		}
+	}
+
+	// The header is parsed as Protected parses it
+	if status := getWithHeader("/api/orders", "bearer "+admin); status != fiber.StatusOK {
+		t.Errorf("in maintenance, admin token with a lower case scheme: status %d, want 200", status)
+	}
+	// An admin token revoked at logout no longer gets through
+	claims, err := auth.ValidateJWT(admin)
+	if err != nil {
+		t.Fatalf("ValidateJWT: %v", err)
+	}
+	if err := auth.TokenBlacklist.Revoke(context.Background(), claims.ID, claims.ExpiresAt.Time); err != nil {
+		t.Fatalf("Revoke: %v", err)
+	}
+	if status := get("/api/orders", admin); status != fiber.StatusServiceUnavailable {
+		t.Errorf("in maintenance, revoked admin token: status %d, want 503", status)
+	}
+}
@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/limiter"
+	"github.com/google/uuid"
+	"github.com/user/minicoinbase/backend/internal/apierror"
+	"github.com/user/minicoinbase/backend/internal/config"
+)
+
+// OrderRateLimit limits each user to ORDER_RATE_LIMIT requests per ORDER_RATE_WINDOW (sliding),
+// answering the rest with 429 and a Retry-After header. Counts are kept in this process only.
+// Must run after Protected, APIKeyProtected or Authenticated, which set the "userID" local.
+// A limit of 0 lets every request through.
+func OrderRateLimit(cfg *config.Config) fiber.Handler {
+	if cfg.OrderRateLimit <= 0 {
+		return func(c *fiber.Ctx) error { return c.Next() }
+	}
+	window := int(cfg.OrderRateWindow.Seconds())
+	return limiter.New(limiter.Config{
+		Max:        cfg.OrderRateLimit,
+		Expiration: cfg.OrderRateWindow,
+		KeyGenerator: func(c *fiber.Ctx) string {
+			if userID, ok := c.Locals("userID").(uuid.UUID); ok {
+				return userID.String()
+			}
+			return c.IP()
+		},
+		LimitReached: func(c *fiber.Ctx) error {
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(window))
+			return apierror.SendDetails(c, apierror.RateLimited, fmt.Sprintf("Too many order requests, at most %d per %s", cfg.OrderRateLimit, cfg.OrderRateWindow),
+				fiber.Map{"retry_after": window})
+		},
+		LimiterMiddleware: limiter.SlidingWindow{},
+	})
+}
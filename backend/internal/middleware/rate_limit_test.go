@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/user/minicoinbase/backend/internal/config"
+)
+
+func TestOrderRateLimitIsPerUser(t *testing.T) {
+	cfg := &config.Config{OrderRateLimit: 2, OrderRateWindow: time.Minute}
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		c.Locals("userID", uuid.MustParse(c.Get("X-Test-User")))
+		return c.Next()
+	})
+	app.Post("/api/orders", OrderRateLimit(cfg), func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusCreated) })
+
+	post := func(userID uuid.UUID) *http.Response {
+		t.Helper()
+		req := httptest.NewRequest(http.MethodPost, "/api/orders", nil)
+		req.Header.Set("X-Test-User", userID.String())
+		resp, err := app.Test(req, -1)
+		if err != nil {
+			t.Fatalf("POST /api/orders: %v", err)
+		}
+		return resp
+	}
+
+	alice, bob := uuid.New(), uuid.New()
+	for i := 0; i < cfg.OrderRateLimit; i++ {
+		if resp := post(alice); resp.StatusCode != fiber.StatusCreated {
+			t.Fatalf("request %d: status %d, want %d", i+1, resp.StatusCode, fiber.StatusCreated)
+		}
+	}
+	resp := post(alice)
+	if resp.StatusCode != fiber.StatusTooManyRequests {
+		t.Errorf("request over the limit: status %d, want %d", resp.StatusCode, fiber.StatusTooManyRequests)
+	}
+	if resp.Header.Get(fiber.HeaderRetryAfter) == "" {
+		t.Error("request over the limit: no Retry-After header")
+	}
+	// Another user's budget is untouched
+	if resp := post(bob); resp.StatusCode != fiber.StatusCreated {
+		t.Errorf("other user's request: status %d, want %d", resp.StatusCode, fiber.StatusCreated)
+	}
+}
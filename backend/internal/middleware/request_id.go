@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"log/slog"
+	"regexp"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/user/minicoinbase/backend/internal/logging"
+)
+
+// RequestIDHeader carries the correlation ID of a request, both ways.
+const RequestIDHeader = "X-Request-ID"
+
+// validRequestID limits client-supplied IDs to something safe to echo and log.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+// RequestID assigns every request a correlation ID: the client's X-Request-ID if it sent a
+// sensible one, a fresh UUID otherwise. The ID is echoed in the response header, stored in
+// Locals("requestID") and attached to the request's logger, so every line logged through
+// logging.FromContext(c.Context()) carries it. Each request is logged on completion.
+func RequestID() fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		requestID := c.Get(RequestIDHeader)
+		if !validRequestID.MatchString(requestID) {
+			requestID = uuid.NewString()
+		}
+		c.Set(RequestIDHeader, requestID)
+		c.Locals("requestID", requestID)
+
+		logger := slog.Default().With("request_id", requestID)
+		c.Locals(logging.LoggerKey, logger)
+
+		start := time.Now()
+		err := c.Next()
+
+		// Errors returned by handlers are turned into responses by the error handler after this,
+		// so work out the status they will get
+		status := c.Response().StatusCode()
+		if err != nil {
+			status = fiber.StatusInternalServerError
+			if fiberErr, ok := err.(*fiber.Error); ok {
+				status = fiberErr.Code
+			}
+		}
+		level := slog.LevelInfo
+		if status >= fiber.StatusInternalServerError {
+			level = slog.LevelError
+		}
+		logger.Log(c.Context(), level, "request",
+			"method", c.Method(), "path", c.Path(), "status", status,
+			"duration_ms", time.Since(start).Milliseconds(), "ip", c.IP())
+		return err
+	}
+}
@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/user/minicoinbase/backend/internal/apierror"
+)
+
+// RequireRole only lets through requests whose authenticated user has the given role.
+// Must run after Protected, APIKeyProtected or Authenticated, which set the "role" local.
+// An admin's API key carries the admin role too: put SessionOnly in front of routes that
+// must not be reachable with one.
+func RequireRole(role string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		userRole, ok := c.Locals("role").(string)
+		if !ok {
+			return apierror.Send(c, apierror.Unauthorized, "Not authenticated")
+		}
+		if userRole != role {
+			return apierror.Send(c, apierror.Forbidden, "Insufficient permissions")
+		}
+		return c.Next()
+	}
+}
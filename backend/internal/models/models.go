@@ -10,22 +10,168 @@ import (
 type User struct {
 	ID        uuid.UUID `json:"id"`
 	Username  string    `json:"username"`
-	Password  string    `json:"-"` // Store hash, exclude from JSON responses
+	Password  string    `json:"-"`    // Store hash, exclude from JSON responses
+	Role      string    `json:"role"` // "user" or "admin"
 	CreatedAt time.Time `json:"created_at"`
 }
 
+// APIKey is a key for HMAC-signed programmatic access, as listed to its owner (never with the secret)
+type APIKey struct {
+	ID              uuid.UUID `json:"id"`
+	UserID          uuid.UUID `json:"user_id"`
+	KeyID           string    `json:"key_id"` // Public identifier, sent in the X-API-KEY header
+	Scope           string    `json:"scope"`  // "read" or "trade"
+	SecretEncrypted []byte    `json:"-"`      // Encrypted HMAC secret, never returned
+	CreatedAt       time.Time `json:"created_at"`
+}
+
 // Order represents a trading order
 type Order struct {
-	ID        uuid.UUID `json:"id"`
-	UserID    uuid.UUID `json:"user_id"`
-	Symbol    string    `json:"symbol"`          // e.g., "BTC-USD"
-	Type      string    `json:"type"`            // e.g., "limit", "market"
-	Side      string    `json:"side"`            // e.g., "buy", "sell"
-	Price     float64   `json:"price,omitempty"` // Only for limit orders
+	ID          uuid.UUID `json:"id"`
+	UserID      uuid.UUID `json:"user_id"`
+	Symbol      string    `json:"symbol"`               // e.g., "BTC-USD"
+	Type        string    `json:"type"`                 // e.g., "limit", "market", "stop", "stop_limit"
+	Side        string    `json:"side"`                 // e.g., "buy", "sell"
+	Price       float64   `json:"price,omitempty"`      // Only for limit and stop_limit orders
+	StopPrice   float64   `json:"stop_price,omitempty"` // Trigger price, only for stop and stop_limit orders
+	TimeInForce string    `json:"time_in_force"`        // "GTC" (default), "IOC" or "FOK"
+	PostOnly    bool      `json:"post_only,omitempty"`  // Limit orders only: rejected instead of matching on entry
+	// ExpiresAt makes a GTC order good-till-date: once past, whatever is unfilled is cancelled. Nil never expires.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// SessionID is the WebSocket trading session the order was placed under, if any; the order is
+	// cancelled when that session disconnects and isn't resumed within the grace period.
+	SessionID *uuid.UUID `json:"session_id,omitempty"`
+	// Quantity is the order's total size: the original quantity unless modified since (never the remaining quantity,
+	// which is Quantity - FilledQuantity; the order book keeps its own count of it).
+	Quantity float64 `json:"quantity"`
+	// QuoteQuantity sizes a market buy in the quote asset instead ("spend 100 USD"): Quantity is 0 and
+	// FilledQuantity counts the base bought. 0 for orders sized in the base asset.
+	QuoteQuantity float64 `json:"quote_quantity,omitempty"`
+	// DisplayQuantity makes a limit order an iceberg: only this much of it shows on the book at a
+	// time, and the next slice is shown from the hidden rest once it fills. 0 shows the whole order.
+	DisplayQuantity float64 `json:"display_quantity,omitempty"`
+	// ProtectionPrice caps how far a market order (or a stop, once triggered) may walk the book: it
+	// doesn't trade above it for a buy or below it for a sell, and what is left when matching stops
+	// there is cancelled. 0 for no protection.
+	ProtectionPrice float64 `json:"protection_price,omitempty"`
+	// LockedAmount is what the order still has locked, in LockedAsset (the quote asset for buys,
+	// the base asset for sells): what was locked when it was placed, less what fills consumed and
+	// what was released. Empty LockedAsset for orders closed before it was recorded.
+	LockedAmount     float64   `json:"locked_amount,omitempty"`
+	LockedAsset      string    `json:"locked_asset,omitempty"`
+	OriginalQuantity float64   `json:"original_quantity"` // Quantity as placed
+	FilledQuantity   float64   `json:"filled_quantity"`   // Executed so far
+	AvgFillPrice     float64   `json:"avg_fill_price"`    // Volume-weighted price of the fills, 0 until the first fill
+	Status           string    `json:"status"`            // e.g., "open", "filled", "cancelled"
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+}
+
+// Trade represents an executed match between a resting (maker) and an incoming (taker) order
+type Trade struct {
+	ID           uuid.UUID `json:"id"`
+	Seq          int64     `json:"seq"` // Per symbol, as assigned by the order book
+	Symbol       string    `json:"symbol"`
+	MakerOrderID uuid.UUID `json:"maker_order_id"`
+	TakerOrderID uuid.UUID `json:"taker_order_id"`
+	TakerSide    string    `json:"taker_side"` // Side of the incoming order, "buy" or "sell"
+	Price        float64   `json:"price"`
+	Quantity     float64   `json:"quantity"`
+	MakerFee     float64   `json:"maker_fee"` // Charged on the asset the maker receives
+	TakerFee     float64   `json:"taker_fee"` // Charged on the asset the taker receives
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// UserTrade is a trade seen from one user's side of it
+type UserTrade struct {
+	TradeID   uuid.UUID `json:"trade_id"`
+	OrderID   uuid.UUID `json:"order_id"`
+	Symbol    string    `json:"symbol"`
+	Side      string    `json:"side"` // The user's side, "buy" or "sell"
+	Role      string    `json:"role"` // "maker" or "taker"
+	Price     float64   `json:"price"`
 	Quantity  float64   `json:"quantity"`
-	Status    string    `json:"status"` // e.g., "open", "filled", "cancelled"
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	Fee       float64   `json:"fee"`       // Fee paid by the user on this trade
+	FeeAsset  string    `json:"fee_asset"` // Asset the fee was charged in (the one the user received)
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Fill is one execution of an order: its share of a trade, with running totals over the
+// order's fills up to and including this one
+type Fill struct {
+	TradeID            uuid.UUID `json:"trade_id"`
+	OrderID            uuid.UUID `json:"order_id"`
+	Symbol             string    `json:"symbol"`
+	Side               string    `json:"side"` // The order's side, "buy" or "sell"
+	Role               string    `json:"role"` // "maker" or "taker"
+	Price              float64   `json:"price"`
+	Quantity           float64   `json:"quantity"`
+	Fee                float64   `json:"fee"`
+	FeeAsset           string    `json:"fee_asset"`
+	CumulativeQuantity float64   `json:"cumulative_quantity"` // Filled so far
+	AvgPrice           float64   `json:"avg_price"`           // Average price of the fills so far
+	CumulativeFee      float64   `json:"cumulative_fee"`
+	Timestamp          time.Time `json:"timestamp"`
+}
+
+// Transfer is a move of available funds from one user to another
+type Transfer struct {
+	ID          uuid.UUID `json:"id"`
+	SenderID    uuid.UUID `json:"sender_id"`
+	RecipientID uuid.UUID `json:"recipient_id"`
+	Asset       string    `json:"asset"`
+	Amount      float64   `json:"amount"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// StatementEntry is one change of a user's total balance of an asset, as listed on an account statement
+type StatementEntry struct {
+	Time     time.Time  `json:"time"`
+	Type     string     `json:"type"` // Ledger reason: "fill", "fee", "deposit", "withdrawal", "transfer_out", "transfer_in" or "opening_balance"
+	Asset    string     `json:"asset"`
+	Amount   float64    `json:"amount"`             // Positive for credits, negative for debits
+	Symbol   string     `json:"symbol,omitempty"`   // Trades and fees only
+	Side     string     `json:"side,omitempty"`     // The user's side of the trade
+	Price    float64    `json:"price,omitempty"`    // Trade price
+	Quantity float64    `json:"quantity,omitempty"` // Trade quantity, in the base asset
+	OrderID  *uuid.UUID `json:"order_id,omitempty"`
+	TradeID  *uuid.UUID `json:"trade_id,omitempty"`
+}
+
+// Kline is one OHLCV candlestick aggregated from the trades within its interval
+type Kline struct {
+	OpenTime time.Time `json:"open_time"` // Start of the interval (inclusive)
+	Open     float64   `json:"open"`      // Price of the first trade in the interval
+	High     float64   `json:"high"`
+	Low      float64   `json:"low"`
+	Close    float64   `json:"close"`  // Price of the last trade in the interval
+	Volume   float64   `json:"volume"` // Total base quantity traded
+	Trades   int       `json:"trades"` // Number of trades in the interval
+}
+
+// TickerStats are the trading statistics of a symbol over a rolling window (24 hours)
+type TickerStats struct {
+	Symbol             string    `json:"symbol"`
+	Open               float64   `json:"open"` // Price of the first trade in the window
+	High               float64   `json:"high"`
+	Low                float64   `json:"low"`
+	Last               float64   `json:"last"`                 // Price of the last trade in the window
+	PriceChangePercent float64   `json:"price_change_percent"` // From Open to Last
+	BaseVolume         float64   `json:"base_volume"`          // Total base quantity traded
+	QuoteVolume        float64   `json:"quote_volume"`         // Total price*quantity traded
+	Trades             int       `json:"trades"`               // Number of trades in the window; 0 leaves the prices at 0
+	WindowStart        time.Time `json:"window_start"`
+	WindowEnd          time.Time `json:"window_end"`
+}
+
+// Position is what a user holds of a market's base asset by way of trading it, see portfolio.Apply
+type Position struct {
+	UserID      uuid.UUID `json:"user_id"`
+	Symbol      string    `json:"symbol"`
+	Quantity    float64   `json:"quantity"`     // Bought less sold, after fees
+	CostBasis   float64   `json:"cost_basis"`   // Quote paid for Quantity
+	RealizedPnL float64   `json:"realized_pnl"` // Lifetime, from selling above or below the average cost
+	UpdatedAt   time.Time `json:"updated_at"`
 }
 
 // Balance represents a user's balance for a specific asset
@@ -33,6 +179,7 @@ type Balance struct {
 	UserID    uuid.UUID `json:"user_id"`
 	Asset     string    `json:"asset"` // e.g., "USD", "BTC"
 	Available float64   `json:"available"`
-	Locked    float64   `json:"locked"` // Funds locked in open orders
+	Locked    float64   `json:"locked"`  // Funds locked in open orders
+	Version   int64     `json:"version"` // Incremented by every change to the balance
 	UpdatedAt time.Time `json:"updated_at"`
 }
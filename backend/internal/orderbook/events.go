@@ -0,0 +1,338 @@
+package orderbook
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/user/minicoinbase/backend/internal/models"
+)
+
+// EventType is the kind of change an Event records.
+type EventType string
+
+const (
+	EventRestore EventType = "restore" // The book was reset to Snapshot, see OrderBook.Restore
+	EventAdd     EventType = "add"     // Order was accepted by AddOrder, as submitted
+	EventCancel  EventType = "cancel"  // OrderID was cancelled
+	EventReplace EventType = "replace" // OrderID, with Remaining left, was replaced with Price and Quantity
+	EventHalt    EventType = "halt"    // Trading was halted or resumed, see Halted
+	EventMatch   EventType = "match"   // Trade was executed by the add or replace logged before it
+)
+
+// Event is one change to an order book, as appended to its event log. Replaying a book's events
+// from a restore onwards (see Replay) rebuilds its state after any of them.
+type Event struct {
+	Seq    uint64    `json:"seq"` // Per book, increases by exactly one per event; a gap means lost events
+	Type   EventType `json:"type"`
+	Symbol string    `json:"symbol"`
+	Time   time.Time `json:"time"`
+
+	Order     *models.Order `json:"order,omitempty"`
+	OrderID   uuid.UUID     `json:"order_id,omitempty"`
+	Price     float64       `json:"price,omitempty"`
+	Quantity  float64       `json:"quantity,omitempty"`
+	Remaining float64       `json:"remaining,omitempty"`
+	Halted    bool          `json:"halted,omitempty"`
+	Trade     *Trade        `json:"trade,omitempty"`
+	Snapshot  *Snapshot     `json:"snapshot,omitempty"`
+}
+
+// EventSink receives the events of order books, see OrderBook.Events. Append runs with the book's
+// lock held, so it must not block for long, and must not keep the event or anything it points to.
+type EventSink interface {
+	Append(event *Event)
+}
+
+// record numbers an event and hands it to the book's sink, if it has one.
+// Must be called with the write lock held.
+func (ob *OrderBook) record(event *Event) {
+	if ob.Events == nil {
+		return
+	}
+	ob.eventSeq++
+	event.Seq = ob.eventSeq
+	event.Symbol = ob.symbol
+	event.Time = time.Now()
+	ob.Events.Append(event)
+}
+
+// eventQueueSize is how many encoded events FileEventSink buffers for its writer.
+const eventQueueSize = 4096
+
+// FileEventSink appends events to a file, one JSON object per line. Append only encodes the event
+// and queues it, so books don't wait on each other or on the disk; a single writer goroutine
+// writes whatever has queued up in one go. Once the queue is full Append waits for room, so
+// events are never dropped.
+//
+// Events are durable once the file has been synced: every sync interval, on Sync and on Close
+// (with a sync interval of 0, after every write). A crash of the process loses the events still
+// queued; a crash of the machine also those written since the last sync.
+type FileEventSink struct {
+	file   *os.File
+	queue  chan []byte
+	syncs  chan chan error // Sync requests, answered once the events queued before them are on disk
+	done   chan struct{}   // Closed when the writer has stopped
+	closed error           // Result of closing the file, set by the writer before done is closed
+}
+
+// NewFileEventSink opens (or creates) the event log at path for appending and starts its writer,
+// which syncs the file to disk every syncInterval (0 to sync after every write).
+func NewFileEventSink(path string, syncInterval time.Duration) (*FileEventSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("error opening order book event log: %w", err)
+	}
+	s := &FileEventSink{
+		file:  file,
+		queue: make(chan []byte, eventQueueSize),
+		syncs: make(chan chan error),
+		done:  make(chan struct{}),
+	}
+	go s.run(syncInterval)
+	return s, nil
+}
+
+// Append encodes an event and queues it for the writer. Failures are logged: the book has
+// already changed.
+func (s *FileEventSink) Append(event *Event) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		slog.Error("Failed to encode order book event", "symbol", event.Symbol, "seq", event.Seq, "err", err)
+		return
+	}
+	s.queue <- append(data, '\n')
+}
+
+// Sync returns once every event appended before it is written and synced to disk.
+func (s *FileEventSink) Sync() error {
+	reply := make(chan error, 1)
+	select {
+	case s.syncs <- reply:
+		return <-reply
+	case <-s.done:
+		return errors.New("order book event log is closed")
+	}
+}
+
+// Close writes the events still queued, syncs the file to disk and closes it.
+// The books logging to the sink must no longer change.
+func (s *FileEventSink) Close() error {
+	close(s.queue)
+	<-s.done
+	return s.closed
+}
+
+// run is the writer goroutine: it writes queued events in batches until the queue is closed.
+func (s *FileEventSink) run(syncInterval time.Duration) {
+	defer close(s.done)
+	var tick <-chan time.Time
+	if syncInterval > 0 {
+		t := time.NewTicker(syncInterval)
+		defer t.Stop()
+		tick = t.C
+	}
+
+	var batch []byte
+	unsynced := false
+	// write writes data and whatever else is queued in one go. Returns false once the queue is closed.
+	write := func(data []byte) bool {
+		batch = append(batch[:0], data...)
+		open := true
+	drain:
+		for {
+			select {
+			case data, ok := <-s.queue:
+				if !ok {
+					open = false
+					break drain
+				}
+				batch = append(batch, data...)
+			default:
+				break drain
+			}
+		}
+		if len(batch) > 0 {
+			if _, err := s.file.Write(batch); err != nil {
+				slog.Error("Failed to write order book events", "bytes", len(batch), "err", err)
+			}
+			unsynced = true
+		}
+		return open
+	}
+	sync := func() error {
+		if !unsynced {
+			return nil
+		}
+		unsynced = false
+		return s.file.Sync()
+	}
+	logSync := func() {
+		if err := sync(); err != nil {
+			slog.Error("Failed to sync order book event log", "err", err)
+		}
+	}
+
+	for {
+		select {
+		case data, ok := <-s.queue:
+			if ok && write(data) {
+				if syncInterval <= 0 {
+					logSync()
+				}
+				continue
+			}
+			s.closed = errors.Join(sync(), s.file.Close())
+			return
+		case <-tick:
+			logSync()
+		case reply := <-s.syncs:
+			// Everything appended before Sync was called is queued by now
+			open := write(nil)
+			reply <- sync()
+			if !open {
+				s.closed = errors.Join(sync(), s.file.Close())
+				return
+			}
+		}
+	}
+}
+
+// ReadEvents reads an event log as FileEventSink writes it and returns the events of symbol,
+// in the order they were written. A log shared by several books holds all their events.
+func ReadEvents(r io.Reader, symbol string) ([]*Event, error) {
+	events := make([]*Event, 0)
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024) // Restore events hold a whole book
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		event := &Event{}
+		if err := json.Unmarshal(scanner.Bytes(), event); err != nil {
+			return nil, fmt.Errorf("line %d of the event log: %w", line, err)
+		}
+		if event.Symbol == symbol {
+			events = append(events, event)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading the event log: %w", err)
+	}
+	return events, nil
+}
+
+// ErrReplayDiverged is returned by Replay when the book it rebuilds doesn't behave as logged.
+var ErrReplayDiverged = errors.New("replay diverged from the event log")
+
+// Replay rebuilds a book's state from its events, applying them in order up to and including
+// the one numbered upTo (0 for all of them). The events must start at a restore, and ob should
+// be a new book configured like the one that logged them (SelfTradePolicy, StepSize), without
+// Events of its own. Orders go through matching again, and the trades each add or replace makes
+// are checked against the match events logged after it; a difference, or a gap in the event
+// numbers, returns ErrReplayDiverged with the first event affected. A book with MaxSlippage
+// picked its trade prices at random, so its trades only replay if no market order traded.
+func Replay(ob *OrderBook, events []*Event, upTo uint64) error {
+	if len(events) > 0 && events[0].Type != EventRestore {
+		return fmt.Errorf("event log of %s must start at a restore, starts at event %d (%s)", ob.symbol, events[0].Seq, events[0].Type)
+	}
+	var expected []*Trade // Trades of the last add or replace not yet matched against the log
+	var seq uint64
+	for _, event := range events {
+		if upTo > 0 && event.Seq > upTo {
+			break
+		}
+		if event.Type != EventRestore && event.Seq != seq+1 {
+			return fmt.Errorf("%w: event %d follows event %d", ErrReplayDiverged, event.Seq, seq)
+		}
+		seq = event.Seq
+		if event.Type == EventMatch {
+			if len(expected) == 0 {
+				return fmt.Errorf("%w: event %d logs trade %d, which the replay didn't make", ErrReplayDiverged, event.Seq, event.Trade.Seq)
+			}
+			if !sameTrade(expected[0], event.Trade) {
+				return fmt.Errorf("%w: event %d logs trade %d as %+v, the replay made %+v", ErrReplayDiverged, event.Seq, event.Trade.Seq, *event.Trade, *expected[0])
+			}
+			expected = expected[1:]
+			continue
+		}
+		if len(expected) > 0 {
+			return fmt.Errorf("%w: the replay made trade %d, which is not logged before event %d", ErrReplayDiverged, expected[0].Seq, event.Seq)
+		}
+
+		var result *MatchResult
+		var err error
+		switch event.Type {
+		case EventRestore:
+			err = ob.Restore(event.Snapshot)
+		case EventAdd:
+			order := *event.Order
+			result, err = ob.AddOrder(&order)
+		case EventCancel:
+			_, err = ob.CancelOrder(event.OrderID)
+		case EventReplace:
+			result, err = ob.ReplaceOrder(event.OrderID, event.Remaining, event.Price, event.Quantity)
+		case EventHalt:
+			ob.SetHalted(event.Halted)
+		default:
+			err = fmt.Errorf("unknown event type %q", event.Type)
+		}
+		if err != nil {
+			return fmt.Errorf("replaying event %d (%s) of %s: %w", event.Seq, event.Type, ob.symbol, err)
+		}
+		if result != nil {
+			expected = result.Trades
+		}
+	}
+	if len(expected) > 0 {
+		return fmt.Errorf("%w: the replay made trade %d, which is not logged", ErrReplayDiverged, expected[0].Seq)
+	}
+	return nil
+}
+
+// sameTrade reports whether a replayed trade is the logged one. IDs and times are new on replay.
+func sameTrade(replayed, logged *Trade) bool {
+	return replayed.Seq == logged.Seq &&
+		replayed.TakerOrderID == logged.TakerOrderID &&
+		replayed.MakerOrderID == logged.MakerOrderID &&
+		replayed.Side == logged.Side &&
+		replayed.Price == logged.Price &&
+		replayed.Quantity == logged.Quantity
+}
+
+// OpenEventLog starts logging the events of every book, existing and future, to the file at
+// path, synced to disk every configured interval and whenever snapshots are saved. Call it
+// before Recover, so each book's log starts at the restore Recover makes.
+func (m *Manager) OpenEventLog(path string) error {
+	sink, err := NewFileEventSink(path, m.eventSyncInterval)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = sink
+	for _, book := range m.books {
+		book.mu.Lock()
+		book.Events = sink
+		book.mu.Unlock()
+	}
+	slog.Info("Logging order book events", "path", path, "sync_interval", m.eventSyncInterval)
+	return nil
+}
+
+// CloseEventLog closes the event log, if one is open. Call it at shutdown, once the books no
+// longer change.
+func (m *Manager) CloseEventLog() error {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.events == nil {
+		return nil
+	}
+	return m.events.Close()
+}
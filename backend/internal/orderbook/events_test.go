@@ -0,0 +1,189 @@
+package orderbook
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// bookState is what a replay must reproduce: the book's snapshot, less when it was taken.
+func bookState(t *testing.T, ob *OrderBook) string {
+	t.Helper()
+	snap := ob.Snapshot()
+	snap.TakenAt = time.Time{}
+	snap.EventSeq = 0 // A replayed book logs no events of its own
+	data, err := json.Marshal(snap)
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	return string(data)
+}
+
+func TestEventLogReplay(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+	sink, err := NewFileEventSink(path, 0)
+	if err != nil {
+		t.Fatalf("NewFileEventSink: %v", err)
+	}
+	ob := NewOrderBook("BTC-USD")
+	ob.Events = sink
+	if err := ob.Restore(&Snapshot{Symbol: "BTC-USD"}); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+
+	alice, bob := uuid.New(), uuid.New()
+	add := func(userID uuid.UUID, side string, price, quantity float64) *MatchResult {
+		t.Helper()
+		result, err := ob.AddOrder(newTestOrder(userID, side, price, quantity))
+		if err != nil {
+			t.Fatalf("AddOrder: %v", err)
+		}
+		return result
+	}
+	add(alice, "sell", 101, 2)
+	add(alice, "sell", 102, 1)
+	bid := newTestOrder(bob, "buy", 99, 3)
+	if _, err := ob.AddOrder(bid); err != nil {
+		t.Fatalf("AddOrder: %v", err)
+	}
+	stop := newTestOrder(bob, "sell", 95, 1)
+	stop.Type, stop.StopPrice = "stop_limit", 100
+	if _, err := ob.AddOrder(stop); err != nil {
+		t.Fatalf("AddOrder: %v", err)
+	}
+	if result := add(bob, "buy", 101.5, 2.5); len(result.Trades) != 1 {
+		t.Fatalf("taker buy made %d trades, want 1", len(result.Trades))
+	}
+	afterFirstTrade := ob.eventSeq
+	stateAfterFirstTrade := bookState(t, ob)
+
+	if _, err := ob.ReplaceOrder(bid.ID, 3, 100, 3); err != nil {
+		t.Fatalf("ReplaceOrder: %v", err)
+	}
+	ob.SetHalted(true)
+	ob.SetHalted(false)
+	// Sells through both bids, down to 100, which triggers the stop: with no bids left it rests at 95
+	if result := add(alice, "sell", 99, 4); len(result.Trades) != 2 {
+		t.Fatalf("taker sell made %d trades, want 2", len(result.Trades))
+	}
+	if _, err := ob.CancelOrder(stop.ID); err != nil {
+		t.Fatalf("CancelOrder of the triggered stop: %v", err)
+	}
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Open: %v", err)
+	}
+	defer file.Close()
+	events, err := ReadEvents(file, "BTC-USD")
+	if err != nil {
+		t.Fatalf("ReadEvents: %v", err)
+	}
+	if len(events) == 0 || uint64(len(events)) != ob.eventSeq {
+		t.Fatalf("read %d events, the book logged %d", len(events), ob.eventSeq)
+	}
+
+	replayed := NewOrderBook("BTC-USD")
+	if err := Replay(replayed, events, 0); err != nil {
+		t.Fatalf("Replay: %v", err)
+	}
+	if got, want := bookState(t, replayed), bookState(t, ob); got != want {
+		t.Errorf("replayed book:\n%s\nwant:\n%s", got, want)
+	}
+
+	partial := NewOrderBook("BTC-USD")
+	if err := Replay(partial, events, afterFirstTrade); err != nil {
+		t.Fatalf("Replay up to event %d: %v", afterFirstTrade, err)
+	}
+	if got := bookState(t, partial); got != stateAfterFirstTrade {
+		t.Errorf("book replayed up to event %d:\n%s\nwant:\n%s", afterFirstTrade, got, stateAfterFirstTrade)
+	}
+
+	// A log that doesn't match what the book does is reported, not replayed over
+	for i, event := range events {
+		if event.Type == EventMatch {
+			tampered := *event.Trade
+			tampered.Quantity *= 2
+			events[i] = &Event{Seq: event.Seq, Type: EventMatch, Symbol: event.Symbol, Trade: &tampered}
+			break
+		}
+	}
+	if err := Replay(NewOrderBook("BTC-USD"), events, 0); !errors.Is(err, ErrReplayDiverged) {
+		t.Errorf("Replay of a tampered log: err = %v, want ErrReplayDiverged", err)
+	}
+}
+
+// TestFileEventSinkConcurrentBooks logs from several books at once: every event must reach the
+// file, in order per book, and Sync must make what was appended before it readable.
+func TestFileEventSinkConcurrentBooks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.log")
+	sink, err := NewFileEventSink(path, time.Hour) // Only Sync and Close write through
+	if err != nil {
+		t.Fatalf("NewFileEventSink: %v", err)
+	}
+	symbols := []string{"BTC-USD", "ETH-USD", "SOL-USD"}
+	const ordersPerBook = 200
+	var wg sync.WaitGroup
+	for _, symbol := range symbols {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ob := NewOrderBook(symbol)
+			ob.Events = sink
+			for i := 0; i < ordersPerBook; i++ {
+				order := newTestOrder(uuid.New(), "buy", 100, 1)
+				order.Symbol = symbol
+				if _, err := ob.AddOrder(order); err != nil {
+					t.Errorf("AddOrder: %v", err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	read := func() map[string][]*Event {
+		t.Helper()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("ReadFile: %v", err)
+		}
+		bySymbol := make(map[string][]*Event)
+		for _, symbol := range symbols {
+			if bySymbol[symbol], err = ReadEvents(bytes.NewReader(data), symbol); err != nil {
+				t.Fatalf("ReadEvents: %v", err)
+			}
+		}
+		return bySymbol
+	}
+	if err := sink.Sync(); err != nil {
+		t.Fatalf("Sync: %v", err)
+	}
+	for symbol, events := range read() {
+		if len(events) != ordersPerBook {
+			t.Fatalf("%s: read %d events after Sync, want %d", symbol, len(events), ordersPerBook)
+		}
+		for i, event := range events {
+			if event.Seq != uint64(i+1) {
+				t.Fatalf("%s: event %d has seq %d", symbol, i, event.Seq)
+			}
+		}
+	}
+
+	if err := sink.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if err := sink.Sync(); err == nil {
+		t.Error("Sync after Close succeeded")
+	}
+}
@@ -0,0 +1,93 @@
+package orderbook
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/user/minicoinbase/backend/internal/database"
+	"github.com/user/minicoinbase/backend/internal/logging"
+)
+
+// expiryBatchSize caps the orders cancelled per sweep; any left over go in the next one.
+const expiryBatchSize = 500
+
+// StartExpirySweeper cancels good-till-date orders each configured interval once their
+// expires_at has passed, until ctx is done. It does nothing if the interval is 0.
+func (m *Manager) StartExpirySweeper(ctx context.Context) {
+	if m.expiryInterval <= 0 {
+		return
+	}
+	go m.expiryLoop(ctx, m.expiryInterval)
+}
+
+// expiryLoop calls ExpireOrders every interval until ctx is done.
+func (m *Manager) expiryLoop(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if n, err := m.ExpireOrders(ctx, time.Now()); err != nil {
+				slog.Error("Failed to expire orders", "expired", n, "err", err)
+			} else if n > 0 {
+				slog.Info("Expired good-till-date orders", "expired", n)
+			}
+		}
+	}
+}
+
+// ExpireOrders cancels up to expiryBatchSize open orders whose expires_at is at or before now
+// and returns how many it cancelled. Each order is cancelled in its own transaction with
+// CancelOrderInTx, like a user cancel, so an order the user cancels (or that fills) first is
+// found no longer cancellable under the row lock and skipped.
+func (m *Manager) ExpireOrders(ctx context.Context, now time.Time) (int, error) {
+	orders, err := database.GetExpiredOrders(ctx, now, expiryBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	expired := 0
+	for _, order := range orders {
+		if ctx.Err() != nil {
+			return expired, ctx.Err()
+		}
+		logger := slog.With("order_id", order.ID, "user_id", order.UserID, "symbol", order.Symbol)
+		if err := m.expireOrder(logging.WithLogger(ctx, logger), order.UserID, order.ID); err != nil {
+			if errors.Is(err, database.ErrOrderNotCancellable) {
+				logger.Debug("Expired order already closed, skipping")
+				continue
+			}
+			logger.Error("Failed to cancel expired order", "err", err)
+			continue
+		}
+		logger.Info("Cancelled expired order", "expires_at", order.ExpiresAt)
+		expired++
+	}
+	return expired, nil
+}
+
+// expireOrder cancels one expired order in its own transaction.
+func (m *Manager) expireOrder(ctx context.Context, userID, orderID uuid.UUID) error {
+	// Detached from the sweeper's context: once the order is out of the book the transaction must
+	// commit, or the order would stay open in the database (see CancelOrderInTx)
+	ctx = context.WithoutCancel(ctx)
+	tx, err := database.DB.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := m.CancelOrderInTx(ctx, tx, userID, orderID); err != nil {
+		return err
+	}
+	if err := tx.Commit(ctx); err != nil {
+		logging.FromContext(ctx).Log(ctx, logging.LevelCritical, "Failed to commit cancellation of expired order after removing it from book", "err", err)
+		return err
+	}
+	return nil
+}
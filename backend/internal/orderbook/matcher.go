@@ -0,0 +1,103 @@
+package orderbook
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrBookBusy is returned when a book's matching queue is full. Nothing was done; the caller can retry.
+var ErrBookBusy = errors.New("order book is busy")
+
+// errBookRetired is returned for operations on a book the reaper has taken out of its manager,
+// see retire. Nothing was done; the caller tries again on the symbol's current book.
+var errBookRetired = errors.New("order book was retired")
+
+// bookQueueSize is how many operations can wait for a book's matching goroutine before new
+// orders and replacements are turned away with ErrBookBusy.
+const bookQueueSize = 1024
+
+// startMatching gives the book a dedicated goroutine that runs the operations queued with
+// enqueue and enqueueWait one at a time, in the order they were queued. The goroutine runs
+// until the book is retired. Call at most once, before the book is shared.
+func (ob *OrderBook) startMatching(queueSize int) {
+	ob.queue = make(chan func(), queueSize)
+	go func() {
+		for op := range ob.queue {
+			op()
+		}
+	}()
+}
+
+// enqueue queues op for the book's matching goroutine without waiting, or returns ErrBookBusy
+// if its queue is full. A book without a matching goroutine runs op right away instead.
+func (ob *OrderBook) enqueue(op func()) error {
+	if ob.queue == nil {
+		op()
+		return nil
+	}
+	if !ob.acquire() {
+		return errBookRetired
+	}
+	select {
+	case ob.queue <- ob.release(op):
+		return nil
+	default:
+		ob.pending.Add(-1)
+		return ErrBookBusy
+	}
+}
+
+// enqueueWait queues op for the book's matching goroutine, waiting for room if its queue is full.
+// For operations that must not be turned away, such as cancellations.
+func (ob *OrderBook) enqueueWait(op func()) error {
+	if ob.queue == nil {
+		op()
+		return nil
+	}
+	if !ob.acquire() {
+		return errBookRetired
+	}
+	ob.queue <- ob.release(op)
+	return nil
+}
+
+// acquire counts an operation about to be queued, which keeps the book from being retired
+// until it has run (see release), and marks the book active. Returns false if it is retired.
+func (ob *OrderBook) acquire() bool {
+	ob.pending.Add(1)
+	if ob.retired.Load() {
+		ob.pending.Add(-1)
+		return false
+	}
+	ob.lastActive.Store(time.Now().UnixNano())
+	return true
+}
+
+// release wraps a queued operation to uncount it once it has run.
+func (ob *OrderBook) release(op func()) func() {
+	return func() {
+		defer ob.pending.Add(-1)
+		op()
+	}
+}
+
+// retire stops the book's matching goroutine if the book holds no orders, isn't halted and
+// nothing was queued on it for idle, and returns whether it did. Once retired, operations
+// fail with errBookRetired. Books without a matching goroutine are never retired.
+func (ob *OrderBook) retire(now time.Time, idle time.Duration) bool {
+	if ob.queue == nil || now.Sub(time.Unix(0, ob.lastActive.Load())) < idle {
+		return false
+	}
+	// Retired first and pending checked after, the reverse of acquire, so an operation
+	// is either turned away or seen here
+	ob.retired.Store(true)
+	ob.mu.RLock()
+	empty := len(ob.Orders) == 0 && !ob.halted
+	ob.mu.RUnlock()
+	if !empty || ob.pending.Load() > 0 {
+		ob.retired.Store(false)
+		return false
+	}
+	close(ob.queue)
+	return true
+}
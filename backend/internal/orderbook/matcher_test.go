@@ -0,0 +1,137 @@
+package orderbook
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/user/minicoinbase/backend/internal/config"
+	"github.com/user/minicoinbase/backend/internal/markets"
+)
+
+func TestMatchingQueueOrdersAndPushesBack(t *testing.T) {
+	ob := NewOrderBook("BTC-USD")
+	ob.startMatching(2)
+
+	// Hold the matching goroutine so the queue fills up behind it
+	release := make(chan struct{})
+	started := make(chan struct{})
+	if err := ob.enqueue(func() { close(started); <-release }); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	<-started
+
+	var ran []int
+	done := make(chan struct{})
+	for i := 1; i <= 2; i++ {
+		if err := ob.enqueue(func() { ran = append(ran, i) }); err != nil {
+			t.Fatalf("enqueue %d: %v", i, err)
+		}
+	}
+	if err := ob.enqueue(func() {}); !errors.Is(err, ErrBookBusy) {
+		t.Fatalf("enqueue on a full queue = %v, want ErrBookBusy", err)
+	}
+	// A cancellation waits for room instead
+	go ob.enqueueWait(func() { ran = append(ran, 3); close(done) })
+
+	close(release)
+	<-done
+	if len(ran) != 3 || ran[0] != 1 || ran[1] != 2 || ran[2] != 3 {
+		t.Errorf("operations ran in order %v, want [1 2 3]", ran)
+	}
+}
+
+func TestRetireIdleBook(t *testing.T) {
+	ob := NewOrderBook("BTC-USD")
+	ob.startMatching(4)
+	now := time.Now()
+
+	if ob.retire(now, time.Minute) {
+		t.Fatal("retired a book that was just created")
+	}
+
+	order := newTestOrder(uuid.New(), "sell", 100, 1)
+	done := make(chan struct{})
+	if err := ob.enqueue(func() { ob.AddOrder(order); close(done) }); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	<-done
+	if ob.retire(now.Add(time.Hour), time.Minute) {
+		t.Fatal("retired a book holding an order")
+	}
+
+	// An operation queued or running keeps the book alive
+	release := make(chan struct{})
+	started := make(chan struct{})
+	if err := ob.enqueue(func() { ob.CancelOrder(order.ID); close(started); <-release }); err != nil {
+		t.Fatalf("enqueue: %v", err)
+	}
+	<-started
+	if ob.retire(now.Add(time.Hour), time.Minute) {
+		t.Fatal("retired a book with an operation running")
+	}
+	close(release)
+
+	for !ob.retire(now.Add(time.Hour), time.Minute) {
+		time.Sleep(time.Millisecond) // Until the running operation is done
+	}
+	if err := ob.enqueue(func() {}); !errors.Is(err, errBookRetired) {
+		t.Errorf("enqueue on a retired book = %v, want errBookRetired", err)
+	}
+	if err := ob.enqueueWait(func() {}); !errors.Is(err, errBookRetired) {
+		t.Errorf("enqueueWait on a retired book = %v, want errBookRetired", err)
+	}
+}
+
+func TestReapIdleBooks(t *testing.T) {
+	markets.Init(&config.Config{Symbols: map[string]config.SymbolConfig{"BTC-USD": {InitialPrice: 100}}})
+	t.Cleanup(func() { markets.Init(&config.Config{}) })
+	m := &Manager{
+		books:           make(map[string]*OrderBook),
+		retired:         make(map[string]retiredBook),
+		bookIdleTimeout: time.Minute,
+	}
+
+	if _, err := m.GetBookDepth("GARBAGE-PAIR", 0); !errors.Is(err, ErrUnknownSymbol) {
+		t.Errorf("GetBookDepth of an unknown symbol = %v, want ErrUnknownSymbol", err)
+	}
+	if len(m.books) != 0 {
+		t.Errorf("looking up an unknown symbol created books %v", m.books)
+	}
+
+	// A trade on the configured symbol, leaving its book empty
+	for _, side := range []string{"sell", "buy"} {
+		book := m.GetOrCreateBook("BTC-USD")
+		if err := book.enqueueWait(func() { book.AddOrder(newTestOrder(uuid.New(), side, 100, 1)) }); err != nil {
+			t.Fatalf("enqueueWait: %v", err)
+		}
+	}
+	depth, err := m.GetBookDepth("BTC-USD", 0)
+	for err == nil && depth.Seq < 2 { // Wait for the matching goroutine
+		time.Sleep(time.Millisecond)
+		depth, err = m.GetBookDepth("BTC-USD", 0)
+	}
+	if err != nil {
+		t.Fatalf("GetBookDepth: %v", err)
+	}
+
+	if n := m.ReapIdleBooks(time.Now()); n != 0 {
+		t.Errorf("reaped %d books before the idle timeout, want 0", n)
+	}
+	if n := m.ReapIdleBooks(time.Now().Add(time.Hour)); n != 1 {
+		t.Fatalf("reaped %d books after the idle timeout, want 1", n)
+	}
+
+	// The symbol gets a new book, numbered on from the old one
+	after, err := m.GetBookDepth("BTC-USD", 0)
+	if err != nil {
+		t.Fatalf("GetBookDepth after reaping: %v", err)
+	}
+	if after.Seq != depth.Seq {
+		t.Errorf("new book seq = %d, want %d carried over", after.Seq, depth.Seq)
+	}
+	if seq := m.GetOrCreateBook("BTC-USD").tradeSeq; seq != 1 {
+		t.Errorf("new book trade seq = %d, want 1 carried over", seq)
+	}
+}
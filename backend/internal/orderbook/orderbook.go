@@ -1,66 +1,252 @@
 package orderbook
 
 import (
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"math"
+	"math/rand/v2"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/uuid"
 	"github.com/user/minicoinbase/backend/internal/models"
 )
 
-// Limit represents a price level in the order book.
-// Contains a list of orders at that price.
-// For simplicity now, we might just store orders directly in sorted slices.
+// ErrPostOnlyWouldCross is returned by AddOrder for a post-only order that would match on entry.
+var ErrPostOnlyWouldCross = errors.New("post-only order would cross the book")
 
-// Order represents an order within the order book.
-// We might reuse models.Order or have a simplified internal representation.
-// For now, using models.Order.
+// ErrOrderChanged is returned by ReplaceOrder when the order filled since the caller last looked at it.
+var ErrOrderChanged = errors.New("order changed while being replaced")
 
-// OrderBookSide represents either the bid or ask side of the book.
-// Using sorted slices for simplicity.
-// Bids should be sorted high to low price.
-// Asks should be sorted low to high price.
+// ErrSymbolHalted is returned for orders on a book whose trading is halted, see SetHalted.
+var ErrSymbolHalted = errors.New("trading is halted for this symbol")
+
+// SelfTradePolicy decides what happens when an incoming order would match
+// a resting order of the same user.
+type SelfTradePolicy string
+
+const (
+	CancelNewest SelfTradePolicy = "cancel_newest" // Cancel the incoming order's remainder (default)
+	CancelOldest SelfTradePolicy = "cancel_oldest" // Cancel the resting order and keep matching
+	CancelBoth   SelfTradePolicy = "cancel_both"   // Cancel both the resting order and the incoming remainder
+)
+
+// MatchResult is the outcome of adding an order to the book.
+type MatchResult struct {
+	Trades []*Trade
+	// Orders that left the book before being fully filled (the incoming order or resting ones).
+	// Their locked funds for the unfilled quantity must be released by the caller.
+	Expired []*ExpiredOrder
+}
+
+// ExpiredOrder is an order removed from the book with quantity left unfilled.
+type ExpiredOrder struct {
+	Order    *models.Order
+	Quantity float64 // Unfilled quantity at the time it was removed
+	Quote    float64 // Unspent quote of a quote-denominated market buy (Quantity is 0 for these)
+	Reason   string  // "market", "protection", "ioc", "fok", "self_trade", or "rejected" for orders the book turned away
+}
+
+// expire records an order leaving the book with what it has left.
+func (r *MatchResult) expire(order *bookOrder, reason string) {
+	r.Expired = append(r.Expired, order.expired(reason))
+}
+
+// bookOrder is an order as the book holds it. Fills count down Remaining (RemainingQuote for a
+// quote-denominated market buy) and leave the model alone, so its Quantity keeps meaning the
+// order's full quantity, as it does everywhere else.
+type bookOrder struct {
+	*models.Order
+	Remaining      float64 `json:"remaining"`                 // Quantity not yet filled
+	RemainingQuote float64 `json:"remaining_quote,omitempty"` // Quote not yet spent, quote-denominated orders only
+	Visible        float64 `json:"visible,omitempty"`         // Resting iceberg orders only: what is left of the slice on show
+}
+
+// newBookOrder wraps an order for the book, with what is left of it after the fills it already has.
+func newBookOrder(order *models.Order) *bookOrder {
+	if order.QuoteQuantity > 0 {
+		return &bookOrder{Order: order, RemainingQuote: unspent(order)}
+	}
+	return &bookOrder{Order: order, Remaining: unfilled(order)}
+}
+
+// unfilled returns the quantity of an order not yet filled according to its model.
+func unfilled(order *models.Order) float64 {
+	return order.Quantity - order.FilledQuantity
+}
+
+// unspent returns the quote a quote-denominated order has left to spend according to its model.
+func unspent(order *models.Order) float64 {
+	return order.QuoteQuantity - order.AvgFillPrice*order.FilledQuantity
+}
+
+// defaultStepSize is the quantity granularity of books without a StepSize: the 8 decimals
+// the database stores.
+const defaultStepSize = 1e-8
+
+// fillable returns how much of the order can still fill at price: its remaining quantity, or for
+// a quote-denominated order as many whole steps as its remaining quote buys there.
+func (o *bookOrder) fillable(price, step float64) float64 {
+	if o.QuoteQuantity == 0 {
+		return o.Remaining
+	}
+	if step <= 0 {
+		step = defaultStepSize
+	}
+	// The epsilon keeps float rounding from costing an exact multiple its last step
+	return math.Floor(o.RemainingQuote/price/step+1e-9) * step
+}
+
+// fill counts down what the order has left by a fill of quantity at price.
+func (o *bookOrder) fill(quantity, price float64) {
+	if o.QuoteQuantity == 0 {
+		o.Remaining -= quantity
+		return
+	}
+	o.RemainingQuote = math.Max(o.RemainingQuote-quantity*price, 0)
+}
+
+// shown returns how much of a resting order shows on the book and can fill before it has to
+// queue again: the current slice of an iceberg order, all of what is left of any other.
+func (o *bookOrder) shown() float64 {
+	if o.DisplayQuantity > 0 {
+		return o.Visible
+	}
+	return o.Remaining
+}
+
+// refresh shows the next slice of an iceberg order from its hidden reserve.
+func (o *bookOrder) refresh() {
+	if o.DisplayQuantity > 0 {
+		o.Visible = math.Min(o.DisplayQuantity, o.Remaining)
+	}
+}
+
+// expired describes the order leaving the book with what it has left.
+func (o *bookOrder) expired(reason string) *ExpiredOrder {
+	return &ExpiredOrder{Order: o.Order, Quantity: o.Remaining, Quote: o.RemainingQuote, Reason: reason}
+}
 
 // OrderBook represents the order book for a single trading pair.
+// Each side keeps its resting orders in FIFO queues per price level,
+// with the active levels in a heap so the best price is always on top (see price_level.go).
 type OrderBook struct {
 	symbol string
 	mu     sync.RWMutex
-	// Using simple slices and sorting for now.
-	// For performance, consider using heaps or balanced trees.
-	Bids []*models.Order // Sorted descending by price
-	Asks []*models.Order // Sorted ascending by price
+	bids   *bookSide // Best (highest) price on top
+	asks   *bookSide // Best (lowest) price on top
+
+	// Stop orders parked until the last traded price crosses their StopPrice.
+	// They are not part of bids/asks, so they don't show up in GetDepth.
+	Stops []*bookOrder
 
 	// Optional: Map for quick order lookup by ID for cancellation
-	Orders map[uuid.UUID]*models.Order
+	Orders map[uuid.UUID]*bookOrder
+
+	// SelfTradePolicy applies when an incoming order meets a resting order of the same user.
+	SelfTradePolicy SelfTradePolicy
+
+	// StepSize is the market's quantity granularity, in which quote-denominated orders buy.
+	// 0 means defaultStepSize.
+	StepSize float64
+
+	// MaxSlippage is the largest fraction by which a market order's trades are moved against the
+	// taker from the resting order's price, picked at random per trade. Only set in sandbox mode,
+	// for clients to test against; 0 (the default) trades at the resting price. See tradePrice.
+	MaxSlippage float64
+
+	lastPrice float64 // Price of the most recent trade on this book, 0 until the first trade
+	halted    bool    // No new orders or replacements while set, see SetHalted
+
+	// OnDepthUpdate, if set, is called with the changed price levels after every operation
+	// that modifies the book. It runs with the book's lock held and must not block.
+	OnDepthUpdate func(update *DepthUpdate)
+
+	// Events, if set, receives every change to the book as it is made, see events.go.
+	Events   EventSink
+	eventSeq uint64 // Sequence number of the last event, see Event.Seq
+
+	seq        uint64               // Depth sequence number, incremented once per published update
+	changedBid map[float64]struct{} // Bid prices touched by the current operation
+	changedAsk map[float64]struct{} // Ask prices touched by the current operation
+
+	tradeSeq uint64 // Sequence number of the last trade, see Trade.Seq
+
+	// The most recent trades and depth updates (at most historySize each, oldest first),
+	// for clients catching up after a reconnect, see TradesSince and DepthUpdatesSince
+	recentTrades  []*Trade
+	recentUpdates []*DepthUpdate
+
+	// Operations waiting for the book's matching goroutine, see startMatching. Nil without one.
+	queue chan func()
+
+	// Reaping of idle books, see retire
+	pending    atomic.Int64 // Operations queued or running
+	retired    atomic.Bool  // Set once the matching goroutine is stopped
+	lastActive atomic.Int64 // Unix nanoseconds of the last operation queued, or of creation
 }
 
+// historySize is how many recent trades and depth updates a book keeps for gap recovery.
+const historySize = 1000
+
 // NewOrderBook creates a new order book for a given symbol.
 func NewOrderBook(symbol string) *OrderBook {
-	return &OrderBook{
+	ob := &OrderBook{
 		symbol: symbol,
-		Bids:   make([]*models.Order, 0),
-		Asks:   make([]*models.Order, 0),
-		Orders: make(map[uuid.UUID]*models.Order),
+		bids:   newBookSide(true),
+		asks:   newBookSide(false),
+		Stops:  make([]*bookOrder, 0),
+		Orders: make(map[uuid.UUID]*bookOrder),
+
+		SelfTradePolicy: CancelNewest,
+
+		changedBid: make(map[float64]struct{}),
+		changedAsk: make(map[float64]struct{}),
 	}
+	ob.lastActive.Store(time.Now().UnixNano())
+	return ob
 }
 
 // AddOrder adds a new order to the book and triggers matching.
-// Returns a list of trades executed.
-func (ob *OrderBook) AddOrder(order *models.Order) ([]*Trade, error) {
+// Market orders match at any price, ignoring their Price, and never rest: an unfilled
+// remainder is discarded and reported in the result's Expired orders (reason "market"),
+// for the caller to unlock its funds. One with a ProtectionPrice stops matching there, and its
+// remainder is reported with reason "protection" if the book had more beyond it. A quote-denominated market buy spends its QuoteQuantity
+// on whole steps of the base asset and is always reported there, with its unspent quote.
+// Stop orders are parked until the last traded price crosses their stop price.
+// IOC orders never rest: whatever doesn't fill immediately is discarded.
+// FOK orders execute in full or not at all.
+// Post-only orders that would match on entry are rejected with ErrPostOnlyWouldCross.
+// Returns the trades executed, including trades from any stops it triggered,
+// and every order that left the book with quantity unfilled.
+// The book keeps the order and tracks its fills apart from it: it enters the book with its Quantity
+// less FilledQuantity remaining, and fills don't change either.
+func (ob *OrderBook) AddOrder(model *models.Order) (*MatchResult, error) {
 	ob.mu.Lock()
 	defer ob.mu.Unlock()
+	defer ob.publishDepth()
+
+	if ob.halted {
+		return nil, ErrSymbolHalted
+	}
+
+	order := newBookOrder(model)
 
 	// Basic validation (ensure correct symbol, type)
 	if order.Symbol != ob.symbol {
 		return nil, fmt.Errorf("order symbol %s does not match book symbol %s", order.Symbol, ob.symbol)
 	}
-	if order.Type != "limit" {
-		// Only limit orders can rest on the book
-		// TODO: Handle market orders - they would match immediately without resting.
-		return nil, fmt.Errorf("only limit orders can be added directly to the book")
+	if order.Type != "limit" && order.Type != "market" && !isStopOrder(order.Order) {
+		return nil, fmt.Errorf("unsupported order type %q", order.Type)
+	}
+	if order.Type == "market" && order.PostOnly {
+		// A market order always takes liquidity
+		return nil, fmt.Errorf("market orders can't be post-only")
 	}
 
 	// Check if order already exists (e.g., resubmission attempt?)
@@ -68,219 +254,1036 @@ func (ob *OrderBook) AddOrder(order *models.Order) ([]*Trade, error) {
 		return nil, fmt.Errorf("order %s already exists in the book", order.ID)
 	}
 
+	if order.PostOnly && ob.wouldCross(order) {
+		// Would execute as a taker, reject it to guarantee maker status
+		return nil, ErrPostOnlyWouldCross
+	}
+
+	// Accepted: whatever happens to it from here on follows from the book's state
+	submitted := *model
+	ob.record(&Event{Type: EventAdd, Order: &submitted})
+
+	result := &MatchResult{Trades: make([]*Trade, 0), Expired: make([]*ExpiredOrder, 0)}
+
+	if order.TimeInForce == "FOK" && ob.fillableQuantity(order) < order.Remaining {
+		// Can't be filled in full, kill it without executing anything
+		result.expire(order, "fok")
+		return result, nil
+	}
+
 	// Add to lookup map
 	ob.Orders[order.ID] = order
 
-	// TODO: Implement Matching Logic Here
-	trades := ob.matchOrder(order)
-
-	// If the order is not fully filled, add the remainder to the book
-	if order.Quantity > 0 { // Assuming Quantity represents remaining quantity
-		if order.Side == "buy" {
-			ob.addBid(order)
-		} else {
-			ob.addAsk(order)
+	if isStopOrder(order.Order) {
+		if !ob.stopTriggered(order) {
+			ob.Stops = append(ob.Stops, order)
+			return result, nil
 		}
+		// Stop is already through the last price, activate it straight away
+		activateStop(order)
 	}
 
+	ob.execute(order, result)
+	ob.triggerStops(result)
+
 	// TODO: Update order status (e.g., partially_filled, filled) based on trades
 	// This should likely happen outside the order book, maybe in a service layer
 	// that calls the DB updates after getting trades from the book.
 
-	return trades, nil
+	return result, nil
+}
+
+// execute matches an active (limit or market) order and rests any limit remainder.
+// Must be called with the write lock held.
+func (ob *OrderBook) execute(order *bookOrder, result *MatchResult) {
+	tradesBefore := len(result.Trades)
+	selfTradeCancelled := ob.matchOrder(order, result)
+	if len(result.Trades) > tradesBefore {
+		ob.lastPrice = result.Trades[len(result.Trades)-1].Price
+	}
+
+	// If the order is not fully filled, add the remainder to the book
+	switch {
+	case order.Remaining == 0 && order.QuoteQuantity == 0:
+		// Fully filled, nothing left to track
+		delete(ob.Orders, order.ID)
+	case selfTradeCancelled:
+		delete(ob.Orders, order.ID)
+		result.expire(order, "self_trade")
+	case order.Type == "market":
+		// Market orders never rest; the unfilled remainder is dropped from the book.
+		// So is the unspent quote of a quote-denominated one, even if only dust.
+		delete(ob.Orders, order.ID)
+		reason := "market"
+		if ob.stoppedByProtection(order) {
+			reason = "protection"
+		}
+		result.expire(order, reason)
+	case order.TimeInForce == "IOC" || order.TimeInForce == "FOK":
+		// Neither do IOC/FOK orders
+		delete(ob.Orders, order.ID)
+		result.expire(order, strings.ToLower(order.TimeInForce))
+	case order.Side == "buy":
+		order.refresh()
+		ob.bids.add(order)
+		ob.touch(order.Side, order.Price)
+	default:
+		order.refresh()
+		ob.asks.add(order)
+		ob.touch(order.Side, order.Price)
+	}
+}
+
+// triggerStops activates every parked stop crossed by the last traded price.
+// Trades from an activated stop can move the price and trigger further stops,
+// so this loops until no parked stop is triggered.
+// Must be called with the write lock held.
+func (ob *OrderBook) triggerStops(result *MatchResult) {
+	for {
+		i := ob.nextTriggeredStop()
+		if i < 0 {
+			return
+		}
+		stop := ob.Stops[i]
+		ob.Stops = append(ob.Stops[:i], ob.Stops[i+1:]...)
+		activateStop(stop)
+		ob.execute(stop, result)
+	}
+}
+
+// nextTriggeredStop returns the index of the oldest triggered stop, or -1.
+func (ob *OrderBook) nextTriggeredStop() int {
+	for i, stop := range ob.Stops {
+		if ob.stopTriggered(stop) {
+			return i
+		}
+	}
+	return -1
+}
+
+// stopTriggered reports whether the last traded price has crossed the stop price.
+// Buy stops trigger when the price rises to the stop, sell stops when it falls to it.
+func (ob *OrderBook) stopTriggered(order *bookOrder) bool {
+	if ob.lastPrice <= 0 {
+		return false // No trades yet, nothing to compare against
+	}
+	if order.Side == "buy" {
+		return ob.lastPrice >= order.StopPrice
+	}
+	return ob.lastPrice <= order.StopPrice
+}
+
+// isStopOrder reports whether the order is a (not yet triggered) stop order.
+func isStopOrder(order *models.Order) bool {
+	return order.Type == "stop" || order.Type == "stop_limit"
+}
+
+// activateStop converts a triggered stop into the order type it becomes on the book:
+// "stop" becomes a market order, "stop_limit" becomes a limit order at its Price.
+func activateStop(order *bookOrder) {
+	if order.Type == "stop" {
+		order.Type = "market"
+	} else {
+		order.Type = "limit"
+	}
 }
 
 // matchOrder attempts to match the incoming order against the resting orders.
-// Modifies the incoming order's quantity and returns executed trades.
-// NOTE: This is a simplified placeholder implementation.
-func (ob *OrderBook) matchOrder(incomingOrder *models.Order) []*Trade {
-	trades := make([]*Trade, 0)
-	if incomingOrder.Side == "buy" {
-		// Match against asks (lowest price first)
-		for i := 0; i < len(ob.Asks) && incomingOrder.Quantity > 0; {
-			ask := ob.Asks[i]
-			if incomingOrder.Price >= ask.Price { // Match possible
-				matchQuantity := math.Min(incomingOrder.Quantity, ask.Quantity)
-				trade := &Trade{
-					TakerOrderID: incomingOrder.ID,
-					MakerOrderID: ask.ID,
-					Symbol:       ob.symbol,
-					Price:        ask.Price, // Trade occurs at the resting order's price
-					Quantity:     matchQuantity,
-					Timestamp:    time.Now(),
+// Levels are consumed best price first and orders within a level oldest first. A resting iceberg
+// order only fills up to its visible slice at a time: once that is gone, the next slice is
+// shown and goes to the back of the queue at its price, behind the orders already there.
+// Counts down what the orders involved have left and appends executed trades to the result.
+// When the incoming order meets a resting order of the same user the book's SelfTradePolicy applies;
+// returns true if that policy cancelled the incoming order's remainder.
+func (ob *OrderBook) matchOrder(incomingOrder *bookOrder, result *MatchResult) bool {
+	opposite, oppositeSide := ob.asks, "sell" // A buy matches against asks (lowest price first)
+	if incomingOrder.Side != "buy" {
+		opposite, oppositeSide = ob.bids, "buy" // A sell matches against bids (highest price first)
+	}
+
+	for {
+		level := opposite.best()
+		if level == nil || !crosses(incomingOrder, level.price) || incomingOrder.fillable(level.price, ob.StepSize) <= 0 {
+			// Book side is empty, the best price is out of reach or the order is done, no more matches
+			break
+		}
+		ob.touch(oppositeSide, level.price)
+
+		for level.orders.Len() > 0 {
+			price := ob.tradePrice(incomingOrder, level.price)
+			fillable := incomingOrder.fillable(price, ob.StepSize)
+			if fillable <= 0 {
+				break
+			}
+			front := level.orders.Front()
+			resting := front.Value.(*bookOrder)
+
+			if resting.UserID == incomingOrder.UserID {
+				// Self-trade: never match, apply the policy instead
+				if ob.SelfTradePolicy == CancelOldest || ob.SelfTradePolicy == CancelBoth {
+					delete(ob.Orders, resting.ID)
+					level.orders.Remove(front)
+					result.expire(resting, "self_trade")
 				}
-				trades = append(trades, trade)
-
-				incomingOrder.Quantity -= matchQuantity
-				ask.Quantity -= matchQuantity
-
-				if ask.Quantity == 0 {
-					// Remove filled ask order
-					delete(ob.Orders, ask.ID)
-					ob.Asks = append(ob.Asks[:i], ob.Asks[i+1:]...)
-					// Don't increment i, the next element is now at index i
-				} else {
-					i++ // Move to next ask
+				if ob.SelfTradePolicy != CancelOldest {
+					if level.orders.Len() == 0 {
+						opposite.removeLevel(level)
+					}
+					return true
+				}
+				continue
+			}
+
+			matchQuantity := math.Min(fillable, resting.shown())
+			ob.tradeSeq++
+			trade := &Trade{
+				ID:              uuid.New(),
+				Seq:             ob.tradeSeq,
+				TakerOrderID:    incomingOrder.ID,
+				MakerOrderID:    resting.ID,
+				Symbol:          ob.symbol,
+				Side:            incomingOrder.Side,
+				Price:           price, // The resting order's price, unless slipped
+				Quantity:        matchQuantity,
+				Timestamp:       time.Now(),
+				TakerLimitPrice: LockPrice(incomingOrder.Order),
+				MakerLimitPrice: level.price,
+			}
+			result.Trades = append(result.Trades, trade)
+			ob.recentTrades = appendBounded(ob.recentTrades, trade)
+			ob.record(&Event{Type: EventMatch, Trade: trade})
+
+			incomingOrder.fill(matchQuantity, price)
+			resting.fill(matchQuantity, price)
+
+			if resting.Remaining == 0 {
+				// Remove filled resting order
+				delete(ob.Orders, resting.ID)
+				level.orders.Remove(front)
+			} else if resting.DisplayQuantity > 0 {
+				resting.Visible -= matchQuantity
+				if resting.Visible <= 0 {
+					// Slice used up: the next one loses its place like a new order would
+					resting.refresh()
+					level.orders.MoveToBack(front)
 				}
-			} else {
-				// Incoming bid price is lower than the best ask, no more matches
-				break
 			}
 		}
-	} else { // Incoming order is a sell
-		// Match against bids (highest price first)
-		for i := 0; i < len(ob.Bids) && incomingOrder.Quantity > 0; {
-			bid := ob.Bids[i]
-			if incomingOrder.Price <= bid.Price { // Match possible
-				matchQuantity := math.Min(incomingOrder.Quantity, bid.Quantity)
-				trade := &Trade{
-					TakerOrderID: incomingOrder.ID,
-					MakerOrderID: bid.ID,
-					Symbol:       ob.symbol,
-					Price:        bid.Price, // Trade occurs at the resting order's price
-					Quantity:     matchQuantity,
-					Timestamp:    time.Now(),
+
+		if level.orders.Len() == 0 {
+			opposite.removeLevel(level)
+		}
+	}
+	return false
+}
+
+// wouldCross reports whether the order would match against the best opposite level on entry.
+// Must be called with the lock held.
+func (ob *OrderBook) wouldCross(order *bookOrder) bool {
+	opposite := ob.asks
+	if order.Side != "buy" {
+		opposite = ob.bids
+	}
+	best := opposite.best()
+	return best != nil && crosses(order, best.price)
+}
+
+// fillableQuantity returns how much of the order could execute against the book right now,
+// capped at its remaining quantity. Only the total matters, so levels are visited in any order.
+// The hidden part of iceberg orders counts, as it is shown and filled within the same match.
+// Resting orders of the same user never fill it. Under cancel_oldest they are skipped; under the
+// other policies matching stops at the first one in price-time order, so only what is ahead of it
+// counts: the levels better than its own and, at its level, the shown part of the orders queued
+// before it, as the hidden part of an iceberg queues again behind it.
+// Must be called with the lock held.
+func (ob *OrderBook) fillableQuantity(order *bookOrder) float64 {
+	opposite := ob.asks
+	if order.Side != "buy" {
+		opposite = ob.bids
+	}
+	better := func(a, b float64) bool {
+		if opposite.heap.descending {
+			return a > b
+		}
+		return a < b
+	}
+
+	// The best level holding an order of the user, where a stopping policy ends the match
+	var stop *priceLevel
+	if ob.SelfTradePolicy != CancelOldest {
+		for price, level := range opposite.levels {
+			if !crosses(order, price) || (stop != nil && !better(price, stop.price)) {
+				continue
+			}
+			for e := level.orders.Front(); e != nil; e = e.Next() {
+				if e.Value.(*bookOrder).UserID == order.UserID {
+					stop = level
+					break
 				}
-				trades = append(trades, trade)
-
-				incomingOrder.Quantity -= matchQuantity
-				bid.Quantity -= matchQuantity
-
-				if bid.Quantity == 0 {
-					// Remove filled bid order
-					delete(ob.Orders, bid.ID)
-					ob.Bids = append(ob.Bids[:i], ob.Bids[i+1:]...)
-					// Don't increment i
-				} else {
-					i++ // Move to next bid
+			}
+		}
+	}
+
+	fillable := 0.0
+	for price, level := range opposite.levels {
+		if !crosses(order, price) || (stop != nil && better(stop.price, price)) {
+			continue
+		}
+		for e := level.orders.Front(); e != nil; e = e.Next() {
+			resting := e.Value.(*bookOrder)
+			if resting.UserID == order.UserID {
+				if level == stop {
+					break
 				}
+				continue
+			}
+			if level == stop {
+				fillable += resting.shown()
 			} else {
-				// Incoming ask price is higher than the best bid, no more matches
-				break
+				fillable += resting.Remaining
+			}
+			if fillable >= order.Remaining {
+				return order.Remaining
+			}
+		}
+	}
+	return fillable
+}
+
+// LockPrice returns the price a buy locks quote at for each unit of its quantity: its limit price,
+// or for a market (or stop) buy sized in the base asset its ProtectionPrice, which it never trades
+// beyond. 0 for a quote-denominated order, which locks its QuoteQuantity, and a market order
+// without protection, which can't lock anything up front.
+func LockPrice(order *models.Order) float64 {
+	if order.QuoteQuantity > 0 {
+		return 0
+	}
+	if order.Type == "market" || order.Type == "stop" {
+		return order.ProtectionPrice
+	}
+	return order.Price
+}
+
+// crosses reports whether the incoming order can trade at the given resting price.
+// Market orders take any price, up to their ProtectionPrice if they have one.
+func crosses(incomingOrder *bookOrder, price float64) bool {
+	limit := incomingOrder.Price
+	if incomingOrder.Type == "market" {
+		if incomingOrder.ProtectionPrice == 0 {
+			return true
+		}
+		limit = incomingOrder.ProtectionPrice
+	}
+	if incomingOrder.Side == "buy" {
+		return limit >= price
+	}
+	return limit <= price
+}
+
+// tradePrice returns the price at which the incoming order trades with a resting order at price:
+// that price, or for a market order on a book with MaxSlippage a random amount worse for the
+// taker, though never beyond its ProtectionPrice. Slipped prices are rounded to the 8 decimals
+// the database stores.
+func (ob *OrderBook) tradePrice(incomingOrder *bookOrder, price float64) float64 {
+	if ob.MaxSlippage <= 0 || incomingOrder.Type != "market" {
+		return price
+	}
+	slipped := price * (1 + ob.MaxSlippage*rand.Float64())
+	if incomingOrder.Side == "sell" {
+		slipped = price * (1 - ob.MaxSlippage*rand.Float64())
+	}
+	slipped = math.Round(slipped*1e8) / 1e8
+	if !crosses(incomingOrder, slipped) {
+		slipped = incomingOrder.ProtectionPrice
+	}
+	return slipped
+}
+
+// stoppedByProtection reports whether a market order that is done matching was stopped by its
+// ProtectionPrice: the best opposite level is beyond it and the order could still have filled there.
+// Must be called with the lock held.
+func (ob *OrderBook) stoppedByProtection(order *bookOrder) bool {
+	if order.ProtectionPrice == 0 {
+		return false
+	}
+	opposite := ob.asks
+	if order.Side != "buy" {
+		opposite = ob.bids
+	}
+	level := opposite.best()
+	return level != nil && !crosses(order, level.price) && order.fillable(level.price, ob.StepSize) > 0
+}
+
+// SimulatedMatch is an estimate of how an order would execute, see SimulateMatch.
+type SimulatedMatch struct {
+	FilledQuantity float64 `json:"filled_quantity"`
+	AvgPrice       float64 `json:"avg_price"`                 // Volume-weighted price of the fills, 0 without any
+	Remaining      float64 `json:"remaining"`                 // Quantity left unfilled
+	RemainingQuote float64 `json:"remaining_quote,omitempty"` // Quote left unspent, quote-denominated orders only
+	Resting        float64 `json:"resting"`                   // Part of Remaining that would rest on the book, the rest is discarded
+	StopPending    bool    `json:"stop_pending"`              // A stop order waiting for its stop price: nothing executes yet
+}
+
+// SimulateMatch estimates how the order would execute if it were added to the book now, by the
+// same rules as AddOrder, without changing anything: it only takes the read lock.
+// It fails with ErrSymbolHalted or ErrPostOnlyWouldCross where AddOrder would reject the order.
+// Only an estimate: orders queued for matching before it, and stops its own trades would trigger,
+// can change the outcome.
+func (ob *OrderBook) SimulateMatch(model *models.Order) (*SimulatedMatch, error) {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+
+	if ob.halted {
+		return nil, ErrSymbolHalted
+	}
+	order := copyOrder(newBookOrder(model)) // A triggered stop changes type, see activateStop
+	sim := &SimulatedMatch{Remaining: order.Remaining, RemainingQuote: order.RemainingQuote}
+	if isStopOrder(order.Order) {
+		if !ob.stopTriggered(order) {
+			sim.StopPending = true
+			return sim, nil
+		}
+		activateStop(order)
+	}
+	if order.PostOnly && ob.wouldCross(order) {
+		return nil, ErrPostOnlyWouldCross
+	}
+	if order.TimeInForce == "FOK" && ob.fillableQuantity(order) < order.Remaining {
+		return sim, nil // Killed without executing anything
+	}
+
+	opposite := ob.asks
+	if order.Side != "buy" {
+		opposite = ob.bids
+	}
+	notional := 0.0
+	selfTradeCancelled := false
+levels:
+	for _, level := range opposite.sortedLevels() {
+		if !crosses(order, level.price) {
+			break
+		}
+		for e := level.orders.Front(); e != nil; e = e.Next() {
+			fillable := order.fillable(level.price, ob.StepSize)
+			if fillable <= 0 {
+				break levels
+			}
+			resting := e.Value.(*bookOrder)
+			if resting.UserID == order.UserID {
+				if ob.SelfTradePolicy == CancelOldest {
+					continue // The resting order would be cancelled instead
+				}
+				selfTradeCancelled = true
+				break levels
 			}
+			quantity := math.Min(fillable, resting.Remaining) // Including an iceberg's hidden part, though it would queue again
+			sim.FilledQuantity += quantity
+			order.fill(quantity, level.price) // order is a copy
+			notional += quantity * level.price
 		}
 	}
-	return trades
+	sim.Remaining, sim.RemainingQuote = order.Remaining, order.RemainingQuote
+	if sim.FilledQuantity > 0 {
+		sim.AvgPrice = notional / sim.FilledQuantity
+	}
+	if sim.Remaining > 0 && !selfTradeCancelled && order.Type == "limit" && order.TimeInForce == "GTC" {
+		sim.Resting = sim.Remaining
+	}
+	return sim, nil
 }
 
-// addBid inserts a bid order into the sorted Bids slice.
-func (ob *OrderBook) addBid(order *models.Order) {
-	// Find insertion point to maintain sort order (descending price)
-	i := sort.Search(len(ob.Bids), func(j int) bool { return ob.Bids[j].Price <= order.Price })
-	ob.Bids = append(ob.Bids, nil)   // Make space
-	copy(ob.Bids[i+1:], ob.Bids[i:]) // Shift elements right
-	ob.Bids[i] = order               // Insert
+// Quote is the estimated execution of a market order against the book, see OrderBook.Quote.
+type Quote struct {
+	Symbol         string  `json:"symbol"`
+	Seq            uint64  `json:"seq"` // Depth sequence number the quote is based on
+	Side           string  `json:"side"`
+	Quantity       float64 `json:"quantity"`        // As requested
+	FilledQuantity float64 `json:"filled_quantity"` // Less than Quantity if the book is too thin to fill it all
+	AvgPrice       float64 `json:"avg_price"`       // Volume-weighted price of the fills, 0 without any
+	Cost           float64 `json:"cost"`            // Quote asset paid (buy) or received (sell) for FilledQuantity, before fees
+	WorstPrice     float64 `json:"worst_price"`     // Price of the last level reached, 0 without any
 }
 
-// addAsk inserts an ask order into the sorted Asks slice.
-func (ob *OrderBook) addAsk(order *models.Order) {
-	// Find insertion point to maintain sort order (ascending price)
-	i := sort.Search(len(ob.Asks), func(j int) bool { return ob.Asks[j].Price >= order.Price })
-	ob.Asks = append(ob.Asks, nil)   // Make space
-	copy(ob.Asks[i+1:], ob.Asks[i:]) // Shift elements right
-	ob.Asks[i] = order               // Insert
+// Quote estimates how a market order of quantity on side ("buy" or "sell") would fill: it takes
+// the opposite side's levels best price first, under the read lock, without changing anything.
+// Only an estimate: self-trade prevention and orders queued for matching can change the outcome.
+func (ob *OrderBook) Quote(side string, quantity float64) *Quote {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+
+	opposite := ob.asks
+	if side != "buy" {
+		opposite = ob.bids
+	}
+	quote := &Quote{Symbol: ob.symbol, Seq: ob.seq, Side: side, Quantity: quantity}
+	for _, level := range opposite.sortedLevels() {
+		if quote.FilledQuantity >= quantity {
+			break
+		}
+		fill := math.Min(levelQuantity(level), quantity-quote.FilledQuantity)
+		quote.FilledQuantity += fill
+		quote.Cost += fill * level.price
+		quote.WorstPrice = level.price
+	}
+	if quote.FilledQuantity > 0 {
+		quote.AvgPrice = quote.Cost / quote.FilledQuantity
+	}
+	return quote
 }
 
-// CancelOrder removes an order from the book.
-func (ob *OrderBook) CancelOrder(orderID uuid.UUID) (*models.Order, error) {
+// CancelOrder removes an order from the book and returns the quantity it had left unfilled.
+func (ob *OrderBook) CancelOrder(orderID uuid.UUID) (float64, error) {
 	ob.mu.Lock()
 	defer ob.mu.Unlock()
+	defer ob.publishDepth()
 
 	order, exists := ob.Orders[orderID]
 	if !exists {
-		return nil, fmt.Errorf("order %s not found in book", orderID)
+		return 0, fmt.Errorf("order %s not found in book", orderID)
 	}
+	ob.record(&Event{Type: EventCancel, OrderID: orderID})
 
 	// Remove from lookup map
 	delete(ob.Orders, orderID)
 
-	// Remove from Bids or Asks slice
-	if order.Side == "buy" {
-		for i, bid := range ob.Bids {
-			if bid.ID == orderID {
-				ob.Bids = append(ob.Bids[:i], ob.Bids[i+1:]...)
+	// Remove from Stops, bids or asks
+	if isStopOrder(order.Order) {
+		for i, stop := range ob.Stops {
+			if stop.ID == orderID {
+				ob.Stops = append(ob.Stops[:i], ob.Stops[i+1:]...)
 				break
 			}
 		}
+	} else if order.Side == "buy" {
+		ob.bids.remove(order)
+		ob.touch(order.Side, order.Price)
 	} else {
-		for i, ask := range ob.Asks {
-			if ask.ID == orderID {
-				ob.Asks = append(ob.Asks[:i], ob.Asks[i+1:]...)
-				break
-			}
+		ob.asks.remove(order)
+		ob.touch(order.Side, order.Price)
+	}
+
+	return order.Remaining, nil
+}
+
+// GetOrder returns a copy of a live order as it currently stands in the book and the quantity
+// it has left unfilled, and false if it is not in the book.
+func (ob *OrderBook) GetOrder(orderID uuid.UUID) (models.Order, float64, bool) {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+	order, exists := ob.Orders[orderID]
+	if !exists {
+		return models.Order{}, 0, false
+	}
+	return *order.Order, order.Remaining, true
+}
+
+// ReplaceOrder changes the price and remaining quantity of a resting limit order. The order's
+// Quantity changes by as much as its remaining quantity, as what has filled stays filled.
+// expectedRemaining is the remaining quantity the caller based its decision on;
+// if the order has filled since, nothing is changed and ErrOrderChanged is returned.
+//
+// Time priority: the order keeps its place in the queue only if its price is unchanged
+// and its quantity is not increased. Otherwise it is re-entered behind the orders already
+// resting at its (new) price, and a new price that crosses the book matches right away
+// like an incoming order. A post-only order that would cross is rejected with ErrPostOnlyWouldCross.
+func (ob *OrderBook) ReplaceOrder(orderID uuid.UUID, expectedRemaining, price, quantity float64) (*MatchResult, error) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	defer ob.publishDepth()
+
+	if ob.halted {
+		return nil, ErrSymbolHalted
+	}
+	order, exists := ob.Orders[orderID]
+	if !exists {
+		return nil, fmt.Errorf("order %s not found in book", orderID)
+	}
+	if order.Type != "limit" {
+		return nil, fmt.Errorf("only resting limit orders can be replaced, order %s is %s", orderID, order.Type)
+	}
+	if order.Remaining != expectedRemaining {
+		return nil, ErrOrderChanged
+	}
+	// The book's own copy of the order, see Manager.EnqueueOrder
+	model := order.Order
+
+	result := &MatchResult{Trades: make([]*Trade, 0), Expired: make([]*ExpiredOrder, 0)}
+
+	inPlace := price == order.Price && quantity <= order.Remaining
+	if !inPlace && order.PostOnly {
+		moved := *model
+		moved.Price = price
+		if ob.wouldCross(&bookOrder{Order: &moved}) {
+			return nil, ErrPostOnlyWouldCross
 		}
 	}
+	ob.record(&Event{Type: EventReplace, OrderID: orderID, Remaining: order.Remaining, Price: price, Quantity: quantity})
+
+	if inPlace {
+		// Shrinking in place keeps the order's place in the queue
+		model.Quantity += quantity - order.Remaining
+		order.Remaining = quantity
+		order.Visible = math.Min(order.Visible, quantity)
+		ob.touch(order.Side, order.Price)
+		return result, nil
+	}
 
-	return order, nil
+	// Loses priority: take it out and bring it back in as if it just arrived
+	if order.Side == "buy" {
+		ob.bids.remove(order)
+	} else {
+		ob.asks.remove(order)
+	}
+	ob.touch(order.Side, order.Price)
+	model.Price = price
+	model.Quantity += quantity - order.Remaining
+	order.Remaining = quantity
+
+	ob.execute(order, result)
+	ob.triggerStops(result)
+	return result, nil
 }
 
-// GetDepth returns a snapshot of the order book depth (e.g., top N levels).
+// BookLevel is one aggregated price level of an OrderBookDepth.
 type BookLevel struct {
 	Price    float64 `json:"price"`
 	Quantity float64 `json:"quantity"`
 }
 
+// OrderBookDepth is a snapshot of the order book depth, see GetDepth.
 type OrderBookDepth struct {
+	Symbol   string      `json:"symbol"`
+	Seq      uint64      `json:"seq"`      // Sequence number of the last depth update included
+	Halted   bool        `json:"halted"`   // Trading is halted, see OrderBook.SetHalted
+	Bids     []BookLevel `json:"bids"`     // Aggregated bids [price, total_quantity]
+	Asks     []BookLevel `json:"asks"`     // Aggregated asks [price, total_quantity]
+	Checksum uint32      `json:"checksum"` // Of the top ChecksumLevels levels, however many are listed, see Checksum
+}
+
+// RawOrder is one resting order in a RawBook. It carries no owner, and OrderID is
+// left out unless the book is published with order ids.
+type RawOrder struct {
+	OrderID  *uuid.UUID `json:"order_id,omitempty"`
+	Price    float64    `json:"price"`
+	Quantity float64    `json:"quantity"` // Remaining, or the visible slice of an iceberg order
+}
+
+// RawBook is a snapshot of the individual resting orders of a book, see GetRawBook.
+type RawBook struct {
+	Symbol string     `json:"symbol"`
+	Seq    uint64     `json:"seq"` // Sequence number of the last depth update included
+	Halted bool       `json:"halted"`
+	Bids   []RawOrder `json:"bids"` // Best price first, in time priority within a price
+	Asks   []RawOrder `json:"asks"`
+}
+
+// LiveOrder is an order as a book holds it, for admins, see GetLiveOrders.
+type LiveOrder struct {
+	OrderID   uuid.UUID `json:"order_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	Side      string    `json:"side"`
+	Type      string    `json:"type"` // What the order is on the book: a triggered stop shows as market or limit
+	Price     float64   `json:"price"`
+	StopPrice float64   `json:"stop_price,omitempty"`
+	Parked    bool      `json:"parked"`            // A stop waiting for its trigger, not on the bids or asks
+	Remaining float64   `json:"remaining"`         // Quantity not yet filled, hidden iceberg reserve included
+	Visible   float64   `json:"visible,omitempty"` // Iceberg orders only: the slice on show
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// LiveOrders is every order a book holds, see GetLiveOrders.
+type LiveOrders struct {
 	Symbol string      `json:"symbol"`
-	Bids   []BookLevel `json:"bids"` // Aggregated bids [price, total_quantity]
-	Asks   []BookLevel `json:"asks"` // Aggregated asks [price, total_quantity]
+	Seq    uint64      `json:"seq"` // Sequence number of the last depth update included
+	Halted bool        `json:"halted"`
+	Orders []LiveOrder `json:"orders"` // Bids best price first, then asks best price first, oldest first within a price
+}
+
+// BookTicker is the top of an order book. BestBid, BestAsk and Spread are nil when the side
+// they need is empty.
+type BookTicker struct {
+	Symbol  string     `json:"symbol"`
+	Seq     uint64     `json:"seq"`
+	BestBid *BookLevel `json:"best_bid"`
+	BestAsk *BookLevel `json:"best_ask"`
+	Spread  *float64   `json:"spread"` // BestAsk.Price - BestBid.Price
+}
+
+// DepthUpdate lists the price levels changed by one operation on the book.
+// Each level carries its new aggregate quantity; a quantity of 0 means the level was removed.
+// Seq increases by exactly one per update, so a client that sees a gap
+// (or a snapshot with a higher Seq) knows it missed updates and must re-snapshot.
+// An update merged from several (see Merge) covers FirstSeq through Seq.
+type DepthUpdate struct {
+	Type     string      `json:"type"` // Always "depth_update"
+	Symbol   string      `json:"symbol"`
+	FirstSeq uint64      `json:"first_seq,omitempty"` // Only set on merged updates, otherwise the update covers just Seq
+	Seq      uint64      `json:"seq"`
+	Bids     []BookLevel `json:"bids"`
+	Asks     []BookLevel `json:"asks"`
+	Checksum uint32      `json:"checksum"` // Of the book with the update applied, see Checksum
+}
+
+// Merge returns a new update with the combined effect of u followed by next, which must be the
+// update right after u for the same book: each changed level appears once, with its latest quantity.
+// Neither u nor next is modified, as they may be shared with other subscribers and the history.
+func (u *DepthUpdate) Merge(next *DepthUpdate) *DepthUpdate {
+	first := u.FirstSeq
+	if first == 0 {
+		first = u.Seq
+	}
+	return &DepthUpdate{
+		Type:     u.Type,
+		Symbol:   u.Symbol,
+		FirstSeq: first,
+		Seq:      next.Seq,
+		Bids:     mergeLevels(u.Bids, next.Bids),
+		Asks:     mergeLevels(u.Asks, next.Asks),
+		Checksum: next.Checksum,
+	}
+}
+
+// mergeLevels combines two lists of changed levels, the quantities in later replacing those in earlier.
+func mergeLevels(earlier, later []BookLevel) []BookLevel {
+	merged := make([]BookLevel, 0, len(earlier)+len(later))
+	index := make(map[float64]int, len(earlier)+len(later))
+	for _, levels := range [][]BookLevel{earlier, later} {
+		for _, level := range levels {
+			if i, ok := index[level.Price]; ok {
+				merged[i] = level
+				continue
+			}
+			index[level.Price] = len(merged)
+			merged = append(merged, level)
+		}
+	}
+	return merged
 }
 
-// GetDepth aggregates quantities at each price level.
-func (ob *OrderBook) GetDepth() *OrderBookDepth {
+// SetHalted halts (or resumes) trading on the book. While halted, AddOrder and ReplaceOrder
+// fail with ErrSymbolHalted; resting orders stay on the book and can still be cancelled.
+func (ob *OrderBook) SetHalted(halted bool) {
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+	ob.halted = halted
+	ob.record(&Event{Type: EventHalt, Halted: halted})
+}
+
+// Halted reports whether trading on the book is halted.
+func (ob *OrderBook) Halted() bool {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+	return ob.halted
+}
+
+// BestBid returns the highest bid price and the total quantity resting there, or false if there are no bids.
+func (ob *OrderBook) BestBid() (BookLevel, bool) {
 	ob.mu.RLock()
 	defer ob.mu.RUnlock()
+	return bestLevel(ob.bids)
+}
 
-	depth := &OrderBookDepth{
+// BestAsk returns the lowest ask price and the total quantity resting there, or false if there are no asks.
+func (ob *OrderBook) BestAsk() (BookLevel, bool) {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+	return bestLevel(ob.asks)
+}
+
+// Spread returns the difference between the best ask and the best bid, or false if either side is empty.
+func (ob *OrderBook) Spread() (float64, bool) {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+	bid, hasBid := bestLevel(ob.bids)
+	ask, hasAsk := bestLevel(ob.asks)
+	if !hasBid || !hasAsk {
+		return 0, false
+	}
+	return ask.Price - bid.Price, true
+}
+
+// Ticker returns the best bid, best ask and spread, all read under one lock so they are consistent.
+func (ob *OrderBook) Ticker() *BookTicker {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+	ticker := &BookTicker{Symbol: ob.symbol, Seq: ob.seq}
+	if bid, ok := bestLevel(ob.bids); ok {
+		ticker.BestBid = &bid
+	}
+	if ask, ok := bestLevel(ob.asks); ok {
+		ticker.BestAsk = &ask
+	}
+	if ticker.BestBid != nil && ticker.BestAsk != nil {
+		spread := ticker.BestAsk.Price - ticker.BestBid.Price
+		ticker.Spread = &spread
+	}
+	return ticker
+}
+
+// bestLevel aggregates the top level of a side. Must be called with the lock held.
+func bestLevel(side *bookSide) (BookLevel, bool) {
+	level := side.best()
+	if level == nil {
+		return BookLevel{}, false
+	}
+	return BookLevel{Price: level.price, Quantity: levelQuantity(level)}, true
+}
+
+// GetDepth aggregates quantities at the best maxLevels price levels of each side
+// (every level if maxLevels <= 0). Deeper levels are not visited.
+func (ob *OrderBook) GetDepth(maxLevels int) *OrderBookDepth {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+
+	// Orders are already grouped by price level, so only the levels need ordering
+	return &OrderBookDepth{
+		Symbol:   ob.symbol,
+		Seq:      ob.seq,
+		Halted:   ob.halted,
+		Bids:     aggregateLevels(ob.bids, maxLevels), // High to low
+		Asks:     aggregateLevels(ob.asks, maxLevels), // Low to high
+		Checksum: ob.checksum(ChecksumLevels),
+	}
+}
+
+// ChecksumLevels is how many price levels per side the checksum of depth snapshots and updates covers.
+const ChecksumLevels = 25
+
+// Checksum returns a CRC-32 of the best levels price levels of each side (all if <= 0), for clients keeping a
+// local copy of the book to check it against after applying each update; on a mismatch the copy
+// has gone wrong and needs a new snapshot.
+//
+// The checksum is the CRC-32 (IEEE polynomial, as zlib and PNG use) of an ASCII string listing
+// the levels rank by rank, best first: the bid of each rank, then the ask of the same rank, each
+// as price:quantity, all joined by ":", e.g. "100:1.5:101:0.25:99:2:102:3". Once a side runs out
+// of levels the ranks below only list the other side's. Quantities are the aggregate quantities as
+// published. Numbers are written in the shortest decimal form that reads back as the same float64,
+// without exponent or trailing zeros ("100", "0.5", "0.00000001"): Go's
+// strconv.FormatFloat(x, 'f', -1, 64); JavaScript's String(x) agrees for numbers from 1e-6 up.
+func (ob *OrderBook) Checksum(levels int) uint32 {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+	return ob.checksum(levels)
+}
+
+// checksum computes Checksum. Must be called with the lock held.
+func (ob *OrderBook) checksum(levels int) uint32 {
+	bids, asks := ob.bids.topLevels(levels), ob.asks.topLevels(levels)
+	parts := make([]string, 0, 2*(len(bids)+len(asks)))
+	for i := 0; i < len(bids) || i < len(asks); i++ {
+		for _, side := range [][]*priceLevel{bids, asks} {
+			if i < len(side) {
+				parts = append(parts, formatChecksumNumber(side[i].price), formatChecksumNumber(levelQuantity(side[i])))
+			}
+		}
+	}
+	return crc32.ChecksumIEEE([]byte(strings.Join(parts, ":")))
+}
+
+// formatChecksumNumber writes a number for Checksum.
+func formatChecksumNumber(x float64) string {
+	return strconv.FormatFloat(x, 'f', -1, 64)
+}
+
+// GetRawBook returns the best maxOrders resting orders per side (all if <= 0), in matching
+// priority, with their order ids.
+func (ob *OrderBook) GetRawBook(maxOrders int) *RawBook {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+
+	return &RawBook{
 		Symbol: ob.symbol,
-		Bids:   make([]BookLevel, 0),
-		Asks:   make([]BookLevel, 0),
+		Seq:    ob.seq,
+		Halted: ob.halted,
+		Bids:   rawOrders(ob.bids, maxOrders),
+		Asks:   rawOrders(ob.asks, maxOrders),
 	}
+}
 
-	// Aggregate Bids (already sorted high to low)
-	levelMapBids := make(map[float64]float64)
-	for _, bid := range ob.Bids {
-		levelMapBids[bid.Price] += bid.Quantity
+// GetLiveOrders lists every order in the book's Orders map, resting and parked stops alike, with
+// their owners. It is the book's own view, for comparing with the database.
+func (ob *OrderBook) GetLiveOrders() *LiveOrders {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+
+	parked := make(map[uuid.UUID]bool, len(ob.Stops))
+	for _, stop := range ob.Stops {
+		parked[stop.ID] = true
 	}
-	// Convert map to sorted slice
-	bidsPrices := make([]float64, 0, len(levelMapBids))
-	for price := range levelMapBids {
-		bidsPrices = append(bidsPrices, price)
+	orders := make([]LiveOrder, 0, len(ob.Orders))
+	for _, order := range ob.Orders {
+		orders = append(orders, LiveOrder{
+			OrderID:   order.ID,
+			UserID:    order.UserID,
+			Side:      order.Side,
+			Type:      order.Type,
+			Price:     order.Price,
+			StopPrice: order.StopPrice,
+			Parked:    parked[order.ID],
+			Remaining: order.Remaining,
+			Visible:   order.Visible,
+			CreatedAt: order.CreatedAt,
+		})
+	}
+	sort.Slice(orders, func(i, j int) bool {
+		a, b := orders[i], orders[j]
+		if a.Side != b.Side {
+			return a.Side == "buy"
+		}
+		if a.Price != b.Price {
+			return (a.Price > b.Price) == (a.Side == "buy")
+		}
+		return a.CreatedAt.Before(b.CreatedAt)
+	})
+	return &LiveOrders{Symbol: ob.symbol, Seq: ob.seq, Halted: ob.halted, Orders: orders}
+}
+
+// rawOrders lists the resting orders of the best levels of a side until maxOrders (all if <= 0).
+// Every level holds at least one order, so the best maxOrders levels are enough.
+func rawOrders(side *bookSide, maxOrders int) []RawOrder {
+	orders := make([]RawOrder, 0)
+	for _, level := range side.topLevels(maxOrders) {
+		for e := level.orders.Front(); e != nil; e = e.Next() {
+			if maxOrders > 0 && len(orders) == maxOrders {
+				return orders
+			}
+			order := e.Value.(*bookOrder)
+			id := order.ID
+			orders = append(orders, RawOrder{OrderID: &id, Price: level.price, Quantity: order.shown()})
+		}
 	}
-	sort.Slice(bidsPrices, func(i, j int) bool { return bidsPrices[i] > bidsPrices[j] }) // Descending
-	for _, price := range bidsPrices {
-		depth.Bids = append(depth.Bids, BookLevel{Price: price, Quantity: levelMapBids[price]})
+	return orders
+}
+
+// touch records that the level at price on the given side changed during the current operation.
+// Must be called with the write lock held.
+func (ob *OrderBook) touch(side string, price float64) {
+	if side == "buy" {
+		ob.changedBid[price] = struct{}{}
+	} else {
+		ob.changedAsk[price] = struct{}{}
 	}
+}
 
-	// Aggregate Asks (already sorted low to high)
-	levelMapAsks := make(map[float64]float64)
-	for _, ask := range ob.Asks {
-		levelMapAsks[ask.Price] += ask.Quantity
+// publishDepth hands the levels touched by the current operation to OnDepthUpdate
+// under the next sequence number, then resets the change set.
+// Must be called with the write lock held.
+func (ob *OrderBook) publishDepth() {
+	if len(ob.changedBid) == 0 && len(ob.changedAsk) == 0 {
+		return
 	}
-	// Convert map to sorted slice
-	asksPrices := make([]float64, 0, len(levelMapAsks))
-	for price := range levelMapAsks {
-		asksPrices = append(asksPrices, price)
+	ob.seq++
+	update := &DepthUpdate{
+		Type:     "depth_update",
+		Symbol:   ob.symbol,
+		Seq:      ob.seq,
+		Bids:     changedLevels(ob.bids, ob.changedBid),
+		Asks:     changedLevels(ob.asks, ob.changedAsk),
+		Checksum: ob.checksum(ChecksumLevels),
 	}
-	sort.Slice(asksPrices, func(i, j int) bool { return asksPrices[i] < asksPrices[j] }) // Ascending
-	for _, price := range asksPrices {
-		depth.Asks = append(depth.Asks, BookLevel{Price: price, Quantity: levelMapAsks[price]})
+	ob.changedBid = make(map[float64]struct{})
+	ob.changedAsk = make(map[float64]struct{})
+	ob.recentUpdates = appendBounded(ob.recentUpdates, update)
+
+	if ob.OnDepthUpdate != nil {
+		ob.OnDepthUpdate(update)
 	}
+}
 
-	// Optional: Limit depth to top N levels
-	// const maxDepthLevels = 20
-	// if len(depth.Bids) > maxDepthLevels { depth.Bids = depth.Bids[:maxDepthLevels] }
-	// if len(depth.Asks) > maxDepthLevels { depth.Asks = depth.Asks[:maxDepthLevels] }
+// changedLevels returns the current aggregate quantity of each changed price, 0 if the level is gone.
+func changedLevels(side *bookSide, prices map[float64]struct{}) []BookLevel {
+	levels := make([]BookLevel, 0, len(prices))
+	for price := range prices {
+		total := 0.0
+		if level, exists := side.levels[price]; exists {
+			total = levelQuantity(level)
+		}
+		levels = append(levels, BookLevel{Price: price, Quantity: total})
+	}
+	return levels
+}
 
-	return depth
+// levelQuantity sums the quantity shown by the orders resting at a price level, which leaves out
+// the hidden part of iceberg orders.
+func levelQuantity(level *priceLevel) float64 {
+	total := 0.0
+	for e := level.orders.Front(); e != nil; e = e.Next() {
+		total += e.Value.(*bookOrder).shown()
+	}
+	return total
+}
+
+// aggregateLevels sums the resting quantity of the best maxLevels price levels (all if <= 0), best price first.
+func aggregateLevels(side *bookSide, maxLevels int) []BookLevel {
+	top := side.topLevels(maxLevels)
+	levels := make([]BookLevel, 0, len(top))
+	for _, level := range top {
+		levels = append(levels, BookLevel{Price: level.price, Quantity: levelQuantity(level)})
+	}
+	return levels
+}
+
+// appendBounded appends v, dropping the oldest entries beyond historySize.
+func appendBounded[T any](history []T, v T) []T {
+	history = append(history, v)
+	if len(history) > historySize {
+		history = history[len(history)-historySize:]
+	}
+	return history
+}
+
+// TradesSince returns the book's trades with a sequence number above seq, oldest first.
+// Returns false if some of them are no longer kept in memory (see historySize).
+func (ob *OrderBook) TradesSince(seq uint64) ([]*Trade, bool) {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+	return since(ob.recentTrades, seq, ob.tradeSeq, func(t *Trade) uint64 { return t.Seq })
+}
+
+// DepthUpdatesSince returns the book's depth updates with a sequence number above seq, oldest first.
+// Returns false if some of them are no longer kept, in which case the client needs a new snapshot.
+func (ob *OrderBook) DepthUpdatesSince(seq uint64) ([]*DepthUpdate, bool) {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+	return since(ob.recentUpdates, seq, ob.seq, func(u *DepthUpdate) uint64 { return u.Seq })
+}
+
+// since returns copies of the entries of history (ordered by sequence number, the last being
+// current) after seq, and false if history doesn't reach back that far.
+// Must be called with the lock held.
+func since[T any](history []*T, seq, current uint64, seqOf func(*T) uint64) ([]*T, bool) {
+	if seq >= current {
+		return []*T{}, true
+	}
+	if len(history) == 0 || seqOf(history[0]) > seq+1 {
+		return nil, false
+	}
+	first := sort.Search(len(history), func(i int) bool { return seqOf(history[i]) > seq })
+	entries := make([]*T, 0, len(history)-first)
+	for _, entry := range history[first:] {
+		e := *entry
+		entries = append(entries, &e)
+	}
+	return entries, true
 }
 
 // Trade represents a successfully matched trade.
 type Trade struct {
+	ID           uuid.UUID `json:"id"`  // Assigned on match, and kept as the trade's ID in the database
+	Seq          uint64    `json:"seq"` // Per book, increases by exactly one per trade
 	TakerOrderID uuid.UUID `json:"taker_order_id"`
 	MakerOrderID uuid.UUID `json:"maker_order_id"`
 	Symbol       string    `json:"symbol"`
+	Side         string    `json:"side"` // Taker side, "buy" or "sell"
 	Price        float64   `json:"price"`
 	Quantity     float64   `json:"quantity"`
 	Timestamp    time.Time `json:"timestamp"`
+	// Taker's LockPrice at the time of the match: its limit price, the protection price of a market
+	// order sized in the base asset, 0 for other market orders.
+	// Settlement releases a buy taker's price improvement from it rather than from the stored order,
+	// whose price may have been modified since.
+	TakerLimitPrice float64 `json:"-"`
+	// Maker's limit price at the time of the match: the trade price, unless a sandbox market
+	// order slipped (see OrderBook.MaxSlippage). 0 means the trade price.
+	MakerLimitPrice float64 `json:"-"`
 }
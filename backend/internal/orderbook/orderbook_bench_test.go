@@ -0,0 +1,110 @@
+package orderbook
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/user/minicoinbase/backend/internal/models"
+)
+
+const benchRestingOrders = 100000
+
+// benchPrice spreads resting orders over 10k price levels (10 orders per level).
+func benchPrice(i int) float64 {
+	return 1000 + float64(i%10000)*0.01
+}
+
+func newBenchOrder(side string, price float64) *models.Order {
+	return &models.Order{
+		ID:       uuid.New(),
+		Symbol:   "BTC-USD",
+		Type:     "limit",
+		Side:     side,
+		Price:    price,
+		Quantity: 1,
+	}
+}
+
+// newBenchBook returns a book with benchRestingOrders bids resting on it.
+func newBenchBook(b *testing.B) *OrderBook {
+	b.Helper()
+	ob := NewOrderBook("BTC-USD")
+	for i := 0; i < benchRestingOrders; i++ {
+		if _, err := ob.AddOrder(newBenchOrder("buy", benchPrice(i))); err != nil {
+			b.Fatal(err)
+		}
+	}
+	return ob
+}
+
+// BenchmarkAddCancel measures resting and cancelling one bid on a book holding 100k bids.
+func BenchmarkAddCancel(b *testing.B) {
+	ob := newBenchBook(b)
+	rng := rand.New(rand.NewSource(1))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		order := newBenchOrder("buy", benchPrice(rng.Intn(benchRestingOrders)))
+		if _, err := ob.AddOrder(order); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := ob.CancelOrder(order.ID); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// BenchmarkMatch measures a sell taking out the best bid of a book holding 100k bids.
+// The consumed bid is replaced so the book stays at 100k resting orders.
+func BenchmarkMatch(b *testing.B) {
+	ob := newBenchBook(b)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		price := ob.bids.best().price
+		if _, err := ob.AddOrder(newBenchOrder("sell", price)); err != nil {
+			b.Fatal(err)
+		}
+		if _, err := ob.AddOrder(newBenchOrder("buy", price)); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// sortedSliceBids is the previous bid-side implementation (a price-sorted slice
+// with O(n) insert and cancel), kept here as the baseline for BenchmarkAddCancel.
+type sortedSliceBids []*models.Order
+
+func (s *sortedSliceBids) add(order *models.Order) {
+	bids := *s
+	i := sort.Search(len(bids), func(j int) bool { return bids[j].Price <= order.Price })
+	bids = append(bids, nil)
+	copy(bids[i+1:], bids[i:])
+	bids[i] = order
+	*s = bids
+}
+
+func (s *sortedSliceBids) cancel(orderID uuid.UUID) {
+	bids := *s
+	for i, bid := range bids {
+		if bid.ID == orderID {
+			*s = append(bids[:i], bids[i+1:]...)
+			return
+		}
+	}
+}
+
+// BenchmarkAddCancelSortedSlice is BenchmarkAddCancel against the old sorted-slice layout.
+func BenchmarkAddCancelSortedSlice(b *testing.B) {
+	bids := make(sortedSliceBids, 0, benchRestingOrders+1)
+	for i := 0; i < benchRestingOrders; i++ {
+		bids.add(newBenchOrder("buy", benchPrice(i)))
+	}
+	rng := rand.New(rand.NewSource(1))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		order := newBenchOrder("buy", benchPrice(rng.Intn(benchRestingOrders)))
+		bids.add(order)
+		bids.cancel(order.ID)
+	}
+}
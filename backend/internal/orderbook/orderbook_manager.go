@@ -1,37 +1,126 @@
 package orderbook
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"log/slog"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/user/minicoinbase/backend/internal/config"
+	"github.com/user/minicoinbase/backend/internal/database"
+	"github.com/user/minicoinbase/backend/internal/fees"
+	"github.com/user/minicoinbase/backend/internal/logging"
+	"github.com/user/minicoinbase/backend/internal/markets"
 	"github.com/user/minicoinbase/backend/internal/models"
-	// TODO: Import database package for trade processing?
+	"github.com/user/minicoinbase/backend/internal/portfolio"
+	"github.com/user/minicoinbase/backend/internal/ticker"
 )
 
+// ErrUnknownSymbol is returned for a symbol that isn't a configured market and has no order book.
+var ErrUnknownSymbol = errors.New("unknown symbol")
+
 // Manager holds and manages multiple OrderBook instances.
 type Manager struct {
 	mu    sync.RWMutex
 	books map[string]*OrderBook // Key: symbol (e.g., "BTC-USD")
-	// TODO: Add channel for broadcasting trades?
+	// Sequence numbers and last price of books retired by the reaper, carried over when
+	// their symbol gets a book again so the feeds continue where they left off
+	retired map[string]retiredBook
+
+	selfTradePolicy  SelfTradePolicy // Applied to every book the manager creates
+	snapshotInterval time.Duration   // How often snapshotLoop persists the books, 0 to disable
+	expiryInterval   time.Duration   // How often expiryLoop cancels expired orders, 0 to disable
+	outboxInterval   time.Duration   // How often outboxLoop retries unsettled trades, 0 to disable
+	outboxAttempts   int             // Failed settlement attempts before a trade is held for review, 0 for no limit
+	bookIdleTimeout  time.Duration   // How long an empty book goes unused before it is retired, 0 to keep books
+
+	// Sandbox mode only, both 0 otherwise
+	settlementDelay time.Duration // Artificial delay before matched trades are settled
+	maxSlippage     float64       // Applied to every book the manager creates, see OrderBook.MaxSlippage
+
+	settling sync.WaitGroup // Tracks trade settlement goroutines, see WaitForSettlement
+
+	events            *FileEventSink // Event log of every book, see OpenEventLog; nil without one
+	eventSyncInterval time.Duration  // How often the event log is synced to disk, 0 after every write
+
+	statsMu sync.Mutex
+	stats   map[string]*symbolStats // Rolling 24h statistics by symbol, see GetSymbolStats
 }
 
 var GlobalOrderBookManager *Manager
 
+// TradeUpdate is the public view of an executed trade, as pushed on the trade feed.
+type TradeUpdate struct {
+	Type     string  `json:"type"` // Always "trade"
+	Seq      uint64  `json:"seq"`  // Per symbol, increases by exactly one per trade; a gap means missed trades
+	Symbol   string  `json:"symbol"`
+	Price    float64 `json:"price"`
+	Quantity float64 `json:"quantity"`
+	Side     string  `json:"side"` // Taker side, "buy" or "sell"
+	Ts       int64   `json:"ts"`   // Unix timestamp milliseconds
+}
+
+// TradeUpdates carries executed trades to the WebSocket hub for the public trade feed.
+var TradeUpdates = make(chan TradeUpdate, 256) // Buffered channel
+
+// DepthUpdates carries incremental order book changes to the WebSocket hub for the depth feed.
+var DepthUpdates = make(chan *DepthUpdate, 1024) // Buffered channel
+
+// FillUpdate is one side of a settled trade, as pushed to the order owner on the private user feed.
+type FillUpdate struct {
+	Type      string    `json:"type"` // Always "fill"
+	UserID    uuid.UUID `json:"-"`    // Owner of the order, the only one who receives the update
+	OrderID   uuid.UUID `json:"order_id"`
+	TradeID   uuid.UUID `json:"trade_id"`
+	Symbol    string    `json:"symbol"`
+	Side      string    `json:"side"`      // Side of the order, "buy" or "sell"
+	Liquidity string    `json:"liquidity"` // "maker" or "taker"
+	Price     float64   `json:"price"`
+	Quantity  float64   `json:"quantity"`
+	Fee       float64   `json:"fee"` // In the asset the order receives
+	Ts        int64     `json:"ts"`  // Unix timestamp milliseconds
+}
+
+// FillUpdates carries settled fills to the WebSocket hub for the private user feed.
+var FillUpdates = make(chan FillUpdate, 256) // Buffered channel
+
 // InitManager initializes the global order book manager.
-func InitManager() {
-	log.Println("Initializing Order Book Manager...")
+func InitManager(cfg *config.Config) {
+	slog.Info("Initializing Order Book Manager")
 	GlobalOrderBookManager = &Manager{
-		books: make(map[string]*OrderBook),
+		books:            make(map[string]*OrderBook),
+		retired:          make(map[string]retiredBook),
+		selfTradePolicy:  SelfTradePolicy(cfg.SelfTradePolicy), // Validated by config.Load
+		snapshotInterval: cfg.OrderBookSnapshotInterval,
+		expiryInterval:   cfg.OrderExpiryInterval,
+		outboxInterval:   cfg.SettlementRetryInterval,
+		outboxAttempts:   cfg.SettlementMaxAttempts,
+		bookIdleTimeout:  cfg.OrderBookIdleTimeout,
+
+		eventSyncInterval: cfg.OrderBookEventLogSyncInterval,
+	}
+	if cfg.Sandbox {
+		slog.Warn("Sandbox mode: delaying settlement and slipping market orders",
+			"settlement_delay", cfg.SandboxSettlementDelay, "max_slippage_bps", cfg.SandboxMaxSlippageBps)
+		GlobalOrderBookManager.settlementDelay = cfg.SandboxSettlementDelay
+		GlobalOrderBookManager.maxSlippage = cfg.SandboxMaxSlippageBps / 10000
+	}
+	// Pre-create books for the configured symbols (the ticker must be initialized first)
+	for _, symbol := range ticker.Symbols() {
+		GlobalOrderBookManager.GetOrCreateBook(symbol)
 	}
-	// TODO: Pre-create books for known symbols?
-	// GlobalOrderBookManager.GetOrCreateBook("BTC-USD")
-	// GlobalOrderBookManager.GetOrCreateBook("ETH-USD")
-	// GlobalOrderBookManager.GetOrCreateBook("SOL-USD")
 }
 
 // GetOrCreateBook retrieves an existing order book or creates a new one for the symbol.
+// It creates a book for any symbol, so it is only for orders, whose symbol was validated when
+// they were placed; lookups on behalf of clients go through getBook.
 func (m *Manager) GetOrCreateBook(symbol string) *OrderBook {
 	symbol = strings.ToUpper(symbol)
 	m.mu.RLock()
@@ -53,76 +142,842 @@ func (m *Manager) GetOrCreateBook(symbol string) *OrderBook {
 	}
 
 	// Create new book
-	log.Printf("Creating new order book for symbol: %s", symbol)
+	slog.Info("Creating new order book", "symbol", symbol)
 	newBook := NewOrderBook(symbol)
+	newBook.SelfTradePolicy = m.selfTradePolicy
+	newBook.MaxSlippage = m.maxSlippage
+	if market, ok := markets.Get(symbol); ok {
+		newBook.StepSize = market.StepSize
+	}
+	if state, ok := m.retired[symbol]; ok {
+		newBook.seq, newBook.tradeSeq, newBook.eventSeq, newBook.lastPrice = state.seq, state.tradeSeq, state.eventSeq, state.lastPrice
+		delete(m.retired, symbol)
+	}
+	newBook.OnDepthUpdate = publishDepthUpdate
+	if m.events != nil {
+		newBook.Events = m.events
+	}
+	newBook.startMatching(bookQueueSize)
 	m.books[symbol] = newBook
 	return newBook
 }
 
-// SubmitOrder adds an order to the appropriate book and handles resulting trades.
-func (m *Manager) SubmitOrder(order *models.Order) error {
-	book := m.GetOrCreateBook(order.Symbol)
-	trades, err := book.AddOrder(order)
+// getBook returns the book of a symbol, creating it only for a configured market, or
+// ErrUnknownSymbol. Unlike GetOrCreateBook it is safe to call with any symbol a client sends.
+func (m *Manager) getBook(symbol string) (*OrderBook, error) {
+	symbol = strings.ToUpper(symbol)
+	m.mu.RLock()
+	book, exists := m.books[symbol]
+	m.mu.RUnlock()
+	if exists {
+		return book, nil
+	}
+	if _, ok := markets.Get(symbol); !ok {
+		return nil, ErrUnknownSymbol
+	}
+	return m.GetOrCreateBook(symbol), nil
+}
+
+// onBook runs op, which queues an operation on the symbol's book, again on the symbol's new
+// book for as long as it fails with errBookRetired.
+func (m *Manager) onBook(symbol string, op func(book *OrderBook) error) error {
+	for {
+		if err := op(m.GetOrCreateBook(symbol)); !errors.Is(err, errBookRetired) {
+			return err
+		}
+	}
+}
+
+// SubmitOrder adds an order to the appropriate book and handles resulting trades,
+// waiting until the book's matching goroutine has processed it; see EnqueueOrder.
+func (m *Manager) SubmitOrder(ctx context.Context, order *models.Order) error {
+	done, err := m.EnqueueOrder(ctx, order)
 	if err != nil {
-		log.Printf("Error adding order %s to book %s: %v", order.ID, order.Symbol, err)
 		return err
 	}
+	return <-done
+}
 
-	if len(trades) > 0 {
-		log.Printf("Order %s generated %d trades on book %s", order.ID, len(trades), order.Symbol)
-		// TODO: Process Trades!
-		// - Start DB transaction
-		// - Update maker order status/quantity in DB
-		// - Update taker order status/quantity in DB
-		// - Update balances for both maker and taker users (using database.UpdateBalancesForFill)
-		// - Record the trade itself in a separate trades table?
-		// - Commit DB transaction
-		// - Broadcast trade event (e.g., via WebSocket)?
-		go m.processTrades(trades) // Process trades asynchronously for now
+// EnqueueOrder queues an order for the matching goroutine of its book and returns without
+// waiting for it to be matched. The returned channel receives the outcome once the order has been
+// processed: nil, or the error that made the book reject it. Orders are matched in the order
+// they were queued, per book.
+// An order that can't be matched - the book's queue is full (ErrBookBusy, returned right away),
+// or the book rejects it (ErrPostOnlyWouldCross, ErrSymbolHalted) - is cancelled and its funds
+// released before its error is delivered.
+// The book works on its own copy of the order, so nothing done to it there (a triggered stop
+// turning into a market or limit order, a replacement) shows in the caller's.
+// ctx only supplies the logger (see logging.FromContext); settlement outlives the request.
+func (m *Manager) EnqueueOrder(ctx context.Context, order *models.Order) (<-chan error, error) {
+	logger := logging.FromContext(ctx).With("order_id", order.ID, "symbol", order.Symbol)
+	bookOrder := *order
+	order = &bookOrder
+	done := make(chan error, 1)
+	err := m.onBook(order.Symbol, func(book *OrderBook) error {
+		return book.enqueue(func() {
+			result, err := book.AddOrder(order)
+			if errors.Is(err, ErrPostOnlyWouldCross) || errors.Is(err, ErrSymbolHalted) {
+				// Rejected before anything executed, so the whole order is released,
+				// off the matching goroutine so the book's next orders don't wait on the database
+				logger.Info("Order rejected by book", "reason", err)
+				m.settling.Add(1)
+				go func() {
+					defer m.settling.Done()
+					m.releaseUnfilled(logger, newBookOrder(order).expired("rejected"))
+					done <- err
+				}()
+				return
+			}
+			if err != nil {
+				logger.Error("Error adding order to book", "err", err)
+				done <- err
+				return
+			}
+			m.handleResult(logger, result)
+			done <- nil
+		})
+	})
+	if err != nil {
+		logger.Warn("Order book queue full, rejecting order", "err", err)
+		m.releaseUnfilled(logger, newBookOrder(order).expired("rejected"))
+		return nil, err
 	}
+	return done, nil
+}
 
+// ReplaceOrder changes a resting limit order's price and remaining quantity in its book
+// and handles any trades that result (see OrderBook.ReplaceOrder for the priority rules).
+// expectedRemaining is the remaining quantity the change was based on, typically from GetOrder;
+// ErrOrderChanged means the order filled in the meantime and nothing was changed.
+// Like SubmitOrder it runs on the book's matching goroutine, failing with ErrBookBusy if its queue is full.
+func (m *Manager) ReplaceOrder(ctx context.Context, order *models.Order, expectedRemaining, price, quantity float64) error {
+	logger := logging.FromContext(ctx).With("order_id", order.ID, "symbol", order.Symbol)
+	done := make(chan error, 1)
+	err := m.onBook(order.Symbol, func(book *OrderBook) error {
+		return book.enqueue(func() {
+			result, err := book.ReplaceOrder(order.ID, expectedRemaining, price, quantity)
+			if err == nil {
+				m.handleResult(logger, result)
+			}
+			done <- err
+		})
+	})
+	if err == nil {
+		err = <-done
+	}
+	if err != nil {
+		logger.Warn("Error replacing order on book", "err", err)
+		return err
+	}
+	logger.Info("Order replaced on book", "price", price, "remaining", quantity)
 	return nil
 }
 
-// CancelOrder removes an order from the appropriate book.
-func (m *Manager) CancelOrder(order *models.Order) error {
-	book := m.GetOrCreateBook(order.Symbol) // Book should exist if order was placed
-	_, err := book.CancelOrder(order.ID)
+// HaltSymbol halts trading on a symbol: new orders and replacements are rejected with
+// ErrSymbolHalted until ResumeSymbol, while cancellations still work. Halts are not persisted,
+// a restart resumes trading everywhere. Returns ErrUnknownSymbol for an unknown symbol, see getBook.
+func (m *Manager) HaltSymbol(symbol string) error {
+	return m.setHalted(symbol, true)
+}
+
+// ResumeSymbol resumes trading on a halted symbol. Returns ErrUnknownSymbol for an unknown symbol.
+func (m *Manager) ResumeSymbol(symbol string) error {
+	return m.setHalted(symbol, false)
+}
+
+func (m *Manager) setHalted(symbol string, halted bool) error {
+	symbol = strings.ToUpper(symbol)
+	book, err := m.getBook(symbol)
 	if err != nil {
-		log.Printf("Error cancelling order %s from book %s: %v", order.ID, order.Symbol, err)
 		return err
 	}
-	log.Printf("Order %s cancelled from book %s", order.ID, order.Symbol)
+	book.SetHalted(halted)
+	slog.Warn("Trading halt changed", "symbol", symbol, "halted", halted)
 	return nil
 }
 
-// GetBookDepth returns the depth for a specific symbol.
-func (m *Manager) GetBookDepth(symbol string) (*OrderBookDepth, error) {
-	symbol = strings.ToUpper(symbol)
-	book := m.GetOrCreateBook(symbol) // Get or create (might be empty if no orders yet)
-	if book == nil {
-		// This shouldn't happen with GetOrCreateBook logic
-		return nil, fmt.Errorf("failed to get order book for symbol %s", symbol)
+// IsHalted reports whether trading on a symbol is halted.
+func (m *Manager) IsHalted(symbol string) bool {
+	m.mu.RLock()
+	book, exists := m.books[strings.ToUpper(symbol)]
+	m.mu.RUnlock()
+	return exists && book.Halted()
+}
+
+// HaltedSymbols returns the symbols whose trading is halted, sorted.
+func (m *Manager) HaltedSymbols() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	halted := make([]string, 0)
+	for symbol, book := range m.books {
+		if book.Halted() {
+			halted = append(halted, symbol)
+		}
 	}
-	return book.GetDepth(), nil
+	sort.Strings(halted)
+	return halted
 }
 
-// processTrades (placeholder) handles database updates after trades occur.
-func (m *Manager) processTrades(trades []*Trade) {
-	log.Printf("Processing %d trades...", len(trades))
-	// !!! This needs full implementation with database transactions !!!
+// GetOrder returns a copy of a live order from its book and its remaining quantity, see OrderBook.GetOrder.
+func (m *Manager) GetOrder(symbol string, orderID uuid.UUID) (models.Order, float64, bool) {
+	book, err := m.getBook(symbol)
+	if err != nil {
+		return models.Order{}, 0, false
+	}
+	return book.GetOrder(orderID)
+}
+
+// SimulateMatch estimates how an order would execute against its book, see OrderBook.SimulateMatch.
+// Returns ErrUnknownSymbol for a symbol without a market.
+func (m *Manager) SimulateMatch(order *models.Order) (*SimulatedMatch, error) {
+	book, err := m.getBook(order.Symbol)
+	if err != nil {
+		return nil, err
+	}
+	return book.SimulateMatch(order)
+}
+
+// handleResult writes the trades an order generated to the outbox, publishes them and hands
+// them, together with any orders that expired on the way, to asynchronous settlement. It runs on
+// the book's matching goroutine, so the trades are durable before the book moves on. Settlement
+// runs in a goroutine of its own per result; in sandbox mode that goroutine sleeps out the
+// settlement delay first, so the delay never holds up matching.
+func (m *Manager) handleResult(logger *slog.Logger, result *MatchResult) {
+	trades, expired := result.Trades, result.Expired
+	if len(trades) == 0 && len(expired) == 0 {
+		return
+	}
+
+	logger.Info("Order matched", "trades", len(trades), "expired", len(expired))
+	if len(trades) > 0 {
+		m.enqueueTrades(logger, trades)
+	}
+	publishTrades(trades)
+	m.settling.Add(1)
+	go func() { // Process trades asynchronously for now
+		defer m.settling.Done()
+		if m.settlementDelay > 0 {
+			// Sandbox mode: fills arrive a while after the order was accepted, as they can elsewhere
+			time.Sleep(m.settlementDelay)
+		}
+		if len(trades) > 0 {
+			m.processTrades(logger, trades)
+		}
+		// Orders that left the book unfilled (IOC/FOK, market, self-trade prevention)
+		// are released after settlement so their fill status is final.
+		for _, e := range expired {
+			expiredLogger := logger.With("expired_order_id", e.Order.ID)
+			expiredLogger.Info("Order expired, releasing unfilled quantity", "reason", e.Reason, "quantity", e.Quantity, "quote", e.Quote)
+			m.releaseUnfilled(expiredLogger, e)
+		}
+	}()
+}
+
+// Recover rebuilds the order books from their latest snapshots and the open orders in the
+// database: see mergeSnapshot for how the two are reconciled. Orders placed after a snapshot
+// (or every open order, for a book without one) are replayed through matching, and any trades
+// they make are settled as usual. Trades a previous run matched but didn't settle are settled
+// from the outbox first, so the orders come with their fills. Call once at startup, before
+// accepting orders.
+func (m *Manager) Recover(ctx context.Context) error {
+	if err := m.drainOutbox(ctx); err != nil {
+		return err
+	}
+	if err := m.loadSymbolStats(ctx); err != nil {
+		// Not fatal: the statistics fill up again from new trades
+		slog.Warn("Failed to load symbol statistics", "err", err)
+	}
+	open, err := database.GetOpenOrders(ctx)
+	if err != nil {
+		return err
+	}
+	tradeSeqs, err := database.GetMaxTradeSeqs(ctx)
+	if err != nil {
+		return err
+	}
+	bySymbol := make(map[string][]*models.Order)
+	for _, order := range open {
+		bySymbol[order.Symbol] = append(bySymbol[order.Symbol], order)
+	}
+	m.mu.RLock()
+	for symbol := range m.books {
+		if _, ok := bySymbol[symbol]; !ok {
+			bySymbol[symbol] = nil
+		}
+	}
+	m.mu.RUnlock()
+	for symbol := range tradeSeqs {
+		if _, ok := bySymbol[symbol]; !ok {
+			bySymbol[symbol] = nil
+		}
+	}
+
+	for symbol, orders := range bySymbol {
+		logger := slog.With("symbol", symbol)
+		snap, err := loadSnapshot(ctx, symbol)
+		if err != nil {
+			// Not fatal: the database alone is enough to rebuild the book, only queue positions are lost
+			logger.Warn("Ignoring unusable order book snapshot", "err", err)
+			snap = nil
+		}
+
+		book := m.GetOrCreateBook(symbol)
+		kept, replay := mergeSnapshot(snap, orders)
+		baseline := kept
+		if baseline == nil {
+			// Restored all the same, for the event log to have a point to replay the book from
+			baseline = &Snapshot{Symbol: symbol}
+		}
+		// Trades settled after the snapshot was taken are already numbered
+		baseline.TradeSeq = max(baseline.TradeSeq, uint64(tradeSeqs[symbol]))
+		if err := book.Restore(baseline); err != nil {
+			return err
+		}
+		if baseline.LastPrice > 0 {
+			ticker.SetLastPrice(symbol, baseline.LastPrice)
+		}
+		for _, order := range replay {
+			// One at a time, so the book's queue never fills up and turns an open order away.
+			// Rejections (post-only, halted) are released by SubmitOrder, other errors are logged there
+			_ = m.SubmitOrder(ctx, order)
+		}
+		logger.Info("Recovered order book", "from_snapshot", kept != nil,
+			"restored", len(orders)-len(replay), "replayed", len(replay))
+	}
+	return nil
+}
+
+// loadSnapshot returns the latest snapshot of a symbol's book, or nil if there is none.
+func loadSnapshot(ctx context.Context, symbol string) (*Snapshot, error) {
+	data, err := database.GetOrderBookSnapshot(ctx, symbol)
+	if err != nil || data == nil {
+		return nil, err
+	}
+	snap := &Snapshot{}
+	if err := json.Unmarshal(data, snap); err != nil {
+		return nil, fmt.Errorf("error decoding order book snapshot for %s: %w", symbol, err)
+	}
+	return snap, nil
+}
+
+// StartSnapshots persists every book's snapshot each configured interval until ctx is done.
+// It does nothing if the interval is 0; call SaveSnapshots at shutdown either way.
+func (m *Manager) StartSnapshots(ctx context.Context) {
+	if m.snapshotInterval <= 0 {
+		return
+	}
+	go m.snapshotLoop(ctx, m.snapshotInterval)
+}
+
+// snapshotLoop calls SaveSnapshots every interval until ctx is done.
+func (m *Manager) snapshotLoop(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if err := m.SaveSnapshots(ctx); err != nil {
+				slog.Error("Failed to save order book snapshots", "err", err)
+			}
+		}
+	}
+}
+
+// SaveSnapshots persists a snapshot of every book, then syncs the event log, if one is open, so it
+// holds at least the events the snapshots include. It tries every book and returns the first error.
+func (m *Manager) SaveSnapshots(ctx context.Context) error {
+	m.mu.RLock()
+	books := make([]*OrderBook, 0, len(m.books))
+	for _, book := range m.books {
+		books = append(books, book)
+	}
+	events := m.events
+	m.mu.RUnlock()
+
+	var firstErr error
+	for _, book := range books {
+		snap := book.Snapshot()
+		data, err := json.Marshal(snap)
+		if err == nil {
+			err = database.SaveOrderBookSnapshot(ctx, snap.Symbol, snap.Seq, data)
+		}
+		if err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("error saving snapshot of %s: %w", snap.Symbol, err)
+		}
+	}
+	if events != nil {
+		if err := events.Sync(); err != nil && firstErr == nil {
+			firstErr = fmt.Errorf("error syncing the order book event log: %w", err)
+		}
+	}
+	return firstErr
+}
+
+// WaitForSettlement blocks until every trade and expired order handed off by SubmitOrder
+// has been written to the database. Call on shutdown, after new orders have stopped coming in.
+func (m *Manager) WaitForSettlement() {
+	m.settling.Wait()
+}
+
+// Update returns the public trade feed message of the trade.
+func (t *Trade) Update() TradeUpdate {
+	return TradeUpdate{
+		Type:     "trade",
+		Seq:      t.Seq,
+		Symbol:   t.Symbol,
+		Price:    t.Price,
+		Quantity: t.Quantity,
+		Side:     t.Side,
+		Ts:       t.Timestamp.UnixMilli(),
+	}
+}
+
+// publishTrades pushes trades onto TradeUpdates without blocking the matching path.
+func publishTrades(trades []*Trade) {
 	for _, trade := range trades {
-		log.Printf(" Trade: Maker=%s, Taker=%s, Qty=%f, Price=%f, Time=%s",
-			trade.MakerOrderID, trade.TakerOrderID, trade.Quantity, trade.Price, trade.Timestamp)
-
-		// TODO:
-		// 1. Get maker & taker order details from DB (need UserID)
-		// 2. Begin transaction
-		// 3. Update maker order status/quantity (filled/partially_filled)
-		// 4. Update taker order status/quantity
-		// 5. Update maker balance (e.g., using UpdateBalancesForFill)
-		// 6. Update taker balance
-		// 7. Commit
-	}
-	log.Printf("Finished processing %d trades (placeholder).", len(trades))
+		update := trade.Update()
+		// Non-blocking send, a slow feed must never hold up matching
+		select {
+		case TradeUpdates <- update:
+		default:
+			slog.Warn("Trade update channel full, dropping trade", "symbol", trade.Symbol)
+		}
+	}
+}
+
+// publishFills pushes settled fills onto FillUpdates without blocking settlement.
+func publishFills(fills []FillUpdate) {
+	for _, fill := range fills {
+		select {
+		case FillUpdates <- fill:
+		default:
+			slog.Warn("Fill update channel full, dropping fill", "order_id", fill.OrderID, "trade_id", fill.TradeID)
+		}
+	}
+}
+
+// publishDepthUpdate pushes a book's depth update onto DepthUpdates without blocking.
+// A dropped update shows up as a sequence gap, which tells clients to re-snapshot.
+func publishDepthUpdate(update *DepthUpdate) {
+	select {
+	case DepthUpdates <- update:
+	default:
+		slog.Warn("Depth update channel full, dropping update", "symbol", update.Symbol, "seq", update.Seq)
+	}
+}
+
+// releaseUnfilled cancels the discarded remainder of an order that can't rest on the book
+// (or a rejected order) and unlocks the funds that were locked for it.
+// logger should identify the order being released.
+func (m *Manager) releaseUnfilled(logger *slog.Logger, e *ExpiredOrder) {
+	ctx := context.Background()
+	order := e.Order
+	parts := strings.Split(order.Symbol, "-")
+	baseAsset, quoteAsset := parts[0], parts[1]
+
+	unlockAsset, unlockAmount := baseAsset, e.Quantity
+	if order.QuoteQuantity > 0 {
+		unlockAsset, unlockAmount = quoteAsset, e.Quote
+	} else if order.Side == "buy" {
+		unlockAsset, unlockAmount = quoteAsset, LockPrice(order)*e.Quantity
+	}
+
+	tx, err := database.DB.Begin(ctx)
+	if err != nil {
+		logger.Log(ctx, logging.LevelCritical, "Failed to begin transaction releasing unfilled order", "err", err)
+		return
+	}
+	defer tx.Rollback(ctx)
+
+	expired, err := database.ExpireOrder(ctx, tx, order.ID)
+	if err != nil {
+		logger.Log(ctx, logging.LevelCritical, "Failed to expire unfilled order", "err", err)
+		return
+	}
+	if !expired {
+		logger.Info("Unfilled order already closed, nothing to release")
+		return
+	}
+
+	if unlockAmount > 0 { // A quote-denominated order can spend all of its quote
+		ref := database.LedgerRef{Reason: database.LedgerOrderUnlock, OrderID: order.ID}
+		if err := database.UnlockFunds(ctx, tx, order.UserID, unlockAsset, unlockAmount, ref); err != nil {
+			logger.Log(ctx, logging.LevelCritical, "Failed to unlock funds of unfilled order", "amount", unlockAmount, "asset", unlockAsset, "err", err)
+			return
+		}
+		if err := database.AdjustOrderLock(ctx, tx, order.ID, -unlockAmount); err != nil {
+			logger.Log(ctx, logging.LevelCritical, "Failed to record release of unfilled order", "err", err)
+			return
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		logger.Log(ctx, logging.LevelCritical, "Failed to commit release of unfilled order", "err", err)
+		return
+	}
+	logger.Info("Released funds of unfilled order", "amount", unlockAmount, "asset", unlockAsset)
+}
+
+// CancelOrder removes an order from the appropriate book.
+// Returns the quantity that was still unfilled when it was removed.
+// It runs on the book's matching goroutine, in order with the orders queued before it; unlike new
+// orders a cancellation is never turned away, it waits for room if the book's queue is full.
+func (m *Manager) CancelOrder(ctx context.Context, order *models.Order) (float64, error) {
+	logger := logging.FromContext(ctx).With("order_id", order.ID, "symbol", order.Symbol)
+	var remaining float64
+	done := make(chan error, 1)
+	_ = m.onBook(order.Symbol, func(book *OrderBook) error { // Book should exist if order was placed
+		return book.enqueueWait(func() {
+			var err error
+			remaining, err = book.CancelOrder(order.ID)
+			done <- err
+		})
+	})
+	if err := <-done; err != nil {
+		logger.Warn("Error cancelling order from book", "err", err)
+		return 0, err
+	}
+	logger.Info("Order cancelled from book", "remaining", remaining)
+	return remaining, nil
+}
+
+// CancelOrderInTx cancels one of the user's orders within tx: it marks the order cancelled,
+// takes it out of the live book and unlocks the funds backing its unfilled quantity.
+// Once it returns without error the order can no longer fill, so tx must be committed.
+// Returns the order as it was before cancellation. Used for user cancels and by the expiry sweeper.
+func (m *Manager) CancelOrderInTx(ctx context.Context, tx pgx.Tx, userID, orderID uuid.UUID) (*models.Order, error) {
+	// 1. Cancel the order in the DB (locks row, checks ownership & status)
+	originalOrder, err := database.CancelOrder(ctx, tx, userID, orderID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 2. Work out how much of the order is still unfilled
+	remaining := unfilled(originalOrder)
+
+	// Take the order out of the live book before committing so it cannot fill any further.
+	// The book also knows about fills that are matched but not yet settled in the DB,
+	// so when the order is still there its remaining quantity is the one to go by.
+	// (It may legitimately be missing, e.g. after a restart, in which case the DB figure stands.)
+	if bookRemaining, err := m.CancelOrder(ctx, originalOrder); err == nil {
+		remaining = bookRemaining
+	}
+
+	// 3. Unlock what the order still has locked, except what its fills that are matched but not
+	// yet settled will consume: settled fills have taken theirs out of the recorded lock already.
+	// A market order is never left in the book (only one whose submission failed is still open),
+	// so there is nothing pending for one
+	unlockAsset, unlockAmount := originalOrder.LockedAsset, originalOrder.LockedAmount
+	if pending := unfilled(originalOrder) - remaining; pending > 0 {
+		if originalOrder.Side == "sell" {
+			unlockAmount -= pending
+		} else {
+			unlockAmount -= LockPrice(originalOrder) * pending
+		}
+	}
+
+	// 4. Unlock the previously locked funds
+	if unlockAmount > 0 {
+		ref := database.LedgerRef{Reason: database.LedgerOrderUnlock, OrderID: orderID}
+		if err := database.UnlockFunds(ctx, tx, userID, unlockAsset, unlockAmount, ref); err != nil {
+			// The order is out of the book but its cancellation is about to be rolled back
+			logging.FromContext(ctx).Log(ctx, logging.LevelCritical, "Failed to unlock funds after removing order from book",
+				"user_id", userID, "order_id", orderID, "amount", unlockAmount, "asset", unlockAsset, "err", err)
+			return nil, err
+		}
+		if err := database.AdjustOrderLock(ctx, tx, orderID, -unlockAmount); err != nil {
+			return nil, err
+		}
+		logging.FromContext(ctx).Debug("Unlocked funds of cancelled order",
+			"user_id", userID, "order_id", orderID, "amount", unlockAmount, "asset", unlockAsset)
+	}
+
+	return originalOrder, nil
+}
+
+// TradesSince returns up to limit trades of a symbol with a sequence number above seq, in
+// sequence order. Recent trades come from the book, older ones from the database; a trade
+// only reaches the database once it is settled, so the book is tried first.
+func (m *Manager) TradesSince(ctx context.Context, symbol string, seq uint64, limit int) ([]TradeUpdate, error) {
+	book, err := m.getBook(symbol)
+	if err != nil {
+		return nil, err
+	}
+	updates := make([]TradeUpdate, 0)
+	if trades, ok := book.TradesSince(seq); ok {
+		for _, trade := range trades {
+			if len(updates) == limit {
+				break
+			}
+			updates = append(updates, trade.Update())
+		}
+		return updates, nil
+	}
+
+	trades, err := database.GetSymbolTradesSince(ctx, strings.ToUpper(symbol), int64(seq), limit)
+	if err != nil {
+		return nil, err
+	}
+	for _, trade := range trades {
+		updates = append(updates, TradeUpdate{
+			Type:     "trade",
+			Seq:      uint64(trade.Seq),
+			Symbol:   trade.Symbol,
+			Price:    trade.Price,
+			Quantity: trade.Quantity,
+			Side:     trade.TakerSide,
+			Ts:       trade.CreatedAt.UnixMilli(),
+		})
+	}
+	return updates, nil
+}
+
+// DepthUpdatesSince returns the depth updates of a symbol after seq, oldest first.
+// Returns false if the book no longer has all of them, or ErrUnknownSymbol.
+func (m *Manager) DepthUpdatesSince(symbol string, seq uint64) ([]*DepthUpdate, bool, error) {
+	book, err := m.getBook(symbol)
+	if err != nil {
+		return nil, false, err
+	}
+	updates, ok := book.DepthUpdatesSince(seq)
+	return updates, ok, nil
+}
+
+// GetBookTicker returns the best bid, best ask and spread of a symbol, or ErrUnknownSymbol.
+func (m *Manager) GetBookTicker(symbol string) (*BookTicker, error) {
+	book, err := m.getBook(symbol)
+	if err != nil {
+		return nil, err
+	}
+	return book.Ticker(), nil
+}
+
+// Quote estimates a market order on a symbol's book, see OrderBook.Quote, or returns ErrUnknownSymbol.
+func (m *Manager) Quote(symbol, side string, quantity float64) (*Quote, error) {
+	book, err := m.getBook(symbol)
+	if err != nil {
+		return nil, err
+	}
+	return book.Quote(side, quantity), nil
+}
+
+// GetBookDepth returns the depth for a specific symbol, limited to maxLevels per side (all if <= 0),
+// or ErrUnknownSymbol.
+func (m *Manager) GetBookDepth(symbol string, maxLevels int) (*OrderBookDepth, error) {
+	book, err := m.getBook(symbol)
+	if err != nil {
+		return nil, err
+	}
+	return book.GetDepth(maxLevels), nil
+}
+
+// GetRawBook returns the individual resting orders of a symbol, up to maxOrders per side
+// (all if <= 0), or ErrUnknownSymbol.
+func (m *Manager) GetRawBook(symbol string, maxOrders int) (*RawBook, error) {
+	book, err := m.getBook(symbol)
+	if err != nil {
+		return nil, err
+	}
+	return book.GetRawBook(maxOrders), nil
+}
+
+// GetLiveOrders returns every order the symbol's book holds, see OrderBook.GetLiveOrders.
+// Returns ErrUnknownSymbol for a symbol without a market.
+func (m *Manager) GetLiveOrders(symbol string) (*LiveOrders, error) {
+	book, err := m.getBook(symbol)
+	if err != nil {
+		return nil, err
+	}
+	return book.GetLiveOrders(), nil
+}
+
+// processTrades settles executed trades in the database, one transaction per trade, see settleTrade.
+// A trade that fails to settle stays in the outbox for the outbox worker to retry.
+func (m *Manager) processTrades(logger *slog.Logger, trades []*Trade) {
+	logger.Debug("Processing trades", "trades", len(trades))
+	m.recordTrades(trades)
+	settled := 0
+	for _, trade := range trades {
+		// The trade happened whether or not it settles, so it always marks the price
+		ticker.SetLastPrice(trade.Symbol, trade.Price)
+
+		if m.settle(context.Background(), logger, trade, 0) {
+			settled++
+		}
+	}
+	logger.Debug("Finished processing trades", "settled", settled, "trades", len(trades))
+}
+
+// settleTrade applies a single trade to the database within one transaction
+// and returns the resulting fill of each order.
+// The transaction records the trade, moves funds between maker and taker net of fees,
+// credits the fees to the house account, updates both orders' fill status and both users'
+// positions (realizing P&L on the sell side) and takes the trade out of the outbox. A trade that is already settled fails with database.ErrDuplicateKey.
+func settleTrade(ctx context.Context, trade *Trade) ([]FillUpdate, error) {
+	parts := strings.Split(trade.Symbol, "-")
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("invalid trade symbol %s", trade.Symbol)
+	}
+	baseAsset, quoteAsset := parts[0], parts[1]
+
+	// 1. Begin transaction
+	tx, err := database.DB.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	// Take every row this settlement changes up front, in lock order (orders, then balances
+	// sorted by user and asset, then positions), so concurrent settlements and cancels can't deadlock
+	if err := database.LockOrders(ctx, tx, trade.MakerOrderID, trade.TakerOrderID); err != nil {
+		return nil, err
+	}
+
+	// 2. Get maker & taker order details (need UserID, Side, Price), read under the row locks
+	// so a concurrent cancel or fill can't change them before this transaction commits
+	makerOrder, err := database.GetOrderByIDInTx(ctx, tx, trade.MakerOrderID)
+	if err != nil || makerOrder == nil {
+		return nil, fmt.Errorf("failed to load maker order %s: %v", trade.MakerOrderID, err)
+	}
+	takerOrder, err := database.GetOrderByIDInTx(ctx, tx, trade.TakerOrderID)
+	if err != nil || takerOrder == nil {
+		return nil, fmt.Errorf("failed to load taker order %s: %v", trade.TakerOrderID, err)
+	}
+
+	balances := []database.BalanceKey{
+		{UserID: makerOrder.UserID, Asset: baseAsset}, {UserID: makerOrder.UserID, Asset: quoteAsset},
+		{UserID: takerOrder.UserID, Asset: baseAsset}, {UserID: takerOrder.UserID, Asset: quoteAsset},
+		{UserID: fees.HouseUserID, Asset: baseAsset}, {UserID: fees.HouseUserID, Asset: quoteAsset},
+	}
+	if err := database.LockBalances(ctx, tx, balances...); err != nil {
+		return nil, err
+	}
+	positions, err := database.LockPositions(ctx, tx, trade.Symbol, makerOrder.UserID, takerOrder.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	// 3. Record the trade, with each side's fee on the asset it receives
+	if err := database.DeleteOutboxTrade(ctx, tx, trade.ID); err != nil {
+		return nil, err
+	}
+	dbTrade := &models.Trade{
+		ID:           trade.ID,
+		Seq:          int64(trade.Seq),
+		Symbol:       trade.Symbol,
+		MakerOrderID: trade.MakerOrderID,
+		TakerOrderID: trade.TakerOrderID,
+		TakerSide:    trade.Side,
+		Price:        trade.Price,
+		Quantity:     trade.Quantity,
+		MakerFee:     fees.MakerFee(receivedAmount(makerOrder.Side, trade.Price, trade.Quantity)),
+		TakerFee:     fees.TakerFee(receivedAmount(takerOrder.Side, trade.Price, trade.Quantity)),
+		CreatedAt:    trade.Timestamp,
+	}
+	if err := database.CreateTrade(ctx, tx, dbTrade); err != nil {
+		return nil, err
+	}
+
+	// 4. Move funds for both sides and update their fill status
+	// A maker trades at its own limit price, unless a sandbox market order slipped
+	makerLimitPrice := trade.MakerLimitPrice
+	if makerLimitPrice == 0 {
+		makerLimitPrice = trade.Price
+	}
+	fills := []struct {
+		order      *models.Order
+		liquidity  string
+		limitPrice float64
+		fee        float64
+	}{
+		{makerOrder, "maker", makerLimitPrice, dbTrade.MakerFee},
+		{takerOrder, "taker", trade.TakerLimitPrice, dbTrade.TakerFee},
+	}
+	for _, fill := range fills {
+		order := fill.order
+		if err := settleFill(ctx, tx, order, dbTrade.ID, fill.limitPrice, baseAsset, quoteAsset, trade.Price, trade.Quantity, fill.fee); err != nil {
+			return nil, err
+		}
+		if err := database.RecordOrderFill(ctx, tx, order.ID, trade.Price, trade.Quantity); err != nil {
+			return nil, err
+		}
+		position := positions[order.UserID]
+		portfolio.Apply(position, order.Side, trade.Price, trade.Quantity, fill.fee)
+		if err := database.UpdatePosition(ctx, tx, position); err != nil {
+			return nil, err
+		}
+	}
+
+	// 5. Commit
+	if err := tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("failed to commit settlement: %w", err)
+	}
+
+	updates := make([]FillUpdate, len(fills))
+	for i, fill := range fills {
+		updates[i] = FillUpdate{
+			Type:      "fill",
+			UserID:    fill.order.UserID,
+			OrderID:   fill.order.ID,
+			TradeID:   dbTrade.ID,
+			Symbol:    trade.Symbol,
+			Side:      fill.order.Side,
+			Liquidity: fill.liquidity,
+			Price:     trade.Price,
+			Quantity:  trade.Quantity,
+			Fee:       fill.fee,
+			Ts:        trade.Timestamp.UnixMilli(),
+		}
+	}
+	return updates, nil
+}
+
+// settleFill updates one order owner's balances for a fill of quantity at price,
+// paying fee (in the received asset) to the house account. Ledger entries refer to tradeID.
+// A buy locked limitPrice*Quantity of quote up front; when it fills at a better (lower) price
+// the difference is released back to available. Everything the fill took out of locked comes off
+// the order's recorded lock.
+func settleFill(ctx context.Context, tx pgx.Tx, order *models.Order, tradeID uuid.UUID, limitPrice float64, baseAsset, quoteAsset string, price, quantity, fee float64) error {
+	quoteAmount := price * quantity
+	ref := database.LedgerRef{Reason: database.LedgerFill, OrderID: order.ID, TradeID: tradeID}
+	err := database.UpdateBalancesForFill(ctx, tx, order.UserID, baseAsset, quoteAsset, quantity, quoteAmount, fee, order.Side, ref)
+	if err != nil {
+		return fmt.Errorf("failed to update balances for order %s: %w", order.ID, err)
+	}
+
+	if fee > 0 {
+		feeAsset := quoteAsset
+		if order.Side == "buy" {
+			feeAsset = baseAsset
+		}
+		feeRef := database.LedgerRef{Reason: database.LedgerFee, OrderID: order.ID, TradeID: tradeID}
+		if err := database.AddFunds(ctx, tx, fees.HouseUserID, feeAsset, fee, feeRef); err != nil {
+			return fmt.Errorf("failed to credit fee for order %s: %w", order.ID, err)
+		}
+	}
+
+	consumed := quantity
+	if order.Side == "buy" {
+		consumed = quoteAmount
+	}
+	if order.Side == "buy" && limitPrice > price {
+		improvement := (limitPrice - price) * quantity
+		unlockRef := database.LedgerRef{Reason: database.LedgerOrderUnlock, OrderID: order.ID, TradeID: tradeID}
+		if err := database.UnlockFunds(ctx, tx, order.UserID, quoteAsset, improvement, unlockRef); err != nil {
+			return fmt.Errorf("failed to release price improvement for order %s: %w", order.ID, err)
+		}
+		consumed += improvement
+	}
+	return database.AdjustOrderLock(ctx, tx, order.ID, -consumed)
+}
+
+// receivedAmount returns how much of the received asset a side gets from a fill:
+// base quantity for a buy, quote amount for a sell.
+func receivedAmount(side string, price, quantity float64) float64 {
+	if side == "buy" {
+		return quantity
+	}
+	return price * quantity
 }
@@ -0,0 +1,1063 @@
+package orderbook
+
+import (
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"math"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/user/minicoinbase/backend/internal/models"
+)
+
+func newTestOrder(userID uuid.UUID, side string, price, quantity float64) *models.Order {
+	return &models.Order{
+		ID:          uuid.New(),
+		UserID:      userID,
+		Symbol:      "BTC-USD",
+		Type:        "limit",
+		Side:        side,
+		TimeInForce: "GTC",
+		Price:       price,
+		Quantity:    quantity,
+	}
+}
+
+func TestSelfTradePrevention(t *testing.T) {
+	tests := []struct {
+		policy          SelfTradePolicy
+		wantExpired     []string // "own_ask" and/or "buy"
+		wantOwnAskQty   float64  // Remaining quantity of the user's own resting ask, 0 if removed
+		wantBidQuantity float64  // Quantity of the incoming buy left resting on the book
+	}{
+		// Taker remainder is cancelled, the user's own ask stays on the book
+		{policy: CancelNewest, wantExpired: []string{"buy"}, wantOwnAskQty: 1},
+		// The user's own ask is cancelled and the buy keeps going, resting its remainder
+		{policy: CancelOldest, wantExpired: []string{"own_ask"}, wantBidQuantity: 1},
+		// Both go away
+		{policy: CancelBoth, wantExpired: []string{"own_ask", "buy"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(string(tt.policy), func(t *testing.T) {
+			ob := NewOrderBook("BTC-USD")
+			ob.SelfTradePolicy = tt.policy
+			alice, bob := uuid.New(), uuid.New()
+
+			// Bob's ask is first in the queue at 100, Alice's own ask is behind it
+			bobAsk := newTestOrder(bob, "sell", 100, 1)
+			ownAsk := newTestOrder(alice, "sell", 100, 1)
+			for _, o := range []*models.Order{bobAsk, ownAsk} {
+				if _, err := ob.AddOrder(o); err != nil {
+					t.Fatalf("AddOrder: %v", err)
+				}
+			}
+
+			buy := newTestOrder(alice, "buy", 100, 2)
+			result, err := ob.AddOrder(buy)
+			if err != nil {
+				t.Fatalf("AddOrder: %v", err)
+			}
+
+			// Bob's ask is always matched, Alice never trades with herself
+			if len(result.Trades) != 1 {
+				t.Fatalf("got %d trades, want 1", len(result.Trades))
+			}
+			if trade := result.Trades[0]; trade.MakerOrderID != bobAsk.ID || trade.Quantity != 1 {
+				t.Errorf("got trade maker=%s qty=%f, want maker=%s qty=1", trade.MakerOrderID, trade.Quantity, bobAsk.ID)
+			}
+
+			names := map[uuid.UUID]string{ownAsk.ID: "own_ask", buy.ID: "buy"}
+			if len(result.Expired) != len(tt.wantExpired) {
+				t.Fatalf("got %d expired orders, want %v", len(result.Expired), tt.wantExpired)
+			}
+			for i, e := range result.Expired {
+				if names[e.Order.ID] != tt.wantExpired[i] || e.Reason != "self_trade" || e.Quantity != 1 {
+					t.Errorf("expired[%d] = %s (%s, %f), want %s (self_trade, 1)", i, names[e.Order.ID], e.Reason, e.Quantity, tt.wantExpired[i])
+				}
+			}
+
+			depth := ob.GetDepth(0)
+			gotOwnAsk := 0.0
+			if order, resting := ob.Orders[ownAsk.ID]; resting {
+				gotOwnAsk = order.Remaining
+			}
+			if gotOwnAsk != tt.wantOwnAskQty {
+				t.Errorf("own ask resting quantity = %f, want %f", gotOwnAsk, tt.wantOwnAskQty)
+			}
+			gotBid := 0.0
+			if len(depth.Bids) > 0 {
+				gotBid = depth.Bids[0].Quantity
+			}
+			if gotBid != tt.wantBidQuantity {
+				t.Errorf("resting bid quantity = %f, want %f", gotBid, tt.wantBidQuantity)
+			}
+		})
+	}
+}
+
+func TestFOKWithSelfTrade(t *testing.T) {
+	tests := []struct {
+		policy      SelfTradePolicy
+		quantity    float64
+		wantFilled  float64
+		wantExpired []string // Reasons, in order
+		wantOwnAsk  bool     // Whether the user's own ask is still resting
+	}{
+		// Matching would stop at the own ask after Bob's, so a FOK for both is killed untouched
+		{policy: CancelNewest, quantity: 2, wantExpired: []string{"fok"}, wantOwnAsk: true},
+		{policy: CancelBoth, quantity: 2, wantExpired: []string{"fok"}, wantOwnAsk: true},
+		// What is ahead of the own ask can still fill one in full
+		{policy: CancelNewest, quantity: 1, wantFilled: 1, wantOwnAsk: true},
+		// The own ask is cancelled and matching goes on to Carol's behind it
+		{policy: CancelOldest, quantity: 2, wantFilled: 2, wantExpired: []string{"self_trade"}},
+		{policy: CancelOldest, quantity: 3, wantExpired: []string{"fok"}, wantOwnAsk: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("%s_%g", tt.policy, tt.quantity), func(t *testing.T) {
+			ob := NewOrderBook("BTC-USD")
+			ob.SelfTradePolicy = tt.policy
+			alice, bob, carol := uuid.New(), uuid.New(), uuid.New()
+
+			ownAsk := newTestOrder(alice, "sell", 101, 1)
+			for _, o := range []*models.Order{newTestOrder(bob, "sell", 100, 1), ownAsk, newTestOrder(carol, "sell", 101, 1)} {
+				if _, err := ob.AddOrder(o); err != nil {
+					t.Fatalf("AddOrder: %v", err)
+				}
+			}
+
+			fok := newTestOrder(alice, "buy", 101, tt.quantity)
+			fok.TimeInForce = "FOK"
+			result, err := ob.AddOrder(fok)
+			if err != nil {
+				t.Fatalf("AddOrder: %v", err)
+			}
+
+			filled := 0.0
+			for _, trade := range result.Trades {
+				filled += trade.Quantity
+			}
+			if filled != tt.wantFilled {
+				t.Errorf("filled %v, want %v", filled, tt.wantFilled)
+			}
+			var reasons []string
+			for _, e := range result.Expired {
+				reasons = append(reasons, e.Reason)
+			}
+			if !slices.Equal(reasons, tt.wantExpired) {
+				t.Errorf("expired reasons = %v, want %v", reasons, tt.wantExpired)
+			}
+			if _, resting := ob.Orders[ownAsk.ID]; resting != tt.wantOwnAsk {
+				t.Errorf("own ask resting = %v, want %v", resting, tt.wantOwnAsk)
+			}
+		})
+	}
+}
+
+func TestReplaceOrderPriority(t *testing.T) {
+	tests := []struct {
+		name      string
+		price     float64
+		quantity  float64
+		wantFirst bool // Whether the replaced order is still first in the queue at 100
+	}{
+		{name: "decrease keeps priority", price: 100, quantity: 0.5, wantFirst: true},
+		{name: "increase loses priority", price: 100, quantity: 2, wantFirst: false},
+		{name: "price change loses priority", price: 101, quantity: 1, wantFirst: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ob := NewOrderBook("BTC-USD")
+			first := newTestOrder(uuid.New(), "sell", 100, 1)
+			second := newTestOrder(uuid.New(), "sell", 100, 1)
+			for _, o := range []*models.Order{first, second} {
+				if _, err := ob.AddOrder(o); err != nil {
+					t.Fatalf("AddOrder: %v", err)
+				}
+			}
+
+			if _, err := ob.ReplaceOrder(first.ID, 1, tt.price, tt.quantity); err != nil {
+				t.Fatalf("ReplaceOrder: %v", err)
+			}
+			if tt.price != 100 {
+				// Bring the order back to 100 without a quantity change to compare queue positions
+				if _, err := ob.ReplaceOrder(first.ID, tt.quantity, 100, tt.quantity); err != nil {
+					t.Fatalf("ReplaceOrder back: %v", err)
+				}
+			}
+
+			front := ob.asks.levels[100].orders.Front().Value.(*bookOrder)
+			if gotFirst := front.ID == first.ID; gotFirst != tt.wantFirst {
+				t.Errorf("replaced order first in queue = %v, want %v", gotFirst, tt.wantFirst)
+			}
+			if got := ob.Orders[first.ID].Remaining; got != tt.quantity {
+				t.Errorf("remaining quantity = %v, want %v", got, tt.quantity)
+			}
+		})
+	}
+}
+
+func TestReplaceOrderCrossingMatches(t *testing.T) {
+	ob := NewOrderBook("BTC-USD")
+	ask := newTestOrder(uuid.New(), "sell", 101, 1)
+	bid := newTestOrder(uuid.New(), "buy", 100, 2)
+	for _, o := range []*models.Order{ask, bid} {
+		if _, err := ob.AddOrder(o); err != nil {
+			t.Fatalf("AddOrder: %v", err)
+		}
+	}
+
+	// A stale expected quantity changes nothing
+	if _, err := ob.ReplaceOrder(bid.ID, 1.5, 101, 2); err != ErrOrderChanged {
+		t.Fatalf("ReplaceOrder with stale quantity: err = %v, want ErrOrderChanged", err)
+	}
+
+	result, err := ob.ReplaceOrder(bid.ID, 2, 101, 2)
+	if err != nil {
+		t.Fatalf("ReplaceOrder: %v", err)
+	}
+	if len(result.Trades) != 1 || result.Trades[0].Price != 101 || result.Trades[0].Quantity != 1 {
+		t.Fatalf("trades = %+v, want one trade of 1 at 101", result.Trades)
+	}
+	if result.Trades[0].TakerLimitPrice != 101 {
+		t.Errorf("taker limit price = %v, want 101", result.Trades[0].TakerLimitPrice)
+	}
+	if got := ob.Orders[bid.ID].Remaining; got != 1 {
+		t.Errorf("bid remaining after crossing = %v, want 1", got)
+	}
+}
+
+func TestFillsLeaveOrderQuantity(t *testing.T) {
+	ob := NewOrderBook("BTC-USD")
+	// Partially filled before it (re-)entered the book, as on recovery
+	bid := newTestOrder(uuid.New(), "buy", 100, 2)
+	bid.FilledQuantity = 0.5
+	if _, err := ob.AddOrder(bid); err != nil {
+		t.Fatalf("AddOrder: %v", err)
+	}
+	if _, err := ob.AddOrder(newTestOrder(uuid.New(), "sell", 100, 1)); err != nil {
+		t.Fatalf("AddOrder: %v", err)
+	}
+
+	order, left, resting := ob.GetOrder(bid.ID)
+	if !resting || left != 0.5 || order.Quantity != 2 || bid.Quantity != 2 {
+		t.Errorf("bid on book = %v with %v left, quantity %v (model %v), want true with 0.5 left, quantity 2",
+			resting, left, order.Quantity, bid.Quantity)
+	}
+	if depth := ob.GetDepth(0); len(depth.Bids) != 1 || depth.Bids[0].Quantity != 0.5 {
+		t.Errorf("bids = %v, want 0.5 at 100", depth.Bids)
+	}
+
+	// Shrinking the remainder shrinks the order by as much, what filled stays filled
+	if _, err := ob.ReplaceOrder(bid.ID, 0.5, 100, 0.25); err != nil {
+		t.Fatalf("ReplaceOrder: %v", err)
+	}
+	if order, _, _ := ob.GetOrder(bid.ID); order.Quantity != 1.75 {
+		t.Errorf("quantity after replace = %v, want 1.75", order.Quantity)
+	}
+	if left, err := ob.CancelOrder(bid.ID); err != nil || left != 0.25 {
+		t.Errorf("CancelOrder = %v, %v, want 0.25 left", left, err)
+	}
+}
+
+func TestSimulateMatch(t *testing.T) {
+	ob := NewOrderBook("BTC-USD")
+	alice, bob := uuid.New(), uuid.New()
+	for _, o := range []*models.Order{
+		newTestOrder(bob, "sell", 100, 1),
+		newTestOrder(bob, "sell", 102, 1),
+		newTestOrder(alice, "sell", 103, 1),
+	} {
+		if _, err := ob.AddOrder(o); err != nil {
+			t.Fatalf("AddOrder: %v", err)
+		}
+	}
+	before := ob.GetDepth(0)
+
+	tests := []struct {
+		name    string
+		order   func() *models.Order
+		want    SimulatedMatch
+		wantErr error
+	}{
+		{name: "partial fill rests", order: func() *models.Order { return newTestOrder(alice, "buy", 102, 3) },
+			want: SimulatedMatch{FilledQuantity: 2, AvgPrice: 101, Remaining: 1, Resting: 1}},
+		{name: "IOC remainder discarded", order: func() *models.Order {
+			o := newTestOrder(alice, "buy", 100, 2)
+			o.TimeInForce = "IOC"
+			return o
+		}, want: SimulatedMatch{FilledQuantity: 1, AvgPrice: 100, Remaining: 1}},
+		{name: "FOK that can't fill", order: func() *models.Order {
+			o := newTestOrder(alice, "buy", 100, 2)
+			o.TimeInForce = "FOK"
+			return o
+		}, want: SimulatedMatch{Remaining: 2}},
+		{name: "self-trade cancels the rest", order: func() *models.Order { return newTestOrder(alice, "buy", 103, 4) },
+			want: SimulatedMatch{FilledQuantity: 2, AvgPrice: 101, Remaining: 2}},
+		{name: "pending stop", order: func() *models.Order {
+			o := newTestOrder(alice, "buy", 110, 1)
+			o.Type, o.StopPrice = "stop_limit", 105
+			return o
+		}, want: SimulatedMatch{Remaining: 1, StopPending: true}},
+		{name: "post-only crossing", order: func() *models.Order {
+			o := newTestOrder(alice, "buy", 100, 1)
+			o.PostOnly = true
+			return o
+		}, wantErr: ErrPostOnlyWouldCross},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ob.SimulateMatch(tt.order())
+			if err != tt.wantErr {
+				t.Fatalf("SimulateMatch err = %v, want %v", err, tt.wantErr)
+			}
+			if err == nil && *got != tt.want {
+				t.Errorf("SimulateMatch = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+
+	// Nothing was executed
+	if after := ob.GetDepth(0); after.Seq != before.Seq || !slices.Equal(after.Asks, before.Asks) {
+		t.Errorf("book changed by simulation: %+v, want %+v", after, before)
+	}
+}
+
+func TestQuote(t *testing.T) {
+	ob := NewOrderBook("BTC-USD")
+	for _, o := range []*models.Order{
+		newTestOrder(uuid.New(), "sell", 100, 1),
+		newTestOrder(uuid.New(), "sell", 100, 1),
+		newTestOrder(uuid.New(), "sell", 104, 2),
+		newTestOrder(uuid.New(), "buy", 99, 1),
+	} {
+		if _, err := ob.AddOrder(o); err != nil {
+			t.Fatalf("AddOrder: %v", err)
+		}
+	}
+
+	tests := []struct {
+		side     string
+		quantity float64
+		want     Quote
+	}{
+		{"buy", 1, Quote{FilledQuantity: 1, AvgPrice: 100, Cost: 100, WorstPrice: 100}},
+		{"buy", 3, Quote{FilledQuantity: 3, AvgPrice: 102 - 2.0/3, Cost: 304, WorstPrice: 104}},
+		{"buy", 5, Quote{FilledQuantity: 4, AvgPrice: 102, Cost: 408, WorstPrice: 104}}, // Book too thin
+		{"sell", 0.5, Quote{FilledQuantity: 0.5, AvgPrice: 99, Cost: 49.5, WorstPrice: 99}},
+	}
+	for _, tt := range tests {
+		got := ob.Quote(tt.side, tt.quantity)
+		tt.want.Symbol, tt.want.Seq, tt.want.Side, tt.want.Quantity = "BTC-USD", got.Seq, tt.side, tt.quantity
+		if math.Abs(got.AvgPrice-tt.want.AvgPrice) < 1e-9 {
+			got.AvgPrice = tt.want.AvgPrice
+		}
+		if *got != tt.want {
+			t.Errorf("Quote(%s, %v) = %+v, want %+v", tt.side, tt.quantity, *got, tt.want)
+		}
+	}
+}
+
+func TestHaltedBookRejectsOrdersButAllowsCancels(t *testing.T) {
+	ob := NewOrderBook("BTC-USD")
+	resting := newTestOrder(uuid.New(), "sell", 100, 1)
+	if _, err := ob.AddOrder(resting); err != nil {
+		t.Fatalf("AddOrder: %v", err)
+	}
+
+	ob.SetHalted(true)
+	if !ob.GetDepth(0).Halted {
+		t.Error("depth of a halted book has halted = false")
+	}
+	if _, err := ob.AddOrder(newTestOrder(uuid.New(), "buy", 100, 1)); !errors.Is(err, ErrSymbolHalted) {
+		t.Errorf("AddOrder on halted book: err = %v, want ErrSymbolHalted", err)
+	}
+	if _, err := ob.ReplaceOrder(resting.ID, 1, 101, 1); !errors.Is(err, ErrSymbolHalted) {
+		t.Errorf("ReplaceOrder on halted book: err = %v, want ErrSymbolHalted", err)
+	}
+	if _, err := ob.CancelOrder(resting.ID); err != nil {
+		t.Errorf("CancelOrder on halted book: %v", err)
+	}
+
+	ob.SetHalted(false)
+	if _, err := ob.AddOrder(newTestOrder(uuid.New(), "buy", 100, 1)); err != nil {
+		t.Errorf("AddOrder after resume: %v", err)
+	}
+}
+
+func TestGetDepthLimitsLevels(t *testing.T) {
+	ob := NewOrderBook("BTC-USD")
+	// Interleaved prices so the heap is not already in sorted order
+	for _, p := range []float64{95, 91, 99, 93, 97, 92, 98, 90, 96, 94} {
+		if _, err := ob.AddOrder(newTestOrder(uuid.New(), "buy", p, 1)); err != nil {
+			t.Fatalf("AddOrder: %v", err)
+		}
+		if _, err := ob.AddOrder(newTestOrder(uuid.New(), "sell", p+100, 2)); err != nil {
+			t.Fatalf("AddOrder: %v", err)
+		}
+	}
+
+	full := ob.GetDepth(0)
+	if len(full.Bids) != 10 || len(full.Asks) != 10 {
+		t.Fatalf("full depth has %d bids / %d asks, want 10 / 10", len(full.Bids), len(full.Asks))
+	}
+	for _, n := range []int{1, 3, 9, 10, 20} {
+		depth := ob.GetDepth(n)
+		want := min(n, 10)
+		if len(depth.Bids) != want || len(depth.Asks) != want {
+			t.Fatalf("GetDepth(%d) has %d bids / %d asks, want %d", n, len(depth.Bids), len(depth.Asks), want)
+		}
+		for i := 0; i < want; i++ {
+			if depth.Bids[i] != full.Bids[i] || depth.Asks[i] != full.Asks[i] {
+				t.Errorf("GetDepth(%d) level %d = %v / %v, want %v / %v", n, i, depth.Bids[i], depth.Asks[i], full.Bids[i], full.Asks[i])
+			}
+		}
+	}
+	if full.Bids[0].Price != 99 || full.Asks[0].Price != 190 {
+		t.Errorf("best bid/ask = %v/%v, want 99/190", full.Bids[0].Price, full.Asks[0].Price)
+	}
+}
+
+func TestGetLiveOrders(t *testing.T) {
+	ob := NewOrderBook("BTC-USD")
+	start := time.Now()
+	older := newTestOrder(uuid.New(), "buy", 100, 1)
+	newer := newTestOrder(uuid.New(), "buy", 100, 2)
+	worse := newTestOrder(uuid.New(), "buy", 99, 3)
+	ask := newTestOrder(uuid.New(), "sell", 101, 4)
+	ask.DisplayQuantity = 1
+	stop := newTestOrder(uuid.New(), "sell", 89, 5)
+	stop.Type, stop.StopPrice = "stop_limit", 90
+	for i, o := range []*models.Order{worse, older, newer, ask, stop} {
+		o.CreatedAt = start.Add(time.Duration(i) * time.Second)
+		if _, err := ob.AddOrder(o); err != nil {
+			t.Fatalf("AddOrder: %v", err)
+		}
+	}
+	// A partial fill counts down the resting order's remaining quantity
+	if _, err := ob.AddOrder(newTestOrder(uuid.New(), "sell", 100, 0.5)); err != nil {
+		t.Fatalf("AddOrder: %v", err)
+	}
+
+	live := ob.GetLiveOrders()
+	want := []struct {
+		order     *models.Order
+		parked    bool
+		remaining float64
+		visible   float64
+	}{
+		{older, false, 0.5, 0},
+		{newer, false, 2, 0},
+		{worse, false, 3, 0},
+		{stop, true, 5, 0}, // Sells after the bids, by price
+		{ask, false, 4, 1},
+	}
+	if len(live.Orders) != len(want) {
+		t.Fatalf("GetLiveOrders has %d orders, want %d", len(live.Orders), len(want))
+	}
+	for i, w := range want {
+		got := live.Orders[i]
+		if got.OrderID != w.order.ID || got.UserID != w.order.UserID || got.Side != w.order.Side || got.Price != w.order.Price ||
+			got.Parked != w.parked || got.Remaining != w.remaining || got.Visible != w.visible {
+			t.Errorf("order %d = %+v, want %v (user %v) %s at %v, parked %v, %v remaining, %v visible", i, got,
+				w.order.ID, w.order.UserID, w.order.Side, w.order.Price, w.parked, w.remaining, w.visible)
+		}
+	}
+}
+
+func TestGetRawBook(t *testing.T) {
+	ob := NewOrderBook("BTC-USD")
+	// Two orders at the best bid, the older one first in the queue
+	older := newTestOrder(uuid.New(), "buy", 100, 1)
+	newer := newTestOrder(uuid.New(), "buy", 100, 2)
+	worse := newTestOrder(uuid.New(), "buy", 99, 3)
+	ask := newTestOrder(uuid.New(), "sell", 101, 4)
+	for _, o := range []*models.Order{worse, older, newer, ask} {
+		if _, err := ob.AddOrder(o); err != nil {
+			t.Fatalf("AddOrder: %v", err)
+		}
+	}
+
+	tests := []struct {
+		maxOrders int
+		bids      []*models.Order
+	}{
+		{0, []*models.Order{older, newer, worse}},
+		{2, []*models.Order{older, newer}},
+		{1, []*models.Order{older}},
+	}
+	for _, tt := range tests {
+		book := ob.GetRawBook(tt.maxOrders)
+		if len(book.Bids) != len(tt.bids) || len(book.Asks) != 1 {
+			t.Fatalf("GetRawBook(%d) has %d bids / %d asks, want %d / 1", tt.maxOrders, len(book.Bids), len(book.Asks), len(tt.bids))
+		}
+		for i, want := range tt.bids {
+			got := book.Bids[i]
+			if *got.OrderID != want.ID || got.Price != want.Price || got.Quantity != want.Quantity {
+				t.Errorf("GetRawBook(%d) bid %d = %v %v x %v, want %v %v x %v", tt.maxOrders, i,
+					*got.OrderID, got.Price, got.Quantity, want.ID, want.Price, want.Quantity)
+			}
+		}
+	}
+}
+
+func TestBestBidAskAndSpread(t *testing.T) {
+	ob := NewOrderBook("BTC-USD")
+	if _, ok := ob.Spread(); ok {
+		t.Error("empty book has a spread")
+	}
+	if ticker := ob.Ticker(); ticker.BestBid != nil || ticker.BestAsk != nil || ticker.Spread != nil {
+		t.Errorf("empty book ticker = %+v, want no levels", ticker)
+	}
+
+	for _, o := range []*models.Order{
+		newTestOrder(uuid.New(), "buy", 99, 1),
+		newTestOrder(uuid.New(), "buy", 99, 2),
+		newTestOrder(uuid.New(), "buy", 98, 5),
+		newTestOrder(uuid.New(), "sell", 101.5, 0.5),
+		newTestOrder(uuid.New(), "sell", 103, 4),
+	} {
+		if _, err := ob.AddOrder(o); err != nil {
+			t.Fatalf("AddOrder: %v", err)
+		}
+	}
+
+	if bid, ok := ob.BestBid(); !ok || bid != (BookLevel{Price: 99, Quantity: 3}) {
+		t.Errorf("BestBid = %v, %v, want 99 x 3", bid, ok)
+	}
+	if ask, ok := ob.BestAsk(); !ok || ask != (BookLevel{Price: 101.5, Quantity: 0.5}) {
+		t.Errorf("BestAsk = %v, %v, want 101.5 x 0.5", ask, ok)
+	}
+	if spread, ok := ob.Spread(); !ok || spread != 2.5 {
+		t.Errorf("Spread = %v, %v, want 2.5", spread, ok)
+	}
+	if ticker := ob.Ticker(); ticker.Spread == nil || *ticker.Spread != 2.5 {
+		t.Errorf("Ticker spread = %v, want 2.5", ticker.Spread)
+	}
+}
+
+func TestMarketOrderSweepsBookAndNeverRests(t *testing.T) {
+	ob := NewOrderBook("BTC-USD")
+	for _, o := range []*models.Order{
+		newTestOrder(uuid.New(), "sell", 100, 1),
+		newTestOrder(uuid.New(), "sell", 105, 1),
+	} {
+		if _, err := ob.AddOrder(o); err != nil {
+			t.Fatalf("AddOrder: %v", err)
+		}
+	}
+
+	// The price of a market order is meaningless and must not stop it from matching
+	buy := newTestOrder(uuid.New(), "buy", 1, 3)
+	buy.Type = "market"
+	result, err := ob.AddOrder(buy)
+	if err != nil {
+		t.Fatalf("AddOrder(market): %v", err)
+	}
+
+	if len(result.Trades) != 2 || result.Trades[0].Price != 100 || result.Trades[1].Price != 105 {
+		t.Fatalf("got trades %+v, want fills at 100 then 105", result.Trades)
+	}
+	if len(result.Expired) != 1 || result.Expired[0].Order.ID != buy.ID || result.Expired[0].Reason != "market" || result.Expired[0].Quantity != 1 {
+		t.Errorf("got expired %+v, want the buy's remaining 1 expired as market", result.Expired)
+	}
+	if _, _, resting := ob.GetOrder(buy.ID); resting {
+		t.Error("market order remainder is resting on the book")
+	}
+	if depth := ob.GetDepth(0); len(depth.Bids) != 0 || len(depth.Asks) != 0 {
+		t.Errorf("book = %+v, want empty", depth)
+	}
+}
+
+func TestSandboxSlippage(t *testing.T) {
+	ob := NewOrderBook("BTC-USD")
+	ob.MaxSlippage = 0.01
+	for i := 0; i < 20; i++ {
+		for _, o := range []*models.Order{newTestOrder(uuid.New(), "sell", 100, 1), newTestOrder(uuid.New(), "buy", 90, 1)} {
+			if _, err := ob.AddOrder(o); err != nil {
+				t.Fatalf("AddOrder: %v", err)
+			}
+		}
+	}
+
+	trade := func(order *models.Order) *Trade {
+		t.Helper()
+		result, err := ob.AddOrder(order)
+		if err != nil || len(result.Trades) != 1 {
+			t.Fatalf("AddOrder: %d trades, err %v, want 1 trade", len(result.Trades), err)
+		}
+		return result.Trades[0]
+	}
+	for i := 0; i < 5; i++ {
+		buy := newTestOrder(uuid.New(), "buy", 0, 1)
+		buy.Type = "market"
+		if tr := trade(buy); tr.Price < 100 || tr.Price > 101 || tr.MakerLimitPrice != 100 {
+			t.Errorf("market buy traded at %v (maker limit %v), want 100 to 101 (100)", tr.Price, tr.MakerLimitPrice)
+		}
+		sell := newTestOrder(uuid.New(), "sell", 0, 1)
+		sell.Type = "market"
+		if tr := trade(sell); tr.Price < 89.1 || tr.Price > 90 || tr.MakerLimitPrice != 90 {
+			t.Errorf("market sell traded at %v (maker limit %v), want 89.1 to 90 (90)", tr.Price, tr.MakerLimitPrice)
+		}
+
+		protected := newTestOrder(uuid.New(), "buy", 0, 1)
+		protected.Type, protected.ProtectionPrice = "market", 100.1
+		if tr := trade(protected); tr.Price < 100 || tr.Price > 100.1 {
+			t.Errorf("protected market buy traded at %v, want 100 to 100.1", tr.Price)
+		}
+		if tr := trade(newTestOrder(uuid.New(), "buy", 100, 1)); tr.Price != 100 {
+			t.Errorf("limit buy traded at %v, want 100: only market orders slip", tr.Price)
+		}
+	}
+
+	// A quote-denominated buy never spends more than its quote at the slipped price
+	quoteBuy := newTestOrder(uuid.New(), "buy", 0, 0)
+	quoteBuy.Type, quoteBuy.QuoteQuantity = "market", 250
+	result, err := ob.AddOrder(quoteBuy)
+	if err != nil {
+		t.Fatalf("AddOrder(quote buy): %v", err)
+	}
+	spent := 0.0
+	for _, tr := range result.Trades {
+		spent += tr.Price * tr.Quantity
+	}
+	if spent > 250+1e-9 {
+		t.Errorf("quote buy spent %v, more than its 250", spent)
+	}
+}
+
+func TestMarketOrderStopsAtProtectionPrice(t *testing.T) {
+	tests := []struct {
+		name       string
+		side       string
+		protection float64
+		wantPrices []float64
+		wantReason string
+	}{
+		{"buy stops below the thin level", "buy", 105, []float64{100, 105}, "protection"},
+		{"buy protected beyond the book", "buy", 200, []float64{100, 105, 150}, "market"},
+		{"sell stops above the thin level", "sell", 95, []float64{99, 95}, "protection"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ob := NewOrderBook("BTC-USD")
+			for _, o := range []*models.Order{
+				newTestOrder(uuid.New(), "sell", 100, 1),
+				newTestOrder(uuid.New(), "sell", 105, 1),
+				newTestOrder(uuid.New(), "sell", 150, 1),
+				newTestOrder(uuid.New(), "buy", 99, 1),
+				newTestOrder(uuid.New(), "buy", 95, 1),
+				newTestOrder(uuid.New(), "buy", 50, 1),
+			} {
+				if _, err := ob.AddOrder(o); err != nil {
+					t.Fatalf("AddOrder: %v", err)
+				}
+			}
+
+			order := newTestOrder(uuid.New(), tt.side, 0, 4)
+			order.Type = "market"
+			order.ProtectionPrice = tt.protection
+			result, err := ob.AddOrder(order)
+			if err != nil {
+				t.Fatalf("AddOrder(market): %v", err)
+			}
+
+			var prices []float64
+			var filled, notional float64
+			for _, trade := range result.Trades {
+				prices = append(prices, trade.Price)
+				filled += trade.Quantity
+				notional += trade.Price * trade.Quantity
+				if trade.TakerLimitPrice != tt.protection {
+					// Settlement releases a buy's price improvement against it
+					t.Errorf("trade taker limit price %v, want the protection price %v", trade.TakerLimitPrice, tt.protection)
+				}
+			}
+			if !slices.Equal(prices, tt.wantPrices) {
+				t.Fatalf("fills at %v, want %v", prices, tt.wantPrices)
+			}
+			// Each fill is at its maker's price, so the average is theirs weighted by quantity
+			wantAvg := 0.0
+			for _, price := range tt.wantPrices {
+				wantAvg += price
+			}
+			wantAvg /= float64(len(tt.wantPrices))
+			if avg := notional / filled; math.Abs(avg-wantAvg) > 1e-9 {
+				t.Errorf("average fill price %v, want %v", avg, wantAvg)
+			}
+			if len(result.Expired) != 1 || result.Expired[0].Reason != tt.wantReason || result.Expired[0].Quantity != 4-filled {
+				t.Errorf("got expired %+v, want the remaining %v expired as %s", result.Expired, 4-filled, tt.wantReason)
+			}
+		})
+	}
+}
+
+func TestQuoteQuantityMarketBuy(t *testing.T) {
+	tests := []struct {
+		name        string
+		spend       float64
+		wantFills   []float64 // Quantities, at 100 then 105
+		wantUnspent float64
+	}{
+		{"spends it all across levels", 310, []float64{1, 2}, 0},
+		{"buys whole steps only", 150, []float64{1, 0.4}, 8},
+		{"part of the best level", 50, []float64{0.5}, 0},
+		{"too little for a step", 5, nil, 5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ob := NewOrderBook("BTC-USD")
+			ob.StepSize = 0.1
+			for _, o := range []*models.Order{
+				newTestOrder(uuid.New(), "sell", 100, 1),
+				newTestOrder(uuid.New(), "sell", 105, 2),
+			} {
+				if _, err := ob.AddOrder(o); err != nil {
+					t.Fatalf("AddOrder: %v", err)
+				}
+			}
+
+			buy := newTestOrder(uuid.New(), "buy", 0, 0)
+			buy.Type = "market"
+			buy.QuoteQuantity = tt.spend
+			result, err := ob.AddOrder(buy)
+			if err != nil {
+				t.Fatalf("AddOrder(quote market): %v", err)
+			}
+
+			if len(result.Trades) != len(tt.wantFills) {
+				t.Fatalf("got %d trades, want %d", len(result.Trades), len(tt.wantFills))
+			}
+			for i, trade := range result.Trades {
+				if math.Abs(trade.Quantity-tt.wantFills[i]) > 1e-9 {
+					t.Errorf("trade %d quantity = %v, want %v", i, trade.Quantity, tt.wantFills[i])
+				}
+			}
+			// Always leaves the book through Expired, so its unspent quote is released
+			if len(result.Expired) != 1 || result.Expired[0].Order.ID != buy.ID || result.Expired[0].Reason != "market" {
+				t.Fatalf("got expired %+v, want the buy expired as market", result.Expired)
+			}
+			if got := result.Expired[0].Quote; math.Abs(got-tt.wantUnspent) > 1e-9 {
+				t.Errorf("unspent quote = %v, want %v", got, tt.wantUnspent)
+			}
+			if _, _, resting := ob.GetOrder(buy.ID); resting {
+				t.Error("quote market order is resting on the book")
+			}
+		})
+	}
+}
+
+func TestTradesAndDepthUpdatesSince(t *testing.T) {
+	ob := NewOrderBook("BTC-USD")
+	for i := 0; i < 3; i++ {
+		if _, err := ob.AddOrder(newTestOrder(uuid.New(), "sell", 100, 1)); err != nil {
+			t.Fatalf("AddOrder: %v", err)
+		}
+	}
+	if _, err := ob.AddOrder(newTestOrder(uuid.New(), "buy", 100, 3)); err != nil {
+		t.Fatalf("AddOrder: %v", err)
+	}
+
+	trades, ok := ob.TradesSince(1)
+	if !ok || len(trades) != 2 || trades[0].Seq != 2 || trades[1].Seq != 3 {
+		t.Errorf("TradesSince(1) = %v, %v, want trades 2 and 3", trades, ok)
+	}
+	if trades, ok := ob.TradesSince(3); !ok || len(trades) != 0 {
+		t.Errorf("TradesSince(3) = %v, %v, want none", trades, ok)
+	}
+	// Three resting asks and one buy that took them all: four depth updates
+	updates, ok := ob.DepthUpdatesSince(2)
+	if !ok || len(updates) != 2 || updates[0].Seq != 3 || updates[1].Seq != 4 {
+		t.Errorf("DepthUpdatesSince(2) = %v, %v, want updates 3 and 4", updates, ok)
+	}
+
+	// Push the first trades out of the history
+	for i := 0; i < historySize; i++ {
+		ob.AddOrder(newTestOrder(uuid.New(), "sell", 100, 1))
+		ob.AddOrder(newTestOrder(uuid.New(), "buy", 100, 1))
+	}
+	if _, ok := ob.TradesSince(1); ok {
+		t.Error("TradesSince(1) succeeded after trade 2 left the history")
+	}
+	if _, ok := ob.DepthUpdatesSince(2); ok {
+		t.Error("DepthUpdatesSince(2) succeeded after update 3 left the history")
+	}
+	if trades, ok := ob.TradesSince(ob.tradeSeq - 1); !ok || len(trades) != 1 {
+		t.Errorf("TradesSince(last-1) = %v, %v, want the last trade", trades, ok)
+	}
+}
+
+func TestMatchOrder(t *testing.T) {
+	type order struct {
+		name     string
+		side     string
+		price    float64
+		quantity float64
+	}
+	type fill struct {
+		maker    string
+		price    float64
+		quantity float64
+	}
+	tests := []struct {
+		name      string
+		resting   []order  // Added in this order, so earlier orders at a price are ahead in its queue
+		cancel    []string // Resting orders cancelled before the incoming order arrives
+		incoming  order
+		wantFills []fill
+		wantLeft  map[string]float64 // Remaining quantity on the book per order, absent if gone
+		wantBids  []BookLevel
+		wantAsks  []BookLevel
+	}{
+		{
+			name:      "exact fill",
+			resting:   []order{{"a", "sell", 100, 1}},
+			incoming:  order{"in", "buy", 100, 1},
+			wantFills: []fill{{"a", 100, 1}},
+			wantLeft:  map[string]float64{},
+		},
+		{
+			name:      "partial fill of incoming rests the remainder at its own price",
+			resting:   []order{{"a", "sell", 100, 1}},
+			incoming:  order{"in", "buy", 101, 3},
+			wantFills: []fill{{"a", 100, 1}},
+			wantLeft:  map[string]float64{"in": 2},
+			wantBids:  []BookLevel{{Price: 101, Quantity: 2}},
+		},
+		{
+			name:      "partial fill of resting leaves its remainder on the book",
+			resting:   []order{{"a", "sell", 100, 3}, {"b", "sell", 100, 1}},
+			incoming:  order{"in", "buy", 100, 1},
+			wantFills: []fill{{"a", 100, 1}},
+			wantLeft:  map[string]float64{"a": 2, "b": 1},
+			wantAsks:  []BookLevel{{Price: 100, Quantity: 3}},
+		},
+		{
+			name: "one taker consumes several makers, best price then time priority",
+			resting: []order{
+				{"d", "sell", 103, 1}, {"b", "sell", 101, 1}, {"a", "sell", 100, 1}, {"c", "sell", 101, 2},
+			},
+			incoming:  order{"in", "buy", 102, 3},
+			wantFills: []fill{{"a", 100, 1}, {"b", 101, 1}, {"c", 101, 1}},
+			wantLeft:  map[string]float64{"c": 1, "d": 1},
+			wantAsks:  []BookLevel{{Price: 101, Quantity: 1}, {Price: 103, Quantity: 1}},
+		},
+		{
+			name:      "sell walks down the bids",
+			resting:   []order{{"a", "buy", 99, 1}, {"b", "buy", 100, 1}},
+			incoming:  order{"in", "sell", 99, 1.5},
+			wantFills: []fill{{"b", 100, 1}, {"a", 99, 0.5}},
+			wantLeft:  map[string]float64{"a": 0.5},
+			wantBids:  []BookLevel{{Price: 99, Quantity: 0.5}},
+		},
+		{
+			name:     "no match when prices don't cross",
+			resting:  []order{{"a", "sell", 101, 1}},
+			incoming: order{"in", "buy", 100, 1},
+			wantLeft: map[string]float64{"a": 1, "in": 1},
+			wantBids: []BookLevel{{Price: 100, Quantity: 1}},
+			wantAsks: []BookLevel{{Price: 101, Quantity: 1}},
+		},
+		{
+			name:      "cancelled order in the middle of a queue is skipped",
+			resting:   []order{{"a", "sell", 100, 1}, {"b", "sell", 100, 1}, {"c", "sell", 100, 1}},
+			cancel:    []string{"b"},
+			incoming:  order{"in", "buy", 100, 2},
+			wantFills: []fill{{"a", 100, 1}, {"c", 100, 1}},
+			wantLeft:  map[string]float64{},
+		},
+		{
+			name:     "cancelling the only order at a level removes the level",
+			resting:  []order{{"a", "sell", 100, 1}, {"b", "sell", 101, 1}},
+			cancel:   []string{"a"},
+			incoming: order{"in", "buy", 100, 1},
+			wantLeft: map[string]float64{"b": 1, "in": 1},
+			wantBids: []BookLevel{{Price: 100, Quantity: 1}},
+			wantAsks: []BookLevel{{Price: 101, Quantity: 1}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ob := NewOrderBook("BTC-USD")
+			orders := make(map[string]*models.Order)
+			names := make(map[uuid.UUID]string)
+			place := func(o order) *MatchResult {
+				t.Helper()
+				placed := newTestOrder(uuid.New(), o.side, o.price, o.quantity)
+				orders[o.name], names[placed.ID] = placed, o.name
+				result, err := ob.AddOrder(placed)
+				if err != nil {
+					t.Fatalf("AddOrder(%s): %v", o.name, err)
+				}
+				return result
+			}
+
+			for _, o := range tt.resting {
+				if result := place(o); len(result.Trades) != 0 {
+					t.Fatalf("resting order %s traded on entry", o.name)
+				}
+			}
+			for _, name := range tt.cancel {
+				if _, err := ob.CancelOrder(orders[name].ID); err != nil {
+					t.Fatalf("CancelOrder(%s): %v", name, err)
+				}
+			}
+			result := place(tt.incoming)
+
+			if len(result.Trades) != len(tt.wantFills) {
+				t.Fatalf("got %d trades, want %d", len(result.Trades), len(tt.wantFills))
+			}
+			for i, trade := range result.Trades {
+				want := tt.wantFills[i]
+				if names[trade.MakerOrderID] != want.maker || trade.Price != want.price || trade.Quantity != want.quantity {
+					t.Errorf("trade %d = %s at %v x %v, want %s at %v x %v", i,
+						names[trade.MakerOrderID], trade.Price, trade.Quantity, want.maker, want.price, want.quantity)
+				}
+				if trade.TakerOrderID != orders["in"].ID || trade.Side != tt.incoming.side {
+					t.Errorf("trade %d taker = %s (%s), want the incoming %s", i, names[trade.TakerOrderID], trade.Side, tt.incoming.side)
+				}
+			}
+			if len(result.Expired) != 0 {
+				t.Errorf("got %d expired orders, want none", len(result.Expired))
+			}
+
+			for name, o := range orders {
+				_, left, resting := ob.GetOrder(o.ID)
+				want, wantResting := tt.wantLeft[name]
+				if resting != wantResting || left != want {
+					t.Errorf("order %s on book = %v with %v left, want %v with %v left", name, resting, left, wantResting, want)
+				}
+			}
+			depth := ob.GetDepth(0)
+			if !slices.Equal(depth.Bids, tt.wantBids) || !slices.Equal(depth.Asks, tt.wantAsks) {
+				t.Errorf("book bids %v / asks %v, want %v / %v", depth.Bids, depth.Asks, tt.wantBids, tt.wantAsks)
+			}
+		})
+	}
+}
+
+func TestIcebergShowsOnlyItsSliceAndReplenishes(t *testing.T) {
+	ob := NewOrderBook("BTC-USD")
+	iceberg := newTestOrder(uuid.New(), "sell", 100, 10)
+	iceberg.DisplayQuantity = 2
+	if _, err := ob.AddOrder(iceberg); err != nil {
+		t.Fatalf("AddOrder: %v", err)
+	}
+	if depth := ob.GetDepth(0); len(depth.Asks) != 1 || depth.Asks[0].Quantity != 2 {
+		t.Fatalf("asks = %v, want only the 2 on display", depth.Asks)
+	}
+
+	// Takes the slice on display and 1 of the next one, each slice its own trade
+	result, err := ob.AddOrder(newTestOrder(uuid.New(), "buy", 100, 3))
+	if err != nil {
+		t.Fatalf("AddOrder: %v", err)
+	}
+	var quantities []float64
+	for _, trade := range result.Trades {
+		quantities = append(quantities, trade.Quantity)
+	}
+	if !slices.Equal(quantities, []float64{2, 1}) {
+		t.Errorf("trade quantities = %v, want [2 1]", quantities)
+	}
+	if depth := ob.GetDepth(0); len(depth.Asks) != 1 || depth.Asks[0].Quantity != 1 {
+		t.Errorf("asks = %v, want the 1 left of the second slice", depth.Asks)
+	}
+	if _, remaining, ok := ob.GetOrder(iceberg.ID); !ok || remaining != 7 {
+		t.Errorf("iceberg remaining = %v (in book %v), want 7", remaining, ok)
+	}
+
+	// The hidden reserve counts for FOK orders, so one for all of it fills
+	fok := newTestOrder(uuid.New(), "buy", 100, 7)
+	fok.TimeInForce = "FOK"
+	result, err = ob.AddOrder(fok)
+	if err != nil {
+		t.Fatalf("AddOrder: %v", err)
+	}
+	filled := 0.0
+	for _, trade := range result.Trades {
+		filled += trade.Quantity
+	}
+	if filled != 7 || len(result.Expired) != 0 {
+		t.Errorf("FOK filled %v with %d expired, want 7 and none", filled, len(result.Expired))
+	}
+	if _, _, ok := ob.GetOrder(iceberg.ID); ok {
+		t.Error("filled iceberg still in the book")
+	}
+}
+
+func TestIcebergRefreshedSliceQueuesBehind(t *testing.T) {
+	ob := NewOrderBook("BTC-USD")
+	iceberg := newTestOrder(uuid.New(), "sell", 100, 5)
+	iceberg.DisplayQuantity = 1
+	behind := newTestOrder(uuid.New(), "sell", 100, 1)
+	for _, o := range []*models.Order{iceberg, behind} {
+		if _, err := ob.AddOrder(o); err != nil {
+			t.Fatalf("AddOrder: %v", err)
+		}
+	}
+
+	// The iceberg is first in line for its first slice only; the next one goes to the back
+	result, err := ob.AddOrder(newTestOrder(uuid.New(), "buy", 100, 1.5))
+	if err != nil {
+		t.Fatalf("AddOrder: %v", err)
+	}
+	if len(result.Trades) != 2 {
+		t.Fatalf("got %d trades, want 2", len(result.Trades))
+	}
+	if result.Trades[0].MakerOrderID != iceberg.ID || result.Trades[0].Quantity != 1 {
+		t.Errorf("first trade = %s for %v, want the iceberg's slice of 1", result.Trades[0].MakerOrderID, result.Trades[0].Quantity)
+	}
+	if result.Trades[1].MakerOrderID != behind.ID || result.Trades[1].Quantity != 0.5 {
+		t.Errorf("second trade = %s for %v, want 0.5 from the order behind it", result.Trades[1].MakerOrderID, result.Trades[1].Quantity)
+	}
+
+	raw := ob.GetRawBook(0)
+	if len(raw.Asks) != 2 || *raw.Asks[0].OrderID != behind.ID || *raw.Asks[1].OrderID != iceberg.ID || raw.Asks[1].Quantity != 1 {
+		t.Errorf("asks = %+v, want the other order first and the iceberg showing 1 behind it", raw.Asks)
+	}
+	if depth := ob.GetDepth(0); depth.Asks[0].Quantity != 1.5 {
+		t.Errorf("ask level = %v, want 1.5 (0.5 + a slice of 1, not the hidden 3)", depth.Asks[0].Quantity)
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	ob := NewOrderBook("BTC-USD")
+	var updates []*DepthUpdate
+	ob.OnDepthUpdate = func(u *DepthUpdate) { updates = append(updates, u) }
+	alice := uuid.New()
+	for _, o := range []*models.Order{
+		newTestOrder(alice, "buy", 100, 1),
+		newTestOrder(alice, "buy", 100, 0.5),
+		newTestOrder(alice, "buy", 99, 2),
+		newTestOrder(alice, "buy", 98.5, 0.00000001),
+		newTestOrder(alice, "sell", 101, 0.25),
+	} {
+		if _, err := ob.AddOrder(o); err != nil {
+			t.Fatalf("AddOrder: %v", err)
+		}
+	}
+
+	// Ranks interleaved bid then ask, the ask side running out after the first
+	want := crc32.ChecksumIEEE([]byte("100:1.5:101:0.25:99:2:98.5:0.00000001"))
+	if got := ob.Checksum(ChecksumLevels); got != want {
+		t.Errorf("Checksum = %d, want %d", got, want)
+	}
+	if depth := ob.GetDepth(1); depth.Checksum != want {
+		t.Errorf("depth checksum = %d, want %d whatever the number of levels listed", depth.Checksum, want)
+	}
+	if last := updates[len(updates)-1]; last.Checksum != want {
+		t.Errorf("last update checksum = %d, want %d", last.Checksum, want)
+	}
+	if got, want := ob.Checksum(1), crc32.ChecksumIEEE([]byte("100:1.5:101:0.25")); got != want {
+		t.Errorf("Checksum(1) = %d, want %d", got, want)
+	}
+	if merged := updates[0].Merge(updates[1]); merged.Checksum != updates[1].Checksum {
+		t.Errorf("merged update checksum = %d, want the later update's %d", merged.Checksum, updates[1].Checksum)
+	}
+}
@@ -0,0 +1,181 @@
+package orderbook
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/user/minicoinbase/backend/internal/database"
+	"github.com/user/minicoinbase/backend/internal/logging"
+)
+
+// outboxBatchSize caps the trades retried per run; any left over go in the next one.
+const outboxBatchSize = 500
+
+// maxOutboxRetryDelay caps the back-off between settlement attempts of an outbox trade.
+const maxOutboxRetryDelay = 5 * time.Minute
+
+// outboxAlertAttempts is how many failed attempts it takes for a trade to be logged as critical.
+const outboxAlertAttempts = 5
+
+// enqueueTrades writes matched trades to the outbox before they are settled, so settlement
+// survives a crash: whatever is left in the outbox is settled at startup (see Recover) or
+// by the outbox worker. The trades are due for a retry after the first back-off delay,
+// which leaves settlement right after matching to go first.
+func (m *Manager) enqueueTrades(logger *slog.Logger, trades []*Trade) {
+	entries := make([]*database.OutboxTrade, len(trades))
+	for i, t := range trades {
+		entries[i] = &database.OutboxTrade{
+			TradeID:         t.ID,
+			Symbol:          t.Symbol,
+			Seq:             int64(t.Seq),
+			MakerOrderID:    t.MakerOrderID,
+			TakerOrderID:    t.TakerOrderID,
+			TakerSide:       t.Side,
+			Price:           t.Price,
+			Quantity:        t.Quantity,
+			TakerLimitPrice: t.TakerLimitPrice,
+			MakerLimitPrice: t.MakerLimitPrice,
+			ExecutedAt:      t.Timestamp,
+		}
+	}
+	ctx := context.Background()
+	// Not due before the sandbox settlement delay is over either, see handleResult
+	if err := database.EnqueueTrades(ctx, entries, m.outboxRetryDelay(0)+m.settlementDelay); err != nil {
+		// Settlement still goes ahead, but nothing retries it if it fails
+		logger.Log(ctx, logging.LevelCritical, "Failed to write trades to outbox", "trades", len(trades), "err", err)
+	}
+}
+
+// settle settles one trade and publishes its fills, and returns whether it did. A trade that
+// fails stays in the outbox with its next attempt put off; attempts is how many failed before.
+// Once the configured number of attempts have failed it is held for manual review instead, see
+// database.GetOutboxTradesForReview. A trade found already settled (by a concurrent attempt) is skipped.
+func (m *Manager) settle(ctx context.Context, logger *slog.Logger, trade *Trade, attempts int) bool {
+	tradeLogger := logger.With("trade_id", trade.ID, "maker_order_id", trade.MakerOrderID, "taker_order_id", trade.TakerOrderID,
+		"price", trade.Price, "quantity", trade.Quantity)
+
+	fills, err := settleTrade(ctx, trade)
+	if errors.Is(err, database.ErrDuplicateKey) {
+		tradeLogger.Debug("Trade already settled")
+		return false
+	}
+	if err != nil {
+		// The match happened in memory but balances were not updated yet
+		if m.outboxAttempts > 0 && attempts+1 >= m.outboxAttempts {
+			tradeLogger.Log(ctx, logging.LevelCritical, "Failed to settle trade, holding it for manual review", "attempts", attempts+1, "err", err)
+			if err := database.MarkOutboxTradeForReview(ctx, trade.ID, err); err != nil {
+				tradeLogger.Error("Failed to hold trade for review", "err", err)
+			}
+			return false
+		}
+		delay := m.outboxRetryDelay(attempts)
+		level := slog.LevelError
+		if attempts+1 >= outboxAlertAttempts {
+			level = logging.LevelCritical
+		}
+		tradeLogger.Log(ctx, level, "Failed to settle trade", "attempts", attempts+1, "retry_in", delay, "err", err)
+		if err := database.RecordOutboxFailure(ctx, trade.ID, err, delay); err != nil {
+			tradeLogger.Error("Failed to record settlement failure", "err", err)
+		}
+		return false
+	}
+	publishFills(fills)
+	tradeLogger.Info("Trade settled")
+	return true
+}
+
+// outboxRetryDelay returns how long to wait before retrying a trade that failed to settle
+// attempts+1 times: the outbox interval, doubling with every further failure.
+func (m *Manager) outboxRetryDelay(attempts int) time.Duration {
+	delay := m.outboxInterval
+	if delay <= 0 {
+		return maxOutboxRetryDelay // Only retried at startup anyway
+	}
+	for i := 0; i < attempts && delay < maxOutboxRetryDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, maxOutboxRetryDelay)
+}
+
+// StartOutboxWorker retries the trades left unsettled in the outbox each configured interval,
+// until ctx is done. It does nothing if the interval is 0.
+func (m *Manager) StartOutboxWorker(ctx context.Context) {
+	if m.outboxInterval <= 0 {
+		return
+	}
+	go m.outboxLoop(ctx, m.outboxInterval)
+}
+
+// outboxLoop calls SettleOutbox every interval until ctx is done.
+func (m *Manager) outboxLoop(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if n, _, err := m.SettleOutbox(ctx, time.Now()); err != nil {
+				slog.Error("Failed to settle outbox trades", "settled", n, "err", err)
+			} else if n > 0 {
+				slog.Info("Settled outbox trades", "settled", n)
+			}
+		}
+	}
+}
+
+// SettleOutbox settles up to outboxBatchSize outbox trades due at now, oldest first, and returns
+// how many it settled and whether more may be due. Trades are settled one at a time with
+// settleTrade, like right after matching, so each is applied at most once however often it is tried.
+func (m *Manager) SettleOutbox(ctx context.Context, now time.Time) (int, bool, error) {
+	entries, err := database.GetDueOutboxTrades(ctx, now, outboxBatchSize)
+	if err != nil {
+		return 0, false, err
+	}
+
+	settled := 0
+	for _, e := range entries {
+		if ctx.Err() != nil {
+			return settled, true, ctx.Err()
+		}
+		trade := &Trade{
+			ID:              e.TradeID,
+			Seq:             uint64(e.Seq),
+			TakerOrderID:    e.TakerOrderID,
+			MakerOrderID:    e.MakerOrderID,
+			Symbol:          e.Symbol,
+			Side:            e.TakerSide,
+			Price:           e.Price,
+			Quantity:        e.Quantity,
+			Timestamp:       e.ExecutedAt,
+			TakerLimitPrice: e.TakerLimitPrice,
+			MakerLimitPrice: e.MakerLimitPrice,
+		}
+		if m.settle(ctx, slog.With("symbol", e.Symbol), trade, e.Attempts) {
+			settled++
+		}
+	}
+	return settled, len(entries) == outboxBatchSize, nil
+}
+
+// drainOutbox settles every trade left in the outbox, whatever its retry schedule, until
+// a batch settles nothing. Trades that keep failing are left for the outbox worker.
+func (m *Manager) drainOutbox(ctx context.Context) error {
+	total := 0
+	for {
+		n, more, err := m.SettleOutbox(ctx, time.Now().Add(maxOutboxRetryDelay))
+		total += n
+		if err != nil {
+			return err
+		}
+		if !more || n == 0 {
+			break
+		}
+	}
+	if total > 0 {
+		slog.Info("Settled trades left in the outbox", "settled", total)
+	}
+	return nil
+}
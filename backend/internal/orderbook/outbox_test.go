@@ -0,0 +1,27 @@
+package orderbook
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOutboxRetryDelay(t *testing.T) {
+	tests := []struct {
+		interval time.Duration
+		attempts int
+		want     time.Duration
+	}{
+		{5 * time.Second, 0, 5 * time.Second},
+		{5 * time.Second, 1, 10 * time.Second},
+		{5 * time.Second, 3, 40 * time.Second},
+		{5 * time.Second, 10, maxOutboxRetryDelay},
+		{5 * time.Second, 1000, maxOutboxRetryDelay},
+		{0, 0, maxOutboxRetryDelay}, // No worker, only retried at startup
+	}
+	for _, tt := range tests {
+		m := &Manager{outboxInterval: tt.interval}
+		if got := m.outboxRetryDelay(tt.attempts); got != tt.want {
+			t.Errorf("outboxRetryDelay(%d) with interval %v = %v, want %v", tt.attempts, tt.interval, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package orderbook
+
+import (
+	"container/heap"
+	"container/list"
+	"sort"
+)
+
+// priceLevel holds the resting orders at a single price, oldest first (FIFO).
+type priceLevel struct {
+	price  float64
+	orders *list.List // of *bookOrder, front is the oldest
+	index  int        // Position in the side's heap, maintained by levelHeap
+}
+
+// levelHeap keeps the active price levels of one side ordered best-first.
+// Bids are a max-heap on price, asks a min-heap.
+type levelHeap struct {
+	levels     []*priceLevel
+	descending bool
+}
+
+func (h *levelHeap) Len() int { return len(h.levels) }
+
+func (h *levelHeap) Less(i, j int) bool {
+	if h.descending {
+		return h.levels[i].price > h.levels[j].price
+	}
+	return h.levels[i].price < h.levels[j].price
+}
+
+func (h *levelHeap) Swap(i, j int) {
+	h.levels[i], h.levels[j] = h.levels[j], h.levels[i]
+	h.levels[i].index = i
+	h.levels[j].index = j
+}
+
+func (h *levelHeap) Push(x interface{}) {
+	level := x.(*priceLevel)
+	level.index = len(h.levels)
+	h.levels = append(h.levels, level)
+}
+
+func (h *levelHeap) Pop() interface{} {
+	old := h.levels
+	n := len(old)
+	level := old[n-1]
+	old[n-1] = nil
+	level.index = -1
+	h.levels = old[:n-1]
+	return level
+}
+
+// bookSide is one side (bids or asks) of an order book.
+// Levels are looked up by price in O(1), the best level is at the top of the heap,
+// and adding or removing a level is O(log n).
+type bookSide struct {
+	levels map[float64]*priceLevel
+	heap   *levelHeap
+}
+
+// newBookSide creates an empty side. Bids pass descending=true so the highest price is best.
+func newBookSide(descending bool) *bookSide {
+	return &bookSide{
+		levels: make(map[float64]*priceLevel),
+		heap:   &levelHeap{levels: make([]*priceLevel, 0), descending: descending},
+	}
+}
+
+// best returns the best price level, or nil if the side is empty.
+func (s *bookSide) best() *priceLevel {
+	if len(s.heap.levels) == 0 {
+		return nil
+	}
+	return s.heap.levels[0]
+}
+
+// add appends an order to the back of the queue at its price, creating the level if needed.
+func (s *bookSide) add(order *bookOrder) {
+	level, exists := s.levels[order.Price]
+	if !exists {
+		level = &priceLevel{price: order.Price, orders: list.New()}
+		s.levels[order.Price] = level
+		heap.Push(s.heap, level)
+	}
+	level.orders.PushBack(order)
+}
+
+// remove takes an order out of its price level, dropping the level once it is empty.
+// Returns false if the order was not found on this side.
+func (s *bookSide) remove(order *bookOrder) bool {
+	level, exists := s.levels[order.Price]
+	if !exists {
+		return false
+	}
+	for e := level.orders.Front(); e != nil; e = e.Next() {
+		if e.Value.(*bookOrder).ID == order.ID {
+			level.orders.Remove(e)
+			if level.orders.Len() == 0 {
+				s.removeLevel(level)
+			}
+			return true
+		}
+	}
+	return false
+}
+
+// removeLevel drops an (empty) price level from the side.
+func (s *bookSide) removeLevel(level *priceLevel) {
+	delete(s.levels, level.price)
+	heap.Remove(s.heap, level.index)
+}
+
+// topLevels returns the n best price levels, best-first, or all of them if n <= 0.
+// Rather than sorting the whole side, it walks the heap from the top: the next best level is
+// always a child of one already taken, so this costs O(n log n) however deep the side is.
+func (s *bookSide) topLevels(n int) []*priceLevel {
+	if n <= 0 || n >= len(s.heap.levels) {
+		return s.sortedLevels()
+	}
+	levels := make([]*priceLevel, 0, n)
+	candidates := &indexHeap{side: s.heap, indexes: []int{0}}
+	for len(levels) < n {
+		i := heap.Pop(candidates).(int)
+		levels = append(levels, s.heap.levels[i])
+		for _, child := range []int{2*i + 1, 2*i + 2} {
+			if child < len(s.heap.levels) {
+				heap.Push(candidates, child)
+			}
+		}
+	}
+	return levels
+}
+
+// indexHeap orders positions in a levelHeap by their level's price, best first.
+// It holds positions rather than levels so it never touches the levels' own heap index.
+type indexHeap struct {
+	side    *levelHeap
+	indexes []int
+}
+
+func (h *indexHeap) Len() int           { return len(h.indexes) }
+func (h *indexHeap) Less(i, j int) bool { return h.side.Less(h.indexes[i], h.indexes[j]) }
+func (h *indexHeap) Swap(i, j int)      { h.indexes[i], h.indexes[j] = h.indexes[j], h.indexes[i] }
+func (h *indexHeap) Push(x interface{}) { h.indexes = append(h.indexes, x.(int)) }
+
+func (h *indexHeap) Pop() interface{} {
+	old := h.indexes
+	i := old[len(old)-1]
+	h.indexes = old[:len(old)-1]
+	return i
+}
+
+// sortedLevels returns the price levels best-first.
+func (s *bookSide) sortedLevels() []*priceLevel {
+	levels := make([]*priceLevel, len(s.heap.levels))
+	copy(levels, s.heap.levels)
+	sort.Slice(levels, func(i, j int) bool {
+		if s.heap.descending {
+			return levels[i].price > levels[j].price
+		}
+		return levels[i].price < levels[j].price
+	})
+	return levels
+}
@@ -0,0 +1,66 @@
+package orderbook
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// maxReapInterval caps how long an idle book can outlive its idle timeout.
+const maxReapInterval = time.Minute
+
+// retiredBook is what a retired book leaves behind, see Manager.retired.
+type retiredBook struct {
+	seq       uint64
+	tradeSeq  uint64
+	eventSeq  uint64
+	lastPrice float64
+}
+
+// StartBookReaper retires books that hold no orders and have been idle for the configured
+// timeout, until ctx is done. It does nothing if the timeout is 0.
+func (m *Manager) StartBookReaper(ctx context.Context) {
+	if m.bookIdleTimeout <= 0 {
+		return
+	}
+	go m.reapLoop(ctx, min(m.bookIdleTimeout, maxReapInterval))
+}
+
+// reapLoop calls ReapIdleBooks every interval until ctx is done.
+func (m *Manager) reapLoop(ctx context.Context, interval time.Duration) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+			if n := m.ReapIdleBooks(time.Now()); n > 0 {
+				slog.Info("Retired idle order books", "retired", n)
+			}
+		}
+	}
+}
+
+// ReapIdleBooks retires every book that holds no orders, isn't halted and had nothing queued
+// on it for the idle timeout as of now, freeing its memory and matching goroutine, and returns
+// how many it retired. The symbol gets a new book the next time it is used (see getBook for
+// which symbols can be), numbered on from where the retired one stopped.
+func (m *Manager) ReapIdleBooks(now time.Time) int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	reaped := 0
+	for symbol, book := range m.books {
+		if !book.retire(now, m.bookIdleTimeout) {
+			continue
+		}
+		book.mu.RLock()
+		m.retired[symbol] = retiredBook{seq: book.seq, tradeSeq: book.tradeSeq, eventSeq: book.eventSeq, lastPrice: book.lastPrice}
+		book.mu.RUnlock()
+		delete(m.books, symbol)
+		slog.Debug("Retired idle order book", "symbol", symbol)
+		reaped++
+	}
+	return reaped
+}
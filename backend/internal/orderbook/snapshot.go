@@ -0,0 +1,173 @@
+package orderbook
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/user/minicoinbase/backend/internal/models"
+)
+
+// Snapshot is the serializable state of an order book, see OrderBook.Snapshot.
+// Orders carry their remaining quantity alongside the order, as in the book.
+// (Snapshots from before that have no "remaining": their quantity was the remaining one. As
+// mergeSnapshot takes the remaining quantity from the database, they still recover fine.)
+type Snapshot struct {
+	Symbol    string       `json:"symbol"`
+	Seq       uint64       `json:"seq"`
+	TradeSeq  uint64       `json:"trade_seq"`
+	EventSeq  uint64       `json:"event_seq"` // Of the last event logged before the snapshot, see Event.Seq
+	LastPrice float64      `json:"last_price"`
+	Halted    bool         `json:"halted"`
+	Bids      []*bookOrder `json:"bids"`  // Best price first, oldest first within a price
+	Asks      []*bookOrder `json:"asks"`  // Best price first, oldest first within a price
+	Stops     []*bookOrder `json:"stops"` // Parked stops, in the order they were parked
+	TakenAt   time.Time    `json:"taken_at"`
+}
+
+// Snapshot copies the book's state, including the exact queue position of every resting order.
+func (ob *OrderBook) Snapshot() *Snapshot {
+	ob.mu.RLock()
+	defer ob.mu.RUnlock()
+	return &Snapshot{
+		Symbol:    ob.symbol,
+		Seq:       ob.seq,
+		TradeSeq:  ob.tradeSeq,
+		EventSeq:  ob.eventSeq,
+		LastPrice: ob.lastPrice,
+		Halted:    ob.halted,
+		Bids:      snapshotSide(ob.bids),
+		Asks:      snapshotSide(ob.asks),
+		Stops:     copyOrders(ob.Stops),
+		TakenAt:   time.Now(),
+	}
+}
+
+// snapshotSide copies the orders of a side in priority order. Must be called with the lock held.
+func snapshotSide(side *bookSide) []*bookOrder {
+	orders := make([]*bookOrder, 0)
+	for _, level := range side.sortedLevels() {
+		for e := level.orders.Front(); e != nil; e = e.Next() {
+			orders = append(orders, copyOrder(e.Value.(*bookOrder)))
+		}
+	}
+	return orders
+}
+
+// copyOrder returns a copy of a book order that shares nothing with it.
+func copyOrder(o *bookOrder) *bookOrder {
+	model := *o.Order
+	c := *o
+	c.Order = &model
+	return &c
+}
+
+// copyOrders returns copies of orders, so a snapshot doesn't share them with the book.
+func copyOrders(orders []*bookOrder) []*bookOrder {
+	copies := make([]*bookOrder, len(orders))
+	for i, o := range orders {
+		copies[i] = copyOrder(o)
+	}
+	return copies
+}
+
+// Restore replaces the book's state with a snapshot. The orders are put back exactly as they
+// were, without matching, and no depth update is published. The recent trades and depth
+// updates are not part of a snapshot, so clients behind it have to start over from a new one.
+// The snapshot is logged as a restore event, the point its book can be replayed from.
+func (ob *OrderBook) Restore(snap *Snapshot) error {
+	if snap.Symbol != ob.symbol {
+		return fmt.Errorf("snapshot symbol %s does not match book symbol %s", snap.Symbol, ob.symbol)
+	}
+	ob.mu.Lock()
+	defer ob.mu.Unlock()
+
+	ob.bids = newBookSide(true)
+	ob.asks = newBookSide(false)
+	ob.Stops = copyOrders(snap.Stops)
+	ob.Orders = make(map[uuid.UUID]*bookOrder)
+	for _, order := range copyOrders(snap.Bids) {
+		ob.bids.add(order)
+		ob.Orders[order.ID] = order
+	}
+	for _, order := range copyOrders(snap.Asks) {
+		ob.asks.add(order)
+		ob.Orders[order.ID] = order
+	}
+	for _, order := range ob.Stops {
+		ob.Orders[order.ID] = order
+	}
+	ob.seq = snap.Seq
+	ob.tradeSeq = snap.TradeSeq
+	ob.recentTrades = nil
+	ob.recentUpdates = nil
+	ob.lastPrice = snap.LastPrice
+	ob.halted = snap.Halted
+	ob.changedBid = make(map[float64]struct{})
+	ob.changedAsk = make(map[float64]struct{})
+	ob.eventSeq = max(ob.eventSeq, snap.EventSeq)
+	ob.record(&Event{Type: EventRestore, Snapshot: snap})
+	return nil
+}
+
+// mergeSnapshot reconciles a (possibly stale) snapshot with the open orders of its symbol in
+// the database, which is authoritative. Orders that are no longer open are dropped; the others
+// keep their queue position as long as their price is unchanged, with their quantities from
+// the database. Everything else - orders placed or repriced after the snapshot, or all
+// of them if snap is nil - is returned to be replayed through matching, in the order given.
+// A stop that had already triggered when the snapshot was taken is replayed as the order it turned into.
+func mergeSnapshot(snap *Snapshot, open []*models.Order) (*Snapshot, []*models.Order) {
+	remaining := make(map[uuid.UUID]*models.Order, len(open))
+	for _, o := range open {
+		// A quote-denominated order still open has yet to be finished off, see releaseUnfilled
+		if unfilled(o) > 0 || o.QuoteQuantity > 0 {
+			order := *o
+			remaining[order.ID] = &order
+		}
+	}
+
+	var kept *Snapshot
+	triggered := make(map[uuid.UUID]string) // Type of stops that had triggered, by ID
+	if snap != nil {
+		kept = &Snapshot{Symbol: snap.Symbol, Seq: snap.Seq, TradeSeq: snap.TradeSeq, EventSeq: snap.EventSeq, LastPrice: snap.LastPrice, Halted: snap.Halted, TakenAt: snap.TakenAt}
+		keep := func(orders []*bookOrder) []*bookOrder {
+			result := make([]*bookOrder, 0, len(orders))
+			for _, snapOrder := range orders {
+				current, ok := remaining[snapOrder.ID]
+				if !ok {
+					continue
+				}
+				if isStopOrder(current) && !isStopOrder(snapOrder.Order) {
+					triggered[snapOrder.ID] = snapOrder.Type
+				}
+				if current.Price != snapOrder.Price || current.StopPrice != snapOrder.StopPrice {
+					continue
+				}
+				order := copyOrder(snapOrder)
+				order.Quantity, order.FilledQuantity, order.Remaining = current.Quantity, current.FilledQuantity, unfilled(current)
+				if order.Visible <= 0 || order.Visible > order.Remaining {
+					order.refresh() // Fills since the snapshot may have used up the slice it shows
+				}
+				result = append(result, order)
+				delete(remaining, snapOrder.ID)
+			}
+			return result
+		}
+		kept.Bids = keep(snap.Bids)
+		kept.Asks = keep(snap.Asks)
+		kept.Stops = keep(snap.Stops)
+	}
+
+	replay := make([]*models.Order, 0, len(remaining))
+	for _, o := range open {
+		order, ok := remaining[o.ID]
+		if !ok {
+			continue
+		}
+		if orderType, ok := triggered[order.ID]; ok {
+			order.Type = orderType
+		}
+		replay = append(replay, order)
+	}
+	return kept, replay
+}
@@ -0,0 +1,105 @@
+package orderbook
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/user/minicoinbase/backend/internal/models"
+)
+
+func TestSnapshotRestorePreservesQueuePositions(t *testing.T) {
+	ob := NewOrderBook("BTC-USD")
+	first := newTestOrder(uuid.New(), "buy", 100, 1)
+	second := newTestOrder(uuid.New(), "buy", 100, 2)
+	ask := newTestOrder(uuid.New(), "sell", 102, 1)
+	stop := newTestOrder(uuid.New(), "sell", 95, 1)
+	stop.Type, stop.StopPrice = "stop_limit", 96
+	for _, o := range []*models.Order{first, second, ask, stop} {
+		if _, err := ob.AddOrder(o); err != nil {
+			t.Fatalf("AddOrder: %v", err)
+		}
+	}
+	// A quantity increase sends first behind second
+	if _, err := ob.ReplaceOrder(first.ID, 1, 100, 3); err != nil {
+		t.Fatalf("ReplaceOrder: %v", err)
+	}
+
+	data, err := json.Marshal(ob.Snapshot())
+	if err != nil {
+		t.Fatalf("Marshal: %v", err)
+	}
+	snap := &Snapshot{}
+	if err := json.Unmarshal(data, snap); err != nil {
+		t.Fatalf("Unmarshal: %v", err)
+	}
+	restored := NewOrderBook("BTC-USD")
+	if err := restored.Restore(snap); err != nil {
+		t.Fatalf("Restore: %v", err)
+	}
+
+	if got, want := restored.GetDepth(0), ob.GetDepth(0); len(got.Bids) != 1 || got.Bids[0] != want.Bids[0] || got.Asks[0] != want.Asks[0] || got.Seq != want.Seq {
+		t.Errorf("restored depth = %+v, want %+v", got, want)
+	}
+	if len(restored.Stops) != 1 || restored.Stops[0].ID != stop.ID {
+		t.Errorf("restored stops = %v, want the stop_limit", restored.Stops)
+	}
+
+	// A sell for 2 fills second (ahead in the queue) before first
+	result, err := restored.AddOrder(newTestOrder(uuid.New(), "sell", 100, 2))
+	if err != nil {
+		t.Fatalf("AddOrder: %v", err)
+	}
+	if len(result.Trades) != 1 || result.Trades[0].MakerOrderID != second.ID {
+		t.Errorf("got trades %+v, want one fill against the second order", result.Trades)
+	}
+}
+
+func TestMergeSnapshot(t *testing.T) {
+	user := uuid.New()
+	kept := newTestOrder(user, "buy", 100, 1)      // Unchanged since the snapshot
+	filled := newTestOrder(user, "buy", 100, 1)    // Filled since
+	partial := newTestOrder(user, "buy", 99, 2)    // Partially filled since
+	repriced := newTestOrder(user, "sell", 105, 1) // Modified to a new price since
+	placed := newTestOrder(user, "sell", 106, 1)   // Placed after the snapshot
+	triggered := newTestOrder(user, "sell", 94, 1) // A stop_limit that had triggered
+	snapTriggered := *triggered
+	triggered.Type, triggered.StopPrice = "stop_limit", 96
+	snapTriggered.StopPrice = 96 // Type "limit": it was activated in the book
+
+	snap := &Snapshot{
+		Symbol: "BTC-USD",
+		Seq:    7,
+		Bids:   []*bookOrder{newBookOrder(kept), newBookOrder(filled), newBookOrder(partial)},
+		Asks:   []*bookOrder{newBookOrder(&snapTriggered), newBookOrder(repriced)},
+	}
+
+	dbPartial := *partial
+	dbPartial.FilledQuantity = 0.5
+	dbRepriced := *repriced
+	dbRepriced.Price = 104
+	open := []*models.Order{kept, &dbPartial, &dbRepriced, placed, triggered}
+
+	merged, replay := mergeSnapshot(snap, open)
+
+	if len(merged.Bids) != 2 || merged.Bids[0].ID != kept.ID || merged.Bids[1].ID != partial.ID {
+		t.Fatalf("merged bids = %v, want kept then partial", merged.Bids)
+	}
+	if merged.Bids[1].Quantity != 2 || merged.Bids[1].Remaining != 1.5 {
+		t.Errorf("partial quantity/remaining = %v/%v, want 2/1.5", merged.Bids[1].Quantity, merged.Bids[1].Remaining)
+	}
+	if len(merged.Asks) != 1 || merged.Asks[0].ID != triggered.ID || merged.Asks[0].Type != "limit" {
+		t.Errorf("merged asks = %v, want the triggered stop as a limit order", merged.Asks)
+	}
+	if merged.Seq != 7 {
+		t.Errorf("merged seq = %d, want 7", merged.Seq)
+	}
+	if len(replay) != 2 || replay[0].ID != repriced.ID || replay[0].Price != 104 || replay[1].ID != placed.ID {
+		t.Errorf("replay = %v, want repriced at 104 then placed", replay)
+	}
+
+	// Without a snapshot everything is replayed, in database order
+	if merged, replay := mergeSnapshot(nil, open); merged != nil || len(replay) != len(open) {
+		t.Errorf("mergeSnapshot(nil) = %v, %d orders, want nil, %d", merged, len(replay), len(open))
+	}
+}
@@ -0,0 +1,150 @@
+package orderbook
+
+import (
+	"context"
+	"log/slog"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/user/minicoinbase/backend/internal/database"
+	"github.com/user/minicoinbase/backend/internal/markets"
+)
+
+// Symbol statistics cover the last statsWindow, in buckets of statsBucket: a trade leaves the
+// window with its whole bucket, so the window is up to one bucket shorter than statsWindow.
+const (
+	statsWindow  = 24 * time.Hour
+	statsBucket  = time.Minute
+	statsBuckets = int(statsWindow / statsBucket)
+)
+
+// SymbolStats are the trading statistics of a symbol over the last 24 hours, kept in memory by
+// the manager from the trades it matches, see GetSymbolStats.
+type SymbolStats struct {
+	Symbol    string  `json:"symbol"`
+	LastPrice float64 `json:"last_price"` // Of the most recent trade, even one before the window; 0 if none is known
+	Volume    float64 `json:"volume"`     // Traded base quantity
+	High      float64 `json:"high"`       // Highest and lowest trade price, 0 without trades in the window
+	Low       float64 `json:"low"`
+}
+
+// tradeBucket accumulates the trades of one statsBucket.
+type tradeBucket struct {
+	index  int64 // Number of the bucket since the Unix epoch, 0 for a bucket never used
+	volume float64
+	high   float64
+	low    float64
+}
+
+// symbolStats is a ring of statsBuckets buckets: the bucket numbered i lives at i % statsBuckets,
+// and one still holding an older number is stale and gets reset on its next use.
+type symbolStats struct {
+	lastPrice float64
+	buckets   [statsBuckets]tradeBucket
+}
+
+// bucketIndex returns the number of the bucket a time falls in.
+func bucketIndex(at time.Time) int64 {
+	return at.UnixNano() / int64(statsBucket)
+}
+
+// add counts a trade, or a bucket's worth of them (volume at prices from low to high).
+func (s *symbolStats) add(at time.Time, volume, high, low float64) {
+	index := bucketIndex(at)
+	b := &s.buckets[index%int64(statsBuckets)]
+	if b.index != index {
+		*b = tradeBucket{index: index, high: high, low: low}
+	}
+	b.volume += volume
+	b.high = math.Max(b.high, high)
+	b.low = math.Min(b.low, low)
+}
+
+// get sums up the buckets still in the window at now.
+func (s *symbolStats) get(symbol string, now time.Time) *SymbolStats {
+	stats := &SymbolStats{Symbol: symbol, LastPrice: s.lastPrice}
+	oldest := bucketIndex(now) - int64(statsBuckets)
+	traded := false
+	for _, b := range s.buckets {
+		if b.index <= oldest {
+			continue
+		}
+		if !traded || b.high > stats.High {
+			stats.High = b.high
+		}
+		if !traded || b.low < stats.Low {
+			stats.Low = b.low
+		}
+		stats.Volume += b.volume
+		traded = true
+	}
+	return stats
+}
+
+// statsFor returns the statistics of a symbol, creating them if it has none. statsMu must be held.
+func (m *Manager) statsFor(symbol string) *symbolStats {
+	if m.stats == nil {
+		m.stats = make(map[string]*symbolStats)
+	}
+	s, ok := m.stats[symbol]
+	if !ok {
+		s = &symbolStats{}
+		m.stats[symbol] = s
+	}
+	return s
+}
+
+// recordTrades counts matched trades in their symbols' statistics.
+func (m *Manager) recordTrades(trades []*Trade) {
+	m.statsMu.Lock()
+	defer m.statsMu.Unlock()
+	for _, trade := range trades {
+		s := m.statsFor(trade.Symbol)
+		s.add(trade.Timestamp, trade.Quantity, trade.Price, trade.Price)
+		s.lastPrice = trade.Price
+	}
+}
+
+// GetSymbolStats returns the last price, volume, high and low of a symbol over the last 24 hours,
+// from memory rather than the trades table. Every statistic is 0 for a symbol that hasn't traded
+// since the statistics were loaded (see loadSymbolStats). Returns ErrUnknownSymbol for a symbol
+// that isn't a market and has no statistics.
+func (m *Manager) GetSymbolStats(symbol string) (*SymbolStats, error) {
+	symbol = strings.ToUpper(symbol)
+	m.statsMu.Lock()
+	defer m.statsMu.Unlock()
+	s, ok := m.stats[symbol]
+	if !ok {
+		if _, ok := markets.Get(symbol); !ok {
+			return nil, ErrUnknownSymbol
+		}
+		return &SymbolStats{Symbol: symbol}, nil
+	}
+	return s.get(symbol, time.Now()), nil
+}
+
+// loadSymbolStats fills the statistics of every market from the last 24 hours of trades in the
+// database, a minute per bucket. Called by Recover after the outbox is drained, so every trade
+// matched before the restart is settled and counted, and before orders are replayed, whose
+// trades processTrades counts.
+func (m *Manager) loadSymbolStats(ctx context.Context) error {
+	now := time.Now()
+	for _, market := range markets.All() {
+		klines, err := database.GetKlines(ctx, market.Symbol, statsBucket, now.Add(-statsWindow), now)
+		if err != nil {
+			return err
+		}
+		m.statsMu.Lock()
+		s := m.statsFor(market.Symbol)
+		for _, k := range klines {
+			s.add(k.OpenTime, k.Volume, k.High, k.Low)
+		}
+		if len(klines) > 0 {
+			s.lastPrice = klines[len(klines)-1].Close
+		}
+		m.statsMu.Unlock()
+		slog.Debug("Loaded symbol statistics", "symbol", market.Symbol, "buckets", len(klines))
+	}
+	return nil
+}
@@ -0,0 +1,57 @@
+package orderbook
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestSymbolStatsWindow(t *testing.T) {
+	var s symbolStats
+	now := time.Now()
+	s.add(now.Add(-25*time.Hour), 100, 1000, 1000) // Out of the window
+	s.add(now.Add(-2*time.Hour), 1, 50, 40)
+	s.add(now.Add(-time.Minute), 2, 45, 45)
+	s.add(now, 0.5, 60, 60)
+	s.lastPrice = 60
+
+	stats := s.get("BTC-USD", now)
+	if stats.Volume != 3.5 || stats.High != 60 || stats.Low != 40 || stats.LastPrice != 60 {
+		t.Fatalf("stats = %+v, want volume 3.5, high 60, low 40, last price 60", stats)
+	}
+
+	// A day later every bucket has aged out but the last price stays
+	stats = s.get("BTC-USD", now.Add(statsWindow+statsBucket))
+	if stats.Volume != 0 || stats.High != 0 || stats.Low != 0 || stats.LastPrice != 60 {
+		t.Fatalf("aged stats = %+v, want only last price 60", stats)
+	}
+
+	// A reused bucket drops what it held a day earlier
+	s.add(now.Add(statsWindow), 1, 70, 70)
+	stats = s.get("BTC-USD", now.Add(statsWindow))
+	if stats.Volume != 1 || stats.High != 70 || stats.Low != 70 {
+		t.Fatalf("reused bucket stats = %+v, want volume 1 at 70", stats)
+	}
+}
+
+func TestGetSymbolStats(t *testing.T) {
+	m := &Manager{}
+	now := time.Now()
+	m.recordTrades([]*Trade{
+		{Symbol: "BTC-USD", Price: 100, Quantity: 1, Timestamp: now},
+		{Symbol: "BTC-USD", Price: 90, Quantity: 2, Timestamp: now},
+		{Symbol: "BTC-USD", Price: 95, Quantity: 0.5, Timestamp: now},
+	})
+
+	stats, err := m.GetSymbolStats("btc-usd")
+	if err != nil {
+		t.Fatalf("GetSymbolStats: %v", err)
+	}
+	if stats.Symbol != "BTC-USD" || stats.LastPrice != 95 || stats.Volume != 3.5 || stats.High != 100 || stats.Low != 90 {
+		t.Fatalf("stats = %+v", stats)
+	}
+
+	if _, err := m.GetSymbolStats("NOPE-USD"); !errors.Is(err, ErrUnknownSymbol) {
+		t.Fatalf("unknown symbol: err = %v, want ErrUnknownSymbol", err)
+	}
+}
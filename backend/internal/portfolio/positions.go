@@ -0,0 +1,86 @@
+package portfolio
+
+import (
+	"math"
+	"strings"
+
+	"github.com/user/minicoinbase/backend/internal/models"
+)
+
+// dust is the quantity below which a position counts as closed: less than the 8 decimals the
+// database stores, so only float rounding left over from summing fills.
+const dust = 1e-9
+
+// Position is a user's position in a market as GET /api/positions reports it: what they hold of
+// its base asset by way of trading it, the weighted-average price they paid for it, and the P&L
+// realized so far. The market fields are nil without a current price.
+type Position struct {
+	Symbol        string  `json:"symbol"`
+	Asset         string  `json:"asset"`
+	QuoteAsset    string  `json:"quote_asset"`
+	Quantity      float64 `json:"quantity"`        // Bought less sold, after fees
+	AvgEntryPrice float64 `json:"avg_entry_price"` // Quote paid per unit held
+	CostBasis     float64 `json:"cost_basis"`      // Quantity * AvgEntryPrice
+	RealizedPnL   float64 `json:"realized_pnl"`    // Lifetime, in the quote asset
+
+	CurrentPrice         *float64 `json:"current_price"`
+	MarketValue          *float64 `json:"market_value"`           // Quantity * CurrentPrice
+	UnrealizedPnL        *float64 `json:"unrealized_pnl"`         // MarketValue - CostBasis
+	UnrealizedPnLPercent *float64 `json:"unrealized_pnl_percent"` // Of CostBasis
+}
+
+// Apply updates a stored position with one of its owner's fills, of quantity at price with fee
+// charged on the asset received, and returns the P&L it realized.
+// Buys add what was received (the fee is taken from it) at what was paid, which moves the
+// average cost; sells take quantity out at the average cost, which leaves it as it is, and
+// realize the difference to the price, less the fee. Holdings that didn't come from trading
+// (deposits, transfers) have no known cost, so a sell of more than the position only closes it,
+// and realizes only on (and pays only its share of the fee for) the part that was held.
+func Apply(p *models.Position, side string, price, quantity, fee float64) float64 {
+	if side == "buy" {
+		p.Quantity += quantity - fee
+		p.CostBasis += price * quantity
+		return 0
+	}
+
+	realized := 0.0
+	if sold := math.Min(quantity, p.Quantity); sold > 0 {
+		avgCost := p.CostBasis / p.Quantity
+		realized = sold*(price-avgCost) - fee*sold/quantity
+		p.Quantity -= sold
+		p.CostBasis -= avgCost * sold
+		p.RealizedPnL += realized
+	}
+	if p.Quantity < dust {
+		p.Quantity, p.CostBasis = 0, 0
+	}
+	return realized
+}
+
+// FromStored describes a stored position, not yet valued at the market, see Mark.
+func FromStored(p *models.Position) *Position {
+	base, quote, _ := strings.Cut(p.Symbol, "-")
+	position := &Position{
+		Symbol:      p.Symbol,
+		Asset:       base,
+		QuoteAsset:  quote,
+		Quantity:    p.Quantity,
+		CostBasis:   p.CostBasis,
+		RealizedPnL: p.RealizedPnL,
+	}
+	if p.Quantity > 0 {
+		position.AvgEntryPrice = p.CostBasis / p.Quantity
+	}
+	return position
+}
+
+// Mark values the position at the current price.
+func (p *Position) Mark(price float64) {
+	value := p.Quantity * price
+	pnl := value - p.CostBasis
+	p.CurrentPrice, p.MarketValue, p.UnrealizedPnL = &price, &value, &pnl
+	if p.CostBasis > 0 {
+		percent := pnl / p.CostBasis * 100
+		p.UnrealizedPnLPercent = &percent
+	}
+}
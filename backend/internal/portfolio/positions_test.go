@@ -0,0 +1,75 @@
+package portfolio
+
+import (
+	"math"
+	"testing"
+
+	"github.com/user/minicoinbase/backend/internal/models"
+)
+
+type fill struct {
+	side                 string
+	price, quantity, fee float64
+}
+
+func replay(fills ...fill) (*models.Position, float64) {
+	p := &models.Position{Symbol: "BTC-USD"}
+	realized := 0.0
+	for _, f := range fills {
+		realized = Apply(p, f.side, f.price, f.quantity, f.fee)
+	}
+	return p, realized
+}
+
+func TestApplyAverageCostAndRealizedPnL(t *testing.T) {
+	p, realized := replay(
+		fill{"buy", 100, 1, 0},
+		fill{"buy", 200, 1, 0},    // 2 @ 150
+		fill{"sell", 300, 0.5, 0}, // Realizes 0.5 * (300 - 150) and leaves the average alone
+	)
+	if p.Quantity != 1.5 || p.CostBasis != 225 {
+		t.Errorf("position = %v for %v, want 1.5 for 225", p.Quantity, p.CostBasis)
+	}
+	if realized != 75 || p.RealizedPnL != 75 {
+		t.Errorf("realized = %v (lifetime %v), want 75", realized, p.RealizedPnL)
+	}
+
+	// Sell fees come off what is realized, buy fees off what is held
+	p, realized = replay(fill{"buy", 100, 1, 0.01}, fill{"sell", 90, 0.99, 0.5})
+	if p.Quantity != 0 || p.CostBasis != 0 {
+		t.Errorf("closed position = %v for %v, want nothing left", p.Quantity, p.CostBasis)
+	}
+	if math.Abs(realized-(0.99*90-100-0.5)) > 1e-9 {
+		t.Errorf("realized = %v, want %v", realized, 0.99*90-100-0.5)
+	}
+}
+
+func TestApplySellBeyondPosition(t *testing.T) {
+	// 1 held from trading, 3 sold: only the part held realizes, with its share of the fee
+	p, realized := replay(fill{"buy", 100, 1, 0}, fill{"sell", 120, 3, 6})
+	if p.Quantity != 0 || p.CostBasis != 0 {
+		t.Errorf("position = %v for %v, want closed", p.Quantity, p.CostBasis)
+	}
+	if realized != 18 {
+		t.Errorf("realized = %v, want 20 - 2 of the fee", realized)
+	}
+
+	// Nothing held at all: nothing to realize
+	if _, realized = replay(fill{"sell", 120, 1, 0.1}); realized != 0 {
+		t.Errorf("realized = %v selling without a position, want 0", realized)
+	}
+}
+
+func TestFromStoredAndMark(t *testing.T) {
+	p := FromStored(&models.Position{Symbol: "BTC-USD", Quantity: 1.5, CostBasis: 225, RealizedPnL: 75})
+	if p.Asset != "BTC" || p.QuoteAsset != "USD" || p.AvgEntryPrice != 150 || p.RealizedPnL != 75 {
+		t.Fatalf("position = %+v, want BTC/USD 1.5 @ 150 with 75 realized", p)
+	}
+	if p.CurrentPrice != nil {
+		t.Error("position has market fields before being marked")
+	}
+	p.Mark(180)
+	if *p.MarketValue != 270 || *p.UnrealizedPnL != 45 || math.Abs(*p.UnrealizedPnLPercent-20) > 1e-9 {
+		t.Errorf("at 180: value %v, P&L %v (%v%%), want 270, 45 (20%%)", *p.MarketValue, *p.UnrealizedPnL, *p.UnrealizedPnLPercent)
+	}
+}
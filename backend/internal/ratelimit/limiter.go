@@ -0,0 +1,125 @@
+package ratelimit
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Limiter counts failures per key (e.g., a username or an IP) in a sliding window
+// and blocks the key once too many failures fall inside it.
+// Implementations must be safe for concurrent use; the default is in-memory,
+// a shared store (e.g., Redis) is needed once there is more than one API instance.
+type Limiter interface {
+	// Check returns how long the key is still blocked, or 0 if it may try again.
+	Check(ctx context.Context, key string) (time.Duration, error)
+	// RecordFailure counts a failed attempt for the key.
+	RecordFailure(ctx context.Context, key string) error
+	// Reset forgets the key's failures and any lockout.
+	Reset(ctx context.Context, key string) error
+}
+
+// Config configures a Limiter.
+type Config struct {
+	MaxFailures int           // Failures allowed within Window before the key is blocked
+	Window      time.Duration // Length of the sliding window
+	Lockout     time.Duration // If > 0, block the key for this long once MaxFailures is reached
+}
+
+// sweepThreshold is the number of tracked keys above which stale ones are swept on insert,
+// so a flood of distinct keys can't grow the map without bound.
+const sweepThreshold = 10000
+
+// MemoryLimiter is an in-process sliding-window Limiter.
+type MemoryLimiter struct {
+	cfg  Config
+	now  func() time.Time // time.Now, replaced in tests
+	mu   sync.Mutex
+	keys map[string]*keyState
+}
+
+type keyState struct {
+	failures    []time.Time // Failure times within the window, oldest first
+	lockedUntil time.Time
+}
+
+// NewMemoryLimiter creates an in-memory limiter.
+func NewMemoryLimiter(cfg Config) *MemoryLimiter {
+	return &MemoryLimiter{cfg: cfg, now: time.Now, keys: make(map[string]*keyState)}
+}
+
+// Check implements Limiter.
+func (l *MemoryLimiter) Check(ctx context.Context, key string) (time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	state, ok := l.keys[key]
+	if !ok {
+		return 0, nil
+	}
+	now := l.now()
+	l.prune(state, now)
+
+	if now.Before(state.lockedUntil) {
+		return state.lockedUntil.Sub(now), nil
+	}
+	if len(state.failures) >= l.cfg.MaxFailures {
+		// Blocked until the oldest failure in the window falls out of it
+		return state.failures[0].Add(l.cfg.Window).Sub(now), nil
+	}
+	if len(state.failures) == 0 {
+		delete(l.keys, key)
+	}
+	return 0, nil
+}
+
+// RecordFailure implements Limiter.
+func (l *MemoryLimiter) RecordFailure(ctx context.Context, key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	state, ok := l.keys[key]
+	if !ok {
+		if len(l.keys) >= sweepThreshold {
+			l.sweep(now)
+		}
+		state = &keyState{}
+		l.keys[key] = state
+	}
+	l.prune(state, now)
+	state.failures = append(state.failures, now)
+
+	if l.cfg.Lockout > 0 && len(state.failures) >= l.cfg.MaxFailures {
+		state.lockedUntil = now.Add(l.cfg.Lockout)
+	}
+	return nil
+}
+
+// Reset implements Limiter.
+func (l *MemoryLimiter) Reset(ctx context.Context, key string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	delete(l.keys, key)
+	return nil
+}
+
+// prune drops failures that have left the window. Must be called with the lock held.
+func (l *MemoryLimiter) prune(state *keyState, now time.Time) {
+	cutoff := now.Add(-l.cfg.Window)
+	i := 0
+	for i < len(state.failures) && !state.failures[i].After(cutoff) {
+		i++
+	}
+	state.failures = state.failures[i:]
+}
+
+// sweep drops keys with no failures in the window and no active lockout. Must be called with the lock held.
+func (l *MemoryLimiter) sweep(now time.Time) {
+	for key, state := range l.keys {
+		l.prune(state, now)
+		if len(state.failures) == 0 && !now.Before(state.lockedUntil) {
+			delete(l.keys, key)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// newTestLimiter returns a limiter on a fake clock, and a function to move the clock forward.
+func newTestLimiter(cfg Config) (*MemoryLimiter, func(time.Duration)) {
+	l := NewMemoryLimiter(cfg)
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	l.now = func() time.Time { return now }
+	return l, func(d time.Duration) { now = now.Add(d) }
+}
+
+// check returns how long key is blocked, failing the test on error.
+func check(t *testing.T, l *MemoryLimiter, key string) time.Duration {
+	t.Helper()
+	blocked, err := l.Check(context.Background(), key)
+	if err != nil {
+		t.Fatalf("Check(%s): %v", key, err)
+	}
+	return blocked
+}
+
+func TestSlidingWindow(t *testing.T) {
+	ctx := context.Background()
+	l, advance := newTestLimiter(Config{MaxFailures: 3, Window: time.Minute})
+
+	for i := 0; i < 2; i++ {
+		l.RecordFailure(ctx, "alice")
+		advance(10 * time.Second)
+	}
+	if blocked := check(t, l, "alice"); blocked != 0 {
+		t.Fatalf("blocked for %v after 2 of 3 failures, want 0", blocked)
+	}
+	l.RecordFailure(ctx, "alice") // At 20s, the failures are at 0s, 10s and 20s
+
+	// Blocked until the oldest failure leaves the window, at 60s
+	if blocked := check(t, l, "alice"); blocked != 40*time.Second {
+		t.Errorf("blocked for %v after 3 failures, want 40s", blocked)
+	}
+	if blocked := check(t, l, "bob"); blocked != 0 {
+		t.Errorf("other key blocked for %v, want 0", blocked)
+	}
+
+	advance(40 * time.Second)
+	if blocked := check(t, l, "alice"); blocked != 0 {
+		t.Errorf("blocked for %v once the oldest failure left the window, want 0", blocked)
+	}
+	// The other two are still in the window, so one more failure blocks again, until 70s
+	l.RecordFailure(ctx, "alice")
+	if blocked := check(t, l, "alice"); blocked != 10*time.Second {
+		t.Errorf("blocked for %v after another failure, want 10s", blocked)
+	}
+}
+
+func TestLockout(t *testing.T) {
+	ctx := context.Background()
+	l, advance := newTestLimiter(Config{MaxFailures: 2, Window: time.Minute, Lockout: 10 * time.Minute})
+
+	l.RecordFailure(ctx, "alice")
+	l.RecordFailure(ctx, "alice")
+	if blocked := check(t, l, "alice"); blocked != 10*time.Minute {
+		t.Fatalf("blocked for %v, want the 10m lockout", blocked)
+	}
+	// The lockout outlasts the window the failures were counted in
+	advance(5 * time.Minute)
+	if blocked := check(t, l, "alice"); blocked != 5*time.Minute {
+		t.Errorf("blocked for %v after 5m, want 5m", blocked)
+	}
+	advance(5 * time.Minute)
+	if blocked := check(t, l, "alice"); blocked != 0 {
+		t.Errorf("blocked for %v after the lockout, want 0", blocked)
+	}
+}
+
+func TestReset(t *testing.T) {
+	ctx := context.Background()
+	l, _ := newTestLimiter(Config{MaxFailures: 1, Window: time.Minute, Lockout: time.Hour})
+
+	l.RecordFailure(ctx, "alice")
+	if blocked := check(t, l, "alice"); blocked == 0 {
+		t.Fatal("not blocked after reaching the limit")
+	}
+	if err := l.Reset(ctx, "alice"); err != nil {
+		t.Fatalf("Reset: %v", err)
+	}
+	if blocked := check(t, l, "alice"); blocked != 0 {
+		t.Errorf("blocked for %v after Reset, want 0", blocked)
+	}
+}
+
+func TestSweepDropsStaleKeys(t *testing.T) {
+	ctx := context.Background()
+	l, advance := newTestLimiter(Config{MaxFailures: 5, Window: time.Minute, Lockout: time.Hour})
+
+	// One key is locked out, the others only have failures that are about to leave the window
+	for i := 0; i < 5; i++ {
+		l.RecordFailure(ctx, "locked")
+	}
+	for i := 0; len(l.keys) < sweepThreshold; i++ {
+		l.RecordFailure(ctx, fmt.Sprintf("key-%d", i))
+	}
+	advance(2 * time.Minute)
+
+	// Tracking one more key sweeps the stale ones, but keeps the lockout
+	l.RecordFailure(ctx, "new")
+	if len(l.keys) != 2 {
+		t.Errorf("%d keys tracked after the sweep, want the locked and the new one", len(l.keys))
+	}
+	if blocked := check(t, l, "locked"); blocked != 58*time.Minute {
+		t.Errorf("locked key blocked for %v after the sweep, want 58m", blocked)
+	}
+}
@@ -2,9 +2,14 @@ package ticker
 
 import (
 	"log"
+	"math"
 	"math/rand"
+	"sort"
+	"strings"
 	"sync"
 	"time"
+
+	"github.com/user/minicoinbase/backend/internal/config"
 )
 
 // PriceUpdate represents a single price update for a symbol.
@@ -14,43 +19,215 @@ type PriceUpdate struct {
 	Ts     int64   `json:"ts"` // Unix timestamp milliseconds
 }
 
+// tradeQuietPeriod is how long after its last trade a symbol's price is left to trades alone.
+// Once it passes without trades, the simulated random walk takes over again.
+const tradeQuietPeriod = time.Minute
+
+// defaultVolatility is the volatility of symbols configured without one: the standard deviation
+// of the uniform +/-0.5% change per tick the ticker used to make.
+var defaultVolatility = 0.01 / math.Sqrt(12)
+
+// Walk shapes the simulated price of a symbol. Each tick its log price moves by Drift, plus
+// Volatility times a standard normal draw, plus MeanReversion of the way back to Anchor's. Moving
+// the log price keeps the price positive and makes the parameters relative to it.
+type Walk struct {
+	Volatility    float64 // Standard deviation of the relative change per tick
+	Drift         float64 // Mean relative change per tick
+	MeanReversion float64 // 0 (none) to 1 (back to Anchor every tick)
+	Anchor        float64 // Price mean reversion pulls towards, the initial price
+}
+
+// step returns the price after one tick from price, with z the standard normal draw.
+func (w Walk) step(price, z float64) float64 {
+	change := w.Drift + w.Volatility*z
+	if w.MeanReversion > 0 && w.Anchor > 0 {
+		change += w.MeanReversion * math.Log(w.Anchor/price)
+	}
+	return price * math.Exp(change)
+}
+
 var (
 	currentPrices = make(map[string]float64)
+	walks         = make(map[string]Walk)      // Simulation parameters per symbol, see SetWalk
+	lastTradeAt   = make(map[string]time.Time) // Time of the last real trade per symbol
 	mu            sync.RWMutex
-	// Channel to broadcast price updates
-	PriceUpdates = make(chan PriceUpdate, 100) // Buffered channel
-	symbols      = []string{"BTC-USD", "ETH-USD", "SOL-USD"}
+	symbols       = make([]string, 0) // Tracked (tradable) symbols
+
+	// Price updates not yet taken by the consumer, only the latest per symbol (guarded by pendingMu),
+	// so a slow consumer skips intermediate prices rather than falling behind or losing the newest.
+	pending   = make(map[string]PriceUpdate)
+	pendingMu sync.Mutex
+	// PriceUpdatesReady receives a value when there are pending updates to take with TakeUpdates.
+	PriceUpdatesReady = make(chan struct{}, 1)
+
+	lastTick time.Time // When runTicker last ran (guarded by mu), see Alive
+
+	quit = make(chan struct{}) // Closed by Stop
+	done = make(chan struct{}) // Closed when runTicker has returned
 )
 
-// InitTicker starts the background process to simulate price changes.
-func InitTicker() {
+// InitTicker registers the configured symbols and starts the background process to simulate price changes.
+func InitTicker(cfg *config.Config) {
+	names := make([]string, 0, len(cfg.Symbols))
+	for symbol := range cfg.Symbols {
+		names = append(names, symbol)
+	}
+	sort.Strings(names) // Deterministic order for logs and book creation
+	for _, symbol := range names {
+		sc := cfg.Symbols[symbol]
+		AddSymbol(symbol, sc.InitialPrice)
+		if sc.Volatility > 0 || sc.Drift != 0 || sc.MeanReversion > 0 {
+			SetWalk(symbol, Walk{Volatility: sc.Volatility, Drift: sc.Drift, MeanReversion: sc.MeanReversion, Anchor: sc.InitialPrice})
+		}
+	}
+
+	log.Printf("Initializing price ticker for %v...", Symbols())
+	go runTicker(cfg.TickerInterval)
+}
+
+// AddSymbol starts tracking a symbol at the given initial price.
+// If the symbol is already tracked, its price is left as is.
+func AddSymbol(symbol string, initialPrice float64) {
+	symbol = strings.ToUpper(strings.TrimSpace(symbol))
+	mu.Lock()
+	defer mu.Unlock()
+	for _, s := range symbols {
+		if s == symbol {
+			return
+		}
+	}
+	symbols = append(symbols, symbol)
+	currentPrices[symbol] = initialPrice
+}
+
+// SetWalk sets the simulation parameters of a symbol. A 0 Volatility keeps the default one,
+// a 0 Anchor makes it the current price.
+func SetWalk(symbol string, w Walk) {
+	symbol = strings.ToUpper(strings.TrimSpace(symbol))
+	mu.Lock()
+	defer mu.Unlock()
+	if w.Volatility == 0 {
+		w.Volatility = defaultVolatility
+	}
+	if w.Anchor == 0 {
+		w.Anchor = currentPrices[symbol]
+	}
+	walks[symbol] = w
+}
+
+// walkOf returns the simulation parameters of a symbol (must hold mu).
+func walkOf(symbol string) Walk {
+	if w, ok := walks[symbol]; ok {
+		return w
+	}
+	return Walk{Volatility: defaultVolatility}
+}
+
+// RemoveSymbol stops tracking a symbol; it no longer gets price updates.
+func RemoveSymbol(symbol string) {
+	symbol = strings.ToUpper(strings.TrimSpace(symbol))
 	mu.Lock()
-	// Initialize starting prices
-	currentPrices["BTC-USD"] = 60000.00
-	currentPrices["ETH-USD"] = 3000.00
-	currentPrices["SOL-USD"] = 150.00
+	defer mu.Unlock()
+	for i, s := range symbols {
+		if s == symbol {
+			symbols = append(symbols[:i], symbols[i+1:]...)
+			break
+		}
+	}
+	delete(currentPrices, symbol)
+	delete(lastTradeAt, symbol)
+	delete(walks, symbol)
+}
+
+// Symbols returns a copy of the tracked symbols.
+func Symbols() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return append([]string(nil), symbols...)
+}
+
+// SetLastPrice marks a symbol's price from an executed trade and broadcasts it right away.
+// While trades keep coming in, the simulation leaves the symbol alone.
+func SetLastPrice(symbol string, price float64) {
+	mu.Lock()
+	currentPrices[symbol] = price
+	lastTradeAt[symbol] = time.Now()
 	mu.Unlock()
 
-	log.Println("Initializing price ticker...")
-	go runTicker()
+	publish(PriceUpdate{Symbol: symbol, Price: price, Ts: time.Now().UnixMilli()})
+}
+
+// publish makes an update the pending one of its symbol, replacing any not taken yet, and
+// signals PriceUpdatesReady without blocking.
+func publish(update PriceUpdate) {
+	pendingMu.Lock()
+	pending[update.Symbol] = update
+	pendingMu.Unlock()
+
+	select {
+	case PriceUpdatesReady <- struct{}{}:
+	default: // A signal is already pending, the consumer takes this update along with it
+	}
+}
+
+// TakeUpdates returns and clears the pending price updates, the latest one of each symbol
+// published since the previous call, sorted by symbol.
+func TakeUpdates() []PriceUpdate {
+	pendingMu.Lock()
+	defer pendingMu.Unlock()
+	updates := make([]PriceUpdate, 0, len(pending))
+	for symbol, update := range pending {
+		updates = append(updates, update)
+		delete(pending, symbol)
+	}
+	sort.Slice(updates, func(i, j int) bool { return updates[i].Symbol < updates[j].Symbol })
+	return updates
 }
 
-// runTicker periodically updates prices and broadcasts them.
-func runTicker() {
-	ticker := time.NewTicker(2 * time.Second) // Update prices every 2 seconds
+// tickInterval is the interval runTicker was started with (guarded by mu).
+var tickInterval time.Duration
+
+// Alive reports whether the ticker loop is running and ticking on schedule.
+func Alive() bool {
+	mu.RLock()
+	defer mu.RUnlock()
+	return !lastTick.IsZero() && time.Since(lastTick) < 3*tickInterval
+}
+
+// Stop stops the price simulation and waits for it to exit. Call at most once, after InitTicker.
+func Stop() {
+	close(quit)
+	<-done
+	log.Println("Price ticker stopped")
+}
+
+// runTicker periodically updates prices and broadcasts them until Stop is called.
+// Symbols with recent trade activity are driven by SetLastPrice instead of the simulation.
+func runTicker(interval time.Duration) {
+	defer close(done)
+	mu.Lock()
+	tickInterval = interval
+	lastTick = time.Now()
+	mu.Unlock()
+	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
 
-	for range ticker.C {
+	for {
+		select {
+		case <-quit:
+			return
+		case <-ticker.C:
+		}
+
 		mu.Lock()
+		lastTick = time.Now()
 		for _, symbol := range symbols {
-			// Simulate a small price change (+/- 0.5%)
-			oldPrice := currentPrices[symbol]
-			changePercent := (rand.Float64() - 0.5) / 100 // Max 0.5% change up or down
-			newPrice := oldPrice * (1 + changePercent)
-			// Ensure price doesn't go negative (unlikely but possible with large swings)
-			if newPrice < 0 {
-				newPrice = oldPrice * 0.1 // drastic recovery if negative
+			if time.Since(lastTradeAt[symbol]) < tradeQuietPeriod {
+				continue // Real trades are setting the price
 			}
+
+			// Simulate a price change along the symbol's walk
+			newPrice := walkOf(symbol).step(currentPrices[symbol], rand.NormFloat64())
 			currentPrices[symbol] = newPrice
 
 			// Create and send update
@@ -60,17 +237,21 @@ func runTicker() {
 				Ts:     time.Now().UnixMilli(),
 			}
 
-			// Non-blocking send to avoid blocking ticker if channel is full
-			select {
-			case PriceUpdates <- update:
-			default:
-				log.Println("Price update channel full, dropping update for", symbol)
-			}
+			// Replaces the symbol's pending update if the consumer hasn't taken it yet
+			publish(update)
 		}
 		mu.Unlock()
 	}
 }
 
+// LastPrice returns the current price of a symbol, and whether the ticker knows it.
+func LastPrice(symbol string) (float64, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	price, ok := currentPrices[symbol]
+	return price, ok
+}
+
 // GetCurrentPrices returns a copy of the current prices.
 func GetCurrentPrices() map[string]float64 {
 	mu.RLock()
@@ -0,0 +1,50 @@
+package ticker
+
+import (
+	"math"
+	"testing"
+)
+
+func TestWalkStep(t *testing.T) {
+	tests := []struct {
+		name  string
+		walk  Walk
+		price float64
+		z     float64
+		want  float64
+	}{
+		{"no draw, no drift", Walk{Volatility: 0.01}, 100, 0, 100},
+		{"one standard deviation up", Walk{Volatility: 0.01}, 100, 1, 100 * math.Exp(0.01)},
+		{"drift", Walk{Volatility: 0.01, Drift: -0.002}, 100, 0, 100 * math.Exp(-0.002)},
+		{"full mean reversion", Walk{Volatility: 0.01, MeanReversion: 1, Anchor: 80}, 100, 0, 80},
+		{"half mean reversion", Walk{Volatility: 0.01, MeanReversion: 0.5, Anchor: 81}, 100, 0, 90},
+		{"never negative", Walk{Volatility: 0.5}, 100, -10, 100 * math.Exp(-5)},
+	}
+	for _, tt := range tests {
+		got := tt.walk.step(tt.price, tt.z)
+		if math.Abs(got-tt.want) > 1e-9 || got <= 0 {
+			t.Errorf("%s: step(%v, %v) = %v, want %v", tt.name, tt.price, tt.z, got, tt.want)
+		}
+	}
+}
+
+func TestPublishKeepsLatestPerSymbol(t *testing.T) {
+	TakeUpdates()
+	for i := 1; i <= 500; i++ { // Far more than the old channel buffer held
+		publish(PriceUpdate{Symbol: "BTC-USD", Price: float64(i)})
+	}
+	publish(PriceUpdate{Symbol: "ETH-USD", Price: 7})
+
+	select {
+	case <-PriceUpdatesReady:
+	default:
+		t.Fatal("no ready signal after publishing")
+	}
+	updates := TakeUpdates()
+	if len(updates) != 2 || updates[0].Symbol != "BTC-USD" || updates[0].Price != 500 || updates[1].Symbol != "ETH-USD" || updates[1].Price != 7 {
+		t.Fatalf("TakeUpdates() = %+v, want the latest BTC-USD (500) and ETH-USD (7)", updates)
+	}
+	if updates := TakeUpdates(); len(updates) != 0 {
+		t.Fatalf("second TakeUpdates() = %+v, want none", updates)
+	}
+}
@@ -0,0 +1,87 @@
+package websocket
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/user/minicoinbase/backend/internal/orderbook"
+)
+
+// Batch collects the messages queued for one client between two flushes of its write pump and
+// coalesces those superseded by a later one: of several price updates for a symbol only the last
+// is kept, and consecutive depth updates for a book are merged into one (see orderbook.DepthUpdate.Merge).
+// Anything else, e.g. trades, is kept as is. Messages keep the position of the first one they replace.
+// A Batch is used by a single goroutine.
+type Batch struct {
+	items []Message
+	keys  map[string]int // Position of the pending message for each coalescing key
+}
+
+// NewBatch creates an empty Batch.
+func NewBatch() *Batch {
+	return &Batch{keys: make(map[string]int)}
+}
+
+// Len returns the number of messages pending after coalescing.
+func (b *Batch) Len() int {
+	return len(b.items)
+}
+
+// Add queues a message, coalescing it with a pending one it supersedes.
+func (b *Batch) Add(msg Message) {
+	if msg.key == "" {
+		b.items = append(b.items, msg)
+		return
+	}
+	i, ok := b.keys[msg.key]
+	if !ok {
+		b.keys[msg.key] = len(b.items)
+		b.items = append(b.items, msg)
+		return
+	}
+	pending := b.items[i]
+	if msg.depth == nil {
+		b.items[i] = msg
+		return
+	}
+	// A gap between the two (an update dropped on the way) must stay visible to the client,
+	// so only back-to-back updates are merged
+	if pending.depth == nil || msg.depth.Seq != pending.depth.Seq+1 {
+		b.keys[msg.key] = len(b.items)
+		b.items = append(b.items, msg)
+		return
+	}
+	merged := msg
+	merged.depth = pending.depth.Merge(msg.depth)
+	merged.Data = nil // Encoded from depth by Flush
+	b.items[i] = merged
+}
+
+// Flush encodes the pending messages as one JSON array and empties the batch.
+func (b *Batch) Flush() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('[')
+	for i, msg := range b.items {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		data := msg.Data
+		if data == nil && msg.depth != nil {
+			var err error
+			if data, err = json.Marshal(msg.depth); err != nil {
+				return nil, err
+			}
+		}
+		buf.Write(data)
+	}
+	buf.WriteByte(']')
+
+	b.items = b.items[:0]
+	clear(b.keys)
+	return buf.Bytes(), nil
+}
+
+// depthMessage builds the depth feed message of an update, coalescable per book.
+func depthMessage(update *orderbook.DepthUpdate, data []byte) Message {
+	return Message{Channel: ChannelDepth, Symbol: update.Symbol, Data: data, key: "depth:" + update.Symbol, depth: update}
+}
@@ -0,0 +1,92 @@
+package websocket
+
+import (
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/user/minicoinbase/backend/internal/orderbook"
+	"github.com/user/minicoinbase/backend/internal/ticker"
+)
+
+func TestBatchCoalesces(t *testing.T) {
+	b := NewBatch()
+	b.Add(priceMessage("BTC-USD", []byte(`{"symbol":"BTC-USD","price":1}`)))
+	b.Add(Message{Channel: ChannelTrades, Data: []byte(`{"type":"trade"}`)})
+	b.Add(priceMessage("BTC-USD", []byte(`{"symbol":"BTC-USD","price":2}`)))
+	depth := func(seq uint64, bids ...orderbook.BookLevel) Message {
+		return depthMessage(&orderbook.DepthUpdate{Type: "depth_update", Symbol: "BTC-USD", Seq: seq, Bids: bids}, []byte(`{}`))
+	}
+	b.Add(depth(7, orderbook.BookLevel{Price: 100, Quantity: 1}, orderbook.BookLevel{Price: 99, Quantity: 3}))
+	b.Add(depth(8, orderbook.BookLevel{Price: 100, Quantity: 0}))
+	b.Add(depth(10, orderbook.BookLevel{Price: 98, Quantity: 2})) // 9 was dropped: not merged
+
+	if b.Len() != 4 {
+		t.Fatalf("Len = %d, want 4 (price, trade, merged depth, depth after the gap)", b.Len())
+	}
+	data, err := b.Flush()
+	if err != nil {
+		t.Fatalf("Flush: %v", err)
+	}
+	var frame []json.RawMessage
+	if err := json.Unmarshal(data, &frame); err != nil {
+		t.Fatalf("frame is not a JSON array: %v: %s", err, data)
+	}
+	if string(frame[0]) != `{"symbol":"BTC-USD","price":2}` {
+		t.Errorf("price = %s, want only the latest", frame[0])
+	}
+	var merged orderbook.DepthUpdate
+	if err := json.Unmarshal(frame[2], &merged); err != nil {
+		t.Fatalf("Unmarshal depth: %v", err)
+	}
+	want := []orderbook.BookLevel{{Price: 100, Quantity: 0}, {Price: 99, Quantity: 3}}
+	if merged.FirstSeq != 7 || merged.Seq != 8 || fmt.Sprint(merged.Bids) != fmt.Sprint(want) {
+		t.Errorf("merged depth = %+v, want seq 7-8 with bids %v", merged, want)
+	}
+	if b.Len() != 0 {
+		t.Errorf("Len after Flush = %d, want 0", b.Len())
+	}
+}
+
+// BenchmarkFrames compares the frames written for a burst of price updates across symbols,
+// one frame per message against one per flush interval. Reports frames and bytes per burst.
+func BenchmarkFrames(b *testing.B) {
+	const symbols, updates, perInterval = 20, 1000, 100 // perInterval: updates arriving within one flush interval
+	msgs := make([]Message, updates)
+	for i := range msgs {
+		symbol := fmt.Sprintf("SYM%d-USD", i%symbols)
+		data, _ := json.Marshal(ticker.PriceUpdate{Symbol: symbol, Price: float64(i), Ts: int64(i)})
+		msgs[i] = priceMessage(symbol, data)
+	}
+
+	b.Run("unbatched", func(b *testing.B) {
+		var frames, bytes int
+		for i := 0; i < b.N; i++ {
+			for _, msg := range msgs {
+				frames++
+				bytes += len(msg.Data)
+			}
+		}
+		b.ReportMetric(float64(frames)/float64(b.N), "frames/op")
+		b.ReportMetric(float64(bytes)/float64(b.N), "bytes/op")
+	})
+	b.Run("batched", func(b *testing.B) {
+		batch := NewBatch()
+		var frames, bytes int
+		for i := 0; i < b.N; i++ {
+			for j, msg := range msgs {
+				batch.Add(msg)
+				if (j+1)%perInterval == 0 || j == len(msgs)-1 {
+					data, err := batch.Flush()
+					if err != nil {
+						b.Fatal(err)
+					}
+					frames++
+					bytes += len(data)
+				}
+			}
+		}
+		b.ReportMetric(float64(frames)/float64(b.N), "frames/op")
+		b.ReportMetric(float64(bytes)/float64(b.N), "bytes/op")
+	})
+}
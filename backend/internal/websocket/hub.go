@@ -4,43 +4,264 @@ import (
 	"encoding/json"
 	"log"
 	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/gofiber/contrib/websocket"
+	"github.com/google/uuid"
+	"github.com/user/minicoinbase/backend/internal/orderbook"
 	"github.com/user/minicoinbase/backend/internal/ticker"
 )
 
+// Feeds a client can subscribe to.
+const (
+	ChannelPrices = "prices"
+	ChannelTrades = "trades"
+	ChannelDepth  = "depth"
+	ChannelUser   = "user" // Private: the authenticated user's own fills
+)
+
 // Client represents a single WebSocket client connection.
 type Client struct {
-	Conn *websocket.Conn
-	Send chan []byte // Buffered channel for outbound messages
+	Conn    *websocket.Conn
+	Send    chan Message // Buffered channel for outbound messages
+	Pongs   chan []byte  // Replies to the client's pings, written as soon as possible and never batched; bypasses the hub
+	Channel string       // Feed the client is subscribed to, e.g., ChannelPrices
+	Symbol  string       // Only receive messages for this symbol; empty means all symbols
+
+	mu           sync.RWMutex
+	userID       uuid.UUID // Set once the client has authenticated, see SetUserID
+	snapshotMode bool      // Depth feed clients only: periodic snapshots instead of updates, see SetSnapshotMode
+	closeCode    int       // Why the hub closed Send, see CloseReason
+	closeReason  string
+
+	dropped atomic.Int64 // Messages not queued because Send was full
+}
+
+// CloseReason returns the WebSocket close code and reason to send the client once the hub has
+// closed its Send channel: 1013 (try again later) if it was disconnected for not keeping up,
+// 1001 (going away) on shutdown, 1000 (normal closure) if it was unregistered.
+func (c *Client) CloseReason() (code int, reason string) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.closeCode == 0 {
+		return websocket.CloseGoingAway, "server shutting down"
+	}
+	return c.closeCode, c.closeReason
+}
+
+// Dropped returns how many messages the client missed because its send buffer was full.
+func (c *Client) Dropped() int64 {
+	return c.dropped.Load()
+}
+
+// UserID returns the user the client authenticated as, or uuid.Nil if it hasn't.
+func (c *Client) UserID() uuid.UUID {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.userID
+}
+
+// SetUserID marks the client as authenticated as userID, so it also receives that user's private messages.
+func (c *Client) SetUserID(userID uuid.UUID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.userID = userID
+}
+
+// SnapshotMode reports whether the client has asked for periodic depth snapshots instead of updates.
+func (c *Client) SnapshotMode() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.snapshotMode
+}
+
+// SetSnapshotMode turns the incremental depth updates of a depth feed client off (on = true) or
+// back on. The snapshots themselves are up to the caller, who sends them with Hub.SendTo.
+func (c *Client) SetSnapshotMode(on bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.snapshotMode = on
+}
+
+// addr returns the client's remote address for logging.
+func (c *Client) addr() string {
+	if c.Conn == nil || c.Conn.Conn == nil {
+		return "<no connection>"
+	}
+	return c.Conn.RemoteAddr().String()
+}
+
+// Message is a payload to broadcast to the clients of one feed.
+type Message struct {
+	Channel string
+	Symbol  string
+	UserID  uuid.UUID // If set, only clients authenticated as this user receive the message
+	Data    []byte
+	to      *Client // If set, the message is a reply to this client only, see SendTo
+
+	// Coalescing in a client's Batch: a message replaces a pending one with the same key,
+	// or for depth updates is merged into it
+	key   string
+	depth *orderbook.DepthUpdate
+}
+
+// wants reports whether the client is subscribed to the message's feed and symbol,
+// and allowed to see it.
+func (c *Client) wants(msg Message) bool {
+	if msg.to != nil {
+		return msg.to == c
+	}
+	if msg.UserID != uuid.Nil && msg.UserID != c.UserID() {
+		return false
+	}
+	if msg.Channel == ChannelDepth && c.SnapshotMode() {
+		return false
+	}
+	return c.Channel == msg.Channel && (c.Symbol == "" || c.Symbol == msg.Symbol)
 }
 
 // Hub manages WebSocket clients and broadcasts messages.
 type Hub struct {
 	clients    map[*Client]bool
-	broadcast  chan []byte  // Keep this unexported if only used internally
+	broadcast  chan Message // Keep this unexported if only used internally
 	Register   chan *Client // Exported
 	Unregister chan *Client // Exported
 	mu         sync.RWMutex
+	quit       chan struct{} // Closed by Close to stop Run
+	done       chan struct{} // Closed once Run has disconnected every client and returned
+	lastBeat   atomic.Int64  // Unix nanos of Run's last heartbeat, see Alive
+
+	dropped         atomic.Int64 // Messages dropped for all clients, see Stats
+	slowDisconnects atomic.Int64 // Clients disconnected for not keeping up
+}
+
+// ClientStats describes the backpressure on one connected client.
+type ClientStats struct {
+	Addr     string `json:"addr"`
+	Channel  string `json:"channel"`
+	Symbol   string `json:"symbol,omitempty"`
+	Queued   int    `json:"queued"`   // Messages waiting in the send buffer
+	Capacity int    `json:"capacity"` // Size of the send buffer
+	Dropped  int64  `json:"dropped"`
+}
+
+// HubStats are the hub's backpressure metrics. Dropped and SlowDisconnects count since startup;
+// Lagging lists the connected clients whose send buffer is at least half full or that missed messages.
+type HubStats struct {
+	Clients         int           `json:"clients"`
+	DroppedMessages int64         `json:"dropped_messages"`
+	SlowDisconnects int64         `json:"slow_disconnects"`
+	Lagging         []ClientStats `json:"lagging"`
+}
+
+// Stats returns the hub's backpressure metrics.
+func (h *Hub) Stats() HubStats {
+	stats := HubStats{
+		DroppedMessages: h.dropped.Load(),
+		SlowDisconnects: h.slowDisconnects.Load(),
+		Lagging:         make([]ClientStats, 0),
+	}
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	stats.Clients = len(h.clients)
+	for client := range h.clients {
+		queued, capacity := len(client.Send), cap(client.Send)
+		if client.Dropped() == 0 && queued*2 < capacity {
+			continue
+		}
+		stats.Lagging = append(stats.Lagging, ClientStats{
+			Addr:     client.addr(),
+			Channel:  client.Channel,
+			Symbol:   client.Symbol,
+			Queued:   queued,
+			Capacity: capacity,
+			Dropped:  client.Dropped(),
+		})
+	}
+	return stats
 }
 
+// drop counts a message that didn't fit in the client's send buffer.
+func (h *Hub) drop(client *Client) {
+	client.dropped.Add(1)
+	h.dropped.Add(1)
+}
+
+// hubHeartbeat is how often Run records that its loop is still turning.
+const hubHeartbeat = 5 * time.Second
+
 var GlobalHub *Hub
 
 // NewHub creates and initializes a new Hub.
 func NewHub() *Hub {
 	return &Hub{
 		clients:    make(map[*Client]bool),
-		broadcast:  make(chan []byte, 256),
+		broadcast:  make(chan Message, 256),
 		Register:   make(chan *Client), // Use exported name
 		Unregister: make(chan *Client), // Use exported name
+		quit:       make(chan struct{}),
+		done:       make(chan struct{}),
+	}
+}
+
+// RegisterClient adds a client to the hub. Returns false if the hub has shut down.
+func (h *Hub) RegisterClient(client *Client) bool {
+	select {
+	case h.Register <- client:
+		return true
+	case <-h.done:
+		return false
+	}
+}
+
+// UnregisterClient removes a client from the hub. Safe to call more than once and after shutdown.
+func (h *Hub) UnregisterClient(client *Client) {
+	select {
+	case h.Unregister <- client:
+	case <-h.done:
+	}
+}
+
+// Alive reports whether Run's event loop is running and not stuck.
+func (h *Hub) Alive() bool {
+	last := h.lastBeat.Load()
+	return last != 0 && time.Since(time.Unix(0, last)) < 3*hubHeartbeat
+}
+
+// Close stops the hub and disconnects all clients: each client's Send channel is closed,
+// which makes its write pump send a close frame (going away). Blocks until Run has returned.
+func (h *Hub) Close() {
+	close(h.quit)
+	<-h.done
+}
+
+// publish queues a message for broadcast, dropping it if the hub has shut down.
+func (h *Hub) publish(msg Message) {
+	select {
+	case h.broadcast <- msg:
+	case <-h.done:
 	}
 }
 
+// SendTo queues data for one client, in order with its feed messages. Dropped if the client
+// has been unregistered in the meantime, so unlike writing to client.Send it is always safe.
+func (h *Hub) SendTo(client *Client, data []byte) {
+	h.publish(Message{Data: data, to: client})
+}
+
 // Run starts the Hub's event loop.
 func (h *Hub) Run() {
 	log.Println("Starting WebSocket Hub...")
-	// Start listening to the price ticker updates
+	// Start listening to the price ticker, trade and depth updates
 	go h.listenToPriceUpdates()
+	go h.listenToTradeUpdates()
+	go h.listenToDepthUpdates()
+	go h.listenToFillUpdates()
+
+	heartbeat := time.NewTicker(hubHeartbeat)
+	defer heartbeat.Stop()
+	h.lastBeat.Store(time.Now().UnixNano())
 
 	for {
 		select {
@@ -48,51 +269,161 @@ func (h *Hub) Run() {
 			h.mu.Lock()
 			h.clients[client] = true
 			h.mu.Unlock()
-			log.Printf("Client registered: %s", client.Conn.RemoteAddr())
-			// Maybe send initial data (e.g., current prices) upon registration
-			// currentPrices := ticker.GetCurrentPrices()
-			// msg, _ := json.Marshal(currentPrices)
-			// client.Send <- msg
+			log.Printf("Client registered: %s", client.addr())
+			if client.Channel == ChannelPrices {
+				h.sendCurrentPrices(client)
+			}
 
 		case client := <-h.Unregister: // Use exported name
 			h.mu.Lock()
 			if _, ok := h.clients[client]; ok {
-				delete(h.clients, client)
-				close(client.Send)
-				log.Printf("Client unregistered: %s", client.Conn.RemoteAddr())
+				h.disconnect(client, websocket.CloseNormalClosure, "")
+				log.Printf("Client unregistered: %s", client.addr())
 			}
 			h.mu.Unlock()
 
 		case message := <-h.broadcast:
+			// Send message to all clients subscribed to its feed,
+			// collecting the ones that can't keep up to disconnect once the read lock is released
+			var slow []*Client
 			h.mu.RLock()
-			// Send message to all registered clients
 			for client := range h.clients {
+				if !client.wants(message) {
+					continue
+				}
 				select {
 				case client.Send <- message:
 				default:
-					// Client's send buffer is full, close connection
-					log.Printf("Client send buffer full, closing connection: %s", client.Conn.RemoteAddr())
-					close(client.Send)
-					delete(h.clients, client) // Need write lock for this, potential improvement needed
+					h.drop(client)
+					slow = append(slow, client)
 				}
 			}
 			h.mu.RUnlock()
+
+			if len(slow) > 0 {
+				h.mu.Lock()
+				for _, client := range slow {
+					// Client's send buffer is full, close connection
+					log.Printf("Client send buffer full, closing connection: %s (channel %s, %d messages dropped)",
+						client.addr(), client.Channel, client.Dropped())
+					h.slowDisconnects.Add(1)
+					h.disconnect(client, websocket.CloseTryAgainLater, "too slow, messages dropped")
+				}
+				h.mu.Unlock()
+			}
+
+		case <-heartbeat.C:
+			h.lastBeat.Store(time.Now().UnixNano())
+
+		case <-h.quit:
+			h.mu.Lock()
+			for client := range h.clients {
+				h.disconnect(client, websocket.CloseGoingAway, "server shutting down")
+			}
+			h.mu.Unlock()
+			log.Println("WebSocket Hub stopped")
+			close(h.done)
+			return
+		}
+	}
+}
+
+// disconnect removes a client and closes its Send channel, which makes its write pump send
+// a close frame with the given code and reason. Must be called with h.mu held.
+func (h *Hub) disconnect(client *Client, code int, reason string) {
+	client.mu.Lock()
+	client.closeCode, client.closeReason = code, reason
+	client.mu.Unlock()
+	delete(h.clients, client)
+	close(client.Send)
+}
+
+// sendCurrentPrices queues the current price of every symbol the client is subscribed to,
+// in the same format as the live updates, so it has something to show before the next tick.
+// Never blocks: whatever doesn't fit in the client's buffer is skipped.
+func (h *Hub) sendCurrentPrices(client *Client) {
+	now := time.Now().UnixMilli()
+	for symbol, price := range ticker.GetCurrentPrices() {
+		if client.Symbol != "" && client.Symbol != symbol {
+			continue
+		}
+		msgBytes, err := json.Marshal(ticker.PriceUpdate{Symbol: symbol, Price: price, Ts: now})
+		if err != nil {
+			log.Printf("Error marshalling initial price for %s: %v", symbol, err)
+			continue
+		}
+		select {
+		case client.Send <- priceMessage(symbol, msgBytes):
+		default:
+			h.drop(client)
+			log.Printf("Client send buffer full, skipping initial prices for %s", client.addr())
+			return
 		}
 	}
 }
 
-// listenToPriceUpdates listens to the ticker's PriceUpdates channel and broadcasts them.
+// priceMessage builds the price feed message of a symbol, coalescable per symbol.
+func priceMessage(symbol string, data []byte) Message {
+	return Message{Channel: ChannelPrices, Symbol: symbol, Data: data, key: "price:" + symbol}
+}
+
+// listenToPriceUpdates takes the ticker's pending price updates whenever it signals some and
+// broadcasts them. Prices that change again before they are taken are only sent at their latest.
 func (h *Hub) listenToPriceUpdates() {
 	log.Println("Hub listening for price updates...")
-	for update := range ticker.PriceUpdates {
-		// Marshal the update to JSON
+	for range ticker.PriceUpdatesReady {
+		for _, update := range ticker.TakeUpdates() {
+			// Marshal the update to JSON
+			msgBytes, err := json.Marshal(update)
+			if err != nil {
+				log.Printf("Error marshalling price update: %v", err)
+				continue
+			}
+			// Send JSON to the broadcast channel
+			h.publish(priceMessage(update.Symbol, msgBytes))
+		}
+	}
+}
+
+// listenToTradeUpdates listens to the order book's TradeUpdates channel and broadcasts them
+// to the trade feed subscribers of each trade's symbol.
+func (h *Hub) listenToTradeUpdates() {
+	log.Println("Hub listening for trade updates...")
+	for update := range orderbook.TradeUpdates {
+		msgBytes, err := json.Marshal(update)
+		if err != nil {
+			log.Printf("Error marshalling trade update: %v", err)
+			continue
+		}
+		h.publish(Message{Channel: ChannelTrades, Symbol: update.Symbol, Data: msgBytes})
+	}
+}
+
+// listenToDepthUpdates listens to the order book's DepthUpdates channel and broadcasts them
+// to the depth feed subscribers of each book.
+func (h *Hub) listenToDepthUpdates() {
+	log.Println("Hub listening for depth updates...")
+	for update := range orderbook.DepthUpdates {
+		msgBytes, err := json.Marshal(update)
+		if err != nil {
+			log.Printf("Error marshalling depth update: %v", err)
+			continue
+		}
+		h.publish(depthMessage(update, msgBytes))
+	}
+}
+
+// listenToFillUpdates listens to the order book's FillUpdates channel and sends each fill
+// to the user feed clients authenticated as the order's owner.
+func (h *Hub) listenToFillUpdates() {
+	log.Println("Hub listening for fill updates...")
+	for update := range orderbook.FillUpdates {
 		msgBytes, err := json.Marshal(update)
 		if err != nil {
-			log.Printf("Error marshalling price update: %v", err)
+			log.Printf("Error marshalling fill update: %v", err)
 			continue
 		}
-		// Send JSON to the broadcast channel
-		h.broadcast <- msgBytes
+		h.publish(Message{Channel: ChannelUser, Symbol: update.Symbol, UserID: update.UserID, Data: msgBytes})
 	}
 }
 
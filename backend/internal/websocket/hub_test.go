@@ -0,0 +1,223 @@
+package websocket
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gofiber/contrib/websocket"
+	"github.com/google/uuid"
+)
+
+// TestBroadcastEvictsSlowClients fills the send buffers of many clients that never read
+// while messages keep coming in, exercising the eviction path. Run with -race.
+func TestBroadcastEvictsSlowClients(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+
+	const slowClients = 200
+	const publishers, messages = 8, 50
+	slow := make([]*Client, slowClients)
+	for i := range slow {
+		slow[i] = &Client{Send: make(chan Message, 1), Channel: ChannelTrades}
+		if !h.RegisterClient(slow[i]) {
+			t.Fatal("RegisterClient failed on a running hub")
+		}
+	}
+
+	// A client that keeps up (here: has room for everything) must stay connected
+	fast := &Client{Send: make(chan Message, publishers*messages), Channel: ChannelTrades}
+	h.RegisterClient(fast)
+	var received atomic.Int64
+	go func() {
+		for range fast.Send {
+			received.Add(1)
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for p := 0; p < publishers; p++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < messages; i++ {
+				h.publish(Message{Channel: ChannelTrades, Symbol: "BTC-USD", Data: []byte(`{}`)})
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Slow clients unregistering themselves as their pumps would, concurrently with eviction
+	for _, client := range slow {
+		go h.UnregisterClient(client)
+	}
+
+	// Wait for the queued broadcasts to drain
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		h.mu.RLock()
+		remaining := len(h.clients)
+		h.mu.RUnlock()
+		if remaining == 1 && received.Load() == publishers*messages {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d clients still registered (want only the fast one), fast client received %d of %d messages",
+				remaining, received.Load(), publishers*messages)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	h.mu.RLock()
+	_, fastConnected := h.clients[fast]
+	h.mu.RUnlock()
+	if !fastConnected {
+		t.Fatal("fast client was evicted")
+	}
+
+	// Every slow client's Send is closed exactly once (a second close would have panicked the hub)
+	for i, client := range slow {
+		for range client.Send {
+		}
+		if _, ok := <-client.Send; ok {
+			t.Fatalf("slow client %d: Send not closed", i)
+		}
+	}
+
+	h.Close()
+}
+
+func TestPrivateMessagesReachOnlyTheirUser(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+	defer h.Close()
+
+	alice, bob := uuid.New(), uuid.New()
+	anonymous := &Client{Send: make(chan Message, 4), Channel: ChannelUser}
+	aliceClient := &Client{Send: make(chan Message, 4), Channel: ChannelUser}
+	aliceClient.SetUserID(alice)
+	bobClient := &Client{Send: make(chan Message, 4), Channel: ChannelUser}
+	bobClient.SetUserID(bob)
+	for _, c := range []*Client{anonymous, aliceClient, bobClient} {
+		h.RegisterClient(c)
+	}
+
+	h.publish(Message{Channel: ChannelUser, Symbol: "BTC-USD", UserID: alice, Data: []byte(`"alice"`)})
+	h.SendTo(anonymous, []byte(`"reply"`))
+
+	expect := func(c *Client, want string) {
+		t.Helper()
+		select {
+		case got := <-c.Send:
+			if string(got.Data) != want {
+				t.Errorf("received %s, want %s", got.Data, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no message, want %s", want)
+		}
+	}
+	expect(aliceClient, `"alice"`)
+	// Messages are handled in order, so the reply arriving means the private message was already dispatched
+	expect(anonymous, `"reply"`)
+	if len(anonymous.Send) != 0 || len(bobClient.Send) != 0 {
+		t.Errorf("private message leaked: anonymous has %d, bob has %d queued", len(anonymous.Send), len(bobClient.Send))
+	}
+}
+
+func TestSnapshotModeClientsSkipDepthUpdates(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+	defer h.Close()
+
+	diff := &Client{Send: make(chan Message, 4), Channel: ChannelDepth, Symbol: "BTC-USD"}
+	snapshots := &Client{Send: make(chan Message, 4), Channel: ChannelDepth, Symbol: "BTC-USD"}
+	snapshots.SetSnapshotMode(true)
+	for _, c := range []*Client{diff, snapshots} {
+		h.RegisterClient(c)
+	}
+
+	h.publish(Message{Channel: ChannelDepth, Symbol: "BTC-USD", Data: []byte(`"update"`)})
+	h.SendTo(snapshots, []byte(`"snapshot"`))
+
+	for _, tc := range []struct {
+		client *Client
+		want   string
+	}{{diff, `"update"`}, {snapshots, `"snapshot"`}} {
+		select {
+		case got := <-tc.client.Send:
+			if string(got.Data) != tc.want {
+				t.Errorf("received %s, want %s", got.Data, tc.want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("no message, want %s", tc.want)
+		}
+	}
+	if len(snapshots.Send) != 0 {
+		t.Errorf("snapshot mode client has %d more messages queued, want none", len(snapshots.Send))
+	}
+}
+
+func TestStatsCountDropsAndSlowDisconnects(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+	defer h.Close()
+
+	slow := &Client{Send: make(chan Message, 1), Channel: ChannelTrades}
+	lagging := &Client{Send: make(chan Message, 4), Channel: ChannelTrades}
+	idle := &Client{Send: make(chan Message, 4), Channel: ChannelDepth}
+	for _, c := range []*Client{slow, lagging, idle} {
+		h.RegisterClient(c)
+	}
+
+	// The second message doesn't fit in slow's buffer, which gets it disconnected
+	for i := 0; i < 2; i++ {
+		h.publish(Message{Channel: ChannelTrades, Symbol: "BTC-USD", Data: []byte(`{}`)})
+	}
+	// Messages are handled in order, so the reply arriving means the trades were dispatched
+	h.SendTo(idle, []byte(`"reply"`))
+	select {
+	case <-idle.Send:
+	case <-time.After(time.Second):
+		t.Fatal("no reply")
+	}
+
+	stats := h.Stats()
+	if stats.Clients != 2 || stats.DroppedMessages != 1 || stats.SlowDisconnects != 1 {
+		t.Fatalf("stats = %+v, want 2 clients, 1 dropped message, 1 slow disconnect", stats)
+	}
+	if slow.Dropped() != 1 {
+		t.Errorf("slow client dropped %d, want 1", slow.Dropped())
+	}
+	if len(stats.Lagging) != 1 || stats.Lagging[0].Queued != 2 || stats.Lagging[0].Dropped != 0 {
+		t.Errorf("lagging = %+v, want only the client with 2 of 4 queued", stats.Lagging)
+	}
+}
+
+func TestCloseReasonSaysWhyTheHubDisconnected(t *testing.T) {
+	h := NewHub()
+	go h.Run()
+
+	slow := &Client{Send: make(chan Message, 1), Channel: ChannelTrades}
+	other := &Client{Send: make(chan Message, 4), Channel: ChannelDepth}
+	h.RegisterClient(slow)
+	h.RegisterClient(other)
+
+	for i := 0; i < 2; i++ {
+		h.publish(Message{Channel: ChannelTrades, Symbol: "BTC-USD", Data: []byte(`{}`)})
+	}
+	h.SendTo(other, []byte(`"reply"`))
+	select {
+	case <-other.Send:
+	case <-time.After(time.Second):
+		t.Fatal("no reply")
+	}
+	if code, _ := slow.CloseReason(); code != websocket.CloseTryAgainLater {
+		t.Errorf("slow client close code = %d, want %d", code, websocket.CloseTryAgainLater)
+	}
+
+	h.Close()
+	if code, _ := other.CloseReason(); code != websocket.CloseGoingAway {
+		t.Errorf("close code on shutdown = %d, want %d", code, websocket.CloseGoingAway)
+	}
+}
@@ -0,0 +1,5 @@
+-- Reverts 0001_initial_schema
+DROP TABLE balances;
+DROP TABLE orders;
+DROP TABLE users;
+DROP FUNCTION trigger_set_timestamp();
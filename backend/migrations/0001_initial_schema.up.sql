@@ -1,8 +1,11 @@
+-- Written to be idempotent, so databases set up by hand before migrations existed
+-- can adopt the migration history without errors.
+
 -- Enable UUID generation
 CREATE EXTENSION IF NOT EXISTS "uuid-ossp";
 
 -- Users Table
-CREATE TABLE users (
+CREATE TABLE IF NOT EXISTS users (
     id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
     username VARCHAR(255) UNIQUE NOT NULL,
     password_hash VARCHAR(255) NOT NULL,
@@ -10,7 +13,7 @@ CREATE TABLE users (
 );
 
 -- Orders Table
-CREATE TABLE orders (
+CREATE TABLE IF NOT EXISTS orders (
     id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
     user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
     symbol VARCHAR(50) NOT NULL, -- e.g., BTC-USD
@@ -22,11 +25,11 @@ CREATE TABLE orders (
     created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
     updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
 );
-CREATE INDEX idx_orders_user_id ON orders(user_id);
-CREATE INDEX idx_orders_symbol_status ON orders(symbol, status);
+CREATE INDEX IF NOT EXISTS idx_orders_user_id ON orders(user_id);
+CREATE INDEX IF NOT EXISTS idx_orders_symbol_status ON orders(symbol, status);
 
 -- Balances Table (Asset balances per user)
-CREATE TABLE balances (
+CREATE TABLE IF NOT EXISTS balances (
     user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
     asset VARCHAR(20) NOT NULL,      -- e.g., USD, BTC, ETH
     available DECIMAL(20, 8) NOT NULL DEFAULT 0,
@@ -45,11 +48,13 @@ END;
 $$ LANGUAGE plpgsql;
 
 -- Triggers to auto-update timestamps
+DROP TRIGGER IF EXISTS set_timestamp_orders ON orders;
 CREATE TRIGGER set_timestamp_orders
 BEFORE UPDATE ON orders
 FOR EACH ROW
 EXECUTE PROCEDURE trigger_set_timestamp();
 
+DROP TRIGGER IF EXISTS set_timestamp_balances ON balances;
 CREATE TRIGGER set_timestamp_balances
 BEFORE UPDATE ON balances
 FOR EACH ROW
@@ -0,0 +1,2 @@
+-- Reverts 0002_stop_orders
+ALTER TABLE orders DROP COLUMN stop_price;
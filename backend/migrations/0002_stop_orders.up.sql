@@ -0,0 +1,3 @@
+-- Stop and stop-limit orders
+-- type now also holds 'stop' (market on trigger) and 'stop_limit' (limit on trigger)
+ALTER TABLE orders ADD COLUMN stop_price DECIMAL(20, 8); -- Nullable, only set for stop orders
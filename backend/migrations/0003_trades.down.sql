@@ -0,0 +1,2 @@
+-- Reverts 0003_trades
+DROP TABLE trades;
@@ -0,0 +1,14 @@
+-- Trades Table (one row per maker/taker match)
+CREATE TABLE trades (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    symbol VARCHAR(50) NOT NULL,
+    maker_order_id UUID NOT NULL REFERENCES orders(id),
+    taker_order_id UUID NOT NULL REFERENCES orders(id),
+    taker_side VARCHAR(4) NOT NULL, -- buy, sell
+    price DECIMAL(20, 8) NOT NULL,
+    quantity DECIMAL(20, 8) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX idx_trades_maker_order_id ON trades(maker_order_id);
+CREATE INDEX idx_trades_taker_order_id ON trades(taker_order_id);
+CREATE INDEX idx_trades_symbol_created_at ON trades(symbol, created_at);
@@ -0,0 +1,5 @@
+-- Reverts 0004_trade_fees
+-- Removes the house account along with the fee balances it collected
+DELETE FROM users WHERE id = '00000000-0000-0000-0000-000000000001';
+ALTER TABLE trades DROP COLUMN maker_fee;
+ALTER TABLE trades DROP COLUMN taker_fee;
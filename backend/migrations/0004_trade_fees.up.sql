@@ -0,0 +1,9 @@
+-- Maker/taker fees, charged on the asset each side receives
+ALTER TABLE trades ADD COLUMN maker_fee DECIMAL(20, 8) NOT NULL DEFAULT 0;
+ALTER TABLE trades ADD COLUMN taker_fee DECIMAL(20, 8) NOT NULL DEFAULT 0;
+
+-- House account that collects fees (fees.HouseUserID).
+-- '!' is not a valid bcrypt hash, so this account can never log in.
+INSERT INTO users (id, username, password_hash)
+VALUES ('00000000-0000-0000-0000-000000000001', '__house__', '!')
+ON CONFLICT (id) DO NOTHING;
@@ -0,0 +1,2 @@
+-- Reverts 0005_time_in_force
+ALTER TABLE orders DROP COLUMN time_in_force;
@@ -0,0 +1,2 @@
+-- Time in force: GTC (good till cancelled), IOC (immediate or cancel), FOK (fill or kill)
+ALTER TABLE orders ADD COLUMN time_in_force VARCHAR(3) NOT NULL DEFAULT 'GTC';
@@ -0,0 +1,2 @@
+-- Reverts 0006_post_only
+ALTER TABLE orders DROP COLUMN post_only;
@@ -0,0 +1,2 @@
+-- Post-only limit orders (rejected instead of taking liquidity)
+ALTER TABLE orders ADD COLUMN post_only BOOLEAN NOT NULL DEFAULT FALSE;
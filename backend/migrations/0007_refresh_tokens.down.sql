@@ -0,0 +1,2 @@
+-- Reverts 0007_refresh_tokens
+DROP TABLE refresh_tokens;
@@ -0,0 +1,2 @@
+-- Reverts 0008_api_keys
+DROP TABLE api_keys;
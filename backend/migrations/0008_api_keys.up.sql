@@ -0,0 +1,10 @@
+-- API keys for programmatic access (HMAC-signed requests)
+CREATE TABLE api_keys (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    key_id VARCHAR(64) UNIQUE NOT NULL, -- Public identifier sent in the X-API-KEY header
+    secret_encrypted BYTEA NOT NULL,    -- AES-GCM encrypted HMAC secret (see auth/apikey.go)
+    scope VARCHAR(10) NOT NULL,         -- read, trade
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX idx_api_keys_user_id ON api_keys(user_id);
@@ -0,0 +1,2 @@
+-- Reverts 0009_user_roles
+ALTER TABLE users DROP COLUMN role;
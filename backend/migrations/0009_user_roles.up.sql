@@ -0,0 +1,2 @@
+-- User roles for admin-only endpoints. Existing users become regular users.
+ALTER TABLE users ADD COLUMN role VARCHAR(20) NOT NULL DEFAULT 'user'; -- user, admin
@@ -0,0 +1,5 @@
+-- Reverts 0010_order_fills
+ALTER TABLE orders
+    DROP COLUMN avg_fill_price,
+    DROP COLUMN filled_quantity,
+    DROP COLUMN original_quantity;
@@ -0,0 +1,19 @@
+-- Fill progress per order: quantity as placed, how much has executed and at what average price.
+-- quantity stays the order's current total size (it can be modified), never the remaining quantity.
+ALTER TABLE orders
+    ADD COLUMN original_quantity DECIMAL(20, 8),
+    ADD COLUMN filled_quantity DECIMAL(20, 8) NOT NULL DEFAULT 0,
+    ADD COLUMN avg_fill_price DECIMAL(20, 8) NOT NULL DEFAULT 0;
+
+UPDATE orders SET original_quantity = quantity;
+
+UPDATE orders o
+SET filled_quantity = f.filled, avg_fill_price = f.notional / f.filled
+FROM (SELECT order_id, SUM(quantity) AS filled, SUM(price * quantity) AS notional
+      FROM (SELECT maker_order_id AS order_id, price, quantity FROM trades
+            UNION ALL
+            SELECT taker_order_id, price, quantity FROM trades) t
+      GROUP BY order_id) f
+WHERE o.id = f.order_id AND f.filled > 0;
+
+ALTER TABLE orders ALTER COLUMN original_quantity SET NOT NULL;
@@ -0,0 +1,2 @@
+-- Reverts 0011_idempotency_keys
+DROP TABLE idempotency_keys;
@@ -0,0 +1,10 @@
+-- Idempotency keys for order creation: a retried request with the same key returns the
+-- order created by the first one instead of placing (and locking funds for) another
+CREATE TABLE idempotency_keys (
+    user_id UUID NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    key VARCHAR(255) NOT NULL,
+    request_hash VARCHAR(64) NOT NULL,                       -- Hex encoded SHA-256 of the request body
+    order_id UUID REFERENCES orders(id) ON DELETE CASCADE,   -- Set in the same transaction as the order is created
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, key)
+);
@@ -0,0 +1,4 @@
+-- Reverts 0012_balance_version
+DROP TRIGGER bump_version_balances ON balances;
+DROP FUNCTION trigger_bump_version();
+ALTER TABLE balances DROP COLUMN version;
@@ -0,0 +1,16 @@
+-- Row version on balances, bumped by every update, so readers can tell whether a balance
+-- changed since they last saw it
+ALTER TABLE balances ADD COLUMN version BIGINT NOT NULL DEFAULT 0;
+
+CREATE OR REPLACE FUNCTION trigger_bump_version()
+RETURNS TRIGGER AS $$
+BEGIN
+  NEW.version = OLD.version + 1;
+  RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER bump_version_balances
+BEFORE UPDATE ON balances
+FOR EACH ROW
+EXECUTE PROCEDURE trigger_bump_version();
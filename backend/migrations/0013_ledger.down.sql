@@ -0,0 +1,3 @@
+-- Reverts 0013_ledger
+DROP TABLE ledger;
+DROP FUNCTION trigger_ledger_append_only();
@@ -0,0 +1,33 @@
+-- Append-only audit log of every balance change. Summing a user's rows per asset gives
+-- their balance, which makes it possible to tell where a wrong balance came from.
+CREATE TABLE ledger (
+    id BIGSERIAL PRIMARY KEY,
+    user_id UUID NOT NULL REFERENCES users(id),
+    asset VARCHAR(20) NOT NULL,
+    delta_available DECIMAL(20, 8) NOT NULL,
+    delta_locked DECIMAL(20, 8) NOT NULL,
+    reason VARCHAR(20) NOT NULL, -- order_lock, order_unlock, fill, deposit, withdrawal, fee
+    order_id UUID,               -- No foreign keys: entries outlive the orders and trades they refer to
+    trade_id UUID,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX idx_ledger_user_asset ON ledger(user_id, asset, id);
+CREATE INDEX idx_ledger_order_id ON ledger(order_id);
+
+-- Entries are never changed or removed
+CREATE OR REPLACE FUNCTION trigger_ledger_append_only()
+RETURNS TRIGGER AS $$
+BEGIN
+  RAISE EXCEPTION 'ledger is append-only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER ledger_append_only
+BEFORE UPDATE OR DELETE ON ledger
+FOR EACH ROW
+EXECUTE PROCEDURE trigger_ledger_append_only();
+
+-- Existing balances predate the ledger: open it with their current values
+INSERT INTO ledger (user_id, asset, delta_available, delta_locked, reason)
+SELECT user_id, asset, available, locked, 'opening_balance' FROM balances
+WHERE available <> 0 OR locked <> 0;
@@ -0,0 +1,2 @@
+-- Reverts 0014_order_book_snapshots
+DROP TABLE order_book_snapshots;
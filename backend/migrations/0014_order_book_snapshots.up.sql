@@ -0,0 +1,8 @@
+-- Latest serialized state of each order book, so a restart can restore exact queue positions
+-- instead of rebuilding the books from open orders alone
+CREATE TABLE order_book_snapshots (
+    symbol VARCHAR(50) PRIMARY KEY,
+    seq BIGINT NOT NULL,                    -- Depth sequence number of the book when the snapshot was taken
+    data JSONB NOT NULL,                    -- orderbook.Snapshot
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1,3 @@
+-- Reverts 0015_trade_seq
+DROP INDEX idx_trades_symbol_seq;
+ALTER TABLE trades DROP COLUMN seq;
@@ -0,0 +1,10 @@
+-- Per-symbol trade sequence numbers, as broadcast on the trade feed, so clients can backfill
+-- the trades they missed after a sequence number
+ALTER TABLE trades ADD COLUMN seq BIGINT;
+
+UPDATE trades t SET seq = s.seq
+FROM (SELECT id, ROW_NUMBER() OVER (PARTITION BY symbol ORDER BY created_at, id) AS seq FROM trades) s
+WHERE t.id = s.id;
+
+ALTER TABLE trades ALTER COLUMN seq SET NOT NULL;
+CREATE INDEX idx_trades_symbol_seq ON trades(symbol, seq);
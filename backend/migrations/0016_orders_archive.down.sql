@@ -0,0 +1,14 @@
+-- Reverts 0016_orders_archive, moving archived orders back first
+DROP VIEW all_orders;
+
+INSERT INTO orders (id, user_id, symbol, type, side, price, stop_price, time_in_force, post_only,
+                    quantity, original_quantity, filled_quantity, avg_fill_price, status, created_at, updated_at)
+SELECT id, user_id, symbol, type, side, price, stop_price, time_in_force, post_only,
+       quantity, original_quantity, filled_quantity, avg_fill_price, status, created_at, updated_at
+FROM orders_archive;
+
+ALTER TABLE trades
+    ADD CONSTRAINT trades_maker_order_id_fkey FOREIGN KEY (maker_order_id) REFERENCES orders(id),
+    ADD CONSTRAINT trades_taker_order_id_fkey FOREIGN KEY (taker_order_id) REFERENCES orders(id);
+
+DROP TABLE orders_archive;
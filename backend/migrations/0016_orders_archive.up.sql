@@ -0,0 +1,23 @@
+-- Cold storage for orders in a final state (filled, cancelled), moved out of orders once they
+-- are older than the retention window so the hot table only holds recent and active orders.
+-- Same columns as orders, in the same order, plus when the order was archived.
+CREATE TABLE orders_archive (LIKE orders INCLUDING DEFAULTS);
+ALTER TABLE orders_archive
+    ADD PRIMARY KEY (id),
+    ADD COLUMN archived_at TIMESTAMPTZ NOT NULL DEFAULT NOW();
+CREATE INDEX idx_orders_archive_user_created_at ON orders_archive(user_id, created_at);
+
+-- Trades outlive the orders they refer to once those are archived
+ALTER TABLE trades
+    DROP CONSTRAINT trades_maker_order_id_fkey,
+    DROP CONSTRAINT trades_taker_order_id_fkey;
+
+-- Hot and archived orders together, for history queries and joins from trades and the ledger
+CREATE VIEW all_orders AS
+SELECT id, user_id, symbol, type, side, price, stop_price, time_in_force, post_only,
+       quantity, original_quantity, filled_quantity, avg_fill_price, status, created_at, updated_at
+FROM orders
+UNION ALL
+SELECT id, user_id, symbol, type, side, price, stop_price, time_in_force, post_only,
+       quantity, original_quantity, filled_quantity, avg_fill_price, status, created_at, updated_at
+FROM orders_archive;
@@ -0,0 +1,14 @@
+-- Reverts 0017_order_expiry
+DROP VIEW all_orders;
+CREATE VIEW all_orders AS
+SELECT id, user_id, symbol, type, side, price, stop_price, time_in_force, post_only,
+       quantity, original_quantity, filled_quantity, avg_fill_price, status, created_at, updated_at
+FROM orders
+UNION ALL
+SELECT id, user_id, symbol, type, side, price, stop_price, time_in_force, post_only,
+       quantity, original_quantity, filled_quantity, avg_fill_price, status, created_at, updated_at
+FROM orders_archive;
+
+DROP INDEX idx_orders_expires_at;
+ALTER TABLE orders_archive DROP COLUMN expires_at;
+ALTER TABLE orders DROP COLUMN expires_at;
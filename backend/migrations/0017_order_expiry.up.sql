@@ -0,0 +1,17 @@
+-- Good-till-date orders: an open order with expires_at set is cancelled once that time has passed
+ALTER TABLE orders ADD COLUMN expires_at TIMESTAMPTZ;
+ALTER TABLE orders_archive ADD COLUMN expires_at TIMESTAMPTZ;
+
+-- Only live orders with an expiry are of interest to the expiry sweeper
+CREATE INDEX idx_orders_expires_at ON orders(expires_at)
+    WHERE expires_at IS NOT NULL AND status IN ('open', 'partially_filled');
+
+DROP VIEW all_orders;
+CREATE VIEW all_orders AS
+SELECT id, user_id, symbol, type, side, price, stop_price, time_in_force, post_only,
+       quantity, original_quantity, filled_quantity, avg_fill_price, status, created_at, updated_at, expires_at
+FROM orders
+UNION ALL
+SELECT id, user_id, symbol, type, side, price, stop_price, time_in_force, post_only,
+       quantity, original_quantity, filled_quantity, avg_fill_price, status, created_at, updated_at, expires_at
+FROM orders_archive;
@@ -0,0 +1,14 @@
+-- Reverts 0018_order_session
+DROP VIEW all_orders;
+CREATE VIEW all_orders AS
+SELECT id, user_id, symbol, type, side, price, stop_price, time_in_force, post_only,
+       quantity, original_quantity, filled_quantity, avg_fill_price, status, created_at, updated_at, expires_at
+FROM orders
+UNION ALL
+SELECT id, user_id, symbol, type, side, price, stop_price, time_in_force, post_only,
+       quantity, original_quantity, filled_quantity, avg_fill_price, status, created_at, updated_at, expires_at
+FROM orders_archive;
+
+DROP INDEX idx_orders_session_id;
+ALTER TABLE orders_archive DROP COLUMN session_id;
+ALTER TABLE orders DROP COLUMN session_id;
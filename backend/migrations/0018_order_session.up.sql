@@ -0,0 +1,17 @@
+-- Orders placed under a WebSocket trading session with cancel-on-disconnect,
+-- which are cancelled when the session's connection drops and isn't resumed in time
+ALTER TABLE orders ADD COLUMN session_id UUID;
+ALTER TABLE orders_archive ADD COLUMN session_id UUID;
+
+CREATE INDEX idx_orders_session_id ON orders(session_id)
+    WHERE session_id IS NOT NULL AND status IN ('open', 'partially_filled');
+
+DROP VIEW all_orders;
+CREATE VIEW all_orders AS
+SELECT id, user_id, symbol, type, side, price, stop_price, time_in_force, post_only,
+       quantity, original_quantity, filled_quantity, avg_fill_price, status, created_at, updated_at, expires_at, session_id
+FROM orders
+UNION ALL
+SELECT id, user_id, symbol, type, side, price, stop_price, time_in_force, post_only,
+       quantity, original_quantity, filled_quantity, avg_fill_price, status, created_at, updated_at, expires_at, session_id
+FROM orders_archive;
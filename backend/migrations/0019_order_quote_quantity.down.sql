@@ -0,0 +1,13 @@
+-- Reverts 0019_order_quote_quantity
+DROP VIEW all_orders;
+CREATE VIEW all_orders AS
+SELECT id, user_id, symbol, type, side, price, stop_price, time_in_force, post_only,
+       quantity, original_quantity, filled_quantity, avg_fill_price, status, created_at, updated_at, expires_at, session_id
+FROM orders
+UNION ALL
+SELECT id, user_id, symbol, type, side, price, stop_price, time_in_force, post_only,
+       quantity, original_quantity, filled_quantity, avg_fill_price, status, created_at, updated_at, expires_at, session_id
+FROM orders_archive;
+
+ALTER TABLE orders_archive DROP COLUMN quote_quantity;
+ALTER TABLE orders DROP COLUMN quote_quantity;
@@ -0,0 +1,16 @@
+-- Quote-denominated market buys ("spend 100 USD"): the amount of quote asset to spend,
+-- NULL for orders sized in the base asset (quantity is 0 for these)
+ALTER TABLE orders ADD COLUMN quote_quantity DECIMAL(20, 8);
+ALTER TABLE orders_archive ADD COLUMN quote_quantity DECIMAL(20, 8);
+
+DROP VIEW all_orders;
+CREATE VIEW all_orders AS
+SELECT id, user_id, symbol, type, side, price, stop_price, time_in_force, post_only,
+       quantity, original_quantity, filled_quantity, avg_fill_price, status, created_at, updated_at, expires_at, session_id,
+       quote_quantity
+FROM orders
+UNION ALL
+SELECT id, user_id, symbol, type, side, price, stop_price, time_in_force, post_only,
+       quantity, original_quantity, filled_quantity, avg_fill_price, status, created_at, updated_at, expires_at, session_id,
+       quote_quantity
+FROM orders_archive;
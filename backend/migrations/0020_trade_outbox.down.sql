@@ -0,0 +1,2 @@
+-- Reverts 0020_trade_outbox
+DROP TABLE trade_outbox;
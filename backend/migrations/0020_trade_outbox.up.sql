@@ -0,0 +1,20 @@
+-- Trades matched by an order book but not yet settled. Written by the matching path before
+-- settlement starts and deleted in the transaction that settles the trade, so a trade whose
+-- settlement fails, or is cut short by a crash, is retried until it goes through.
+-- trade_id becomes the id of the trade in trades, which makes settling it twice impossible.
+CREATE TABLE trade_outbox (
+    trade_id UUID PRIMARY KEY,
+    symbol VARCHAR(50) NOT NULL,
+    seq BIGINT NOT NULL,
+    maker_order_id UUID NOT NULL,
+    taker_order_id UUID NOT NULL,
+    taker_side VARCHAR(4) NOT NULL,
+    price DECIMAL(20, 8) NOT NULL,
+    quantity DECIMAL(20, 8) NOT NULL,
+    taker_limit_price DECIMAL(20, 8) NOT NULL, -- 0 for market orders, see Trade.TakerLimitPrice
+    executed_at TIMESTAMPTZ NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT,
+    next_attempt_at TIMESTAMPTZ NOT NULL
+);
+CREATE INDEX idx_trade_outbox_next_attempt_at ON trade_outbox(next_attempt_at);
@@ -0,0 +1,16 @@
+-- The funds locked when the order was placed, in the quote asset for buys and the base asset
+-- for sells. NULL for orders placed before this column existed.
+ALTER TABLE orders ADD COLUMN locked_amount DECIMAL(20, 8);
+ALTER TABLE orders_archive ADD COLUMN locked_amount DECIMAL(20, 8);
+
+DROP VIEW all_orders;
+CREATE VIEW all_orders AS
+SELECT id, user_id, symbol, type, side, price, stop_price, time_in_force, post_only,
+       quantity, original_quantity, filled_quantity, avg_fill_price, status, created_at, updated_at, expires_at, session_id,
+       quote_quantity, locked_amount
+FROM orders
+UNION ALL
+SELECT id, user_id, symbol, type, side, price, stop_price, time_in_force, post_only,
+       quantity, original_quantity, filled_quantity, avg_fill_price, status, created_at, updated_at, expires_at, session_id,
+       quote_quantity, locked_amount
+FROM orders_archive;
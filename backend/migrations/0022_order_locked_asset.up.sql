@@ -0,0 +1,27 @@
+-- locked_amount becomes what the order still has locked, in locked_asset: set when the order is
+-- placed, it goes down as fills consume it and is released on cancellation or expiry.
+-- Open orders get theirs worked out from their price and fills as of now.
+ALTER TABLE orders ADD COLUMN locked_asset VARCHAR(20);
+ALTER TABLE orders_archive ADD COLUMN locked_asset VARCHAR(20);
+
+UPDATE orders
+SET locked_asset = CASE WHEN side = 'sell' THEN split_part(symbol, '-', 1) ELSE split_part(symbol, '-', 2) END,
+    locked_amount = CASE
+        WHEN side = 'sell' THEN quantity - filled_quantity
+        WHEN quote_quantity IS NOT NULL THEN quote_quantity - avg_fill_price * filled_quantity
+        WHEN type IN ('limit', 'stop_limit') THEN price * (quantity - filled_quantity)
+        ELSE GREATEST(COALESCE(locked_amount, 0) - avg_fill_price * filled_quantity, 0) -- Market buys, see 0021
+    END
+WHERE status IN ('open', 'partially_filled');
+
+DROP VIEW all_orders;
+CREATE VIEW all_orders AS
+SELECT id, user_id, symbol, type, side, price, stop_price, time_in_force, post_only,
+       quantity, original_quantity, filled_quantity, avg_fill_price, status, created_at, updated_at, expires_at, session_id,
+       quote_quantity, locked_amount, locked_asset
+FROM orders
+UNION ALL
+SELECT id, user_id, symbol, type, side, price, stop_price, time_in_force, post_only,
+       quantity, original_quantity, filled_quantity, avg_fill_price, status, created_at, updated_at, expires_at, session_id,
+       quote_quantity, locked_amount, locked_asset
+FROM orders_archive;
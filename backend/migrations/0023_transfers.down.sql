@@ -0,0 +1,3 @@
+-- Reverts 0023_transfers
+ALTER TABLE ledger DROP COLUMN transfer_id;
+DROP TABLE transfers;
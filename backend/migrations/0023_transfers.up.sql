@@ -0,0 +1,15 @@
+-- Internal transfers of available funds from one user to another
+CREATE TABLE transfers (
+    id UUID PRIMARY KEY DEFAULT uuid_generate_v4(),
+    sender_id UUID NOT NULL REFERENCES users(id),
+    recipient_id UUID NOT NULL REFERENCES users(id),
+    asset VARCHAR(20) NOT NULL,
+    amount DECIMAL(20, 8) NOT NULL CHECK (amount > 0),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    CHECK (sender_id <> recipient_id)
+);
+CREATE INDEX idx_transfers_sender_id ON transfers(sender_id, created_at);
+CREATE INDEX idx_transfers_recipient_id ON transfers(recipient_id, created_at);
+
+-- The transfer behind a transfer_out or transfer_in ledger entry
+ALTER TABLE ledger ADD COLUMN transfer_id UUID;
@@ -0,0 +1,2 @@
+-- Reverts 0024_fills
+DROP VIEW fills;
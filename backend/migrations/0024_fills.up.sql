@@ -0,0 +1,19 @@
+-- One row per order per trade it took part in: each trade is a fill of its taker order and
+-- one of its maker order. Running totals are per order, in execution order.
+CREATE VIEW fills AS
+SELECT trade_id, order_id, symbol, side, role, price, quantity, fee,
+       split_part(symbol, '-', CASE WHEN side = 'buy' THEN 1 ELSE 2 END) AS fee_asset,
+       SUM(quantity) OVER w AS cumulative_quantity,
+       SUM(price * quantity) OVER w / SUM(quantity) OVER w AS avg_price,
+       SUM(fee) OVER w AS cumulative_fee,
+       seq, created_at
+FROM (
+    SELECT id AS trade_id, taker_order_id AS order_id, symbol, taker_side AS side, 'taker' AS role,
+           price, quantity, taker_fee AS fee, seq, created_at
+    FROM trades
+    UNION ALL
+    SELECT id, maker_order_id, symbol, CASE WHEN taker_side = 'buy' THEN 'sell' ELSE 'buy' END, 'maker',
+           price, quantity, maker_fee, seq, created_at
+    FROM trades
+) f
+WINDOW w AS (PARTITION BY order_id ORDER BY seq, trade_id);
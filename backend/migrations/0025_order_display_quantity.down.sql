@@ -0,0 +1,15 @@
+-- Reverts 0025_order_display_quantity
+DROP VIEW all_orders;
+CREATE VIEW all_orders AS
+SELECT id, user_id, symbol, type, side, price, stop_price, time_in_force, post_only,
+       quantity, original_quantity, filled_quantity, avg_fill_price, status, created_at, updated_at, expires_at, session_id,
+       quote_quantity, locked_amount, locked_asset
+FROM orders
+UNION ALL
+SELECT id, user_id, symbol, type, side, price, stop_price, time_in_force, post_only,
+       quantity, original_quantity, filled_quantity, avg_fill_price, status, created_at, updated_at, expires_at, session_id,
+       quote_quantity, locked_amount, locked_asset
+FROM orders_archive;
+
+ALTER TABLE orders_archive DROP COLUMN display_quantity;
+ALTER TABLE orders DROP COLUMN display_quantity;
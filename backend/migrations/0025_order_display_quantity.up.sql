@@ -0,0 +1,16 @@
+-- Iceberg orders: only display_quantity of the order shows on the book at a time, the rest is
+-- held back and shown a slice at a time as the visible part fills. NULL for ordinary orders.
+ALTER TABLE orders ADD COLUMN display_quantity DECIMAL(20, 8);
+ALTER TABLE orders_archive ADD COLUMN display_quantity DECIMAL(20, 8);
+
+DROP VIEW all_orders;
+CREATE VIEW all_orders AS
+SELECT id, user_id, symbol, type, side, price, stop_price, time_in_force, post_only,
+       quantity, original_quantity, filled_quantity, avg_fill_price, status, created_at, updated_at, expires_at, session_id,
+       quote_quantity, locked_amount, locked_asset, display_quantity
+FROM orders
+UNION ALL
+SELECT id, user_id, symbol, type, side, price, stop_price, time_in_force, post_only,
+       quantity, original_quantity, filled_quantity, avg_fill_price, status, created_at, updated_at, expires_at, session_id,
+       quote_quantity, locked_amount, locked_asset, display_quantity
+FROM orders_archive;
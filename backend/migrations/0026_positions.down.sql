@@ -0,0 +1,2 @@
+-- Reverts 0026_positions
+DROP TABLE positions;
@@ -0,0 +1,43 @@
+-- What each user holds of a market's base asset by way of trading it, at what cost, and the
+-- P&L realized by selling it. Kept up to date by settlement, see portfolio.Apply.
+CREATE TABLE positions (
+    user_id UUID NOT NULL REFERENCES users(id),
+    symbol VARCHAR(50) NOT NULL,
+    quantity DECIMAL(20, 8) NOT NULL DEFAULT 0,
+    cost_basis DECIMAL(20, 8) NOT NULL DEFAULT 0,
+    realized_pnl DECIMAL(20, 8) NOT NULL DEFAULT 0,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, symbol)
+);
+
+-- Replay the trades so far, oldest first, by the same rules: buys add what was received (net
+-- of the fee) at what was paid; sells take out at the average cost, at most what is held, and
+-- realize the difference to the price less their share of the fee.
+WITH RECURSIVE fills AS (
+    SELECT o.user_id, t.symbol, o.side, t.price, t.quantity,
+           CASE WHEN o.id = t.taker_order_id THEN t.taker_fee ELSE t.maker_fee END AS fee,
+           ROW_NUMBER() OVER (PARTITION BY o.user_id, t.symbol ORDER BY t.created_at, t.seq, t.id) AS n
+    FROM trades t
+    JOIN all_orders o ON o.id = t.maker_order_id OR o.id = t.taker_order_id
+),
+replay AS (
+    SELECT user_id, symbol, 0::BIGINT AS n, 0::NUMERIC AS quantity, 0::NUMERIC AS cost_basis, 0::NUMERIC AS realized_pnl
+    FROM fills
+    GROUP BY user_id, symbol
+    UNION ALL
+    SELECT r.user_id, r.symbol, f.n,
+           CASE WHEN f.side = 'buy' THEN r.quantity + f.quantity - f.fee
+                ELSE r.quantity - LEAST(f.quantity, r.quantity) END,
+           CASE WHEN f.side = 'buy' THEN r.cost_basis + f.price * f.quantity
+                WHEN f.quantity >= r.quantity THEN 0
+                ELSE r.cost_basis - r.cost_basis / r.quantity * f.quantity END,
+           CASE WHEN f.side = 'buy' OR r.quantity = 0 THEN r.realized_pnl
+                ELSE r.realized_pnl + LEAST(f.quantity, r.quantity) * (f.price - r.cost_basis / r.quantity)
+                     - f.fee * LEAST(f.quantity, r.quantity) / f.quantity END
+    FROM replay r
+    JOIN fills f ON f.user_id = r.user_id AND f.symbol = r.symbol AND f.n = r.n + 1
+)
+INSERT INTO positions (user_id, symbol, quantity, cost_basis, realized_pnl)
+SELECT DISTINCT ON (user_id, symbol) user_id, symbol, quantity, cost_basis, realized_pnl
+FROM replay
+ORDER BY user_id, symbol, n DESC;
@@ -0,0 +1,15 @@
+-- Reverts 0027_order_protection_price
+DROP VIEW all_orders;
+CREATE VIEW all_orders AS
+SELECT id, user_id, symbol, type, side, price, stop_price, time_in_force, post_only,
+       quantity, original_quantity, filled_quantity, avg_fill_price, status, created_at, updated_at, expires_at, session_id,
+       quote_quantity, locked_amount, locked_asset, display_quantity
+FROM orders
+UNION ALL
+SELECT id, user_id, symbol, type, side, price, stop_price, time_in_force, post_only,
+       quantity, original_quantity, filled_quantity, avg_fill_price, status, created_at, updated_at, expires_at, session_id,
+       quote_quantity, locked_amount, locked_asset, display_quantity
+FROM orders_archive;
+
+ALTER TABLE orders_archive DROP COLUMN protection_price;
+ALTER TABLE orders DROP COLUMN protection_price;
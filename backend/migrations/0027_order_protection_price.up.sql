@@ -0,0 +1,16 @@
+-- Market protection: a market order (or stop, once triggered) stops matching at protection_price,
+-- the worst price it may trade at, and the remainder is cancelled. NULL for unprotected orders.
+ALTER TABLE orders ADD COLUMN protection_price DECIMAL(20, 8);
+ALTER TABLE orders_archive ADD COLUMN protection_price DECIMAL(20, 8);
+
+DROP VIEW all_orders;
+CREATE VIEW all_orders AS
+SELECT id, user_id, symbol, type, side, price, stop_price, time_in_force, post_only,
+       quantity, original_quantity, filled_quantity, avg_fill_price, status, created_at, updated_at, expires_at, session_id,
+       quote_quantity, locked_amount, locked_asset, display_quantity, protection_price
+FROM orders
+UNION ALL
+SELECT id, user_id, symbol, type, side, price, stop_price, time_in_force, post_only,
+       quantity, original_quantity, filled_quantity, avg_fill_price, status, created_at, updated_at, expires_at, session_id,
+       quote_quantity, locked_amount, locked_asset, display_quantity, protection_price
+FROM orders_archive;
@@ -0,0 +1,3 @@
+-- Reverts 0028_outbox_review
+DROP INDEX idx_ledger_trade_id;
+ALTER TABLE trade_outbox DROP COLUMN needs_review;
@@ -0,0 +1,6 @@
+-- Trades that failed to settle too many times are held for manual review instead of retried:
+-- needs_review takes them out of the outbox worker's schedule until an admin releases them.
+ALTER TABLE trade_outbox ADD COLUMN needs_review BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Finding the ledger entries of a trade, which tells whether it was applied twice
+CREATE INDEX idx_ledger_trade_id ON ledger(trade_id) WHERE trade_id IS NOT NULL;
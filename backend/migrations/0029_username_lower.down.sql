@@ -0,0 +1,2 @@
+-- Reverts 0029_username_lower
+DROP INDEX users_username_lower_key;
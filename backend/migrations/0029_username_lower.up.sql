@@ -0,0 +1,5 @@
+-- Usernames are case-insensitive: signup and login lowercase them, and no two accounts may
+-- differ only by case. Existing mixed-case usernames are kept as they are and keep working,
+-- since lookups compare LOWER(username). Fails if two existing accounts collide; those need
+-- renaming by hand first.
+CREATE UNIQUE INDEX users_username_lower_key ON users (LOWER(username));
@@ -0,0 +1,3 @@
+-- Reverts 0030_ledger_admin_adjustment
+ALTER TABLE ledger DROP COLUMN note;
+ALTER TABLE ledger DROP COLUMN actor_id;
@@ -0,0 +1,3 @@
+-- Balance corrections made by an admin (admin_adjustment entries) record who made them and why
+ALTER TABLE ledger ADD COLUMN actor_id UUID; -- The acting admin; no foreign key, like the other references
+ALTER TABLE ledger ADD COLUMN note TEXT;
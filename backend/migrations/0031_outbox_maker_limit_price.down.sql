@@ -0,0 +1,2 @@
+-- Reverts 0031_outbox_maker_limit_price
+ALTER TABLE trade_outbox DROP COLUMN maker_limit_price;
@@ -0,0 +1,4 @@
+-- The maker's limit price at the time of the match, which is the trade price except when a
+-- sandbox market order slipped; settlement releases a buy maker's price improvement from it.
+-- 0 for trades enqueued before this column, which settle at the trade price as before.
+ALTER TABLE trade_outbox ADD COLUMN maker_limit_price DECIMAL(20, 8) NOT NULL DEFAULT 0;
@@ -0,0 +1,12 @@
+// Package migrations embeds the versioned SQL schema migrations.
+//
+// Files are named NNNN_description.up.sql / NNNN_description.down.sql and are applied
+// in version order by database.Migrate when the server starts.
+package migrations
+
+import "embed"
+
+// FS holds every migration file.
+//
+//go:embed *.sql
+var FS embed.FS
@@ -47,12 +47,16 @@ function useWebSocket({
 
     ws.current.onmessage = (event) => {
       try {
-        const data = JSON.parse(event.data);
-        // Basic validation (can be more robust)
-        if (typeof data === 'object' && data !== null && 'symbol' in data && 'price' in data) {
-          onMessage(data as PriceUpdateMessage);
-        } else {
-          console.warn('WebSocket: Received non-price update message:', data);
+        const parsed = JSON.parse(event.data);
+        // The server batches messages into a JSON array per frame (unless WS_FLUSH_INTERVAL is 0)
+        const messages = Array.isArray(parsed) ? parsed : [parsed];
+        for (const data of messages) {
+          // Basic validation (can be more robust)
+          if (typeof data === 'object' && data !== null && 'symbol' in data && 'price' in data) {
+            onMessage(data as PriceUpdateMessage);
+          } else {
+            console.warn('WebSocket: Received non-price update message:', data);
+          }
         }
       } catch (error) {
         console.error('WebSocket: Error parsing message:', error, event.data);
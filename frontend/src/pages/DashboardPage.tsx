@@ -1,7 +1,7 @@
 import React, { useState, useEffect, useCallback } from 'react';
 import { useNavigate } from 'react-router-dom';
 import { useAuthStore } from '../store/authStore';
-import { portfolioService, orderService } from '../services/api';
+import { authService, portfolioService, orderService } from '../services/api';
 import { Balance, Order } from '../types'; // Import types
 import useWebSocket from '../hooks/useWebSocket'; // Import the hook
 import OrderForm from '../components/OrderForm'; // Import OrderForm
@@ -34,8 +34,8 @@ function DashboardPage() {
     setLoadingOrders(true);
     setError(null); // Clear previous errors
     try {
-      const ordersRes = await orderService.getOrders();
-      setOrders(ordersRes.data);
+      const ordersRes = await orderService.getOrders('active');
+      setOrders(ordersRes.data.orders);
     } catch (err) {
       console.error('Failed to fetch orders:', err);
       setError('Failed to load orders.');
@@ -81,7 +81,12 @@ function DashboardPage() {
   });
 
   // --- Logout Handler ---
-  const handleLogout = () => {
+  const handleLogout = async () => {
+    try {
+      await authService.logout(useAuthStore.getState().refreshToken);
+    } catch (err) {
+      console.error('Logout request failed:', err); // Logged out locally all the same
+    }
     logout();
     navigate('/login'); // Redirect to login after logout
   };
@@ -179,6 +184,8 @@ function DashboardPage() {
                     <th>Side</th>
                     <th>Price</th>
                     <th>Quantity</th>
+                    <th>Filled</th>
+                    <th>Avg Price</th>
                     <th>Status</th>
                     <th>Created At</th>
                     <th>Action</th>
@@ -193,10 +200,12 @@ function DashboardPage() {
                       <td>{order.side}</td>
                       <td>{order.price ? order.price.toFixed(2) : 'N/A'}</td> {/* Adjust precision */}
                       <td>{order.quantity.toFixed(8)}</td> {/* Adjust precision */}
+                      <td>{order.filled_quantity.toFixed(8)}</td>
+                      <td>{order.filled_quantity > 0 ? order.avg_fill_price.toFixed(2) : 'N/A'}</td>
                       <td>{order.status}</td>
                       <td>{new Date(order.created_at).toLocaleString()}</td>
                       <td>
-                        {order.status === 'open' && (
+                        {(order.status === 'open' || order.status === 'partially_filled') && (
                           <button onClick={() => handleCancelOrder(order.id)}>
                             Cancel
                           </button>
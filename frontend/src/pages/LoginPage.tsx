@@ -35,11 +35,11 @@ function LoginPage() {
       const response = await authService.login({ username, password });
       console.log('Login successful:', response.data);
 
-      const { token, user } = response.data; // Assuming backend returns token and user object
+      const { token, refresh_token: refreshToken, user } = response.data; // Access token, refresh token and user object
 
-      if (token && user) {
+      if (token && refreshToken && user) {
         // Update Zustand store
-        login(token, user);
+        login(token, refreshToken, user);
         // Navigate to dashboard (will happen automatically via useEffect, but can be explicit too)
         // navigate('/dashboard'); 
       } else {
@@ -41,11 +41,11 @@ function SignupPage() {
       const response = await authService.signup({ username, password });
       console.log('Signup successful:', response.data);
 
-      const { token, user } = response.data; // Assuming backend returns token and user upon signup
+      const { token, refresh_token: refreshToken, user } = response.data; // Backend logs the new user in: tokens and user object
 
-      if (token && user) {
+      if (token && refreshToken && user) {
         // Login user immediately after successful signup
-        login(token, user);
+        login(token, refreshToken, user);
         // Navigate to dashboard (will happen automatically via useEffect)
         // navigate('/dashboard');
       } else {
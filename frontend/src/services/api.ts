@@ -29,13 +29,51 @@ apiClient.interceptors.request.use(
   }
 );
 
+// --- Token Refresh ---
+// Access tokens are short-lived: a request rejected with 401 gets a new one with the refresh
+// token and is retried once. Concurrent 401s share one refresh, as each refresh token is single-use.
+let refreshing: Promise<string | null> | null = null;
+
+const refreshAccessToken = (): Promise<string | null> => {
+  if (!refreshing) {
+    const refreshToken = useAuthStore.getState().refreshToken;
+    refreshing = (refreshToken
+      ? axios
+          // Plain axios, so a failed refresh doesn't come back through the interceptor
+          .post(`${API_BASE_URL}/auth/refresh`, { refresh_token: refreshToken })
+          .then((response) => {
+            const { token, refresh_token } = response.data;
+            useAuthStore.getState().setTokens(token, refresh_token);
+            return token as string;
+          })
+          .catch((error) => {
+            console.error('Token refresh failed', error.response);
+            return null;
+          })
+      : Promise.resolve(null)
+    ).finally(() => {
+      refreshing = null;
+    });
+  }
+  return refreshing;
+};
+
 // --- Response Interceptor (for global error handling, e.g., 401)
 apiClient.interceptors.response.use(
   (response) => response, // Pass through successful responses
-  (error) => {
+  async (error) => {
+    const request = error.config;
+    if (error.response && error.response.status === 401 && request && !request._retried && !request.url?.startsWith('/auth/')) {
+      request._retried = true;
+      const token = await refreshAccessToken();
+      if (token) {
+        request.headers.Authorization = `Bearer ${token}`;
+        return apiClient(request);
+      }
+    }
     if (error.response && error.response.status === 401) {
       console.error('Unauthorized access - 401', error.response);
-      // Trigger logout action from Zustand store
+      // Refresh token missing, expired or revoked: trigger logout action from Zustand store
       useAuthStore.getState().logout();
       // Redirect logic might be better handled in a component effect listening to auth state
       // window.location.href = '/login';
@@ -54,6 +92,10 @@ export const authService = {
   signup: (userData: { username: string; password: string }) =>
     apiClient.post('/auth/signup', userData),
 
+  // Revokes the access token and, so it can't be used to log back in, the refresh token
+  logout: (refreshToken: string | null) =>
+    apiClient.post('/auth/logout', refreshToken ? { refresh_token: refreshToken } : {}),
+
   // Add other auth-related calls if needed (e.g., fetch user profile /me)
   getProfile: () => apiClient.get('/me'),
 };
@@ -62,7 +104,8 @@ export const authService = {
 export const orderService = {
   // Define types for order creation request and response if different from backend models
   createOrder: (orderData: any) => apiClient.post('/orders', orderData),
-  getOrders: () => apiClient.get('/orders'),
+  // state: 'active' (open and partially filled) or 'history' (filled and cancelled); all non-cancelled orders if omitted
+  getOrders: (state?: 'active' | 'history') => apiClient.get('/orders', { params: state ? { state } : undefined }),
   getOrderById: (id: string) => apiClient.get(`/orders/${id}`),
   cancelOrder: (id: string) => apiClient.delete(`/orders/${id}`),
 };
@@ -9,12 +9,14 @@ interface User {
 }
 
 interface AuthState {
-  token: string | null;
+  token: string | null; // Short-lived access token (JWT)
+  refreshToken: string | null; // Single-use, exchanged for new tokens at /auth/refresh
   user: User | null;
   isAuthenticated: boolean;
-  login: (token: string, user: User) => void;
+  login: (token: string, refreshToken: string, user: User) => void;
   logout: () => void;
   setToken: (token: string | null) => void; // Allow setting token directly (e.g., on initial load)
+  setTokens: (token: string, refreshToken: string) => void; // After a refresh, which rotates both
 }
 
 export const useAuthStore = create<AuthState>()(
@@ -22,11 +24,12 @@ export const useAuthStore = create<AuthState>()(
   persist(
     (set) => ({
       token: null,
+      refreshToken: null,
       user: null,
       isAuthenticated: false,
 
-      login: (token, user) => {
-        set({ token, user, isAuthenticated: true });
+      login: (token, refreshToken, user) => {
+        set({ token, refreshToken, user, isAuthenticated: true });
         // Optional: Update axios default header immediately if needed,
         // although interceptor handles subsequent requests.
         // apiClient.defaults.headers.common['Authorization'] = `Bearer ${token}`;
@@ -34,7 +37,7 @@ export const useAuthStore = create<AuthState>()(
       },
 
       logout: () => {
-        set({ token: null, user: null, isAuthenticated: false });
+        set({ token: null, refreshToken: null, user: null, isAuthenticated: false });
         // delete apiClient.defaults.headers.common['Authorization'];
         console.log('Logged out');
         // Consider redirecting to login page here or in a component effect
@@ -46,12 +49,16 @@ export const useAuthStore = create<AuthState>()(
         // Useful if token is loaded but user info needs separate fetch.
         set({ token, isAuthenticated: !!token });
       },
+
+      setTokens: (token, refreshToken) => {
+        set({ token, refreshToken, isAuthenticated: true });
+      },
     }),
     {
       name: 'auth-storage', // name of the item in storage (must be unique)
       storage: createJSONStorage(() => localStorage), // use localStorage
-      // Only persist the token, user can be re-fetched or derived if needed
-      partialize: (state) => ({ token: state.token }),
+      // Only persist the tokens, user can be re-fetched or derived if needed
+      partialize: (state) => ({ token: state.token, refreshToken: state.refreshToken }),
       // onRehydrateStorage: () => (state) => {
       //   // Optional: Perform actions after state is rehydrated
       //   if (state?.token) {
@@ -5,6 +5,7 @@ export interface Balance {
   asset: string;
   available: number; // Go float64 maps to number
   locked: number;
+  version: number; // Incremented by every change to the balance
   updated_at: string; // Assuming timestamp comes as string
 }
 
@@ -15,7 +16,10 @@ export interface Order {
   type: 'limit' | 'market';
   side: 'buy' | 'sell';
   price?: number; // Optional for market orders
-  quantity: number;
+  quantity: number; // Total size, never the remaining quantity
+  original_quantity: number;
+  filled_quantity: number;
+  avg_fill_price: number; // 0 until the first fill
   status: 'open' | 'filled' | 'partially_filled' | 'cancelled' | 'pending';
   created_at: string;
   updated_at: string;